@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+type AccountHandler struct {
+	accounts *services.AccountService
+	privacy  *services.PrivacyService
+	tokens   *services.TokenService
+}
+
+func NewAccountHandler(accounts *services.AccountService, privacy *services.PrivacyService, tokens *services.TokenService) *AccountHandler {
+	return &AccountHandler{accounts: accounts, privacy: privacy, tokens: tokens}
+}
+
+// GetMe returns the user's profile.
+func (h *AccountHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.accounts.Profile(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, user)
+}
+
+type updateProfileRequest struct {
+	FirstName *string `json:"first_name"`
+}
+
+// UpdateMe changes the user's profile. Fields left out of the body are unchanged.
+func (h *AccountHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	var (
+		user *models.User
+		err  error
+	)
+	if req.FirstName != nil {
+		user, err = h.accounts.UpdateProfile(r.Context(), userID, *req.FirstName)
+	} else {
+		user, err = h.accounts.Profile(r.Context(), userID)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, user)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword sets a new password. Every other session is signed out, so the caller
+// gets a new token pair in place of its current one.
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims := requireClaims(w, r)
+	if claims == nil {
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	if err := h.accounts.ChangePassword(r.Context(), claims.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.tokens.RevokeAccess(r.Context(), claims); err != nil {
+		respond.Internal(w, r, fmt.Errorf("failed to end session: %w", err))
+		return
+	}
+
+	pair, err := h.tokens.Login(r.Context(), claims.UserID)
+	if err != nil {
+		respond.Internal(w, r, fmt.Errorf("failed to issue tokens: %w", err))
+		return
+	}
+	respond.JSON(w, http.StatusOK, authResponse{Token: pair.AccessToken, TokenPair: pair})
+}
+
+// SendVerification mails the user a new email verification link.
+func (h *AccountHandler) SendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.accounts.SendVerification(r.Context(), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type accountTokenRequest struct {
+	Token       string `json:"token"`
+	Email       string `json:"email"`
+	NewPassword string `json:"new_password"`
+}
+
+// VerifyEmail verifies the address a verification link was sent to.
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		badRequest(w, "token is required")
+		return
+	}
+
+	if err := h.accounts.VerifyEmail(r.Context(), req.Token); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset link. It answers the same whether or not the
+// email has an account.
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		badRequest(w, "email is required")
+		return
+	}
+
+	if err := h.accounts.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a reset link.
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req accountTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		badRequest(w, "token is required")
+		return
+	}
+
+	if err := h.accounts.ResetPassword(r.Context(), req.Token, req.NewPassword); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteMe deletes the user's account and all of their data. Accounts with a password
+// must confirm it.
+func (h *AccountHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req deleteAccountRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			badRequest(w, "invalid body")
+			return
+		}
+	}
+
+	if err := h.privacy.DeleteAccount(r.Context(), userID, req.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportResponse is an export plus, once it is ready, where to download it.
+type exportResponse struct {
+	*models.DataExport
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func newExportResponse(export *models.DataExport) exportResponse {
+	resp := exportResponse{DataExport: export}
+	if export.Status == services.ExportReady {
+		resp.DownloadURL = "/api/me/export/" + export.ID + "/download"
+	}
+	return resp
+}
+
+// StartExport starts a data export, unless one is pending or still downloadable. It
+// answers 202 with the export's ID while the archive is built, and 200 when the current
+// export is ready; poll GET /me/export/{id} for its status.
+func (h *AccountHandler) StartExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := h.privacy.Export(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusAccepted
+	if export.Status == services.ExportReady {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", "/api/me/export/"+export.ID)
+	respond.JSON(w, status, newExportResponse(export))
+}
+
+// GetExport returns the status of one of the user's exports, or of the latest without
+// an ID, with the download URL once it is ready.
+func (h *AccountHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var export *models.DataExport
+	var err error
+	if id := chi.URLParam(r, "id"); id != "" {
+		export, err = h.privacy.GetExport(r.Context(), userID, id)
+	} else {
+		export, err = h.privacy.LatestExport(r.Context(), userID)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	respond.JSON(w, http.StatusOK, newExportResponse(export))
+}
+
+// DownloadExport streams a ready export archive.
+func (h *AccountHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	export, rc, err := h.privacy.OpenExport(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="contexta-export-%s.zip"`, export.CreatedAt.UTC().Format("2006-01-02")))
+	if export.SizeBytes > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(export.SizeBytes, 10))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("download export %s: %v", export.ID, err)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+func TestExportIsStartedByPostAndFailedByShutdown(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.users["user-1"] = &models.User{ID: "user-1", Email: "ada@example.com"}
+	fdb.building = make(chan struct{}, 1)
+	privacy := services.NewPrivacyService(fdb, nil, "bucket")
+	h := NewAccountHandler(nil, privacy, nil)
+
+	r := chi.NewRouter()
+	r.Post("/me/export", h.StartExport)
+	r.Get("/me/export", h.GetExport)
+	r.Get("/me/export/{id}", h.GetExport)
+	do := func(method, path string) (*httptest.ResponseRecorder, exportResponse) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, withPrincipal(httptest.NewRequest(method, path, nil), sessionPrincipal("user-1")))
+		var resp exportResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if rec, _ := do(http.MethodGet, "/me/export"); rec.Code != http.StatusNotFound {
+		t.Fatalf("status of no export: %d, want 404", rec.Code)
+	}
+
+	rec, started := do(http.MethodPost, "/me/export")
+	if rec.Code != http.StatusAccepted || started.DataExport == nil || started.ID == "" {
+		t.Fatalf("start: status %d, body %s", rec.Code, rec.Body)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/me/export/"+started.ID {
+		t.Fatalf("start: location %q", loc)
+	}
+	<-fdb.building
+
+	for _, path := range []string{"/me/export", "/me/export/" + started.ID} {
+		rec, got := do(http.MethodGet, path)
+		if rec.Code != http.StatusOK || got.DataExport == nil || got.ID != started.ID || got.Status != services.ExportPending {
+			t.Fatalf("GET %s while building: status %d, body %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	// Starting again while one is built returns the same export.
+	if rec, again := do(http.MethodPost, "/me/export"); rec.Code != http.StatusAccepted || again.DataExport == nil || again.ID != started.ID {
+		t.Fatalf("second start: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := privacy.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown did not wait for the build: %v", err)
+	}
+	if _, got := do(http.MethodGet, "/me/export/"+started.ID); got.DataExport == nil || got.Status != services.ExportFailed || got.FailureReason == "" {
+		t.Fatalf("export after shutdown: %+v, want it failed", got.DataExport)
+	}
+}
+
+func TestInterruptedExportsFailAtStartup(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.exports = []models.DataExport{
+		{ID: "ready", UserID: "user-1", Status: services.ExportReady},
+		{ID: "pending", UserID: "user-1", Status: services.ExportPending},
+	}
+	if err := services.NewPrivacyService(fdb, nil, "bucket").FailInterruptedExports(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fdb.exports[1]; got.Status != services.ExportFailed || got.FailureReason == "" {
+		t.Fatalf("pending export left %+v, want it failed", got)
+	}
+	if got := fdb.exports[0]; got.Status != services.ExportReady {
+		t.Fatalf("ready export changed to %+v", got)
+	}
+}
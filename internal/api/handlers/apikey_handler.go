@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+type APIKeyHandler struct {
+	keys *services.APIKeyService
+}
+
+func NewAPIKeyHandler(keys *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys}
+}
+
+type createAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 never expires
+}
+
+// createAPIKeyResponse is the new key's metadata plus the key itself, returned only here.
+type createAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey makes a named, scoped key for the user. The key is in the response and
+// cannot be retrieved again.
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	key, plaintext, err := h.keys.Create(r.Context(), userID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusCreated, createAPIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+// ListAPIKeys returns the user's keys without their secrets.
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.keys.List(r.Context(), userID)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	respond.JSON(w, http.StatusOK, keys)
+}
+
+type renameAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *APIKeyHandler) RenameAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req renameAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	if err := h.keys.Rename(r.Context(), userID, chi.URLParam(r, "id"), req.Name); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeAPIKey revokes one of the user's keys; requests made with it fail from then on.
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.keys.Revoke(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,87 +1,210 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/google/uuid"
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
 	db "github.com/markdave123-py/Contexta/internal/core/database"
 	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
 )
 
 type AuthHandler struct {
 	dbclient db.DbClient
+	tokens   *services.TokenService
+	accounts *services.AccountService
+	guard    *services.LoginGuard
 }
 
-func NewAuthHandler(dbclient db.DbClient) *AuthHandler {
-	return &AuthHandler{dbclient: dbclient}
+func NewAuthHandler(dbclient db.DbClient, tokens *services.TokenService, accounts *services.AccountService, guard *services.LoginGuard) *AuthHandler {
+	return &AuthHandler{dbclient: dbclient, tokens: tokens, accounts: accounts, guard: guard}
 }
 
 type signupRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"first_name"`
+	Name      string `json:"name"` // sent by the web app; first_name wins
+}
+
+// authResponse carries a new token pair. Token repeats the access token for clients of
+// the original login response.
+type authResponse struct {
+	Token string `json:"token"`
+	*services.TokenPair
 }
 
+// Signup creates an account and mails a verification link. It answers 202 the same way
+// when the address already has an account, mailing its owner instead, so it cannot be
+// used to find out which addresses do; the client logs in afterwards.
 func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 	var req signupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid body", 400)
+		badRequest(w, "invalid body")
 		return
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	email, err := services.NormalizeEmail(req.Email)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := services.ValidatePassword(req.Password, email); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	hash, err := services.HashPassword(req.Password)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+
+	firstName := req.FirstName
+	if firstName == "" {
+		firstName = req.Name
+	}
 
 	user := &models.User{
 		ID:           uuid.NewString(),
-		Email:        req.Email,
-		PasswordHash: string(hash),
+		FirstName:    strings.TrimSpace(firstName),
+		Email:        email,
+		PasswordHash: hash,
 		CreatedAt:    time.Now(),
 	}
 
-	if err := h.dbclient.CreateUser(context.Background(), user); err != nil {
-		http.Error(w, "user exists", http.StatusConflict)
+	existing, err := h.dbclient.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		respond.Internal(w, r, fmt.Errorf("signup failed: %w", err))
 		return
 	}
+	if existing == nil {
+		if err := h.dbclient.CreateUser(r.Context(), user); err != nil {
+			// Another signup for the address may have won the race.
+			if existing, _ = h.dbclient.GetUserByEmail(r.Context(), email); existing == nil {
+				respond.Internal(w, r, fmt.Errorf("signup failed: %w", err))
+				return
+			}
+		}
+	}
 
-	token := generateJWT(user.ID)
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	// Best effort either way: the answer must not depend on which mail was sent.
+	if existing != nil {
+		if err := h.accounts.SendAccountExists(r.Context(), existing); err != nil {
+			log.Printf("signup: send account exists notice to %s: %v", existing.ID, err)
+		}
+	} else if err := h.accounts.SendVerification(r.Context(), user.ID); err != nil {
+		log.Printf("signup: send verification to %s: %v", user.ID, err)
+	}
+
+	respond.JSON(w, http.StatusAccepted, map[string]string{
+		"status":  "accepted",
+		"message": "check your email to continue, then log in",
+	})
 }
 
+// errInvalidLogin is the one answer to every failed login, so it does not tell whether
+// the email has an account.
+const errInvalidLogin = "invalid email or password"
+
+// Login checks an email and password. Failed attempts are throttled per email and per
+// client IP; while either is locked out the answer is 429 with Retry-After.
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req signupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid body", http.StatusNotFound)
+		badRequest(w, "invalid body")
+		return
+	}
+
+	// An invalid address cannot have an account; it is still counted under its raw form.
+	email, err := services.NormalizeEmail(req.Email)
+	if err != nil {
+		email = strings.ToLower(strings.TrimSpace(req.Email))
+	}
+	ip := appMiddleware.ClientIP(r)
+	if wait := h.guard.Check(email, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respond.Error(w, http.StatusTooManyRequests, respond.CodeRateLimited, "too many failed login attempts; try again later")
 		return
 	}
 
-	user, err := h.dbclient.GetUserByEmail(context.Background(), req.Email)
-	if err != nil || user == nil {
-		http.Error(w, "user not found", http.StatusNotFound)
+	var user *models.User
+	if email != "" {
+		user, err = h.dbclient.GetUserByEmail(r.Context(), email)
+		if err != nil {
+			respond.Internal(w, r, fmt.Errorf("login failed: %w", err))
+			return
+		}
+	}
+	if !services.CheckPassword(user, req.Password) {
+		h.guard.Fail(email, ip)
+		respond.Error(w, http.StatusUnauthorized, "invalid_credentials", errInvalidLogin)
+		return
+	}
+	h.guard.Succeed(email)
+
+	h.respondWithTokens(w, r, user.ID)
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented refresh token
+// is spent; presenting it again revokes every token of the login it came from.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		badRequest(w, "refresh_token is required")
 		return
 	}
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
-		http.Error(w, "invalid credentials", 401)
+
+	pair, err := h.tokens.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	token := generateJWT(user.ID)
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	respond.JSON(w, http.StatusOK, authResponse{Token: pair.AccessToken, TokenPair: pair})
 }
 
-// generateJWT creates a signed token with user ID claim
-func generateJWT(userID string) string {
-	secret := os.Getenv("JWT_SECRET")
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+// Logout revokes the caller's access token and the refresh tokens of its login. The
+// body may name the refresh token to revoke; it defaults to the access token's login.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims := requireClaims(w, r)
+	if claims == nil {
+		return
+	}
+
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			badRequest(w, "invalid body")
+			return
+		}
+	}
+
+	if err := h.tokens.Logout(r.Context(), claims, req.RefreshToken); err != nil {
+		respond.Internal(w, r, fmt.Errorf("logout failed: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithTokens starts a new login for the user and writes its token pair.
+func (h *AuthHandler) respondWithTokens(w http.ResponseWriter, r *http.Request, userID string) {
+	pair, err := h.tokens.Login(r.Context(), userID)
+	if err != nil {
+		respond.Internal(w, r, fmt.Errorf("failed to issue tokens: %w", err))
+		return
 	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, _ := tok.SignedString([]byte(secret))
-	return token
+	respond.JSON(w, http.StatusOK, authResponse{Token: pair.AccessToken, TokenPair: pair})
 }
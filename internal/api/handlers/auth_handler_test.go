@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+func TestSignupDoesNotRevealExistingAccounts(t *testing.T) {
+	fdb := newFakeDB()
+	mail := &recordingMailer{}
+	h := NewAuthHandler(fdb, nil, services.NewAccountService(fdb, mail, "https://contexta.test"), nil)
+
+	signup := func(password string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.Signup(rec, httptest.NewRequest(http.MethodPost, "/signup",
+			strings.NewReader(`{"email":"Ada@Example.com","password":"`+password+`","name":"Ada"}`)))
+		return rec
+	}
+
+	first := signup("correct horse battery staple")
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("new address: status %d, body %s", first.Code, first.Body)
+	}
+	if len(fdb.users) != 1 {
+		t.Fatalf("%d users after signup, want 1", len(fdb.users))
+	}
+
+	second := signup("another long passphrase here")
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Fatalf("existing address answered %d %s, new address %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if len(fdb.users) != 1 {
+		t.Fatalf("%d users after a second signup, want 1", len(fdb.users))
+	}
+	for _, rec := range []*httptest.ResponseRecorder{first, second} {
+		if strings.Contains(rec.Body.String(), "token") {
+			t.Fatalf("signup answered with tokens: %s", rec.Body)
+		}
+	}
+
+	if len(mail.sent) != 2 {
+		t.Fatalf("%d mails sent, want 2", len(mail.sent))
+	}
+	if s := mail.sent[0].Subject; !strings.Contains(s, "Verify") {
+		t.Errorf("first mail %q, want the verification", s)
+	}
+	if m := mail.sent[1]; !strings.Contains(m.Subject, "already have") || !strings.Contains(m.Body, "reset_token=") || m.To != "ada@example.com" {
+		t.Errorf("second mail %+v, want the account exists notice with a reset link", m)
+	}
+}
@@ -1,23 +1,37 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
 	"github.com/markdave123-py/Contexta/internal/core"
 	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
 )
 
 type ChatHandler struct {
 	dbclient db.DbClient
 	embedder core.EmbeddingProvider
 	llm      core.LLMProvider
+	quotas   *services.QuotaService
+	policy   *services.Policy
+	shares   *services.ShareService
 }
 
-func NewChatHandler(db db.DbClient, emb core.EmbeddingProvider, llm core.LLMProvider) *ChatHandler {
-	return &ChatHandler{dbclient: db, embedder: emb, llm: llm}
+func NewChatHandler(db db.DbClient, emb core.EmbeddingProvider, llm core.LLMProvider, quotas *services.QuotaService, policy *services.Policy, shares *services.ShareService) *ChatHandler {
+	return &ChatHandler{dbclient: db, embedder: emb, llm: llm, quotas: quotas, policy: policy, shares: shares}
 }
 
 type ChatRequest struct {
@@ -28,62 +42,243 @@ type ChatRequest struct {
 func (h *ChatHandler) QueryDocument(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	userID, ok := ctx.Value("user_id").(string)
+	userID, ok := requireUser(w, r)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", 400)
+		badRequest(w, "invalid request")
 		return
 	}
 
-	// Confirm document belongs to user
-	doc, err := h.dbclient.GetDocumentByID(ctx, req.DocumentID)
-	if err != nil || doc == nil {
-		http.Error(w, "document not found", http.StatusNotFound)
+	// Confirm the user may chat with the document in its workspace
+	doc, err := h.policy.AuthorizeDocument(ctx, appMiddleware.PrincipalFrom(ctx), req.DocumentID, services.ActionChat)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := h.quotas.CheckQuery(ctx, userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Record the question; the calls that answer it are billed to it
+	session, err := h.dbclient.GetOrCreateChatSession(ctx, userID, doc.ID)
+	if err != nil {
+		respond.Internal(w, r, fmt.Errorf("chat session failed: %w", err))
+		return
+	}
+	history, err := h.dbclient.ListRecentChatMessages(ctx, session.ID, rewriteHistory)
+	if err != nil {
+		log.Printf("chat: loading history of session %s: %v", session.ID, err)
+	}
+	question := &models.ChatMessage{ID: uuid.NewString(), SessionID: session.ID, Role: "user", Content: req.Query, CreatedAt: time.Now()}
+	if err := h.dbclient.AddChatMessage(ctx, question); err != nil {
+		respond.Internal(w, r, fmt.Errorf("saving message failed: %w", err))
+		return
+	}
+	ctx = core.WithUsageScope(ctx, core.UsageScope{UserID: userID, DocumentID: doc.ID, MessageID: question.ID})
+
+	res, err := h.answer(ctx, doc.ID, h.rewriteQuery(ctx, history, req.Query), req.Query)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+
+	reply := &models.ChatMessage{ID: uuid.NewString(), SessionID: session.ID, Role: "assistant", Content: res.Answer, CreatedAt: time.Now()}
+	if err := h.dbclient.AddChatMessage(ctx, reply); err != nil {
+		log.Printf("chat: saving answer to message %s: %v", question.ID, err)
+	}
+
+	respond.JSON(w, http.StatusOK, map[string]any{
+		"message_id": question.ID,
+		"answer":     res.Answer,
+		"sources":    res.Sources,
+		"route":      res.Route,
+	})
+}
+
+type sharedChatRequest struct {
+	Query string `json:"query"`
+}
+
+// QuerySharedDocument answers a question about the document behind a share link. Public:
+// the link's token is the credential, and it only reaches that document's chunks. Each
+// query spends one of the link's budget and is billed to the link's creator, counting
+// against their daily query quota.
+func (h *ChatHandler) QuerySharedDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req sharedChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		badRequest(w, "query is required")
+		return
+	}
+
+	link, doc, err := h.shares.Open(ctx, chi.URLParam(r, "token"), r.Header.Get(sharePasswordHeader), appMiddleware.ClientIP(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The creator's quota applies, but its figures are theirs: callers only learn that the
+	// link is out of questions for now.
+	if err := h.quotas.CheckQuery(ctx, link.CreatedBy); err != nil {
+		var qe *services.QuotaError
+		if !errors.As(err, &qe) {
+			respond.Internal(w, r, err)
+			return
+		}
+		if !qe.RetryAfter.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(qe.RetryAfter).Seconds()))))
+		}
+		respond.Error(w, http.StatusTooManyRequests, "share_quota_exceeded", "this link cannot answer more questions today")
+		return
+	}
+	if err := h.shares.Use(ctx, link); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	link.QueryCount++
 
-	if doc.UserID != userID {
-		http.Error(w, "you are unauthoriazed to access this document", http.StatusUnauthorized)
+	ctx = core.WithUsageScope(ctx, core.UsageScope{UserID: link.CreatedBy, DocumentID: doc.ID})
+	res, err := h.answer(ctx, doc.ID, req.Query, req.Query)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
 	}
 
+	respond.JSON(w, http.StatusOK, map[string]any{
+		"answer":            res.Answer,
+		"sources":           res.Sources,
+		"queries_remaining": queriesRemaining(link),
+	})
+}
+
+// chatAnswer is a generated answer, the chunks it was given and the backend that wrote it.
+type chatAnswer struct {
+	Answer  string
+	Sources []chatSource
+	Route   *core.GenerationInfo
+}
+
+// Follow-up questions are rewritten against at most this many earlier messages, each cut
+// to rewriteMessageRunes.
+const (
+	rewriteHistory      = 6
+	rewriteMessageRunes = 500
+)
+
+// rewriteQuery turns a follow-up question into a standalone search query using the
+// conversation so far, on the core.TaskRewrite route. Without history, or if the rewrite
+// fails, the question is searched as asked.
+func (h *ChatHandler) rewriteQuery(ctx context.Context, history []models.ChatMessage, question string) string {
+	if len(history) == 0 {
+		return question
+	}
+	var sb strings.Builder
+	for _, m := range history {
+		content := []rune(m.Content)
+		if len(content) > rewriteMessageRunes {
+			content = append(content[:rewriteMessageRunes], '…')
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", m.Role, string(content))
+	}
+
+	systemPrompt := "Rewrite the user's latest question as a standalone search query over the document, resolving anything it refers to in the conversation. Reply with the query only."
+	userPrompt := fmt.Sprintf("Conversation:\n%s\nLatest question: %s", sb.String(), question)
+	out, err := h.llm.Generate(core.WithTask(ctx, core.TaskRewrite), systemPrompt, userPrompt)
+	if err != nil {
+		log.Printf("chat: rewriting query: %v", err)
+		return question
+	}
+	if out = strings.TrimSpace(out); out == "" {
+		return question
+	}
+	return out
+}
+
+// answer retrieves the document's chunks most relevant to searchQuery and generates an
+// answer to question citing them. Usage is billed to the usage scope in ctx.
+func (h *ChatHandler) answer(ctx context.Context, documentID, searchQuery, question string) (*chatAnswer, error) {
 	// Embed the query
-	vecs, err := h.embedder.EmbedTexts(ctx, []string{req.Query})
+	vecs, err := h.embedder.EmbedTexts(ctx, []string{searchQuery})
 	if err != nil || len(vecs) == 0 {
-		http.Error(w, fmt.Sprintf("embedding failed: %v", err), 500)
-		return
+		return nil, fmt.Errorf("embedding failed: %v", err)
 	}
 	queryVec := vecs[0]
 
 	// Retrieve top chunks
-	chunks, err := h.dbclient.SearchDocumentChunks(ctx, req.DocumentID, queryVec, 5)
+	chunks, err := h.dbclient.SearchDocumentChunks(ctx, documentID, queryVec, 5)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("search failed: %v", err), 500)
-		return
+		return nil, fmt.Errorf("search failed: %v", err)
 	}
 
-	// 3️⃣ Build context prompt
+	// 3️⃣ Build context prompt, labelling each excerpt with where it came from
 	var sb strings.Builder
-	for _, ch := range chunks {
+	sources := make([]chatSource, 0, len(chunks))
+	for n, ch := range chunks {
+		src := newChatSource(n+1, ch)
+		sources = append(sources, src)
+		if src.Label != "" {
+			fmt.Fprintf(&sb, "[%d] (%s)\n", src.Ref, src.Label)
+		} else {
+			fmt.Fprintf(&sb, "[%d]\n", src.Ref)
+		}
 		sb.WriteString(ch.Text)
 		sb.WriteString("\n---\n")
 	}
 
-	systemPrompt := "You are an intelligent assistant answering based only on the given document content. If unsure, say 'I cannot find this in the document.'"
-	userPrompt := fmt.Sprintf("Context:\n%s\n\nQuestion: %s", sb.String(), req.Query)
+	systemPrompt := "You are an intelligent assistant answering based only on the given document content. Cite excerpts by their [n] marker and location. If unsure, say 'I cannot find this in the document.'"
+	userPrompt := fmt.Sprintf("Context:\n%s\n\nQuestion: %s", sb.String(), question)
 
-	// Generate response
-	answer, err := h.llm.Generate(ctx, systemPrompt, userPrompt)
+	// Generate response, recording which backend served it
+	genCtx, route := core.WithGenerationInfo(core.WithTask(ctx, core.TaskAnswer))
+	answer, err := h.llm.Generate(genCtx, systemPrompt, userPrompt)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("LLM failed: %v", err), 500)
-		return
+		return nil, fmt.Errorf("LLM failed: %v", err)
+	}
+	return &chatAnswer{Answer: answer, Sources: sources, Route: route}, nil
+}
+
+// chatSource describes one retrieved chunk so the client can render citations.
+type chatSource struct {
+	Ref         int      `json:"ref"`
+	Position    int      `json:"position"`
+	PageStart   int      `json:"page_start,omitempty"`
+	PageEnd     int      `json:"page_end,omitempty"`
+	HeadingPath []string `json:"heading_path,omitempty"`
+	Label       string   `json:"label,omitempty"` // e.g. "page 12, §Setup > Installation" or "§Q3, rows 2-26"
+}
+
+func newChatSource(ref int, ch models.DocumentChunk) chatSource {
+	src := chatSource{
+		Ref:         ref,
+		Position:    ch.Position,
+		PageStart:   ch.PageStart,
+		PageEnd:     ch.PageEnd,
+		HeadingPath: ch.HeadingPath,
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"answer": answer,
-	})
+	var parts []string
+	switch {
+	case ch.PageStart > 0 && ch.PageEnd > ch.PageStart:
+		parts = append(parts, fmt.Sprintf("pages %d-%d", ch.PageStart, ch.PageEnd))
+	case ch.PageStart > 0:
+		parts = append(parts, fmt.Sprintf("page %d", ch.PageStart))
+	}
+	if len(ch.HeadingPath) > 0 {
+		parts = append(parts, "§"+strings.Join(ch.HeadingPath, " > "))
+	}
+	if start, end := ch.Metadata["row_start"], ch.Metadata["row_end"]; start != "" {
+		if end != "" && end != start {
+			parts = append(parts, fmt.Sprintf("rows %s-%s", start, end))
+		} else {
+			parts = append(parts, "row "+start)
+		}
+	}
+	src.Label = strings.Join(parts, ", ")
+	return src
 }
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+const (
+	testOrgID = "22222222-2222-2222-2222-222222222222"
+	testDocID = "11111111-1111-1111-1111-111111111111"
+)
+
+func newTestChatHandler(fdb *fakeDB, emb *countingEmbedder) *ChatHandler {
+	return newTestChatHandlerWithLLM(fdb, emb, fixedLLM{})
+}
+
+func newTestChatHandlerWithLLM(fdb *fakeDB, emb *countingEmbedder, gen core.LLMProvider) *ChatHandler {
+	policy := services.NewPolicy(fdb)
+	quotas := services.NewQuotaService(fdb, map[string]services.QuotaLimits{"free": {MaxQueriesPerDay: 2}}, "free")
+	return NewChatHandler(fdb, emb, gen, quotas, policy, services.NewShareService(fdb, policy))
+}
+
+func TestSharedQueriesSpendCreatorQuota(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner", FileName: "a.pdf", Status: "ready"}
+	fdb.addMember(testOrgID, "owner", services.RoleOwner)
+	token := fdb.addShareLink(testDocID, "owner")
+	emb := &countingEmbedder{}
+	h := newTestChatHandler(fdb, emb)
+
+	router := chi.NewRouter()
+	router.Post("/share/{token}/chat", h.QuerySharedDocument)
+	ask := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/share/"+token+"/chat", strings.NewReader(`{"query":"where is it?"}`)))
+		return rec
+	}
+
+	// The free plan allows two queries a day; the link itself is unlimited.
+	for n := 1; n <= 2; n++ {
+		if rec := ask(); rec.Code != http.StatusOK {
+			t.Fatalf("query %d: status %d, body %s", n, rec.Code, rec.Body)
+		}
+	}
+	if got := fdb.queries["owner"]; got != 2 {
+		t.Fatalf("creator's queries today = %d, want 2", got)
+	}
+
+	rec := ask()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("query over quota: status %d, body %s", rec.Code, rec.Body)
+	}
+	if e := decodeError(t, rec); e.Code != "share_quota_exceeded" || e.Details != nil {
+		t.Fatalf("over quota error = %+v; the creator's figures must not be shown", e)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("over quota response has no Retry-After")
+	}
+	if emb.calls != 2 {
+		t.Fatalf("embedder calls = %d, want 2: the refused query must not be answered", emb.calls)
+	}
+}
+
+func TestOwnerAndSharedQueriesShareOneQuota(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner", Status: "ready"}
+	fdb.addMember(testOrgID, "owner", services.RoleOwner)
+	token := fdb.addShareLink(testDocID, "owner")
+	h := newTestChatHandler(fdb, &countingEmbedder{})
+
+	// The owner asks once themselves...
+	rec := httptest.NewRecorder()
+	h.QueryDocument(rec, withPrincipal(httptest.NewRequest(http.MethodPost, "/chat/query",
+		strings.NewReader(`{"document_id":"`+testDocID+`","query":"q"}`)), sessionPrincipal("owner")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner query: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// ...so the link has one query of the owner's two left.
+	router := chi.NewRouter()
+	router.Post("/share/{token}/chat", h.QuerySharedDocument)
+	for n, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/share/"+token+"/chat", strings.NewReader(`{"query":"q"}`)))
+		if rec.Code != want {
+			t.Fatalf("shared query %d: status %d, want %d", n+1, rec.Code, want)
+		}
+	}
+}
+
+func TestFollowUpQueriesAreRewrittenForSearch(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner", Status: "ready"}
+	fdb.addMember(testOrgID, "owner", services.RoleOwner)
+	emb := &countingEmbedder{}
+	gen := &taskLLM{}
+	h := newTestChatHandlerWithLLM(fdb, emb, gen)
+
+	ask := func(query string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.QueryDocument(rec, withPrincipal(httptest.NewRequest(http.MethodPost, "/chat/query",
+			strings.NewReader(`{"document_id":"`+testDocID+`","query":"`+query+`"}`)), sessionPrincipal("owner")))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query %q: status %d, body %s", query, rec.Code, rec.Body)
+		}
+	}
+
+	// The first question has nothing to refer to and is searched as asked.
+	ask("who signed the lease?")
+	if len(gen.prompts[core.TaskRewrite]) != 0 {
+		t.Fatal("a first question was rewritten")
+	}
+
+	// A follow-up is rewritten on the rewrite route with the conversation so far; the
+	// rewrite is searched, and the answer is to the question as asked.
+	ask("when did they sign it?")
+	rewrites := gen.prompts[core.TaskRewrite]
+	if len(rewrites) != 1 || !strings.Contains(rewrites[0], "who signed the lease?") || !strings.Contains(rewrites[0], "when did they sign it?") {
+		t.Fatalf("rewrite prompts = %q", rewrites)
+	}
+	if got := emb.texts[len(emb.texts)-1]; got != "standalone query" {
+		t.Fatalf("searched for %q, want the rewritten query", got)
+	}
+	answers := gen.prompts[core.TaskAnswer]
+	if last := answers[len(answers)-1]; !strings.Contains(last, "Question: when did they sign it?") {
+		t.Fatalf("answer prompt %q does not ask the user's question", last)
+	}
+}
+
+func TestQueryDocumentRefusesBeforeRetrieval(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner", Status: "ready"}
+	fdb.addMember(testOrgID, "owner", services.RoleOwner)
+	emb := &countingEmbedder{}
+	h := newTestChatHandler(fdb, emb)
+	readOnlyKey := &services.Principal{UserID: "owner", Method: services.AuthAPIKey, Scopes: []string{services.ScopeRead}}
+
+	cases := []struct {
+		name      string
+		principal *services.Principal
+		body      string
+		status    int
+		code      string
+	}{
+		{"no principal", nil, `{"document_id":"` + testDocID + `","query":"q"}`, http.StatusUnauthorized, "unauthorized"},
+		{"malformed body", sessionPrincipal("owner"), `{"document_id":`, http.StatusBadRequest, "bad_request"},
+		{"unknown document", sessionPrincipal("owner"), `{"document_id":"33333333-3333-3333-3333-333333333333","query":"q"}`, http.StatusNotFound, "not_found"},
+		{"another workspace's document", sessionPrincipal("stranger"), `{"document_id":"` + testDocID + `","query":"q"}`, http.StatusNotFound, "not_found"},
+		{"key without the chat scope", readOnlyKey, `{"document_id":"` + testDocID + `","query":"q"}`, http.StatusForbidden, "forbidden"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/chat/query", strings.NewReader(c.body))
+			if c.principal != nil {
+				r = withPrincipal(r, c.principal)
+			}
+			rec := httptest.NewRecorder()
+			h.QueryDocument(rec, r)
+			if rec.Code != c.status {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, c.status, rec.Body)
+			}
+			if e := decodeError(t, rec); e.Code != c.code {
+				t.Fatalf("error code %q, want %q", e.Code, c.code)
+			}
+		})
+	}
+	if emb.calls != 0 || len(fdb.searches) != 0 || len(fdb.messages) != 0 {
+		t.Fatalf("refused queries reached retrieval: %d embeddings, %d searches, %d sessions", emb.calls, len(fdb.searches), len(fdb.messages))
+	}
+}
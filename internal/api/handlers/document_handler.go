@@ -2,30 +2,38 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
 	"github.com/markdave123-py/Contexta/internal/config"
 	db "github.com/markdave123-py/Contexta/internal/core/database"
 	"github.com/markdave123-py/Contexta/internal/core/ingestion_engine"
 	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
 	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
 )
 
 type DocumentHandler struct {
 	dbclient     db.DbClient
 	objectclient objectclient.ObjectClient
 	ingestor     ingestion_engine.Ingestor
+	quotas       *services.QuotaService
+	policy       *services.Policy
+	orgs         *services.OrgService
 	cfg          *config.Config
 }
 
-func NewDocumentHandler(dbclient db.DbClient, objectclient objectclient.ObjectClient, ing ingestion_engine.Ingestor, cfg *config.Config) *DocumentHandler {
-	return &DocumentHandler{dbclient: dbclient, objectclient: objectclient, ingestor: ing, cfg: cfg}
+func NewDocumentHandler(dbclient db.DbClient, objectclient objectclient.ObjectClient, ing ingestion_engine.Ingestor, quotas *services.QuotaService, policy *services.Policy, orgs *services.OrgService, cfg *config.Config) *DocumentHandler {
+	return &DocumentHandler{dbclient: dbclient, objectclient: objectclient, ingestor: ing, quotas: quotas, policy: policy, orgs: orgs, cfg: cfg}
 }
 
 // UploadDocument handles file upload, DB insert, and background processing.
@@ -33,20 +41,42 @@ func (h *DocumentHandler) UploadDocument(w http.ResponseWriter, r *http.Request)
 
 	r.ParseMultipartForm(52 << 20) // 352 MB
 
-	userID, ok := r.Context().Value("user_id").(string)
+	userID, ok := requireUser(w, r)
 	if !ok {
-		http.Error(w, "user_id not found in context", http.StatusUnauthorized)
 		return
 	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		println(err.Error())
-		http.Error(w, "invalid file", http.StatusBadRequest)
+		badRequest(w, "invalid file")
 		return
 	}
 	defer file.Close()
 
+	chunkStrategy := r.FormValue("chunk_strategy")
+	if !ingestion_engine.ValidChunkStrategy(chunkStrategy) {
+		badRequest(w, fmt.Sprintf("unknown chunk_strategy %q", chunkStrategy))
+		return
+	}
+
+	uploadctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	// The document goes to the given organization, or the user's personal workspace.
+	orgID := r.FormValue("org_id")
+	if orgID == "" {
+		personal, err := h.orgs.Personal(uploadctx, userID)
+		if err != nil {
+			respond.Internal(w, r, fmt.Errorf("failed to find workspace: %w", err))
+			return
+		}
+		orgID = personal.ID
+	}
+	if _, err := h.policy.Authorize(uploadctx, appMiddleware.PrincipalFrom(r.Context()), orgID, services.ActionWriteDocuments); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	// --- Key Generation for S3 ---
 	// Sanitize filename to prevent path traversal or invalid characters
 	cleanFilename := filepath.Base(header.Filename) // Removes any path components
@@ -60,52 +90,117 @@ func (h *DocumentHandler) UploadDocument(w http.ResponseWriter, r *http.Request)
 		contentType = "application/octet-stream" // Default if not provided
 	}
 
-	uploadctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
-	defer cancel()
+	// The upload is hashed as it streams to storage, so it is read once. A file the
+	// workspace already has is then dropped from storage rather than embedded again.
+	hasher := sha256.New()
+	url, err := h.objectclient.UploadFile(uploadctx, h.cfg.BucketName, s3Key, io.TeeReader(file, hasher), contentType)
+	if err != nil {
+		respond.Internal(w, r, fmt.Errorf("upload failed: %w", err))
+		return
+	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
 
-	url, err := h.objectclient.UploadFile(uploadctx, h.cfg.BucketName, s3Key, file, contentType)
+	existing, err := h.dbclient.GetDocumentByHash(uploadctx, orgID, contentHash)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("upload failed: %v", err), 500)
+		h.discardUpload(s3Key)
+		respond.Internal(w, r, fmt.Errorf("failed to check for duplicates: %w", err))
+		return
+	}
+	if existing != nil {
+		h.discardUpload(s3Key)
+		// Uploading a failed document again is a retry, and ingests its pages again.
+		if existing.Status == "failed" {
+			if err := h.quotas.CheckRetry(uploadctx, existing.UserID); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if err := h.dbclient.UpdateDocumentStatus(uploadctx, existing.ID, "uploaded"); err != nil {
+				respond.Internal(w, r, fmt.Errorf("failed to requeue document: %w", err))
+				return
+			}
+			existing.Status, existing.FailureReason = "uploaded", ""
+			h.ingestor.Enqueue(existing.ID)
+		}
+		respond.JSON(w, http.StatusOK, uploadResponse{Document: existing, Duplicate: true})
+		return
+	}
+
+	// A new document must fit the user's storage, document and page quotas.
+	if err := h.quotas.CheckUpload(uploadctx, userID, header.Size); err != nil {
+		h.discardUpload(s3Key)
+		writeError(w, r, err)
 		return
 	}
 
 	doc := &models.Document{
-		ID:          uuid.NewString(),
-		UserID:      userID,
-		FileName:    header.Filename,
-		StorageURL:  url,
-		SourceType:  "upload",
-		Status:      "uploaded",
-		ContentType: contentType,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		ID:            uuid.NewString(),
+		UserID:        userID,
+		OrgID:         orgID,
+		FileName:      header.Filename,
+		StorageURL:    url,
+		SourceType:    "upload",
+		Status:        "uploaded",
+		ContentType:   contentType,
+		ChunkStrategy: chunkStrategy,
+		ContentHash:   contentHash,
+		SizeBytes:     header.Size,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}
 
 	if err := h.dbclient.CreateDocument(uploadctx, doc); err != nil {
 		log.Printf("DB insert failed for doc %s: %v", docID, err)
-		http.Error(w, fmt.Sprintf("failed to store document metadata: %v", err), http.StatusInternalServerError)
+		h.discardUpload(s3Key)
+		respond.Internal(w, r, fmt.Errorf("failed to store document metadata: %w", err))
 		return
 	}
 
 	h.ingestor.Enqueue(doc.ID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(doc)
+	respond.JSON(w, http.StatusOK, uploadResponse{Document: doc})
+}
+
+// discardUpload deletes an upload that no document will point to. It outlives the
+// request, which may already be cancelled.
+func (h *DocumentHandler) discardUpload(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.objectclient.DeleteFile(ctx, h.cfg.BucketName, key); err != nil {
+		log.Printf("delete discarded upload %s: %v", key, err)
+	}
+}
+
+// uploadResponse is the uploaded document; Duplicate is set when the workspace already
+// had a document with the same content and that one is returned instead.
+type uploadResponse struct {
+	*models.Document
+	Duplicate bool `json:"duplicate,omitempty"`
 }
 
 func (h *DocumentHandler) GetDocuments(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("user_id").(string)
+	userID, ok := requireUser(w, r)
 	if !ok {
-		http.Error(w, "user_id not found in context", http.StatusUnauthorized)
 		return
 	}
 
-	documents, err := h.dbclient.ListDocumentsByUser(r.Context(), userID)
+	// One organization's documents, or those of every organization the user is in.
+	var (
+		documents []models.Document
+		err       error
+	)
+	if orgID := r.URL.Query().Get("org_id"); orgID != "" {
+		if _, err := h.policy.Authorize(r.Context(), appMiddleware.PrincipalFrom(r.Context()), orgID, services.ActionReadDocuments); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		documents, err = h.dbclient.ListDocumentsByOrg(r.Context(), orgID)
+	} else {
+		documents, err = h.dbclient.ListMemberDocuments(r.Context(), userID)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respond.Internal(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(documents)
+	respond.JSON(w, http.StatusOK, documents)
 }
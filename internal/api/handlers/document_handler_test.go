@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/config"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+func newTestDocumentHandler(fdb *fakeDB, objects *memoryObjects, queue *queueRecorder) *DocumentHandler {
+	policy := services.NewPolicy(fdb)
+	quotas := services.NewQuotaService(fdb, map[string]services.QuotaLimits{"free": {MaxPagesPerMonth: 100}}, "free")
+	return NewDocumentHandler(fdb, objects, queue, quotas, policy, services.NewOrgService(fdb, policy), &config.Config{BucketName: "docs"})
+}
+
+// upload posts content to the workspace as userID and returns the response.
+func upload(t *testing.T, h *DocumentHandler, userID string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("org_id", testOrgID)
+	part, err := form.CreateFormFile("file", "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/documents", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.UploadDocument(rec, withPrincipal(req, sessionPrincipal(userID)))
+	return rec
+}
+
+func decodeUpload(t *testing.T, rec *httptest.ResponseRecorder) uploadResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var resp uploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestUploadHashesWhatItStores(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.addMember(testOrgID, "owner", services.RoleOwner)
+	objects, queue := &memoryObjects{}, &queueRecorder{}
+	h := newTestDocumentHandler(fdb, objects, queue)
+
+	content := []byte("%PDF-1.7 the quarterly report")
+	sum := sha256.Sum256(content)
+	first := decodeUpload(t, upload(t, h, "owner", content))
+	if first.Duplicate || first.ContentHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("first upload = %+v, want a new document with hash %x", first, sum)
+	}
+	if len(objects.objects) != 1 {
+		t.Fatalf("%d objects stored, want 1", len(objects.objects))
+	}
+	for _, stored := range objects.objects {
+		if !bytes.Equal(stored, content) {
+			t.Fatalf("stored %q, want the whole upload %q", stored, content)
+		}
+	}
+
+	// The same file again is stored, hashed, recognised and dropped from storage.
+	again := decodeUpload(t, upload(t, h, "owner", content))
+	if !again.Duplicate || again.ID != first.ID {
+		t.Fatalf("second upload = %+v, want document %s as a duplicate", again, first.ID)
+	}
+	if len(objects.objects) != 1 || len(objects.deleted) != 1 {
+		t.Fatalf("%d objects stored, %d deleted; want the duplicate's deleted", len(objects.objects), len(objects.deleted))
+	}
+	if len(fdb.docs) != 1 || len(queue.queued) != 1 {
+		t.Fatalf("%d documents, %d enqueued; want the first upload only", len(fdb.docs), len(queue.queued))
+	}
+}
+
+func TestRetryingFailedUploadChecksPageQuota(t *testing.T) {
+	content := []byte("%PDF-1.7 a scan that failed")
+	sum := sha256.Sum256(content)
+	for _, c := range []struct {
+		name   string
+		pages  int
+		status int
+	}{
+		{"pages left", 99, http.StatusOK},
+		{"pages used up", 100, http.StatusTooManyRequests},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			fdb := newFakeDB()
+			fdb.addMember(testOrgID, "owner", services.RoleOwner)
+			fdb.addMember(testOrgID, "editor", services.RoleEditor)
+			fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner",
+				Status: "failed", ContentHash: hex.EncodeToString(sum[:])}
+			// The retry ingests the owner's document, so it spends the owner's pages.
+			fdb.pages["owner"] = c.pages
+			objects, queue := &memoryObjects{}, &queueRecorder{}
+			h := newTestDocumentHandler(fdb, objects, queue)
+
+			rec := upload(t, h, "editor", content)
+			if rec.Code != c.status {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, c.status, rec.Body)
+			}
+			if len(objects.objects) != 0 {
+				t.Fatalf("%d objects left in storage; the retry uses the stored original", len(objects.objects))
+			}
+			if c.status != http.StatusOK {
+				if e := decodeError(t, rec); e.Code != "quota_exceeded" {
+					t.Fatalf("error = %+v, want quota_exceeded", e)
+				}
+				if len(queue.queued) != 0 || fdb.docs[testDocID].Status != "failed" {
+					t.Fatalf("refused retry enqueued %v, status %q", queue.queued, fdb.docs[testDocID].Status)
+				}
+				return
+			}
+			if resp := decodeUpload(t, rec); !resp.Duplicate || resp.Status != "uploaded" {
+				t.Fatalf("retry = %+v, want the document requeued", resp)
+			}
+			if len(queue.queued) != 1 || queue.queued[0] != testDocID {
+				t.Fatalf("enqueued %v, want %s", queue.queued, testDocID)
+			}
+		})
+	}
+}
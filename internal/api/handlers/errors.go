@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+// serviceError is the response for a service error and any error wrapping it.
+type serviceError struct {
+	err    error
+	status int
+	code   string
+}
+
+// serviceErrors maps the services' errors to responses. The first match wins.
+var serviceErrors = []serviceError{
+	{services.ErrNotFound, http.StatusNotFound, respond.CodeNotFound},
+	{services.ErrForbidden, http.StatusForbidden, respond.CodeForbidden},
+	{services.ErrOrgInput, http.StatusBadRequest, respond.CodeBadRequest},
+	{services.ErrInvitationInvalid, http.StatusBadRequest, "invitation_invalid"},
+	{services.ErrLastOwner, http.StatusConflict, "last_owner"},
+
+	{services.ErrAccountNotFound, http.StatusNotFound, respond.CodeNotFound},
+	{services.ErrAccountInput, http.StatusBadRequest, respond.CodeBadRequest},
+	{services.ErrAccountTokenUsed, http.StatusBadRequest, "link_invalid"},
+	{services.ErrInvalidEmail, http.StatusBadRequest, "invalid_email"},
+	{services.ErrWeakPassword, http.StatusBadRequest, "weak_password"},
+	{services.ErrWrongPassword, http.StatusForbidden, "wrong_password"},
+	{services.ErrAlreadyVerified, http.StatusConflict, "already_verified"},
+	{services.ErrExportNotReady, http.StatusConflict, "export_not_ready"},
+	{services.ErrExportExpired, http.StatusGone, respond.CodeGone},
+
+	{services.ErrAPIKeyInput, http.StatusBadRequest, respond.CodeBadRequest},
+	{services.ErrAPIKeyNotFound, http.StatusNotFound, respond.CodeNotFound},
+
+	{services.ErrShareInput, http.StatusBadRequest, respond.CodeBadRequest},
+	{services.ErrShareInvalid, http.StatusNotFound, respond.CodeNotFound},
+	{services.ErrSharePassword, http.StatusUnauthorized, "share_password"},
+	{services.ErrShareExhausted, http.StatusTooManyRequests, "share_exhausted"},
+	{services.ErrShareLocked, http.StatusTooManyRequests, respond.CodeRateLimited},
+
+	{services.ErrInvalidToken, http.StatusUnauthorized, "invalid_token"},
+	{services.ErrRevokedToken, http.StatusUnauthorized, "invalid_token"},
+	{services.ErrTokenReused, http.StatusUnauthorized, "invalid_token"},
+
+	{services.ErrUnknownProvider, http.StatusNotFound, respond.CodeNotFound},
+	{services.ErrLoginState, http.StatusUnauthorized, "login_failed"},
+	{services.ErrIdentityRejected, http.StatusUnauthorized, "login_failed"},
+	{services.ErrEmailUnverified, http.StatusConflict, "email_unverified"},
+}
+
+// writeError answers with the response for err: a quota error, one of serviceErrors, or
+// else a 500 that is logged but not revealed.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if writeQuotaError(w, err) {
+		return
+	}
+	var locked *services.ShareLockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(locked.Wait.Seconds())))))
+	}
+	for _, se := range serviceErrors {
+		if errors.Is(err, se.err) {
+			respond.Error(w, se.status, se.code, err.Error())
+			return
+		}
+	}
+	respond.Internal(w, r, err)
+}
+
+// NotFound answers API requests for unknown routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respond.Error(w, http.StatusNotFound, respond.CodeNotFound, "no such endpoint")
+}
+
+// MethodNotAllowed answers API requests with a method the route does not accept.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respond.Error(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed here")
+}
+
+// writeQuotaError answers with err if it is a *services.QuotaError, with the quota in the
+// error's details, and reports whether it was one.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var qe *services.QuotaError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	if !qe.RetryAfter.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(qe.RetryAfter).Seconds()))))
+	}
+	respond.ErrorWithDetails(w, qe.Status, "quota_exceeded", qe.Error(), qe)
+	return true
+}
+
+// badRequest answers 400 with message.
+func badRequest(w http.ResponseWriter, message string) {
+	respond.Error(w, http.StatusBadRequest, respond.CodeBadRequest, message)
+}
+
+// requireUser returns the ID of the authenticated user, or answers 401 and returns false.
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	p := appMiddleware.PrincipalFrom(r.Context())
+	if p == nil || p.UserID == "" {
+		respond.Error(w, http.StatusUnauthorized, respond.CodeUnauthorized, "authentication required")
+		return "", false
+	}
+	return p.UserID, true
+}
+
+// requireClaims returns the access token claims of a session, or answers 401 and returns
+// nil.
+func requireClaims(w http.ResponseWriter, r *http.Request) *services.AccessClaims {
+	claims := appMiddleware.ClaimsFrom(r.Context())
+	if claims == nil {
+		respond.Error(w, http.StatusUnauthorized, respond.CodeUnauthorized, "authentication required")
+	}
+	return claims
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := errors.New(`pq: relation "documents" does not exist`)
+	writeError(rec, httptest.NewRequest(http.MethodGet, "/documents", nil), fmt.Errorf("list documents: %w", err))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	e := decodeError(t, rec)
+	if e.Code != "internal_error" || strings.Contains(rec.Body.String(), "relation") || strings.Contains(rec.Body.String(), "list documents") {
+		t.Fatalf("internal error revealed: %s", rec.Body)
+	}
+}
+
+func TestWriteErrorMapsServiceErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "/", nil), fmt.Errorf("%w: no such document", services.ErrNotFound))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Code != "not_found" || !strings.Contains(e.Message, "no such document") {
+		t.Fatalf("error %+v", e)
+	}
+}
+
+func TestQuotaErrorsCarryTheirDetails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	qe := &services.QuotaError{
+		Quota:      services.QuotaDocuments,
+		Limit:      10,
+		Used:       10,
+		Status:     http.StatusForbidden,
+		RetryAfter: time.Now().Add(time.Hour),
+	}
+	writeError(rec, httptest.NewRequest(http.MethodPost, "/documents/upload", nil), fmt.Errorf("upload: %w", qe))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("no Retry-After")
+	}
+	e := decodeError(t, rec)
+	details, ok := e.Details.(map[string]any)
+	if e.Code != "quota_exceeded" || !ok {
+		t.Fatalf("error %+v, want quota_exceeded with details", e)
+	}
+	if details["quota"] != services.QuotaDocuments || details["limit"] != float64(10) || details["used"] != float64(10) || details["resets_at"] == nil {
+		t.Fatalf("details %v", details)
+	}
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/core"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/core/ingestion_engine"
+	"github.com/markdave123-py/Contexta/internal/core/mailer"
+	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+// fakeDB keeps what the handler tests need in memory. Methods no test uses fall through
+// to the nil embedded client and panic.
+type fakeDB struct {
+	db.DbClient
+
+	mu       sync.Mutex
+	docs     map[string]*models.Document
+	members  map[string]map[string]string // org ID -> user ID -> role
+	links    map[string]*models.ShareLink // by token hash
+	quotas   map[string]*models.UserQuota
+	queries  map[string]int                  // queries today, by the user they count against
+	pages    map[string]int                  // pages ingested this month, by user
+	messages map[string][]models.ChatMessage // by session ID
+	searches []string                        // document searched, per search
+	users    map[string]*models.User         // by ID
+	tokens   []models.AccountToken
+	exports  []models.DataExport // oldest first
+	building chan struct{}       // signalled when an export build lists documents
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		docs:     make(map[string]*models.Document),
+		members:  make(map[string]map[string]string),
+		links:    make(map[string]*models.ShareLink),
+		quotas:   make(map[string]*models.UserQuota),
+		queries:  make(map[string]int),
+		pages:    make(map[string]int),
+		messages: make(map[string][]models.ChatMessage),
+		users:    make(map[string]*models.User),
+	}
+}
+
+func (f *fakeDB) addMember(orgID, userID, role string) {
+	if f.members[orgID] == nil {
+		f.members[orgID] = make(map[string]string)
+	}
+	f.members[orgID][userID] = role
+}
+
+func (f *fakeDB) GetUserByID(_ context.Context, id string) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[id]; ok {
+		cp := *u
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) GetUserByEmail(_ context.Context, email string) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == email {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) CreateUser(_ context.Context, user *models.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == user.Email {
+			return errors.New("duplicate key value violates unique constraint")
+		}
+	}
+	cp := *user
+	f.users[user.ID] = &cp
+	return nil
+}
+
+func (f *fakeDB) CreateAccountToken(_ context.Context, t *models.AccountToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, *t)
+	return nil
+}
+
+func (f *fakeDB) CreateDataExport(_ context.Context, export *models.DataExport) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exports = append(f.exports, *export)
+	return nil
+}
+
+func (f *fakeDB) ListDataExports(_ context.Context, userID string) ([]models.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []models.DataExport
+	for i := len(f.exports) - 1; i >= 0; i-- {
+		if f.exports[i].UserID == userID {
+			out = append(out, f.exports[i])
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDB) GetDataExport(_ context.Context, userID, id string) (*models.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.exports {
+		if e.UserID == userID && e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) DeleteDataExport(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.exports {
+		if e.ID == id {
+			f.exports = append(f.exports[:i], f.exports[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) FailDataExport(ctx context.Context, id, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.exports {
+		if e.ID == id && e.Status == services.ExportPending {
+			f.exports[i].Status, f.exports[i].FailureReason = services.ExportFailed, reason
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) FailPendingDataExports(_ context.Context, reason string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for i, e := range f.exports {
+		if e.Status == services.ExportPending {
+			f.exports[i].Status, f.exports[i].FailureReason = services.ExportFailed, reason
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListDocumentsByUser stands in for a slow export build: it blocks until ctx ends.
+func (f *fakeDB) ListDocumentsByUser(ctx context.Context, _ string) ([]models.Document, error) {
+	if f.building != nil {
+		f.building <- struct{}{}
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (f *fakeDB) GetDocumentByID(_ context.Context, id string) (*models.Document, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.docs[id]; ok {
+		cp := *d
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) GetDocumentByHash(_ context.Context, orgID, contentHash string) (*models.Document, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range f.docs {
+		if d.OrgID == orgID && d.ContentHash == contentHash {
+			cp := *d
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) CreateDocument(_ context.Context, doc *models.Document) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp := *doc
+	f.docs[doc.ID] = &cp
+	return nil
+}
+
+func (f *fakeDB) UpdateDocumentStatus(_ context.Context, id, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.docs[id]; ok {
+		d.Status = status
+	}
+	return nil
+}
+
+func (f *fakeDB) GetMembership(_ context.Context, orgID, userID string) (*models.Membership, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if role, ok := f.members[orgID][userID]; ok {
+		return &models.Membership{OrgID: orgID, UserID: userID, Role: role}, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) SearchDocumentChunks(_ context.Context, docID string, _ []float32, _ int) ([]models.DocumentChunk, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.searches = append(f.searches, docID)
+	return []models.DocumentChunk{{DocumentID: docID, Text: "the answer is in here", PageStart: 1}}, nil
+}
+
+func (f *fakeDB) GetUserQuota(_ context.Context, userID string) (*models.UserQuota, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q, ok := f.quotas[userID]; ok {
+		return q, nil
+	}
+	return &models.UserQuota{Plan: "free"}, nil
+}
+
+func (f *fakeDB) GetQuotaUsage(_ context.Context, userID string, _, _ time.Time) (*models.QuotaUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &models.QuotaUsage{QueriesToday: f.queries[userID], PagesThisMonth: f.pages[userID]}, nil
+}
+
+func (f *fakeDB) GetOrCreateChatSession(_ context.Context, userID, documentID string) (*models.ChatSession, error) {
+	return &models.ChatSession{ID: "session-" + userID, UserID: userID, DocumentID: documentID}, nil
+}
+
+func (f *fakeDB) AddChatMessage(_ context.Context, m *models.ChatMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages[m.SessionID] = append(f.messages[m.SessionID], *m)
+	if m.Role == "user" {
+		f.queries[userOfSession(m.SessionID)]++
+	}
+	return nil
+}
+
+func (f *fakeDB) ListRecentChatMessages(_ context.Context, sessionID string, limit int) ([]models.ChatMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := f.messages[sessionID]
+	return append([]models.ChatMessage(nil), msgs[max(0, len(msgs)-limit):]...), nil
+}
+
+func userOfSession(sessionID string) string {
+	return sessionID[len("session-"):]
+}
+
+func (f *fakeDB) GetShareLinkByTokenHash(_ context.Context, hash string) (*models.ShareLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l, ok := f.links[hash]; ok {
+		cp := *l
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) UseShareLink(_ context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.links {
+		if l.ID != id {
+			continue
+		}
+		if l.MaxQueries != nil && l.QueryCount >= *l.MaxQueries {
+			return false, nil
+		}
+		l.QueryCount++
+		f.queries[l.CreatedBy]++
+		return true, nil
+	}
+	return false, nil
+}
+
+// addShareLink stores an unlimited link to the document and returns its token.
+func (f *fakeDB) addShareLink(documentID, createdBy string) string {
+	token := "share-token-" + documentID
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	f.links[hash] = &models.ShareLink{
+		ID:         "link-" + documentID,
+		DocumentID: documentID,
+		CreatedBy:  createdBy,
+		TokenHash:  hash,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	return token
+}
+
+// countingEmbedder returns a fixed vector, counts its calls and keeps the texts.
+type countingEmbedder struct {
+	mu    sync.Mutex
+	calls int
+	texts []string
+}
+
+func (e *countingEmbedder) EmbedTexts(_ context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+	e.texts = append(e.texts, texts...)
+	out := make([][]float32, len(texts))
+	for k := range out {
+		out[k] = []float32{1, 0}
+	}
+	return out, nil
+}
+
+// memoryObjects is an object store in memory that keeps the keys it deleted.
+type memoryObjects struct {
+	objectclient.ObjectClient
+
+	mu      sync.Mutex
+	objects map[string][]byte // by key
+	deleted []string
+}
+
+func (o *memoryObjects) UploadFile(_ context.Context, bucket, key string, data io.Reader, _ string) (string, error) {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return "", err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.objects == nil {
+		o.objects = make(map[string][]byte)
+	}
+	o.objects[key] = b
+	return "https://" + bucket + ".s3.test.amazonaws.com/" + key, nil
+}
+
+func (o *memoryObjects) DeleteFile(_ context.Context, _, key string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.objects, key)
+	o.deleted = append(o.deleted, key)
+	return nil
+}
+
+// queueRecorder is an ingestor that keeps the documents enqueued and processes nothing.
+type queueRecorder struct {
+	ingestion_engine.Ingestor
+
+	mu     sync.Mutex
+	queued []string
+}
+
+func (q *queueRecorder) Enqueue(docID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued = append(q.queued, docID)
+}
+
+// fixedLLM answers every prompt the same way.
+type fixedLLM struct{}
+
+func (fixedLLM) Generate(context.Context, string, string) (string, error) {
+	return "it is in here [1]", nil
+}
+
+// taskLLM answers by task, keeping the user prompt of each request.
+type taskLLM struct {
+	mu      sync.Mutex
+	prompts map[core.Task][]string
+}
+
+func (l *taskLLM) Generate(ctx context.Context, _, userPrompt string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	task := core.TaskFrom(ctx)
+	if l.prompts == nil {
+		l.prompts = make(map[core.Task][]string)
+	}
+	l.prompts[task] = append(l.prompts[task], userPrompt)
+	if task == core.TaskRewrite {
+		return "standalone query", nil
+	}
+	return "it is in here [1]", nil
+}
+
+// recordingMailer keeps the messages it is asked to send.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// decodeError reads an error envelope, failing the test if the response is not one.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) respond.ErrorDetail {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type = %q, body %q", ct, rec.Body.String())
+	}
+	var body respond.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code == "" || body.Error.Message == "" {
+		t.Fatalf("error body %q lacks a code or message", rec.Body.String())
+	}
+	return body.Error
+}
+
+func sessionPrincipal(userID string) *services.Principal {
+	return &services.Principal{UserID: userID, Method: services.AuthSession, Scopes: services.Scopes}
+}
+
+// withPrincipal returns r as AuthMiddleware would pass it on for p.
+func withPrincipal(r *http.Request, p *services.Principal) *http.Request {
+	return r.WithContext(appMiddleware.WithPrincipal(r.Context(), p))
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+// oidcCookie holds the signed login state between the redirect to the provider and the
+// callback. It is only sent to the OIDC routes.
+const (
+	oidcCookie     = "contexta_oidc"
+	oidcCookiePath = "/api/auth/oidc"
+)
+
+type OIDCHandler struct {
+	oidc *services.OIDCService
+}
+
+func NewOIDCHandler(oidc *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidc: oidc}
+}
+
+// ListProviders returns the names of the configured login providers.
+func (h *OIDCHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	respond.JSON(w, http.StatusOK, map[string][]string{"providers": h.oidc.Providers()})
+}
+
+// Login redirects to the provider's login page. The optional redirect query parameter
+// is a path on this site to return to with the tokens once logged in.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.oidc.Begin(r.Context(), chi.URLParam(r, "provider"), r.URL.Query().Get("redirect"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		// Lax, not Strict: the callback is a top-level navigation from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes the login the provider redirected back from. It answers with the
+// token pair, or redirects to the path given at login with the tokens in the fragment,
+// which never reaches a server.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	// The state cookie is single use whatever the outcome.
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: oidcCookiePath, MaxAge: -1, HttpOnly: true})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		msg := "login was not completed: " + e
+		if desc := q.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		respond.Error(w, http.StatusUnauthorized, "login_failed", msg)
+		return
+	}
+
+	var signed string
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		signed = c.Value
+	}
+	pair, user, redirect, err := h.oidc.Complete(r.Context(), chi.URLParam(r, "provider"), q.Get("code"), q.Get("state"), signed)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if redirect == "" {
+		respond.JSON(w, http.StatusOK, authResponse{Token: pair.AccessToken, TokenPair: pair})
+		return
+	}
+	fragment := url.Values{
+		"access_token":       {pair.AccessToken},
+		"refresh_token":      {pair.RefreshToken},
+		"access_expires_at":  {pair.AccessExpiresAt.UTC().Format(time.RFC3339)},
+		"refresh_expires_at": {pair.RefreshExpiresAt.UTC().Format(time.RFC3339)},
+		"email":              {user.Email},
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, strings.SplitN(redirect, "#", 2)[0]+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+type OrgHandler struct {
+	orgs *services.OrgService
+}
+
+func NewOrgHandler(orgs *services.OrgService) *OrgHandler {
+	return &OrgHandler{orgs: orgs}
+}
+
+type createOrgRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateOrg creates an organization owned by the user.
+func (h *OrgHandler) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req createOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	org, err := h.orgs.Create(r.Context(), userID, req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusCreated, org)
+}
+
+// ListOrgs returns the user's organizations and their role in each.
+func (h *OrgHandler) ListOrgs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgs, err := h.orgs.List(r.Context(), userID)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+	if orgs == nil {
+		orgs = []models.Organization{}
+	}
+
+	respond.JSON(w, http.StatusOK, orgs)
+}
+
+func (h *OrgHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.orgs.Members(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, members)
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (h *OrgHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	var req setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	err := h.orgs.SetRole(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"), chi.URLParam(r, "userID"), req.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMember removes a member; members may remove themselves to leave.
+func (h *OrgHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	err := h.orgs.RemoveMember(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"), chi.URLParam(r, "userID"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type inviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// inviteResponse is the new invitation plus its token, returned only here.
+type inviteResponse struct {
+	*models.Invitation
+	Token string `json:"token"`
+}
+
+// Invite invites an email address to the organization. The invitee accepts with the
+// token in the response.
+func (h *OrgHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	inv, token, err := h.orgs.Invite(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"), req.Email, req.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusCreated, inviteResponse{Invitation: inv, Token: token})
+}
+
+func (h *OrgHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	invs, err := h.orgs.Invitations(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if invs == nil {
+		invs = []models.Invitation{}
+	}
+
+	respond.JSON(w, http.StatusOK, invs)
+}
+
+func (h *OrgHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	err := h.orgs.RevokeInvitation(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "orgID"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// AcceptInvitation joins the user to the organization they were invited to.
+func (h *OrgHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req acceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		badRequest(w, "token is required")
+		return
+	}
+
+	membership, err := h.orgs.Accept(r.Context(), userID, req.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, membership)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	appMiddleware "github.com/markdave123-py/Contexta/internal/api/middlewares"
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+// sharePasswordHeader carries the password of a password-protected share link.
+const sharePasswordHeader = "X-Share-Password"
+
+type ShareHandler struct {
+	shares *services.ShareService
+}
+
+func NewShareHandler(shares *services.ShareService) *ShareHandler {
+	return &ShareHandler{shares: shares}
+}
+
+type createShareRequest struct {
+	ExpiresInDays int    `json:"expires_in_days"` // 0 uses the default of 7 days
+	Password      string `json:"password"`
+	MaxQueries    int    `json:"max_queries"` // 0 is unlimited
+}
+
+// createShareResponse is the new link plus its token and URL, returned only here.
+type createShareResponse struct {
+	*models.ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// CreateShare makes a share link for the document in the URL.
+func (h *ShareHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var req createShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid body")
+		return
+	}
+
+	link, token, err := h.shares.Create(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "id"), services.ShareOptions{
+		ExpiresIn:  time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+		Password:   req.Password,
+		MaxQueries: req.MaxQueries,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusCreated, createShareResponse{ShareLink: link, Token: token, URL: "/api/share/" + token})
+}
+
+func (h *ShareHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	links, err := h.shares.List(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if links == nil {
+		links = []models.ShareLink{}
+	}
+
+	respond.JSON(w, http.StatusOK, links)
+}
+
+func (h *ShareHandler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	if err := h.shares.Revoke(r.Context(), appMiddleware.PrincipalFrom(r.Context()), chi.URLParam(r, "id"), chi.URLParam(r, "shareID")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sharedDocument is what a share link reveals about its document: never the file itself
+// or where it is stored.
+type sharedDocument struct {
+	FileName         string    `json:"file_name"`
+	Status           string    `json:"status"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	QueriesRemaining *int      `json:"queries_remaining"` // null is unlimited
+}
+
+// GetShare describes the document behind a share link. Public; password-protected links
+// need the X-Share-Password header.
+func (h *ShareHandler) GetShare(w http.ResponseWriter, r *http.Request) {
+	link, doc, err := h.shares.Open(r.Context(), chi.URLParam(r, "token"), r.Header.Get(sharePasswordHeader), appMiddleware.ClientIP(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, sharedDocument{
+		FileName:         doc.FileName,
+		Status:           doc.Status,
+		ExpiresAt:        link.ExpiresAt,
+		QueriesRemaining: queriesRemaining(link),
+	})
+}
+
+func queriesRemaining(link *models.ShareLink) *int {
+	if link.MaxQueries == nil {
+		return nil
+	}
+	n := max(*link.MaxQueries-link.QueryCount, 0)
+	return &n
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+func TestSharePasswordGuessesAreLockedOut(t *testing.T) {
+	fdb := newFakeDB()
+	fdb.docs[testDocID] = &models.Document{ID: testDocID, OrgID: testOrgID, UserID: "owner", FileName: "a.pdf", Status: "ready"}
+	token := fdb.addShareLink(testDocID, "owner")
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range fdb.links {
+		l.PasswordHash, l.HasPassword = string(hash), true
+	}
+	h := NewShareHandler(services.NewShareService(fdb, services.NewPolicy(fdb)))
+	router := chi.NewRouter()
+	router.Get("/share/{token}", h.GetShare)
+	open := func(password, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/share/"+token, nil)
+		req.Header.Set(sharePasswordHeader, password)
+		req.RemoteAddr = ip + ":4711"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := open("open sesame", "198.51.100.7"); rec.Code != http.StatusOK {
+		t.Fatalf("right password: status %d, body %s", rec.Code, rec.Body)
+	}
+	for n := 1; n <= 10; n++ {
+		rec := open("guess", "203.0.113.9")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d, body %s", n, rec.Code, rec.Body)
+		}
+		if e := decodeError(t, rec); e.Code != "share_password" {
+			t.Fatalf("guess %d: error %+v", n, e)
+		}
+	}
+
+	// Locked: even the right password is refused until the lockout ends, from any IP.
+	for _, ip := range []string{"203.0.113.9", "198.51.100.7"} {
+		rec := open("open sesame", ip)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("locked link from %s: status %d, body %s", ip, rec.Code, rec.Body)
+		}
+		if e := decodeError(t, rec); e.Code != "rate_limited" {
+			t.Fatalf("locked link from %s: error %+v", ip, e)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("locked link from %s: no Retry-After", ip)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+const (
+	usageDateLayout  = "2006-01-02"
+	usageDefaultDays = 30
+	usageMaxDays     = 366
+)
+
+type UsageHandler struct {
+	dbclient db.DbClient
+	quotas   *services.QuotaService
+}
+
+func NewUsageHandler(dbclient db.DbClient, quotas *services.QuotaService) *UsageHandler {
+	return &UsageHandler{dbclient: dbclient, quotas: quotas}
+}
+
+// usageTotals sums tokens and estimated cost over some set of calls.
+type usageTotals struct {
+	Calls        int     `json:"calls"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+func (t *usageTotals) add(u models.UsageTotal) {
+	t.Calls += u.Calls
+	t.InputTokens += u.InputTokens
+	t.OutputTokens += u.OutputTokens
+	t.CostUSD += u.CostUSD
+}
+
+// usageModel is one operation and model within a day.
+type usageModel struct {
+	Operation string `json:"operation"`
+	Model     string `json:"model"`
+	usageTotals
+}
+
+// usageDay is one UTC day of usage, broken down by operation and model.
+type usageDay struct {
+	Date string `json:"date"`
+	usageTotals
+	ByModel []usageModel `json:"by_model"`
+}
+
+type usageResponse struct {
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Currency string      `json:"currency"`
+	Days     []usageDay  `json:"days"`
+	Total    usageTotals `json:"total"`
+}
+
+// GetUsage returns the authenticated user's daily token totals and cost estimates.
+// Query parameters from and to (YYYY-MM-DD, UTC, inclusive) default to the last 30 days.
+func (h *UsageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	from, to, err := usageRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now().UTC())
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	totals, err := h.dbclient.GetDailyUsage(r.Context(), userID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+
+	resp := usageResponse{
+		From:     from.Format(usageDateLayout),
+		To:       to.Format(usageDateLayout),
+		Currency: "USD",
+		Days:     []usageDay{},
+	}
+	for _, t := range totals {
+		date := t.Day.Format(usageDateLayout)
+		if n := len(resp.Days); n == 0 || resp.Days[n-1].Date != date {
+			resp.Days = append(resp.Days, usageDay{Date: date})
+		}
+		day := &resp.Days[len(resp.Days)-1]
+		m := usageModel{Operation: t.Operation, Model: t.Model}
+		m.add(t)
+		day.ByModel = append(day.ByModel, m)
+		day.add(t)
+		resp.Total.add(t)
+	}
+
+	respond.JSON(w, http.StatusOK, resp)
+}
+
+// GetQuota returns the authenticated user's plan, quota limits and remaining allowance.
+func (h *UsageHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.quotas.Status(r.Context(), userID)
+	if err != nil {
+		respond.Internal(w, r, err)
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, status)
+}
+
+// usageRange parses the inclusive [from, to] day range of a usage query.
+func usageRange(fromStr, toStr string, now time.Time) (from, to time.Time, err error) {
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if toStr != "" {
+		if to, err = time.Parse(usageDateLayout, toStr); err != nil {
+			return from, to, fmt.Errorf("invalid to date %q, want YYYY-MM-DD", toStr)
+		}
+	}
+	from = to.AddDate(0, 0, -(usageDefaultDays - 1))
+	if fromStr != "" {
+		if from, err = time.Parse(usageDateLayout, fromStr); err != nil {
+			return from, to, fmt.Errorf("invalid from date %q, want YYYY-MM-DD", fromStr)
+		}
+	}
+	if from.After(to) {
+		return from, to, fmt.Errorf("from %s is after to %s", from.Format(usageDateLayout), to.Format(usageDateLayout))
+	}
+	if to.Sub(from) >= usageMaxDays*24*time.Hour {
+		return from, to, fmt.Errorf("range is limited to %d days", usageMaxDays)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+type principalKey struct{}
+
+// AuthMiddleware authenticates a request by its X-API-Key header or its Bearer token,
+// which may be an access token or an API key, and attaches the resulting principal to the
+// request context; read it with PrincipalFrom. Revoked tokens and keys are rejected.
+func AuthMiddleware(tokens *services.TokenService, keys *services.APIKeyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, status, err := authenticate(r, tokens, keys)
+			if err != nil {
+				code := respond.CodeUnauthorized
+				if status == http.StatusInternalServerError {
+					code = respond.CodeInternal
+				}
+				respond.Error(w, status, code, err.Error())
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func authenticate(r *http.Request, tokens *services.TokenService, keys *services.APIKeyService) (*services.Principal, int, error) {
+	credential := r.Header.Get("X-API-Key")
+	if credential == "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, http.StatusUnauthorized, errors.New("missing or invalid token")
+		}
+		credential = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if strings.HasPrefix(credential, services.APIKeyPrefix) {
+		principal, err := keys.Authenticate(r.Context(), credential)
+		switch {
+		case errors.Is(err, services.ErrInvalidAPIKey):
+			return nil, http.StatusUnauthorized, err
+		case err != nil:
+			return nil, http.StatusInternalServerError, errors.New("failed to verify api key")
+		}
+		return principal, 0, nil
+	}
+
+	claims, err := tokens.Verify(r.Context(), credential)
+	switch {
+	case errors.Is(err, services.ErrRevokedToken):
+		return nil, http.StatusUnauthorized, errors.New("token has been revoked")
+	case errors.Is(err, services.ErrInvalidToken):
+		return nil, http.StatusUnauthorized, errors.New("invalid token")
+	case err != nil:
+		return nil, http.StatusInternalServerError, errors.New("failed to verify token")
+	}
+	return &services.Principal{
+		UserID: claims.UserID,
+		Method: services.AuthSession,
+		Scopes: services.Scopes,
+		Claims: claims,
+	}, 0, nil
+}
+
+// RequireScope rejects principals without scope with 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !PrincipalFrom(r.Context()).Can(scope) {
+				respond.Error(w, http.StatusForbidden, respond.CodeForbidden, fmt.Sprintf("credentials lack the %q scope", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects principals that did not log in, such as API keys, with 403.
+// Account and key management stay out of reach of a leaked key.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := PrincipalFrom(r.Context()); p == nil || p.Method != services.AuthSession {
+			respond.Error(w, http.StatusForbidden, respond.CodeForbidden, "this endpoint requires a logged-in session")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, p *services.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal attached by AuthMiddleware, or nil.
+func PrincipalFrom(ctx context.Context) *services.Principal {
+	p, _ := ctx.Value(principalKey{}).(*services.Principal)
+	return p
+}
+
+// ClaimsFrom returns the access token claims of a session principal, or nil.
+func ClaimsFrom(ctx context.Context) *services.AccessClaims {
+	if p := PrincipalFrom(ctx); p != nil {
+		return p.Claims
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/api/respond"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+	"github.com/markdave123-py/Contexta/internal/services"
+)
+
+// keyDB fails every API key lookup with err. Other methods fall through to the nil
+// embedded client and panic.
+type keyDB struct {
+	db.DbClient
+	err error
+}
+
+func (d keyDB) GetAPIKeyByPrefix(context.Context, string) (*models.APIKey, error) {
+	return nil, d.err
+}
+
+// sessionStore keeps the access token denylist and which refresh families were revoked.
+type sessionStore struct {
+	db.DbClient
+	denied   map[string]bool
+	families map[string]bool
+}
+
+func (d sessionStore) CreateRefreshToken(context.Context, *models.RefreshToken) error { return nil }
+
+func (d sessionStore) RevokeAccessToken(_ context.Context, jti, _ string, _ time.Time) error {
+	d.denied[jti] = true
+	return nil
+}
+
+func (d sessionStore) RevokeRefreshFamily(_ context.Context, familyID string) error {
+	d.families[familyID] = true
+	return nil
+}
+
+func (d sessionStore) IsAccessTokenRevoked(_ context.Context, jti, sessionID string) (bool, error) {
+	return d.denied[jti] || d.families[sessionID], nil
+}
+
+// reached answers 204 and records that the request got through.
+type reached bool
+
+func (h *reached) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	*h = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expectError checks rec is a JSON error envelope with status and code.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) respond.ErrorDetail {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d, want %d; body %s", rec.Code, status, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type %q, body %q", ct, rec.Body)
+	}
+	var body respond.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	if body.Error.Code != code || body.Error.Message == "" {
+		t.Fatalf("error %+v, want code %q with a message", body.Error, code)
+	}
+	return body.Error
+}
+
+func TestAuthMiddlewareAnswersWithJSONErrors(t *testing.T) {
+	store := keyDB{err: errors.New("dial tcp 10.0.0.5:5432: connection refused")}
+	tokens, err := services.NewTokenService(store, "k8#Qz!v2Lp9@wR4m^Xt7&Yb1-nF6%hJ3*cD0", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := AuthMiddleware(tokens, services.NewAPIKeyService(store))
+
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		status int
+		code   string
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized, respond.CodeUnauthorized},
+		{"not a bearer token", "Authorization", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, respond.CodeUnauthorized},
+		{"malformed token", "Authorization", "Bearer not.a.jwt", http.StatusUnauthorized, respond.CodeUnauthorized},
+		{"malformed api key", "X-API-Key", services.APIKeyPrefix + "nosecret", http.StatusUnauthorized, respond.CodeUnauthorized},
+		{"key store down", "X-API-Key", services.APIKeyPrefix + "abcd_secret", http.StatusInternalServerError, respond.CodeInternal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var next reached
+			r := httptest.NewRequest(http.MethodGet, "/documents", nil)
+			if c.header != "" {
+				r.Header.Set(c.header, c.value)
+			}
+			rec := httptest.NewRecorder()
+			mw(&next).ServeHTTP(rec, r)
+
+			e := expectError(t, rec, c.status, c.code)
+			if next {
+				t.Fatal("request reached the handler")
+			}
+			if strings.Contains(e.Message, "10.0.0.5") {
+				t.Fatalf("message %q reveals the store's error", e.Message)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsDenylistedTokens(t *testing.T) {
+	ctx := context.Background()
+	store := sessionStore{denied: map[string]bool{}, families: map[string]bool{}}
+	tokens, err := services.NewTokenService(store, "k8#Qz!v2Lp9@wR4m^Xt7&Yb1-nF6%hJ3*cD0", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := AuthMiddleware(tokens, services.NewAPIKeyService(store))
+	send := func(access string) (*httptest.ResponseRecorder, bool) {
+		var next reached
+		r := httptest.NewRequest(http.MethodGet, "/documents", nil)
+		r.Header.Set("Authorization", "Bearer "+access)
+		rec := httptest.NewRecorder()
+		mw(&next).ServeHTTP(rec, r)
+		return rec, bool(next)
+	}
+
+	pair, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := send(pair.AccessToken); !ok {
+		t.Fatalf("live token refused: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	claims, err := tokens.Verify(ctx, pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Denylisting alone, as ChangePassword does for the caller's token, ends it.
+	if err := tokens.RevokeAccess(ctx, claims); err != nil {
+		t.Fatal(err)
+	}
+	if !store.denied[claims.ID] || len(store.families) != 0 {
+		t.Fatalf("denied %v, revoked families %v", store.denied, store.families)
+	}
+	rec, ok := send(pair.AccessToken)
+	if ok {
+		t.Fatal("denylisted token reached the handler")
+	}
+	if e := expectError(t, rec, http.StatusUnauthorized, respond.CodeUnauthorized); !strings.Contains(e.Message, "revoked") {
+		t.Fatalf("message %q", e.Message)
+	}
+
+	// Only that token ended; logging out ends the other session's.
+	if rec, ok := send(other.AccessToken); !ok {
+		t.Fatalf("other session refused: status %d, body %s", rec.Code, rec.Body)
+	}
+	otherClaims, err := tokens.Verify(ctx, other.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Logout(ctx, otherClaims, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := send(other.AccessToken); ok {
+		t.Fatal("logged out token reached the handler")
+	}
+}
+
+func TestRequireScopeAndSession(t *testing.T) {
+	key := &services.Principal{UserID: "user-1", Method: services.AuthAPIKey, Scopes: []string{services.ScopeRead}}
+	session := &services.Principal{UserID: "user-1", Method: services.AuthSession, Scopes: services.Scopes}
+
+	cases := []struct {
+		name      string
+		mw        func(http.Handler) http.Handler
+		principal *services.Principal
+		allowed   bool
+	}{
+		{"scope held", RequireScope(services.ScopeRead), key, true},
+		{"scope missing", RequireScope(services.ScopeChat), key, false},
+		{"scope without principal", RequireScope(services.ScopeRead), nil, false},
+		{"session", RequireSession, session, true},
+		{"session with api key", RequireSession, key, false},
+		{"session without principal", RequireSession, nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var next reached
+			r := httptest.NewRequest(http.MethodGet, "/keys", nil)
+			if c.principal != nil {
+				r = r.WithContext(WithPrincipal(r.Context(), c.principal))
+			}
+			rec := httptest.NewRecorder()
+			c.mw(&next).ServeHTTP(rec, r)
+
+			if c.allowed {
+				if !next || rec.Code != http.StatusNoContent {
+					t.Fatalf("allowed request refused: status %d, body %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			expectError(t, rec, http.StatusForbidden, respond.CodeForbidden)
+			if next {
+				t.Fatal("request reached the handler")
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address of the client that sent the request. Behind a trusted
+// proxy, the server's RealIP middleware has already put the forwarded address in
+// RemoteAddr.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,40 +0,0 @@
-package middleware
-
-import (
-	"context"
-	"net/http"
-	"os"
-	"strings"
-
-	"github.com/golang-jwt/jwt/v5"
-)
-
-// JWTMiddleware validates the Authorization header and attaches user_id to the request context.
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			http.Error(w, "invalid token claims", http.StatusUnauthorized)
-			return
-		}
-
-		ctx := context.WithValue(r.Context(), "user_id", userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/markdave123-py/Contexta/internal/core/ratelimit"
+)
+
+func TestShareRoutesAreLimitedPerLinkAndIP(t *testing.T) {
+	var ok reached
+	r := chi.NewRouter()
+	r.Group(func(share chi.Router) {
+		share.Use(RateLimitBy(ratelimit.NewMemoryLimiter(), "share", ratelimit.Limit{Requests: 3, Per: time.Minute}, ShareLinkAndIP))
+		share.Get("/share/{token}", ok.ServeHTTP)
+		share.Post("/share/{token}/chat", ok.ServeHTTP)
+	})
+	send := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":4711"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Both routes of one link draw on the same bucket.
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodGet} {
+		path := "/share/tok-a"
+		if method == http.MethodPost {
+			path += "/chat"
+		}
+		if rec := send(method, path, "203.0.113.9"); rec.Code != http.StatusNoContent {
+			t.Fatalf("%s %s: status %d", method, path, rec.Code)
+		}
+	}
+	rec := send(http.MethodGet, "/share/tok-a", "203.0.113.9")
+	expectError(t, rec, http.StatusTooManyRequests, "rate_limited")
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("refused request has no Retry-After")
+	}
+
+	// Another link, or another client of the same link, has its own bucket.
+	if rec := send(http.MethodGet, "/share/tok-b", "203.0.113.9"); rec.Code != http.StatusNoContent {
+		t.Fatalf("other link: status %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/share/tok-a", "198.51.100.7"); rec.Code != http.StatusNoContent {
+		t.Fatalf("other client: status %d", rec.Code)
+	}
+}
+
+func TestResetMailIsLimitedPerAddress(t *testing.T) {
+	var got []string
+	r := chi.NewRouter()
+	r.With(RateLimitBy(ratelimit.NewMemoryLimiter(), "account_mail", ratelimit.Limit{Requests: 2, Per: time.Hour}, BodyEmail)).
+		Post("/auth/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			got = append(got, string(body))
+			w.WriteHeader(http.StatusAccepted)
+		})
+	forgot := func(email, ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/auth/password/forgot", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = ip + ":4711"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Spreading the requests over IPs, or changing the case, does not get more mail out.
+	for n, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		if code := forgot("victim@example.com", ip); code != http.StatusAccepted {
+			t.Fatalf("request %d: status %d", n+1, code)
+		}
+	}
+	if code := forgot(" Victim@Example.com", "203.0.113.3"); code != http.StatusTooManyRequests {
+		t.Fatalf("third mail to one address: status %d, want 429", code)
+	}
+	if code := forgot("someone@example.com", "203.0.113.3"); code != http.StatusAccepted {
+		t.Fatalf("other address: status %d", code)
+	}
+	if len(got) != 3 || got[0] != `{"email":"victim@example.com"}` {
+		t.Fatalf("handler read bodies %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Package respond writes the API's JSON responses. Every error, from a handler or a
+// middleware, has the same envelope:
+//
+//	{"error": {"code": "not_found", "message": "not found"}}
+//
+// Code is stable and meant for programs; message is for people and may change.
+package respond
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Error codes shared across the API. Handlers may use more specific codes of their own.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeNotFound     = "not_found"
+	CodeConflict     = "conflict"
+	CodeGone         = "gone"
+	CodeRateLimited  = "rate_limited"
+	CodeInternal     = "internal_error"
+)
+
+// ErrorBody is the error envelope.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes one error.
+//
+// Code:    machine-readable error code.
+// Message: human-readable description.
+// Details: optional structured data about the error, such as the quota that was hit.
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// JSON writes v as a JSON body with status.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("respond: encode %T: %v", v, err)
+	}
+}
+
+// Error writes an error envelope with status.
+func Error(w http.ResponseWriter, status int, code, message string) {
+	ErrorWithDetails(w, status, code, message, nil)
+}
+
+// ErrorWithDetails writes an error envelope with status and structured details.
+func ErrorWithDetails(w http.ResponseWriter, status int, code, message string, details any) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	JSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: message, Details: details}})
+}
+
+// Internal logs err and answers 500 without revealing it.
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	Error(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
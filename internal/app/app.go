@@ -5,13 +5,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/markdave123-py/Contexta/internal/config"
+	"github.com/markdave123-py/Contexta/internal/core"
 	db "github.com/markdave123-py/Contexta/internal/core/database"
 	"github.com/markdave123-py/Contexta/internal/core/ingestion_engine"
 	"github.com/markdave123-py/Contexta/internal/core/llm"
+	"github.com/markdave123-py/Contexta/internal/core/mailer"
 	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
+	"github.com/markdave123-py/Contexta/internal/core/ratelimit"
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+	"github.com/markdave123-py/Contexta/internal/services"
 )
 
 type App struct {
@@ -25,6 +32,11 @@ func NewApp(ctx context.Context, cfg *config.Config) (*App, error) {
 	appCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
+	// Refuse to start with a secret that tokens could be forged with.
+	if err := services.ValidateSecret(cfg.JWTSecret); err != nil {
+		return nil, err
+	}
+
 	dbClient, err := db.NewDatabaseClient(appCtx, cfg)
 	if err != nil {
 		return nil, err
@@ -38,33 +50,269 @@ func NewApp(ctx context.Context, cfg *config.Config) (*App, error) {
 	}
 	log.Println("Object client initialized and ready.")
 
+	tok, err := tokenizer.New(cfg.Tokenizer, cfg.TokenizerVocab)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize the tokenizer, %w", err)
+	}
+
+	priceTable := cfg.ModelPrices
+	if priceTable == "" {
+		priceTable = llm.DefaultPrices
+	}
+	prices, err := llm.ParsePrices(priceTable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MODEL_PRICES: %w", err)
+	}
+
 	geminiEmbedder, err := llm.NewGeminiEmbedder(appCtx, cfg.AIAPIKey, cfg.EmbedModel)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't initialize the embedder, %w", err)
 	}
+	// Metered inside the limiter so every provider request, retries included, is recorded once.
+	meteredEmbedder := llm.NewMeteredEmbedder(geminiEmbedder, cfg.EmbedModel, tok, prices, dbClient)
+	// Shared by every worker and the chat handler so the limits hold process-wide.
+	embedder := llm.NewLimitedEmbedder(meteredEmbedder, tok, llm.EmbedLimits{
+		RequestsPerMinute: cfg.EmbedRPM,
+		TokensPerMinute:   cfg.EmbedTPM,
+		MaxBatch:          cfg.EmbedMaxBatch,
+		MaxBatchTokens:    cfg.EmbedMaxBatchTokens,
+		MaxConcurrent:     cfg.EmbedConcurrency,
+		MaxRetries:        cfg.EmbedMaxRetries,
+	})
 
-	llmProvider, err := llm.NewGeminiLLM(appCtx, cfg.AIAPIKey, cfg.GenModel)
+	gemini, err := llm.NewGeminiLLM(appCtx, cfg.AIAPIKey, "")
 
 	if err != nil {
 		return nil, fmt.Errorf("couldn't initialize the embedder, %w", err)
 	}
+	router, err := newLLMRouter(cfg, gemini)
+	if err != nil {
+		return nil, err
+	}
+	llmProvider := llm.NewMeteredLLM(router, tok, prices, dbClient)
+
+	planTable := cfg.QuotaPlans
+	if planTable == "" {
+		planTable = services.DefaultQuotaPlans
+	}
+	plans, err := services.ParseQuotaPlans(planTable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUOTA_PLANS: %w", err)
+	}
+	if _, ok := plans[cfg.QuotaDefaultPlan]; !ok {
+		return nil, fmt.Errorf("QUOTA_DEFAULT_PLAN %q is not one of QUOTA_PLANS", cfg.QuotaDefaultPlan)
+	}
+	quotas := services.NewQuotaService(dbClient, plans, cfg.QuotaDefaultPlan)
+
+	tokens, err := services.NewTokenService(dbClient, cfg.JWTSecret,
+		time.Duration(cfg.AccessTokenTTLMinutes)*time.Minute, time.Duration(cfg.RefreshTokenTTLHours)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := services.NewOIDCService(dbClient, tokens, cfg.OIDCProviders, cfg.OIDCRedirectBaseURL, &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	mail, err := newMailer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	accounts := services.NewAccountService(dbClient, mail, cfg.AppBaseURL)
 
 	useReadability := false
-	documentExtractor := ingestion_engine.NewDocconvExtractor(useReadability)
+	documentExtractor := ingestion_engine.NewExtractorRegistry(ingestion_engine.NewDocconvExtractor(useReadability))
+	documentExtractor.Register(ingestion_engine.NewMarkdownExtractor(), ingestion_engine.MarkdownContentTypes, ingestion_engine.MarkdownExtensions)
+	ingestion_engine.RegisterCodeExtractor(documentExtractor, ingestion_engine.NewCodeExtractor())
+	documentExtractor.Register(ingestion_engine.NewOCRExtractor(ingestion_engine.OCRConfig{
+		Languages:   cfg.OCRLanguages,
+		MaxPages:    cfg.OCRMaxPages,
+		Parallelism: cfg.OCRParallelism,
+	}), ingestion_engine.OCRContentTypes, ingestion_engine.OCRExtensions)
 
 	ingCfg := &ingestion_engine.IngestConfig{
 		TargetTokens:  100,
 		OverlapTokens: 5,
 		BatchSize:     16,
+		EmbedModel:    cfg.EmbedModel,
+		ChunkStrategy: cfg.ChunkStrategy,
+		ChunkStrategyByType: map[string]string{
+			"text/markdown":   ingestion_engine.ChunkHeadings,
+			"text/x-markdown": ingestion_engine.ChunkHeadings,
+		},
+		Semantic: ingestion_engine.SemanticConfig{
+			MaxSentences: cfg.SemanticMaxSentences,
+			BatchSize:    cfg.SemanticBatchSize,
+		},
+		PageQuota: quotas,
 	}
+	// Row groups fit a chunk, so no chunk of a table loses its header.
+	documentExtractor.Register(ingestion_engine.NewTableExtractor(ingestion_engine.TableConfig{
+		MaxGroupTokens: ingCfg.TargetTokens,
+		Tokenizer:      tok,
+	}), ingestion_engine.TableContentTypes, ingestion_engine.TableExtensions)
 
-	docIngestor := ingestion_engine.NewDocumentIngestor(dbClient, objClient, geminiEmbedder, documentExtractor, ingCfg)
+	if !ingestion_engine.ValidChunkStrategy(ingCfg.ChunkStrategy) {
+		return nil, fmt.Errorf("unknown CHUNK_STRATEGY %q", ingCfg.ChunkStrategy)
+	}
 
-	server := NewServer(context.Background(), cfg, dbClient, objClient, docIngestor, geminiEmbedder, llmProvider)
+	docIngestor := ingestion_engine.NewDocumentIngestor(dbClient, objClient, embedder, documentExtractor, tok, ingCfg)
+
+	limits, err := newRateLimits(cfg, dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	server := NewServer(context.Background(), cfg, dbClient, objClient, docIngestor, embedder, llmProvider, quotas, tokens, services.NewAPIKeyService(dbClient), oidc, accounts, limits)
 
 	return &App{DBClient: dbClient.(*db.DatabaseClient), ObjectClient: objClient.(*objectclient.S3Client), DocProcessor: docIngestor, Server: server}, nil
 }
 
+// newLLMRouter routes answers to the configured model with the fallback models behind it,
+// and query rewrites to the cheaper rewrite model, falling back to the answer chain.
+// Models are named as [provider/]model, e.g. "openai/gpt-4o-mini"; without a provider
+// they are Gemini models.
+func newLLMRouter(cfg *config.Config, gemini *llm.GeminiLLM) (*llm.Router, error) {
+	primary := cfg.GenModel
+	if primary == "" {
+		primary = gemini.Model()
+	}
+	var answer []llm.Backend
+	seen := make(map[string]bool)
+	for _, spec := range append([]string{primary}, cfg.GenFallbackModels...) {
+		b, err := llmBackend(cfg, gemini, spec)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[b.Name] {
+			seen[b.Name] = true
+			answer = append(answer, b)
+		}
+	}
+
+	routes := map[core.Task][]llm.Backend{core.TaskAnswer: answer}
+	if cfg.RewriteModel != "" {
+		b, err := llmBackend(cfg, gemini, cfg.RewriteModel)
+		if err != nil {
+			return nil, fmt.Errorf("REWRITE_MODEL: %w", err)
+		}
+		routes[core.TaskRewrite] = []llm.Backend{b}
+		for _, a := range answer {
+			if a.Name != b.Name {
+				routes[core.TaskRewrite] = append(routes[core.TaskRewrite], a)
+			}
+		}
+	}
+
+	return llm.NewRouter(llm.RouterConfig{
+		Routes:           routes,
+		Default:          answer,
+		FailureThreshold: cfg.LLMFailureThreshold,
+		Cooldown:         time.Duration(cfg.LLMCooldownSeconds) * time.Second,
+	})
+}
+
+// llmBackend returns the router backend for a [provider/]model spec.
+func llmBackend(cfg *config.Config, gemini *llm.GeminiLLM, spec string) (llm.Backend, error) {
+	provider, model, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		provider, model = "gemini", provider
+	}
+	if model == "" {
+		return llm.Backend{}, fmt.Errorf("llm model %q: no model name", spec)
+	}
+
+	switch provider {
+	case "gemini":
+		return llm.Backend{Name: "gemini/" + model, Model: model, Provider: gemini.WithModel(model)}, nil
+	case "openai":
+		if cfg.OpenAIAPIKey == "" && cfg.OpenAIBaseURL == "" {
+			return llm.Backend{}, fmt.Errorf("llm model %q: set OPENAI_API_KEY, or OPENAI_BASE_URL for a local server", spec)
+		}
+		o, err := llm.NewOpenAILLM(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, model)
+		if err != nil {
+			return llm.Backend{}, err
+		}
+		return llm.Backend{Name: "openai/" + model, Model: model, Provider: o}, nil
+	default:
+		return llm.Backend{}, fmt.Errorf("llm model %q: unknown provider %q: want gemini or openai", spec, provider)
+	}
+}
+
+// RateLimits are the request limits of the expensive route groups.
+type RateLimits struct {
+	Limiter ratelimit.Limiter
+	Chat    ratelimit.Limit
+	Upload  ratelimit.Limit
+	Signup  ratelimit.Limit
+	Share   ratelimit.Limit
+
+	Account     ratelimit.Limit // per IP, on the public account link routes
+	AccountMail ratelimit.Limit // per email address, on password reset mail
+}
+
+// newRateLimits reads the route group limits and picks the limiter backend.
+func newRateLimits(cfg *config.Config, dbClient db.DbClient) (*RateLimits, error) {
+	chat, err := ratelimit.ParseLimit(cfg.RateLimitChat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CHAT: %w", err)
+	}
+	upload, err := ratelimit.ParseLimit(cfg.RateLimitUpload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_UPLOAD: %w", err)
+	}
+	signup, err := ratelimit.ParseLimit(cfg.RateLimitSignup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_SIGNUP: %w", err)
+	}
+	share, err := ratelimit.ParseLimit(cfg.RateLimitShare)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_SHARE: %w", err)
+	}
+	account, err := ratelimit.ParseLimit(cfg.RateLimitAccount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_ACCOUNT: %w", err)
+	}
+	accountMail, err := ratelimit.ParseLimit(cfg.RateLimitAccountMail)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_ACCOUNT_MAIL: %w", err)
+	}
+
+	limits := &RateLimits{Chat: chat, Upload: upload, Signup: signup, Share: share, Account: account, AccountMail: accountMail}
+	switch cfg.RateLimitBackend {
+	case "memory", "":
+		limits.Limiter = ratelimit.NewMemoryLimiter()
+	case "postgres":
+		// Keep buckets until they are certainly full again.
+		limits.Limiter = ratelimit.NewPostgresLimiter(dbClient, max(chat.Per, upload.Per, signup.Per, share.Per, account.Per, accountMail.Per, time.Hour))
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q: want memory or postgres", cfg.RateLimitBackend)
+	}
+	log.Printf("Rate limits (%s): chat %s, upload %s, signup %s, share %s, account links %s, reset mail %s.",
+		cfg.RateLimitBackend, chat, upload, signup, share, account, accountMail)
+	return limits, nil
+}
+
+// newMailer sends mail over SMTP when SMTP_HOST is set, and logs it otherwise.
+func newMailer(cfg *config.Config) (mailer.Mailer, error) {
+	if cfg.SMTPHost == "" {
+		log.Println("SMTP_HOST is not set; outgoing mail will be logged.")
+		return mailer.NewLogMailer(), nil
+	}
+	m, err := mailer.NewSMTPMailer(mailer.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP settings: %w", err)
+	}
+	return m, nil
+}
+
 func (a *App) Close() {
 	if a.DBClient != nil {
 		_ = a.DBClient.Close()
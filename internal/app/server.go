@@ -17,29 +17,58 @@ import (
 	db "github.com/markdave123-py/Contexta/internal/core/database"
 	"github.com/markdave123-py/Contexta/internal/core/ingestion_engine"
 	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
+	"github.com/markdave123-py/Contexta/internal/services"
 )
 
 // Server wraps the HTTP server instance and its handlers.
 type Server struct {
 	httpServer *http.Server
+	privacy    *services.PrivacyService
 }
 
 // NewServer builds and wires all routes.
-func NewServer(ctx context.Context, cfg *config.Config, db db.DbClient, obj objectclient.ObjectClient, ing ingestion_engine.Ingestor, emb core.EmbeddingProvider, llm core.LLMProvider) *Server {
-	authHandler := handlers.NewAuthHandler(db)
-	docHandler := handlers.NewDocumentHandler(db, obj, ing, cfg)
-	chatHandler := handlers.NewChatHandler(db, emb, llm)
+func NewServer(ctx context.Context, cfg *config.Config, db db.DbClient, obj objectclient.ObjectClient, ing ingestion_engine.Ingestor, emb core.EmbeddingProvider, llm core.LLMProvider, quotas *services.QuotaService, tokens *services.TokenService, keys *services.APIKeyService, oidc *services.OIDCService, accounts *services.AccountService, limits *RateLimits) *Server {
+	policy := services.NewPolicy(db)
+	orgs := services.NewOrgService(db, policy)
+	shares := services.NewShareService(db, policy)
+
+	guard := services.NewLoginGuard(services.LoginGuardConfig{
+		MaxAccountFailures: cfg.LoginMaxAccountFailures,
+		MaxIPFailures:      cfg.LoginMaxIPFailures,
+		Window:             time.Duration(cfg.LoginWindowMinutes) * time.Minute,
+		Lockout:            time.Duration(cfg.LoginLockoutMinutes) * time.Minute,
+	})
+
+	authHandler := handlers.NewAuthHandler(db, tokens, accounts, guard)
+	privacy := services.NewPrivacyService(db, obj, cfg.BucketName)
+	// Builds do not survive a restart; their users can ask again.
+	if err := privacy.FailInterruptedExports(ctx); err != nil {
+		log.Printf("startup: %v", err)
+	}
+	accountHandler := handlers.NewAccountHandler(accounts, privacy, tokens)
+	docHandler := handlers.NewDocumentHandler(db, obj, ing, quotas, policy, orgs, cfg)
+	chatHandler := handlers.NewChatHandler(db, emb, llm, quotas, policy, shares)
+	usageHandler := handlers.NewUsageHandler(db, quotas)
+	apiKeyHandler := handlers.NewAPIKeyHandler(keys)
+	oidcHandler := handlers.NewOIDCHandler(oidc)
+	orgHandler := handlers.NewOrgHandler(orgs)
+	shareHandler := handlers.NewShareHandler(shares)
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
+	if cfg.TrustProxyHeaders {
+		// Only behind a proxy that sets these headers; otherwise clients could pick their IP.
+		r.Use(middleware.RealIP)
+	}
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:8888"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-Share-Password"},
+		ExposedHeaders:   []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 	}))
 
@@ -49,16 +78,75 @@ func NewServer(ctx context.Context, cfg *config.Config, db db.DbClient, obj obje
 
 	// API routes
 	r.Route("/api", func(api chi.Router) {
+		api.NotFound(handlers.NotFound)
+		api.MethodNotAllowed(handlers.MethodNotAllowed)
+
 		// public endpoints
-		api.Post("/signup", authHandler.Signup)
+		api.With(appMiddleware.RateLimit(limits.Limiter, "signup", limits.Signup)).Post("/signup", authHandler.Signup)
 		api.Post("/login", authHandler.Login)
+		api.Post("/auth/refresh", authHandler.Refresh)
+		api.Get("/auth/oidc", oidcHandler.ListProviders)
+		api.Get("/auth/oidc/{provider}/login", oidcHandler.Login)
+		api.Get("/auth/oidc/{provider}/callback", oidcHandler.Callback)
+
+		// account links: these mail people or spend mailed tokens, so are limited per IP,
+		// and reset mail per address as well
+		api.Group(func(account chi.Router) {
+			account.Use(appMiddleware.RateLimit(limits.Limiter, "account", limits.Account))
+			account.Post("/auth/verify-email", accountHandler.VerifyEmail)
+			account.With(appMiddleware.RateLimitBy(limits.Limiter, "account_mail", limits.AccountMail, appMiddleware.BodyEmail)).Post("/auth/password/forgot", accountHandler.ForgotPassword)
+			account.Post("/auth/password/reset", accountHandler.ResetPassword)
+		})
 
-		// protected endpoints
+		// share links: the token is the credential, for one document's chat only
+		api.Group(func(share chi.Router) {
+			share.Use(appMiddleware.RateLimitBy(limits.Limiter, "share", limits.Share, appMiddleware.ShareLinkAndIP))
+			share.Get("/share/{token}", shareHandler.GetShare)
+			share.With(appMiddleware.RateLimit(limits.Limiter, "chat", limits.Chat)).Post("/share/{token}/chat", chatHandler.QuerySharedDocument)
+		})
+
+		// protected endpoints, for a logged-in session or an API key with the right scope
 		api.Group(func(protected chi.Router) {
-			protected.Use(appMiddleware.JWTMiddleware)
-			protected.Post("/documents/upload", docHandler.UploadDocument)
-			protected.Get("/documents", docHandler.GetDocuments)
-			protected.Post("/chat/query", chatHandler.QueryDocument)
+			protected.Use(appMiddleware.AuthMiddleware(tokens, keys))
+
+			protected.With(appMiddleware.RequireScope(services.ScopeUpload), appMiddleware.RateLimit(limits.Limiter, "upload", limits.Upload)).Post("/documents/upload", docHandler.UploadDocument)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/documents", docHandler.GetDocuments)
+			protected.With(appMiddleware.RequireScope(services.ScopeChat), appMiddleware.RateLimit(limits.Limiter, "chat", limits.Chat)).Post("/chat/query", chatHandler.QueryDocument)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/usage", usageHandler.GetUsage)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/usage/quota", usageHandler.GetQuota)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/orgs", orgHandler.ListOrgs)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/orgs/{orgID}/members", orgHandler.ListMembers)
+			protected.With(appMiddleware.RequireScope(services.ScopeRead)).Get("/me", accountHandler.GetMe)
+
+			// session only: API keys cannot manage the session or other keys
+			protected.Group(func(session chi.Router) {
+				session.Use(appMiddleware.RequireSession)
+				session.Post("/auth/logout", authHandler.Logout)
+				session.Patch("/me", accountHandler.UpdateMe)
+				session.Post("/me/password", accountHandler.ChangePassword)
+				session.Post("/me/verify-email", accountHandler.SendVerification)
+				session.Delete("/me", accountHandler.DeleteMe)
+				session.Post("/me/export", accountHandler.StartExport)
+				session.Get("/me/export", accountHandler.GetExport)
+				session.Get("/me/export/{id}", accountHandler.GetExport)
+				session.Get("/me/export/{id}/download", accountHandler.DownloadExport)
+				session.Post("/keys", apiKeyHandler.CreateAPIKey)
+				session.Get("/keys", apiKeyHandler.ListAPIKeys)
+				session.Patch("/keys/{id}", apiKeyHandler.RenameAPIKey)
+				session.Delete("/keys/{id}", apiKeyHandler.RevokeAPIKey)
+
+				session.Post("/orgs", orgHandler.CreateOrg)
+				session.Patch("/orgs/{orgID}/members/{userID}", orgHandler.SetMemberRole)
+				session.Delete("/orgs/{orgID}/members/{userID}", orgHandler.RemoveMember)
+				session.Post("/orgs/{orgID}/invitations", orgHandler.Invite)
+				session.Get("/orgs/{orgID}/invitations", orgHandler.ListInvitations)
+				session.Delete("/orgs/{orgID}/invitations/{id}", orgHandler.RevokeInvitation)
+				session.Post("/invitations/accept", orgHandler.AcceptInvitation)
+
+				session.Post("/documents/{id}/shares", shareHandler.CreateShare)
+				session.Get("/documents/{id}/shares", shareHandler.ListShares)
+				session.Delete("/documents/{id}/shares/{shareID}", shareHandler.RevokeShare)
+			})
 		})
 	})
 
@@ -67,7 +155,7 @@ func NewServer(ctx context.Context, cfg *config.Config, db db.DbClient, obj obje
 		Handler: r,
 	}
 
-	return &Server{httpServer: httpSrv}
+	return &Server{httpServer: httpSrv, privacy: privacy}
 }
 
 // Start runs the HTTP server.
@@ -78,8 +166,12 @@ func (s *Server) Start() {
 	}
 }
 
-// Shutdown gracefully stops the server.
+// Shutdown gracefully stops the server, then the data exports still being built.
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down HTTP server...")
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	if perr := s.privacy.Shutdown(ctx); err == nil {
+		err = perr
+	}
+	return err
 }
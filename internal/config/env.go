@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,74 @@ type Config struct {
 	GenModel      string
 	Port          string
 	NumProcessors int
+
+	OCRLanguages   string
+	OCRMaxPages    int
+	OCRParallelism int
+
+	Tokenizer      string
+	TokenizerVocab string
+	ChunkStrategy  string
+
+	SemanticMaxSentences int
+	SemanticBatchSize    int
+
+	EmbedRPM            int
+	EmbedTPM            int
+	EmbedMaxBatch       int
+	EmbedMaxBatchTokens int
+	EmbedConcurrency    int
+	EmbedMaxRetries     int
+
+	GenFallbackModels   []string // models to fail over to, as [provider/]model; provider gemini or openai
+	RewriteModel        string   // [provider/]model for query rewrites
+	LLMFailureThreshold int
+	LLMCooldownSeconds  int
+	OpenAIAPIKey        string
+	OpenAIBaseURL       string // any OpenAI-compatible chat completions API
+
+	ModelPrices string // model=input/output USD per million tokens, comma-separated; empty uses the built-in table
+
+	QuotaPlans       string // name:limit=value,...;name:... ; empty uses the built-in plans
+	QuotaDefaultPlan string // plan applied to users whose plan is not configured
+
+	JWTSecret             string
+	AccessTokenTTLMinutes int
+	RefreshTokenTTLHours  int
+
+	OIDCProviders       map[string]OIDCProvider // by name, from OIDC_PROVIDERS
+	OIDCRedirectBaseURL string                  // public base URL the providers redirect back to
+
+	AppBaseURL   string // public URL of the web app, for links sent by email
+	SMTPHost     string // empty logs mail instead of sending it
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	LoginMaxAccountFailures int  // failed logins to one email before it is locked
+	LoginMaxIPFailures      int  // failed logins from one IP before it is locked
+	LoginWindowMinutes      int  // how long a failed login counts
+	LoginLockoutMinutes     int  // how long a lockout lasts
+	TrustProxyHeaders       bool // take the client IP from X-Forwarded-For / X-Real-IP
+
+	RateLimitBackend     string // "memory" or "postgres"
+	RateLimitChat        string // requests/unit per client for chat queries, e.g. 30/m
+	RateLimitUpload      string // requests/unit per client for uploads, e.g. 20/h
+	RateLimitSignup      string // requests/unit per client IP for signups, e.g. 10/h
+	RateLimitShare       string // requests/unit per share link and client IP, e.g. 30/m
+	RateLimitAccount     string // requests/unit per client IP for email verification and password reset
+	RateLimitAccountMail string // password reset mails/unit per email address, e.g. 3/h
+}
+
+// OIDCProvider configures one external login provider, read from OIDC_<NAME>_* variables.
+// "google" defaults its issuer; "github" is plain OAuth2, where Issuer optionally names a
+// GitHub Enterprise host.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // in addition to openid; defaults to email and profile
 }
 
 // LoadConfig loads the environment variables and return config
@@ -41,6 +110,64 @@ func LoadConfig() *Config {
 		GenModel:     getEnv("GEN_MODEL", "gemini-1.5-flash"),
 		Port:         getEnv("PORT", "8080"),
 		NumProcessors: getEnvInt("NUMBER_OF_PROCESSORS", 5),
+
+		OCRLanguages:   getEnv("OCR_LANGUAGES", "eng"),
+		OCRMaxPages:    getEnvInt("OCR_MAX_PAGES", 50),
+		OCRParallelism: getEnvInt("OCR_PARALLELISM", 4),
+
+		Tokenizer:      getEnv("TOKENIZER", ""),
+		TokenizerVocab: getEnv("TOKENIZER_VOCAB", ""),
+		ChunkStrategy:  getEnv("CHUNK_STRATEGY", "recursive"),
+
+		SemanticMaxSentences: getEnvInt("SEMANTIC_MAX_SENTENCES", 4000),
+		SemanticBatchSize:    getEnvInt("SEMANTIC_BATCH_SIZE", 64),
+
+		EmbedRPM:            getEnvInt("EMBED_RPM", 1500),
+		EmbedTPM:            getEnvInt("EMBED_TPM", 1000000),
+		EmbedMaxBatch:       getEnvInt("EMBED_MAX_BATCH", 100),
+		EmbedMaxBatchTokens: getEnvInt("EMBED_MAX_BATCH_TOKENS", 20000),
+		EmbedConcurrency:    getEnvInt("EMBED_CONCURRENCY", 4),
+		EmbedMaxRetries:     getEnvInt("EMBED_MAX_RETRIES", 5),
+
+		GenFallbackModels:   getEnvList("GEN_FALLBACK_MODELS", []string{"gemini-2.5-flash"}),
+		RewriteModel:        getEnv("REWRITE_MODEL", "gemini-2.5-flash-lite"),
+		LLMFailureThreshold: getEnvInt("LLM_FAILURE_THRESHOLD", 3),
+		LLMCooldownSeconds:  getEnvInt("LLM_COOLDOWN_SECONDS", 30),
+		OpenAIAPIKey:        getEnv("OPENAI_API_KEY", ""),
+		OpenAIBaseURL:       getEnv("OPENAI_BASE_URL", ""),
+
+		ModelPrices: getEnv("MODEL_PRICES", ""),
+
+		QuotaPlans:       getEnv("QUOTA_PLANS", ""),
+		QuotaDefaultPlan: getEnv("QUOTA_DEFAULT_PLAN", "free"),
+
+		JWTSecret:             getEnv("JWT_SECRET", ""),
+		AccessTokenTTLMinutes: getEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15),
+		RefreshTokenTTLHours:  getEnvInt("REFRESH_TOKEN_TTL_HOURS", 720),
+
+		OIDCProviders:       loadOIDCProviders(),
+		OIDCRedirectBaseURL: getEnv("OIDC_REDIRECT_BASE_URL", "http://localhost:8888"),
+
+		AppBaseURL:   getEnv("APP_BASE_URL", "http://localhost:8888"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", ""),
+
+		LoginMaxAccountFailures: getEnvInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		LoginMaxIPFailures:      getEnvInt("LOGIN_MAX_IP_FAILURES", 50),
+		LoginWindowMinutes:      getEnvInt("LOGIN_WINDOW_MINUTES", 15),
+		LoginLockoutMinutes:     getEnvInt("LOGIN_LOCKOUT_MINUTES", 15),
+		TrustProxyHeaders:       getEnvBool("TRUST_PROXY_HEADERS", false),
+
+		RateLimitBackend:     getEnv("RATE_LIMIT_BACKEND", "memory"),
+		RateLimitChat:        getEnv("RATE_LIMIT_CHAT", "30/m"),
+		RateLimitUpload:      getEnv("RATE_LIMIT_UPLOAD", "20/h"),
+		RateLimitSignup:      getEnv("RATE_LIMIT_SIGNUP", "10/h"),
+		RateLimitShare:       getEnv("RATE_LIMIT_SHARE", "30/m"),
+		RateLimitAccount:     getEnv("RATE_LIMIT_ACCOUNT", "20/h"),
+		RateLimitAccountMail: getEnv("RATE_LIMIT_ACCOUNT_MAIL", "3/h"),
 	}
 
 	if cfg.DatabaseURL == "" {
@@ -58,6 +185,44 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS.
+func loadOIDCProviders() map[string]OIDCProvider {
+	providers := map[string]OIDCProvider{}
+	for _, name := range getEnvList("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer, scopes := "", []string{"email", "profile"}
+		switch name {
+		case "google":
+			issuer = "https://accounts.google.com"
+		case "github":
+			scopes = []string{"read:user", "user:email"}
+		}
+		providers[name] = OIDCProvider{
+			Issuer:       getEnv(prefix+"ISSUER", issuer),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getEnvList(prefix+"SCOPES", scopes),
+		}
+	}
+	return providers
+}
+
+// getEnvList reads a comma-separated list; an empty variable means an empty list.
+func getEnvList(key string, def []string) []string {
+	v, exists := os.LookupEnv(key)
+	if !exists {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func getEnvInt(key string, def int) int {
 	v := getEnv(key, "")
 	if v == "" {
@@ -70,3 +235,16 @@ func getEnvInt(key string, def int) int {
 	}
 	return n
 }
+
+func getEnvBool(key string, def bool) bool {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("WARN: %s=%q not a bool, using default %t", key, v, def)
+		return def
+	}
+	return b
+}
@@ -6,10 +6,14 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-//go:embed scripts/initdb.sql
+//go:embed scripts/initdb.sql scripts/migrations/*.sql
 
 var bootstrapFS embed.FS
 
@@ -21,7 +25,7 @@ func EnsureBootstrapped(ctx context.Context, db *sql.DB) error {
 	var exists bool
 	err := db.QueryRowContext(ctxBoot, `
 		SELECT EXISTS (
-		  SELECT 1 FROM information_schema.tables 
+		  SELECT 1 FROM information_schema.tables
 		  WHERE table_name = 'contexta_meta'
 		)`).
 		Scan(&exists)
@@ -29,14 +33,17 @@ func EnsureBootstrapped(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("meta table check failed: %w", err)
 	}
 
-	if exists{
+	if exists {
 		println("meta data already exists!")
 		// return nil
 	}
 
 	// 2) If table missing OR version row missing, run bootstrap.sql
 	if !exists {
-		return runBootstrap(ctxBoot, db)
+		if err := runBootstrap(ctxBoot, db); err != nil {
+			return err
+		}
+		return runMigrations(ctxBoot, db)
 	}
 
 	var hasVersion bool
@@ -44,10 +51,12 @@ func EnsureBootstrapped(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("meta version check failed: %w", err)
 	}
 	if !hasVersion {
-		return runBootstrap(ctxBoot, db)
+		if err := runBootstrap(ctxBoot, db); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return runMigrations(ctxBoot, db)
 }
 
 func runBootstrap(ctx context.Context, db *sql.DB) error {
@@ -55,12 +64,61 @@ func runBootstrap(ctx context.Context, db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("read initdb.sql: %w", err)
 	}
+	return execScript(ctx, db, string(sqlBytes))
+}
+
+// runMigrations applies every scripts/migrations/NNN_name.sql whose version is not yet
+// recorded in contexta_meta, in version order. Each script records its own version.
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	entries, err := bootstrapFS.ReadDir("scripts/migrations")
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+
+	type migration struct {
+		version int
+		name    string
+	}
+	var pending []migration
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			return fmt.Errorf("migration %q: missing version prefix", e.Name())
+		}
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("migration %q: invalid version: %w", e.Name(), err)
+		}
+		pending = append(pending, migration{version: v, name: e.Name()})
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].version < pending[b].version })
+
+	for _, m := range pending {
+		var applied bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM contexta_meta WHERE version = $1)`, m.version).Scan(&applied); err != nil {
+			return fmt.Errorf("meta version check failed: %w", err)
+		}
+		if applied {
+			continue
+		}
+
+		sqlBytes, err := bootstrapFS.ReadFile(path.Join("scripts/migrations", m.name))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", m.name, err)
+		}
+		if err := execScript(ctx, db, string(sqlBytes)); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
 
+func execScript(ctx context.Context, db *sql.DB, script string) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
-	if _, err := tx.ExecContext(ctx, string(sqlBytes)); err != nil {
+	if _, err := tx.ExecContext(ctx, script); err != nil {
 		_ = tx.Rollback()
 		return fmt.Errorf("exec bootstrap: %w", err)
 	}
@@ -3,12 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pgvector/pgvector-go"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -85,33 +87,407 @@ func (c *DatabaseClient) CreateUser(ctx context.Context, user *models.User) erro
 	if user == nil {
 		return errors.New("nil user")
 	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
 	const q = `
 		INSERT INTO users (id, first_name, email, password_hash, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, COALESCE($5, now()), COALESCE($6, now()))
 	`
-	_, err := c.db.ExecContext(ctx, q,
-		user.ID, user.FirstName, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt)
-	return err
+	if _, err := tx.ExecContext(ctx, q,
+		user.ID, user.FirstName, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt,
+	); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	// Every user gets a personal workspace for their documents.
+	personal := &models.Organization{ID: uuid.NewString(), Name: "Personal", Personal: true, CreatedBy: user.ID}
+	if err := createOrganization(ctx, tx, personal, user.ID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
+// GetUserByEmail looks the email up case-insensitively. Should accounts from before
+// emails were lowercased differ only in case, an exact match wins, then the oldest.
 func (c *DatabaseClient) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	const q = `
-		SELECT id, first_name, email, password_hash, created_at, updated_at
-		FROM users WHERE email = $1
+		SELECT ` + userColumns + `
+		FROM users WHERE lower(email) = lower($1)
+		ORDER BY email = $1 DESC, created_at
+		LIMIT 1
+	`
+	return scanUser(c.db.QueryRowContext(ctx, q, email))
+}
+
+func (c *DatabaseClient) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	const q = `
+		SELECT ` + userColumns + `
+		FROM users WHERE id = $1
 	`
-	var u models.User
-	err := c.db.QueryRowContext(ctx, q, email).Scan(
-		&u.ID, &u.FirstName, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt,
+	return scanUser(c.db.QueryRowContext(ctx, q, id))
+}
+
+// GetUserByIdentity returns the user linked to the provider's subject, or nil.
+func (c *DatabaseClient) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	const q = `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)
+	`
+	return scanUser(c.db.QueryRowContext(ctx, q, provider, subject))
+}
+
+// LinkIdentity links a provider subject to a user; an existing link is left as it is.
+func (c *DatabaseClient) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	if identity == nil {
+		return errors.New("nil identity")
+	}
+	const q = `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, now()))
+		ON CONFLICT (provider, subject) DO NOTHING
+	`
+	_, err := c.db.ExecContext(ctx, q,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email, nullTime(identity.CreatedAt))
+	return err
+}
+
+const userColumns = `id, first_name, email, password_hash, plan, email_verified_at, created_at, updated_at`
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var (
+		u        models.User
+		verified sql.NullTime
 	)
+	err := row.Scan(&u.ID, &u.FirstName, &u.Email, &u.PasswordHash, &u.Plan, &verified, &u.CreatedAt, &u.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	u.EmailVerifiedAt = nullTimePtr(verified)
 	return &u, nil
 }
 
+// UpdateUserProfile sets the user's editable profile fields.
+func (c *DatabaseClient) UpdateUserProfile(ctx context.Context, id, firstName string) error {
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE users SET first_name = $2, updated_at = now() WHERE id = $1`, id, firstName)
+	return err
+}
+
+func (c *DatabaseClient) UpdateUserPassword(ctx context.Context, id, passwordHash string) error {
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE users SET password_hash = $2, updated_at = now() WHERE id = $1`, id, passwordHash)
+	return err
+}
+
+// MarkEmailVerified records that the user verified email. It reports false if email is
+// no longer the user's address.
+func (c *DatabaseClient) MarkEmailVerified(ctx context.Context, id, email string) (bool, error) {
+	res, err := c.db.ExecContext(ctx, `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()), updated_at = now()
+		WHERE id = $1 AND email = $2`, id, email)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// CreateAccountToken stores a token and drops the user's earlier unused tokens of the
+// same purpose, so only the latest one mailed works.
+func (c *DatabaseClient) CreateAccountToken(ctx context.Context, token *models.AccountToken) error {
+	if token == nil {
+		return errors.New("nil account token")
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, token.UserID, token.Purpose); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	const q = `
+		INSERT INTO account_tokens (id, user_id, purpose, token_hash, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, now()))
+	`
+	if _, err := tx.ExecContext(ctx, q,
+		token.ID, token.UserID, token.Purpose, token.TokenHash, token.Email, token.ExpiresAt, nullTime(token.CreatedAt),
+	); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConsumeAccountToken spends an unused, unexpired token of the purpose and returns it, or
+// nil if there is none. A token can be consumed only once.
+func (c *DatabaseClient) ConsumeAccountToken(ctx context.Context, purpose, tokenHash string) (*models.AccountToken, error) {
+	const q = `
+		UPDATE account_tokens SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at
+	`
+	var (
+		t    models.AccountToken
+		used sql.NullTime
+	)
+	err := c.db.QueryRowContext(ctx, q, tokenHash, purpose).Scan(
+		&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.Email, &t.ExpiresAt, &used, &t.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.UsedAt = nullTimePtr(used)
+	return &t, nil
+}
+
+// Implementing the db interface for auth tokens
+
+// CreateRefreshToken stores a refresh token and drops the user's expired ones.
+func (c *DatabaseClient) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if token == nil {
+		return errors.New("nil refresh token")
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < now()`, token.UserID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	const q = `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()))
+	`
+	if _, err := tx.ExecContext(ctx, q,
+		token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, nullTime(token.CreatedAt),
+	); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRefreshToken returns the refresh token with the given hash, revoked or not, or nil.
+func (c *DatabaseClient) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	const q = `
+		SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, COALESCE(replaced_by::text, ''), created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+	var (
+		t       models.RefreshToken
+		revoked sql.NullTime
+	)
+	err := c.db.QueryRowContext(ctx, q, tokenHash).Scan(
+		&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &revoked, &t.ReplacedBy, &t.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
+
+// RevokeRefreshToken revokes an active refresh token, recording its successor if any.
+// It reports false if the token was already revoked, so two concurrent rotations of the
+// same token cannot both succeed.
+func (c *DatabaseClient) RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error) {
+	const q = `
+		UPDATE refresh_tokens
+		SET revoked_at = now(), replaced_by = NULLIF($2, '')::uuid
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+	res, err := c.db.ExecContext(ctx, q, id, replacedBy)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RevokeRefreshFamily revokes every active token of a refresh token family.
+func (c *DatabaseClient) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every active refresh token of the user.
+func (c *DatabaseClient) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// RevokeAccessToken denylists an access token until it expires, and drops denylist
+// entries whose tokens have expired anyway.
+func (c *DatabaseClient) RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	const q = `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, q, jti, userID, expiresAt); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsAccessTokenRevoked reports whether the access token with the given jti is
+// denylisted, or its session ended: a refresh token of the family sessionID was revoked
+// other than by rotation, as logout, reuse detection and password resets do.
+func (c *DatabaseClient) IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	const q = `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		    OR EXISTS (
+		        SELECT 1 FROM refresh_tokens
+		        WHERE family_id = NULLIF($2, '')::uuid AND revoked_at IS NOT NULL AND replaced_by IS NULL
+		    )
+	`
+	var revoked bool
+	err := c.db.QueryRowContext(ctx, q, jti, sessionID).Scan(&revoked)
+	return revoked, err
+}
+
+// Implementing the db interface for API keys
+
+func (c *DatabaseClient) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if key == nil {
+		return errors.New("nil api key")
+	}
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return fmt.Errorf("encode scopes: %w", err)
+	}
+	const q = `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, now()))
+	`
+	_, err = c.db.ExecContext(ctx, q,
+		key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash, string(scopes), key.ExpiresAt, nullTime(key.CreatedAt))
+	return err
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+// GetAPIKeyByPrefix returns the key with the given prefix, revoked or not, or nil.
+func (c *DatabaseClient) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanAPIKeys(rows)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return &keys[0], nil
+}
+
+// ListAPIKeys returns the user's keys, newest first, including revoked ones.
+func (c *DatabaseClient) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKeys(rows)
+}
+
+func (c *DatabaseClient) RenameAPIKey(ctx context.Context, userID, id, name string) (bool, error) {
+	res, err := c.db.ExecContext(ctx,
+		`UPDATE api_keys SET name = $3 WHERE id = $2 AND user_id = $1`, userID, id, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RevokeAPIKey revokes an active key; it reports false if there was none to revoke.
+func (c *DatabaseClient) RevokeAPIKey(ctx context.Context, userID, id string) (bool, error) {
+	res, err := c.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = now() WHERE id = $2 AND user_id = $1 AND revoked_at IS NULL`, userID, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RevokeUserAPIKeys revokes every active key of the user.
+func (c *DatabaseClient) RevokeUserAPIKeys(ctx context.Context, userID string) error {
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// TouchAPIKey records that a key was used, at most once a minute per key.
+func (c *DatabaseClient) TouchAPIKey(ctx context.Context, id string) error {
+	const q = `
+		UPDATE api_keys SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+	`
+	_, err := c.db.ExecContext(ctx, q, id)
+	return err
+}
+
+func scanAPIKeys(rows *sql.Rows) ([]models.APIKey, error) {
+	defer rows.Close()
+
+	var out []models.APIKey
+	for rows.Next() {
+		var (
+			k                         models.APIKey
+			scopes                    []byte
+			expires, lastUsed, revoke sql.NullTime
+		)
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes,
+			&expires, &lastUsed, &revoke, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(scopes, &k.Scopes); err != nil {
+			return nil, fmt.Errorf("decode api key scopes: %w", err)
+		}
+		k.ExpiresAt, k.LastUsedAt, k.RevokedAt = nullTimePtr(expires), nullTimePtr(lastUsed), nullTimePtr(revoke)
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
 // Implementing the db interface for Document
 
 func (c *DatabaseClient) CreateDocument(ctx context.Context, doc *models.Document) error {
@@ -120,24 +496,47 @@ func (c *DatabaseClient) CreateDocument(ctx context.Context, doc *models.Documen
 	}
 	const q = `
 		INSERT INTO documents
-			(id, user_id, file_name, storage_url, source_type, content_type, status, created_at, updated_at)
+			(id, user_id, org_id, file_name, storage_url, source_type, content_type, status, chunk_strategy, content_hash, size_bytes, created_at, updated_at)
 		VALUES
-			($1, $2, $3, $4, $5, $6, $7, COALESCE($8, now()), COALESCE($9, now()))
+			($1, $2, COALESCE(NULLIF($3, '')::uuid, (SELECT id FROM organizations WHERE personal AND created_by = $2)),
+			 $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, COALESCE($12, now()), COALESCE($13, now()))
+		RETURNING org_id
 	`
-	_, err := c.db.ExecContext(ctx, q,
-		doc.ID, doc.UserID, doc.FileName, doc.StorageURL, doc.SourceType, doc.ContentType, doc.Status, doc.CreatedAt, doc.UpdatedAt)
-	return err
+	// Without an organization the document goes to the uploader's personal workspace.
+	return c.db.QueryRowContext(ctx, q,
+		doc.ID, doc.UserID, doc.OrgID, doc.FileName, doc.StorageURL, doc.SourceType, doc.ContentType, doc.Status, doc.ChunkStrategy, doc.ContentHash, doc.SizeBytes, doc.CreatedAt, doc.UpdatedAt,
+	).Scan(&doc.OrgID)
 }
 
 func (c *DatabaseClient) GetDocumentByID(ctx context.Context, id string) (*models.Document, error) {
 	const q = `
-		SELECT id, user_id, file_name, storage_url, source_type, content_type, status, created_at, updated_at
+		SELECT ` + documentColumns + `
 		FROM documents
 		WHERE id = $1
 	`
+	return scanDocument(c.db.QueryRowContext(ctx, q, id))
+}
+
+// GetDocumentByHash returns the organization's most recent document with the given content hash, or nil.
+func (c *DatabaseClient) GetDocumentByHash(ctx context.Context, orgID, contentHash string) (*models.Document, error) {
+	const q = `
+		SELECT ` + documentColumns + `
+		FROM documents
+		WHERE org_id = $1 AND content_hash = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	return scanDocument(c.db.QueryRowContext(ctx, q, orgID, contentHash))
+}
+
+const documentColumns = `id, user_id, org_id, file_name, storage_url, source_type, content_type, status,
+		       COALESCE(failure_reason, ''), COALESCE(chunk_strategy, ''), COALESCE(content_hash, ''), size_bytes, page_count, created_at, updated_at`
+
+func scanDocument(row *sql.Row) (*models.Document, error) {
 	var d models.Document
-	err := c.db.QueryRowContext(ctx, q, id).Scan(
-		&d.ID, &d.UserID, &d.FileName, &d.StorageURL, &d.SourceType, &d.ContentType, &d.Status, &d.CreatedAt, &d.UpdatedAt,
+	err := row.Scan(
+		&d.ID, &d.UserID, &d.OrgID, &d.FileName, &d.StorageURL, &d.SourceType, &d.ContentType, &d.Status,
+		&d.FailureReason, &d.ChunkStrategy, &d.ContentHash, &d.SizeBytes, &d.PageCount, &d.CreatedAt, &d.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -150,12 +549,40 @@ func (c *DatabaseClient) GetDocumentByID(ctx context.Context, id string) (*model
 
 func (c *DatabaseClient) ListDocumentsByUser(ctx context.Context, userID string) ([]models.Document, error) {
 	const q = `
-		SELECT id, user_id, file_name, storage_url, source_type, status, created_at, updated_at
+		SELECT ` + documentListColumns + `
 		FROM documents
 		WHERE user_id = $1
 		ORDER BY created_at DESC
 	`
-	rows, err := c.db.QueryContext(ctx, q, userID)
+	return c.listDocuments(ctx, q, userID)
+}
+
+// ListDocumentsByOrg returns the organization's documents, newest first.
+func (c *DatabaseClient) ListDocumentsByOrg(ctx context.Context, orgID string) ([]models.Document, error) {
+	const q = `
+		SELECT ` + documentListColumns + `
+		FROM documents
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+	`
+	return c.listDocuments(ctx, q, orgID)
+}
+
+// ListMemberDocuments returns the documents of every organization the user belongs to, newest first.
+func (c *DatabaseClient) ListMemberDocuments(ctx context.Context, userID string) ([]models.Document, error) {
+	const q = `
+		SELECT ` + documentListColumns + `
+		FROM documents
+		WHERE org_id IN (SELECT org_id FROM org_memberships WHERE user_id = $1)
+		ORDER BY created_at DESC
+	`
+	return c.listDocuments(ctx, q, userID)
+}
+
+const documentListColumns = `id, user_id, org_id, file_name, storage_url, source_type, status, COALESCE(failure_reason, ''), size_bytes, page_count, created_at, updated_at`
+
+func (c *DatabaseClient) listDocuments(ctx context.Context, q string, args ...any) ([]models.Document, error) {
+	rows, err := c.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -165,7 +592,7 @@ func (c *DatabaseClient) ListDocumentsByUser(ctx context.Context, userID string)
 	for rows.Next() {
 		var d models.Document
 		if err := rows.Scan(
-			&d.ID, &d.UserID, &d.FileName, &d.StorageURL, &d.SourceType, &d.Status, &d.CreatedAt, &d.UpdatedAt,
+			&d.ID, &d.UserID, &d.OrgID, &d.FileName, &d.StorageURL, &d.SourceType, &d.Status, &d.FailureReason, &d.SizeBytes, &d.PageCount, &d.CreatedAt, &d.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -177,7 +604,7 @@ func (c *DatabaseClient) ListDocumentsByUser(ctx context.Context, userID string)
 func (c *DatabaseClient) UpdateDocumentStatus(ctx context.Context, id string, status string) error {
 	const q = `
 		UPDATE documents
-		SET status = $2, updated_at = now()
+		SET status = $2, failure_reason = NULL, updated_at = now()
 		WHERE id = $1
 	`
 	res, err := c.db.ExecContext(ctx, q, id, status)
@@ -191,6 +618,42 @@ func (c *DatabaseClient) UpdateDocumentStatus(ctx context.Context, id string, st
 	return nil
 }
 
+// MarkDocumentFailed sets the document status to failed and records why.
+func (c *DatabaseClient) MarkDocumentFailed(ctx context.Context, id string, reason string) error {
+	const q = `
+		UPDATE documents
+		SET status = 'failed', failure_reason = $2, updated_at = now()
+		WHERE id = $1
+	`
+	res, err := c.db.ExecContext(ctx, q, id, reason)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("document not found: %s", id)
+	}
+	return nil
+}
+
+// SetDocumentPageCount records how many pages ingesting the document consumed.
+func (c *DatabaseClient) SetDocumentPageCount(ctx context.Context, id string, pages int) error {
+	const q = `
+		UPDATE documents
+		SET page_count = $2, updated_at = now()
+		WHERE id = $1
+	`
+	res, err := c.db.ExecContext(ctx, q, id, pages)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("document not found: %s", id)
+	}
+	return nil
+}
+
 // // Implementing the db interface for Document Chunks
 
 // InsertDocumentChunks inserts chunks in a single transaction.
@@ -205,8 +668,9 @@ func (c *DatabaseClient) InsertDocumentChunks(ctx context.Context, chunks []mode
 
 	const q = `
 		INSERT INTO document_chunks
-			(id, document_id, position, text, embedding, token_count, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, now()))
+			(id, document_id, position, text, embedding, token_count,
+			 page_start, page_end, heading_path, source_offset, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0), NULLIF($8, 0), $9, $10, $11, COALESCE($12, now()))
 	`
 	stmt, err := tx.PrepareContext(ctx, q)
 	if err != nil {
@@ -219,9 +683,16 @@ func (c *DatabaseClient) InsertDocumentChunks(ctx context.Context, chunks []mode
 		ch := &chunks[i]
 		vec := pgvector.NewVector(ch.Embedding)
 
+		headings, meta, err := encodeChunkSource(ch)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
 		// Embedding []float32 maps to pgvector via pgx stdlib; ensure your pgx/stdlib is imported.
 		if _, err := stmt.ExecContext(ctx,
-			ch.ID, ch.DocumentID, ch.Position, ch.Text, vec, ch.TokenCount, ch.CreatedAt,
+			ch.ID, ch.DocumentID, ch.Position, ch.Text, vec, ch.TokenCount,
+			ch.PageStart, ch.PageEnd, headings, ch.SourceOffset, meta, ch.CreatedAt,
 		); err != nil {
 			_ = tx.Rollback()
 			return err
@@ -230,9 +701,17 @@ func (c *DatabaseClient) InsertDocumentChunks(ctx context.Context, chunks []mode
 	return tx.Commit()
 }
 
+// DeleteDocumentChunks deletes every chunk of a document, such as those a failed
+// ingestion left behind.
+func (c *DatabaseClient) DeleteDocumentChunks(ctx context.Context, documentID string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM document_chunks WHERE document_id = $1`, documentID)
+	return err
+}
+
 func (c *DatabaseClient) GetChunksByDocument(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
 	const q = `
-		SELECT id, document_id, position, text, embedding, token_count, created_at
+		SELECT id, document_id, position, text, embedding, token_count,
+		       COALESCE(page_start, 0), COALESCE(page_end, 0), heading_path, source_offset, metadata, created_at
 		FROM document_chunks
 		WHERE document_id = $1
 		ORDER BY position ASC
@@ -245,12 +724,21 @@ func (c *DatabaseClient) GetChunksByDocument(ctx context.Context, documentID str
 
 	var out []models.DocumentChunk
 	for rows.Next() {
-		var ch models.DocumentChunk
+		var (
+			ch             models.DocumentChunk
+			emb            pgvector.Vector
+			headings, meta []byte
+		)
 		if err := rows.Scan(
-			&ch.ID, &ch.DocumentID, &ch.Position, &ch.Text, &ch.Embedding, &ch.TokenCount, &ch.CreatedAt,
+			&ch.ID, &ch.DocumentID, &ch.Position, &ch.Text, &emb, &ch.TokenCount,
+			&ch.PageStart, &ch.PageEnd, &headings, &ch.SourceOffset, &meta, &ch.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
+		ch.Embedding = emb.Slice()
+		if err := decodeChunkSource(&ch, headings, meta); err != nil {
+			return nil, err
+		}
 		out = append(out, ch)
 	}
 	return out, rows.Err()
@@ -259,7 +747,8 @@ func (c *DatabaseClient) GetChunksByDocument(ctx context.Context, documentID str
 // SearchDocumentChunks finds top-k similar chunks within a document for a query embedding.
 func (c *DatabaseClient) SearchDocumentChunks(ctx context.Context, docID string, queryVec []float32, limit int) ([]models.DocumentChunk, error) {
 	const q = `
-        SELECT id, document_id, position, text, embedding, token_count
+        SELECT id, document_id, position, text, embedding, token_count,
+               COALESCE(page_start, 0), COALESCE(page_end, 0), heading_path, source_offset, metadata
         FROM document_chunks
         WHERE document_id = $1
         ORDER BY embedding <-> $2
@@ -275,14 +764,928 @@ func (c *DatabaseClient) SearchDocumentChunks(ctx context.Context, docID string,
 	var out []models.DocumentChunk
 	for rows.Next() {
 		var (
-			ch  models.DocumentChunk
-			emb pgvector.Vector
+			ch             models.DocumentChunk
+			emb            pgvector.Vector
+			headings, meta []byte
 		)
-		if err := rows.Scan(&ch.ID, &ch.DocumentID, &ch.Position, &ch.Text, &emb, &ch.TokenCount); err != nil {
+		if err := rows.Scan(&ch.ID, &ch.DocumentID, &ch.Position, &ch.Text, &emb, &ch.TokenCount,
+			&ch.PageStart, &ch.PageEnd, &headings, &ch.SourceOffset, &meta); err != nil {
 			return nil, err
 		}
 		ch.Embedding = emb.Slice()
+		if err := decodeChunkSource(&ch, headings, meta); err != nil {
+			return nil, err
+		}
 		out = append(out, ch)
 	}
 	return out, nil
 }
+
+// GetCachedEmbeddings returns the cached embeddings for the given text hashes under model.
+// Hashes without an entry are absent from the map.
+func (c *DatabaseClient) GetCachedEmbeddings(ctx context.Context, model string, textHashes []string) (map[string][]float32, error) {
+	out := make(map[string][]float32, len(textHashes))
+	if len(textHashes) == 0 {
+		return out, nil
+	}
+	const q = `
+		SELECT text_hash, embedding
+		FROM embedding_cache
+		WHERE model = $1 AND text_hash = ANY($2)
+	`
+	rows, err := c.db.QueryContext(ctx, q, model, textHashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			hash string
+			emb  pgvector.Vector
+		)
+		if err := rows.Scan(&hash, &emb); err != nil {
+			return nil, err
+		}
+		out[hash] = emb.Slice()
+	}
+	return out, rows.Err()
+}
+
+// PutCachedEmbeddings stores embeddings by text hash under model; existing entries are kept.
+func (c *DatabaseClient) PutCachedEmbeddings(ctx context.Context, model string, embeddings map[string][]float32) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	const q = `
+		INSERT INTO embedding_cache (model, text_hash, embedding)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (model, text_hash) DO NOTHING
+	`
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for hash, emb := range embeddings {
+		if _, err := stmt.ExecContext(ctx, model, hash, pgvector.NewVector(emb)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetOrCreateChatSession returns the user's latest chat session on the document, creating one if none exists.
+func (c *DatabaseClient) GetOrCreateChatSession(ctx context.Context, userID, documentID string) (*models.ChatSession, error) {
+	const sel = `
+		SELECT id, user_id, document_id, created_at
+		FROM chat_sessions
+		WHERE user_id = $1 AND document_id = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	var s models.ChatSession
+	err := c.db.QueryRowContext(ctx, sel, userID, documentID).Scan(&s.ID, &s.UserID, &s.DocumentID, &s.CreatedAt)
+	if err == nil {
+		return &s, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	const ins = `
+		INSERT INTO chat_sessions (user_id, document_id)
+		VALUES ($1, $2)
+		RETURNING id, user_id, document_id, created_at
+	`
+	if err := c.db.QueryRowContext(ctx, ins, userID, documentID).Scan(&s.ID, &s.UserID, &s.DocumentID, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *DatabaseClient) AddChatMessage(ctx context.Context, message *models.ChatMessage) error {
+	if message == nil {
+		return errors.New("nil chat message")
+	}
+	const q = `
+		INSERT INTO chat_messages (id, session_id, role, content, created_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, now()))
+	`
+	_, err := c.db.ExecContext(ctx, q,
+		message.ID, message.SessionID, message.Role, message.Content, nullTime(message.CreatedAt))
+	return err
+}
+
+// ListChatTranscripts returns the user's chat sessions with their messages, oldest first.
+func (c *DatabaseClient) ListChatTranscripts(ctx context.Context, userID string) ([]models.ChatTranscript, error) {
+	const q = `
+		SELECT s.id, s.document_id, d.file_name, s.created_at, m.id, m.role, m.content, m.created_at
+		FROM chat_sessions s
+		JOIN documents d ON d.id = s.document_id
+		LEFT JOIN chat_messages m ON m.session_id = s.id
+		WHERE s.user_id = $1
+		ORDER BY s.created_at, s.id, m.created_at
+	`
+	rows, err := c.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ChatTranscript
+	for rows.Next() {
+		var (
+			t                    models.ChatTranscript
+			msgID, role, content sql.NullString
+			msgAt                sql.NullTime
+		)
+		if err := rows.Scan(&t.SessionID, &t.DocumentID, &t.FileName, &t.CreatedAt, &msgID, &role, &content, &msgAt); err != nil {
+			return nil, err
+		}
+		if len(out) == 0 || out[len(out)-1].SessionID != t.SessionID {
+			t.Messages = []models.ChatMessage{}
+			out = append(out, t)
+		}
+		if msgID.Valid {
+			last := &out[len(out)-1]
+			last.Messages = append(last.Messages, models.ChatMessage{
+				ID: msgID.String, SessionID: t.SessionID, Role: role.String, Content: content.String, CreatedAt: msgAt.Time,
+			})
+		}
+	}
+	return out, rows.Err()
+}
+
+// ListRecentChatMessages returns the session's last limit messages, oldest first.
+func (c *DatabaseClient) ListRecentChatMessages(ctx context.Context, sessionID string, limit int) ([]models.ChatMessage, error) {
+	const q = `
+		SELECT id, session_id, role, content, created_at FROM (
+			SELECT id, session_id, role, content, created_at
+			FROM chat_messages
+			WHERE session_id = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at
+	`
+	rows, err := c.db.QueryContext(ctx, q, sessionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ChatMessage
+	for rows.Next() {
+		var m models.ChatMessage
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// InsertUsageRecords stores usage records in a single transaction.
+func (c *DatabaseClient) InsertUsageRecords(ctx context.Context, records []models.UsageRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	const q = `
+		INSERT INTO usage_records
+			(id, user_id, document_id, message_id, operation, model, input_tokens, output_tokens, cost_usd, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, $6, $7, $8, $9, COALESCE($10, now()))
+	`
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if _, err := stmt.ExecContext(ctx,
+			r.ID, r.UserID, r.DocumentID, r.MessageID, r.Operation, r.Model,
+			r.InputTokens, r.OutputTokens, r.CostUSD, nullTime(r.CreatedAt),
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDailyUsage sums the user's usage per UTC day, operation and model for days in [from, to).
+func (c *DatabaseClient) GetDailyUsage(ctx context.Context, userID string, from, to time.Time) ([]models.UsageTotal, error) {
+	const q = `
+		SELECT date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, operation, model,
+		       count(*), COALESCE(sum(input_tokens), 0), COALESCE(sum(output_tokens), 0), COALESCE(sum(cost_usd), 0)
+		FROM usage_records
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY day, operation, model
+		ORDER BY day ASC, operation ASC, model ASC
+	`
+	rows, err := c.db.QueryContext(ctx, q, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.UsageTotal
+	for rows.Next() {
+		var t models.UsageTotal
+		if err := rows.Scan(&t.Day, &t.Operation, &t.Model, &t.Calls, &t.InputTokens, &t.OutputTokens, &t.CostUSD); err != nil {
+			return nil, err
+		}
+		t.Day = time.Date(t.Day.Year(), t.Day.Month(), t.Day.Day(), 0, 0, 0, 0, time.UTC)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetUserQuota returns the user's plan and per-user limit overrides, or nil if the user does not exist.
+func (c *DatabaseClient) GetUserQuota(ctx context.Context, userID string) (*models.UserQuota, error) {
+	const q = `
+		SELECT u.plan, q.max_bytes, q.max_documents, q.max_pages_per_month, q.max_queries_per_day
+		FROM users u
+		LEFT JOIN user_quotas q ON q.user_id = u.id
+		WHERE u.id = $1
+	`
+	var (
+		uq                       models.UserQuota
+		maxBytes                 sql.NullInt64
+		maxDocs, maxPages, maxQs sql.NullInt32
+	)
+	err := c.db.QueryRowContext(ctx, q, userID).Scan(&uq.Plan, &maxBytes, &maxDocs, &maxPages, &maxQs)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes.Valid {
+		uq.MaxBytes = &maxBytes.Int64
+	}
+	uq.MaxDocuments = nullIntPtr(maxDocs)
+	uq.MaxPagesPerMonth = nullIntPtr(maxPages)
+	uq.MaxQueriesPerDay = nullIntPtr(maxQs)
+	return &uq, nil
+}
+
+// GetQuotaUsage returns what the user has stored, the pages of documents uploaded since
+// monthStart, and the chat queries sent since dayStart.
+func (c *DatabaseClient) GetQuotaUsage(ctx context.Context, userID string, monthStart, dayStart time.Time) (*models.QuotaUsage, error) {
+	const q = `
+		SELECT
+			(SELECT COALESCE(sum(size_bytes), 0) FROM documents WHERE user_id = $1),
+			(SELECT count(*) FROM documents WHERE user_id = $1),
+			(SELECT COALESCE(sum(page_count), 0) FROM documents WHERE user_id = $1 AND created_at >= $2),
+			(SELECT count(*)
+			   FROM chat_messages m
+			   JOIN chat_sessions s ON s.id = m.session_id
+			  WHERE s.user_id = $1 AND m.role = 'user' AND m.created_at >= $3)
+			+ (SELECT count(*) FROM share_queries WHERE user_id = $1 AND created_at >= $3)
+	`
+	var u models.QuotaUsage
+	if err := c.db.QueryRowContext(ctx, q, userID, monthStart, dayStart).Scan(
+		&u.Bytes, &u.Documents, &u.PagesThisMonth, &u.QueriesToday,
+	); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Implementing the db interface for organizations
+
+// execer is what createOrganization needs from a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// CreateOrganization creates an organization with ownerID as its first owner.
+func (c *DatabaseClient) CreateOrganization(ctx context.Context, org *models.Organization, ownerID string) error {
+	if org == nil {
+		return errors.New("nil organization")
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := createOrganization(ctx, tx, org, ownerID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func createOrganization(ctx context.Context, db execer, org *models.Organization, ownerID string) error {
+	const q = `
+		INSERT INTO organizations (id, name, personal, created_by, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid, COALESCE($5, now()))
+	`
+	if _, err := db.ExecContext(ctx, q, org.ID, org.Name, org.Personal, org.CreatedBy, nullTime(org.CreatedAt)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO org_memberships (org_id, user_id, role) VALUES ($1, $2, 'owner')`, org.ID, ownerID)
+	return err
+}
+
+const orgColumns = `o.id, o.name, o.personal, COALESCE(o.created_by::text, ''), o.created_at`
+
+func (c *DatabaseClient) GetOrganization(ctx context.Context, id string) (*models.Organization, error) {
+	var o models.Organization
+	err := c.db.QueryRowContext(ctx, `SELECT `+orgColumns+` FROM organizations o WHERE o.id = $1`, id).Scan(
+		&o.ID, &o.Name, &o.Personal, &o.CreatedBy, &o.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ListOrganizations returns the organizations the user belongs to with their role in each,
+// personal workspace first.
+func (c *DatabaseClient) ListOrganizations(ctx context.Context, userID string) ([]models.Organization, error) {
+	const q = `
+		SELECT ` + orgColumns + `, m.role
+		FROM organizations o
+		JOIN org_memberships m ON m.org_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.personal DESC, o.name, o.created_at
+	`
+	rows, err := c.db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Organization
+	for rows.Next() {
+		var o models.Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Personal, &o.CreatedBy, &o.CreatedAt, &o.Role); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// GetMembership returns the user's membership of the organization, or nil.
+func (c *DatabaseClient) GetMembership(ctx context.Context, orgID, userID string) (*models.Membership, error) {
+	const q = `
+		SELECT org_id, user_id, role, created_at
+		FROM org_memberships
+		WHERE org_id = $1 AND user_id = $2
+	`
+	var m models.Membership
+	err := c.db.QueryRowContext(ctx, q, orgID, userID).Scan(&m.OrgID, &m.UserID, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListMemberships returns the organization's members with their emails, oldest first.
+func (c *DatabaseClient) ListMemberships(ctx context.Context, orgID string) ([]models.Membership, error) {
+	const q = `
+		SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.created_at
+	`
+	rows, err := c.db.QueryContext(ctx, q, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Membership
+	for rows.Next() {
+		var m models.Membership
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// lastOwnerGuard keeps an organization from losing its last owner; $1 is the org and
+// the statement's target row is the membership being changed.
+const lastOwnerGuard = `(role <> 'owner' OR (SELECT count(*) FROM org_memberships WHERE org_id = $1 AND role = 'owner') > 1)`
+
+// SetMembershipRole changes a member's role. It reports false if there is no such member,
+// or the change would leave the organization without an owner.
+func (c *DatabaseClient) SetMembershipRole(ctx context.Context, orgID, userID, role string) (bool, error) {
+	q := `UPDATE org_memberships SET role = $3 WHERE org_id = $1 AND user_id = $2 AND (role = $3 OR $3 = 'owner' OR ` + lastOwnerGuard + `)`
+	res, err := c.db.ExecContext(ctx, q, orgID, userID, role)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RemoveMembership removes a member. It reports false if there is no such member, or they
+// are the organization's last owner.
+func (c *DatabaseClient) RemoveMembership(ctx context.Context, orgID, userID string) (bool, error) {
+	q := `DELETE FROM org_memberships WHERE org_id = $1 AND user_id = $2 AND ` + lastOwnerGuard
+	res, err := c.db.ExecContext(ctx, q, orgID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (c *DatabaseClient) CreateInvitation(ctx context.Context, inv *models.Invitation) error {
+	if inv == nil {
+		return errors.New("nil invitation")
+	}
+	const q = `
+		INSERT INTO org_invitations (id, org_id, email, role, token_hash, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7, COALESCE($8, now()))
+	`
+	_, err := c.db.ExecContext(ctx, q,
+		inv.ID, inv.OrgID, inv.Email, inv.Role, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt, nullTime(inv.CreatedAt))
+	return err
+}
+
+const invitationColumns = `id, org_id, email, role, token_hash, COALESCE(invited_by::text, ''), expires_at, accepted_at, created_at`
+
+// GetInvitationByTokenHash returns the invitation with the given token hash, accepted or not, or nil.
+func (c *DatabaseClient) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+invitationColumns+` FROM org_invitations WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	invs, err := scanInvitations(rows)
+	if err != nil || len(invs) == 0 {
+		return nil, err
+	}
+	return &invs[0], nil
+}
+
+// ListInvitations returns the organization's pending invitations, newest first.
+func (c *DatabaseClient) ListInvitations(ctx context.Context, orgID string) ([]models.Invitation, error) {
+	const q = `
+		SELECT ` + invitationColumns + `
+		FROM org_invitations
+		WHERE org_id = $1 AND accepted_at IS NULL AND expires_at > now()
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.QueryContext(ctx, q, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvitations(rows)
+}
+
+// DeleteInvitation withdraws a pending invitation; it reports false if there was none.
+func (c *DatabaseClient) DeleteInvitation(ctx context.Context, orgID, id string) (bool, error) {
+	res, err := c.db.ExecContext(ctx,
+		`DELETE FROM org_invitations WHERE id = $2 AND org_id = $1 AND accepted_at IS NULL`, orgID, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// AcceptInvitation spends a pending, unexpired invitation and makes the user a member with
+// its role; an existing membership keeps its role. It reports false if the invitation
+// could not be spent.
+func (c *DatabaseClient) AcceptInvitation(ctx context.Context, id, userID string) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	const spend = `
+		UPDATE org_invitations SET accepted_at = now()
+		WHERE id = $1 AND accepted_at IS NULL AND expires_at > now()
+		RETURNING org_id, role
+	`
+	var orgID, role string
+	err = tx.QueryRowContext(ctx, spend, id).Scan(&orgID, &role)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	const join = `
+		INSERT INTO org_memberships (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, join, orgID, userID, role); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func scanInvitations(rows *sql.Rows) ([]models.Invitation, error) {
+	defer rows.Close()
+
+	var out []models.Invitation
+	for rows.Next() {
+		var (
+			inv      models.Invitation
+			accepted sql.NullTime
+		)
+		if err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy,
+			&inv.ExpiresAt, &accepted, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		inv.AcceptedAt = nullTimePtr(accepted)
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+// Implementing the db interface for share links
+
+func (c *DatabaseClient) CreateShareLink(ctx context.Context, link *models.ShareLink) error {
+	if link == nil {
+		return errors.New("nil share link")
+	}
+	const q = `
+		INSERT INTO share_links (id, document_id, created_by, token_hash, password_hash, expires_at, max_queries, created_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, NULLIF($5, ''), $6, $7, COALESCE($8, now()))
+	`
+	_, err := c.db.ExecContext(ctx, q,
+		link.ID, link.DocumentID, link.CreatedBy, link.TokenHash, link.PasswordHash, link.ExpiresAt, link.MaxQueries, nullTime(link.CreatedAt))
+	return err
+}
+
+const shareLinkColumns = `id, document_id, COALESCE(created_by::text, ''), token_hash, COALESCE(password_hash, ''),
+		       expires_at, max_queries, query_count, last_used_at, revoked_at, created_at`
+
+// GetShareLinkByTokenHash returns the link with the given token hash, usable or not, or nil.
+func (c *DatabaseClient) GetShareLinkByTokenHash(ctx context.Context, tokenHash string) (*models.ShareLink, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	links, err := scanShareLinks(rows)
+	if err != nil || len(links) == 0 {
+		return nil, err
+	}
+	return &links[0], nil
+}
+
+// ListShareLinks returns the document's links, newest first, including revoked and expired ones.
+func (c *DatabaseClient) ListShareLinks(ctx context.Context, documentID string) ([]models.ShareLink, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT `+shareLinkColumns+` FROM share_links WHERE document_id = $1 ORDER BY created_at DESC`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	return scanShareLinks(rows)
+}
+
+// RevokeShareLink revokes an active link of the document; it reports false if there was none.
+func (c *DatabaseClient) RevokeShareLink(ctx context.Context, documentID, id string) (bool, error) {
+	res, err := c.db.ExecContext(ctx,
+		`UPDATE share_links SET revoked_at = now() WHERE id = $2 AND document_id = $1 AND revoked_at IS NULL`, documentID, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// UseShareLink counts one query against a link. It reports false if the link is revoked,
+// expired or out of queries, so concurrent queries cannot overspend the budget.
+func (c *DatabaseClient) UseShareLink(ctx context.Context, id string) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	const use = `
+		UPDATE share_links
+		SET query_count = query_count + 1, last_used_at = now()
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+		  AND (max_queries IS NULL OR query_count < max_queries)
+		  AND created_by IS NOT NULL
+		RETURNING created_by
+	`
+	var createdBy string
+	if err := tx.QueryRowContext(ctx, use, id).Scan(&createdBy); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The query counts against the creator's daily quota like one of their own.
+	const record = `INSERT INTO share_queries (share_link_id, user_id) VALUES ($1, $2)`
+	if _, err := tx.ExecContext(ctx, record, id, createdBy); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func scanShareLinks(rows *sql.Rows) ([]models.ShareLink, error) {
+	defer rows.Close()
+
+	var out []models.ShareLink
+	for rows.Next() {
+		var (
+			l                 models.ShareLink
+			maxQueries        sql.NullInt32
+			lastUsed, revoked sql.NullTime
+		)
+		if err := rows.Scan(&l.ID, &l.DocumentID, &l.CreatedBy, &l.TokenHash, &l.PasswordHash,
+			&l.ExpiresAt, &maxQueries, &l.QueryCount, &lastUsed, &revoked, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		l.HasPassword = l.PasswordHash != ""
+		l.MaxQueries = nullIntPtr(maxQueries)
+		l.LastUsedAt, l.RevokedAt = nullTimePtr(lastUsed), nullTimePtr(revoked)
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func nullIntPtr(n sql.NullInt32) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int32)
+	return &v
+}
+
+// nullTime maps the zero time to NULL so the column default applies.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// encodeChunkSource serialises the JSONB source columns of a chunk.
+func encodeChunkSource(ch *models.DocumentChunk) (headings, meta string, err error) {
+	hp := ch.HeadingPath
+	if hp == nil {
+		hp = []string{}
+	}
+	hb, err := json.Marshal(hp)
+	if err != nil {
+		return "", "", fmt.Errorf("encode heading path: %w", err)
+	}
+	md := ch.Metadata
+	if md == nil {
+		md = map[string]string{}
+	}
+	mb, err := json.Marshal(md)
+	if err != nil {
+		return "", "", fmt.Errorf("encode chunk metadata: %w", err)
+	}
+	return string(hb), string(mb), nil
+}
+
+// decodeChunkSource fills the JSONB source columns of a scanned chunk.
+func decodeChunkSource(ch *models.DocumentChunk, headings, meta []byte) error {
+	if len(headings) > 0 {
+		if err := json.Unmarshal(headings, &ch.HeadingPath); err != nil {
+			return fmt.Errorf("decode heading path: %w", err)
+		}
+	}
+	if len(meta) > 0 {
+		if err := json.Unmarshal(meta, &ch.Metadata); err != nil {
+			return fmt.Errorf("decode chunk metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// soleMemberOrgs selects the organizations whose only member is $1, including their
+// personal workspace.
+const soleMemberOrgs = `
+	SELECT m.org_id FROM org_memberships m
+	WHERE m.user_id = $1
+	  AND NOT EXISTS (SELECT 1 FROM org_memberships o WHERE o.org_id = m.org_id AND o.user_id <> $1)`
+
+// DeleteUser deletes the user with their documents, chat history and every organization
+// they are the only member of. It returns the storage URLs of the deleted uploads, which
+// the caller removes from object storage. It reports false, deleting nothing, if the user
+// is the last owner of an organization that has other members.
+func (c *DatabaseClient) DeleteUser(ctx context.Context, id string) ([]string, bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	const lastOwner = `
+		SELECT EXISTS (
+			SELECT 1 FROM org_memberships m
+			WHERE m.user_id = $1 AND m.role = 'owner'
+			  AND NOT EXISTS (SELECT 1 FROM org_memberships o WHERE o.org_id = m.org_id AND o.role = 'owner' AND o.user_id <> $1)
+			  AND EXISTS (SELECT 1 FROM org_memberships o WHERE o.org_id = m.org_id AND o.user_id <> $1)
+		)
+	`
+	var blocked bool
+	if err := tx.QueryRowContext(ctx, lastOwner, id).Scan(&blocked); err != nil {
+		return nil, false, err
+	}
+	if blocked {
+		return nil, false, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT storage_url FROM documents
+		WHERE source_type = 'upload' AND (user_id = $1 OR org_id IN (`+soleMemberOrgs+`))`, id)
+	if err != nil {
+		return nil, false, err
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		urls = append(urls, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// Documents, chunks, chat sessions and messages go with the organizations and the
+	// user through their foreign keys.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM organizations WHERE id IN (`+soleMemberOrgs+`)`, id); err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return urls, true, nil
+}
+
+func (c *DatabaseClient) CreateDataExport(ctx context.Context, export *models.DataExport) error {
+	if export == nil {
+		return errors.New("nil data export")
+	}
+	const q = `
+		INSERT INTO data_exports (id, user_id, status, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, now()))
+	`
+	_, err := c.db.ExecContext(ctx, q, export.ID, export.UserID, export.Status, export.ExpiresAt, nullTime(export.CreatedAt))
+	return err
+}
+
+const dataExportColumns = `id, user_id, status, storage_key, size_bytes, COALESCE(failure_reason, ''), expires_at, completed_at, created_at`
+
+// GetDataExport returns one of the user's exports, or nil.
+func (c *DatabaseClient) GetDataExport(ctx context.Context, userID, id string) (*models.DataExport, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+dataExportColumns+` FROM data_exports WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	exports, err := scanDataExports(rows)
+	if err != nil || len(exports) == 0 {
+		return nil, err
+	}
+	return &exports[0], nil
+}
+
+// ListDataExports returns the user's exports, newest first.
+func (c *DatabaseClient) ListDataExports(ctx context.Context, userID string) ([]models.DataExport, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+dataExportColumns+` FROM data_exports WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDataExports(rows)
+}
+
+// CompleteDataExport marks a pending export ready. It reports false if the export no
+// longer exists, as when its user was deleted while it was being built.
+func (c *DatabaseClient) CompleteDataExport(ctx context.Context, id, storageKey string, sizeBytes int64) (bool, error) {
+	res, err := c.db.ExecContext(ctx, `
+		UPDATE data_exports SET status = 'ready', storage_key = $2, size_bytes = $3, completed_at = now()
+		WHERE id = $1 AND status = 'pending'`, id, storageKey, sizeBytes)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (c *DatabaseClient) FailDataExport(ctx context.Context, id, reason string) error {
+	_, err := c.db.ExecContext(ctx, `
+		UPDATE data_exports SET status = 'failed', failure_reason = $2, completed_at = now()
+		WHERE id = $1 AND status = 'pending'`, id, reason)
+	return err
+}
+
+// FailPendingDataExports marks every pending export failed and reports how many were.
+func (c *DatabaseClient) FailPendingDataExports(ctx context.Context, reason string) (int64, error) {
+	res, err := c.db.ExecContext(ctx, `
+		UPDATE data_exports SET status = 'failed', failure_reason = $1, completed_at = now()
+		WHERE status = 'pending'`, reason)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c *DatabaseClient) DeleteDataExport(ctx context.Context, id string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM data_exports WHERE id = $1`, id)
+	return err
+}
+
+func scanDataExports(rows *sql.Rows) ([]models.DataExport, error) {
+	defer rows.Close()
+
+	var out []models.DataExport
+	for rows.Next() {
+		var (
+			e         models.DataExport
+			completed sql.NullTime
+		)
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.StorageKey, &e.SizeBytes, &e.FailureReason,
+			&e.ExpiresAt, &completed, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.CompletedAt = nullTimePtr(completed)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// TakeRateLimitToken refills the bucket by the time since it was last used and takes a
+// token if there is one. The row is locked for the transaction, so concurrent requests
+// from any replica queue on it, and the database clock is the only one used.
+func (c *DatabaseClient) TakeRateLimitToken(ctx context.Context, key string, capacity, perSecond float64) (float64, bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, now()) ON CONFLICT (key) DO NOTHING`,
+		key, capacity); err != nil {
+		return 0, false, err
+	}
+
+	var tokens, elapsed float64
+	const q = `
+		SELECT tokens, GREATEST(EXTRACT(EPOCH FROM now() - updated_at), 0)::float8
+		FROM rate_limit_buckets WHERE key = $1
+		FOR UPDATE
+	`
+	if err := tx.QueryRowContext(ctx, q, key).Scan(&tokens, &elapsed); err != nil {
+		return 0, false, err
+	}
+	tokens = min(capacity, tokens+elapsed*perSecond)
+	taken := tokens >= 1
+	if taken {
+		tokens--
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE rate_limit_buckets SET tokens = $2, updated_at = now() WHERE key = $1`, key, tokens); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return tokens, taken, nil
+}
+
+func (c *DatabaseClient) PruneRateLimitBuckets(ctx context.Context, idle time.Duration) error {
+	_, err := c.db.ExecContext(ctx,
+		`DELETE FROM rate_limit_buckets WHERE updated_at < now() - make_interval(secs => $1)`, idle.Seconds())
+	return err
+}
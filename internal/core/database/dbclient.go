@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/markdave123-py/Contexta/internal/models"
 )
@@ -11,20 +12,107 @@ import (
 type DbClient interface {
 	CreateUser(ctx context.Context, user *models.User) (err error)
 	GetUserByEmail(ctx context.Context, email string) (user *models.User, err error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	UpdateUserProfile(ctx context.Context, id, firstName string) error
+	UpdateUserPassword(ctx context.Context, id, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, id, email string) (bool, error)
+	DeleteUser(ctx context.Context, id string) (storageURLs []string, deleted bool, err error)
+
+	// Single-use tokens for password resets and email verification.
+	CreateAccountToken(ctx context.Context, token *models.AccountToken) error
+	ConsumeAccountToken(ctx context.Context, purpose, tokenHash string) (*models.AccountToken, error)
+
+	// Federated identities.
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	LinkIdentity(ctx context.Context, identity *models.UserIdentity) error
+
+	// Refresh tokens and access token revocation.
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error)
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string) error
+	RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error)
+
+	// API keys. Mutations are scoped to the owning user and report whether a key matched.
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	RenameAPIKey(ctx context.Context, userID, id, name string) (bool, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) (bool, error)
+	RevokeUserAPIKeys(ctx context.Context, userID string) error
+	TouchAPIKey(ctx context.Context, id string) error
+
+	// Organizations. Every user has a personal one, created by CreateUser. Membership
+	// changes report false when nothing matched or the last owner would be lost.
+	CreateOrganization(ctx context.Context, org *models.Organization, ownerID string) error
+	GetOrganization(ctx context.Context, id string) (*models.Organization, error)
+	ListOrganizations(ctx context.Context, userID string) ([]models.Organization, error)
+	GetMembership(ctx context.Context, orgID, userID string) (*models.Membership, error)
+	ListMemberships(ctx context.Context, orgID string) ([]models.Membership, error)
+	SetMembershipRole(ctx context.Context, orgID, userID, role string) (bool, error)
+	RemoveMembership(ctx context.Context, orgID, userID string) (bool, error)
+	CreateInvitation(ctx context.Context, inv *models.Invitation) error
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	ListInvitations(ctx context.Context, orgID string) ([]models.Invitation, error)
+	DeleteInvitation(ctx context.Context, orgID, id string) (bool, error)
+	AcceptInvitation(ctx context.Context, id, userID string) (bool, error)
+
+	// Share links. UseShareLink spends one query of a usable link, counting it against the
+	// creator's daily queries, and reports false otherwise.
+	CreateShareLink(ctx context.Context, link *models.ShareLink) error
+	GetShareLinkByTokenHash(ctx context.Context, tokenHash string) (*models.ShareLink, error)
+	ListShareLinks(ctx context.Context, documentID string) ([]models.ShareLink, error)
+	RevokeShareLink(ctx context.Context, documentID, id string) (bool, error)
+	UseShareLink(ctx context.Context, id string) (bool, error)
 
 	CreateDocument(ctx context.Context, doc *models.Document) error
 	GetDocumentByID(ctx context.Context, id string) (*models.Document, error)
+	GetDocumentByHash(ctx context.Context, orgID, contentHash string) (*models.Document, error)
 	ListDocumentsByUser(ctx context.Context, userID string) ([]models.Document, error)
+	ListDocumentsByOrg(ctx context.Context, orgID string) ([]models.Document, error)
+	ListMemberDocuments(ctx context.Context, userID string) ([]models.Document, error)
 	UpdateDocumentStatus(ctx context.Context, id string, status string) error
+	MarkDocumentFailed(ctx context.Context, id string, reason string) error
+	SetDocumentPageCount(ctx context.Context, id string, pages int) error
 
 	InsertDocumentChunks(ctx context.Context, chunks []models.DocumentChunk) error
+	DeleteDocumentChunks(ctx context.Context, documentID string) error
 	GetChunksByDocument(ctx context.Context, documentID string) ([]models.DocumentChunk, error)
 
 	SearchDocumentChunks(ctx context.Context, docID string, queryVec []float32, limit int) ([]models.DocumentChunk, error)
 
-	Close() error
+	// Embedding cache, keyed by model and text hash.
+	GetCachedEmbeddings(ctx context.Context, model string, textHashes []string) (map[string][]float32, error)
+	PutCachedEmbeddings(ctx context.Context, model string, embeddings map[string][]float32) error
 
-	// CreateChatSession(ctx context.Context, session *models.ChatSession) error
-	// AddChatMessage(ctx context.Context, message *models.ChatMessage) error
-	// GetMessagesBySession(ctx context.Context, sessionID string) ([]models.ChatMessage, error)
+	// Chat history. A user has one running session per document.
+	GetOrCreateChatSession(ctx context.Context, userID, documentID string) (*models.ChatSession, error)
+	AddChatMessage(ctx context.Context, message *models.ChatMessage) error
+	ListChatTranscripts(ctx context.Context, userID string) ([]models.ChatTranscript, error)
+	ListRecentChatMessages(ctx context.Context, sessionID string, limit int) ([]models.ChatMessage, error)
+
+	// Data exports. CompleteDataExport reports false if the export is gone.
+	CreateDataExport(ctx context.Context, export *models.DataExport) error
+	GetDataExport(ctx context.Context, userID, id string) (*models.DataExport, error)
+	ListDataExports(ctx context.Context, userID string) ([]models.DataExport, error)
+	CompleteDataExport(ctx context.Context, id, storageKey string, sizeBytes int64) (bool, error)
+	FailDataExport(ctx context.Context, id, reason string) error
+	FailPendingDataExports(ctx context.Context, reason string) (int64, error)
+	DeleteDataExport(ctx context.Context, id string) error
+
+	// Rate limiter token buckets, for ratelimit.PostgresLimiter.
+	TakeRateLimitToken(ctx context.Context, key string, capacity, perSecond float64) (tokens float64, taken bool, err error)
+	PruneRateLimitBuckets(ctx context.Context, idle time.Duration) error
+
+	// Usage accounting.
+	InsertUsageRecords(ctx context.Context, records []models.UsageRecord) error
+	GetDailyUsage(ctx context.Context, userID string, from, to time.Time) ([]models.UsageTotal, error)
+
+	// Quotas.
+	GetUserQuota(ctx context.Context, userID string) (*models.UserQuota, error)
+	GetQuotaUsage(ctx context.Context, userID string, monthStart, dayStart time.Time) (*models.QuotaUsage, error)
+
+	Close() error
 }
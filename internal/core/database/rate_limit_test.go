@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/markdave123-py/Contexta/internal/core/ratelimit"
+)
+
+// TestPostgresBucketsAgreeWithMemory runs one sequence of requests through the Postgres
+// and the in-memory limiter, against a real database: set TEST_DATABASE_URL to a
+// database the schema may be installed in. The rate is slow enough that the few
+// milliseconds between the two calls of a step cannot change a decision.
+func TestPostgresBucketsAgreeWithMemory(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	sqlDB, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := EnsureBootstrapped(ctx, sqlDB); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &DatabaseClient{db: sqlDB}
+	postgres := ratelimit.NewPostgresLimiter(client, time.Hour)
+	memory := ratelimit.NewMemoryLimiter()
+	key := "test:" + uuid.NewString()
+	t.Cleanup(func() { sqlDB.Exec(`DELETE FROM rate_limit_buckets WHERE key = $1`, key) })
+
+	limit := ratelimit.Limit{Requests: 4, Per: 2 * time.Second} // 2 tokens a second
+	pauses := []time.Duration{0, 0, 0, 0, 0, 0, 750 * time.Millisecond, 0, 0, 2 * time.Second, 0, 0, 0, 0, 0}
+	for k, pause := range pauses {
+		time.Sleep(pause)
+		want, err := memory.Allow(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := postgres.Allow(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		near := func(a, b time.Duration) bool { return (a - b).Abs() < 100*time.Millisecond }
+		if got.Allowed != want.Allowed || got.Remaining != want.Remaining ||
+			!near(got.RetryAfter, want.RetryAfter) || !near(got.Reset, want.Reset) {
+			t.Fatalf("request %d: postgres %+v, memory %+v", k+1, got, want)
+		}
+	}
+}
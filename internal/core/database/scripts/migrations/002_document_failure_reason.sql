@@ -0,0 +1,8 @@
+BEGIN;
+
+-- Why ingestion failed (unsupported_format, encrypted, corrupt, empty_text, ...)
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+
+INSERT INTO contexta_meta(version) VALUES (2) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Where each chunk came from, so answers can cite "page 12, §3.2 Installation".
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS page_start    INT;
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS page_end      INT;
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS heading_path  JSONB NOT NULL DEFAULT '[]'::jsonb;
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS source_offset BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS metadata      JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+INSERT INTO contexta_meta(version) VALUES (3) ON CONFLICT DO NOTHING;
+
+COMMIT;
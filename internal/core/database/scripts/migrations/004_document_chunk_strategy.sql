@@ -0,0 +1,8 @@
+BEGIN;
+
+-- Chunking strategy requested at upload (lines, recursive, headings, window, semantic); NULL uses the server config.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS chunk_strategy TEXT;
+
+INSERT INTO contexta_meta(version) VALUES (4) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+-- SHA-256 (hex) of the uploaded bytes, used to detect a user uploading the same file twice.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS content_hash TEXT;
+CREATE INDEX IF NOT EXISTS idx_documents_user_hash ON documents(user_id, content_hash);
+
+-- Embeddings already paid for, keyed by model and SHA-256 (hex) of the embedded text.
+CREATE TABLE IF NOT EXISTS embedding_cache (
+  model       TEXT   NOT NULL,
+  text_hash   TEXT   NOT NULL,
+  embedding   VECTOR NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (model, text_hash)
+);
+
+INSERT INTO contexta_meta(version) VALUES (5) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,22 @@
+BEGIN;
+
+-- One row per billed provider call. Cost is priced when the call is made so that later
+-- price changes do not rewrite history; rows outlive the documents and messages they name.
+CREATE TABLE IF NOT EXISTS usage_records (
+  id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       UUID REFERENCES users(id) ON DELETE CASCADE,
+  document_id   UUID REFERENCES documents(id) ON DELETE SET NULL,
+  message_id    UUID REFERENCES chat_messages(id) ON DELETE SET NULL,
+  operation     TEXT NOT NULL CHECK (operation IN ('embed','generate')),
+  model         TEXT NOT NULL,
+  input_tokens  INT  NOT NULL DEFAULT 0,
+  output_tokens INT  NOT NULL DEFAULT 0,
+  cost_usd      DOUBLE PRECISION NOT NULL DEFAULT 0,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_usage_user_created ON usage_records(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_document     ON usage_records(document_id);
+
+INSERT INTO contexta_meta(version) VALUES (6) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+
+-- Quota plan of each user; limits per plan come from the server configuration.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT 'free';
+
+-- What a document counts against the storage and monthly page quotas.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS size_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS page_count INT    NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_documents_user_created ON documents(user_id, created_at);
+
+-- Per-user overrides of their plan's limits; NULL keeps the plan's value, 0 is unlimited.
+CREATE TABLE IF NOT EXISTS user_quotas (
+  user_id             UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  max_bytes           BIGINT,
+  max_documents       INT,
+  max_pages_per_month INT,
+  max_queries_per_day INT,
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO contexta_meta(version) VALUES (7) ON CONFLICT DO NOTHING;
+
+COMMIT;
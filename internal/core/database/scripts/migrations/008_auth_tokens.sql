@@ -0,0 +1,30 @@
+BEGIN;
+
+-- Rotating refresh tokens, stored as SHA-256 (hex) of the token. Each login starts a
+-- family; every refresh revokes the presented token and issues its successor in the same
+-- family. Presenting a revoked token again means it leaked, and revokes the family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  family_id    UUID NOT NULL,
+  token_hash   TEXT NOT NULL UNIQUE,
+  expires_at   TIMESTAMPTZ NOT NULL,
+  revoked_at   TIMESTAMPTZ,
+  replaced_by  UUID,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user   ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+
+-- Access tokens revoked before they expire, by jti. Rows can go once the token expires.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti         TEXT PRIMARY KEY,
+  user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  revoked_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON revoked_tokens(expires_at);
+
+INSERT INTO contexta_meta(version) VALUES (8) ON CONFLICT DO NOTHING;
+
+COMMIT;
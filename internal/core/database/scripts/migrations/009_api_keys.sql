@@ -0,0 +1,21 @@
+BEGIN;
+
+-- Personal API keys. The key is shown once; only its SHA-256 (hex) is kept, with the
+-- public prefix embedded in the key used to find the row.
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name          TEXT NOT NULL,
+  prefix        TEXT NOT NULL UNIQUE,
+  key_hash      TEXT NOT NULL,
+  scopes        JSONB NOT NULL DEFAULT '[]'::jsonb,
+  expires_at    TIMESTAMPTZ,
+  last_used_at  TIMESTAMPTZ,
+  revoked_at    TIMESTAMPTZ,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+INSERT INTO contexta_meta(version) VALUES (9) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+
+-- Federated identities (OIDC / OAuth2) linked to users, keyed by the provider's subject.
+CREATE TABLE IF NOT EXISTS user_identities (
+  provider    TEXT NOT NULL,
+  subject     TEXT NOT NULL,
+  user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email       TEXT NOT NULL DEFAULT '',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+INSERT INTO contexta_meta(version) VALUES (10) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,59 @@
+BEGIN;
+
+-- Workspaces that own documents. Every user has a personal one, created with the user.
+CREATE TABLE IF NOT EXISTS organizations (
+  id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  name        TEXT NOT NULL,
+  personal    BOOLEAN NOT NULL DEFAULT false,
+  created_by  UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_personal ON organizations(created_by) WHERE personal;
+
+CREATE TABLE IF NOT EXISTS org_memberships (
+  org_id      UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role        TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_memberships_user ON org_memberships(user_id);
+
+-- Pending invitations. Only the hash of the invitation token is kept.
+CREATE TABLE IF NOT EXISTS org_invitations (
+  id           UUID PRIMARY KEY,
+  org_id       UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  email        TEXT NOT NULL,
+  role         TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+  token_hash   TEXT NOT NULL UNIQUE,
+  invited_by   UUID REFERENCES users(id) ON DELETE SET NULL,
+  expires_at   TIMESTAMPTZ NOT NULL,
+  accepted_at  TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org ON org_invitations(org_id);
+
+-- Give existing users their personal workspace and move their documents into it.
+INSERT INTO organizations (name, personal, created_by, created_at)
+SELECT 'Personal', true, u.id, u.created_at
+FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM organizations o WHERE o.personal AND o.created_by = u.id);
+
+INSERT INTO org_memberships (org_id, user_id, role, created_at)
+SELECT o.id, o.created_by, 'owner', o.created_at
+FROM organizations o
+WHERE o.personal
+ON CONFLICT DO NOTHING;
+
+-- user_id stays as the uploader; org_id is the owning workspace.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE documents d SET org_id = o.id
+FROM organizations o
+WHERE d.org_id IS NULL AND o.personal AND o.created_by = d.user_id;
+ALTER TABLE documents ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_org_created ON documents(org_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_documents_org_hash ON documents(org_id, content_hash);
+
+INSERT INTO contexta_meta(version) VALUES (11) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+-- Public, chat-only links to one document. Only hashes of the token and password are kept.
+CREATE TABLE IF NOT EXISTS share_links (
+  id             UUID PRIMARY KEY,
+  document_id    UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+  created_by     UUID REFERENCES users(id) ON DELETE SET NULL,
+  token_hash     TEXT NOT NULL UNIQUE,
+  password_hash  TEXT,                 -- bcrypt; NULL when the link has no password
+  expires_at     TIMESTAMPTZ NOT NULL,
+  max_queries    INT,                  -- NULL is unlimited
+  query_count    INT NOT NULL DEFAULT 0,
+  last_used_at   TIMESTAMPTZ,
+  revoked_at     TIMESTAMPTZ,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_share_links_document ON share_links(document_id);
+
+INSERT INTO contexta_meta(version) VALUES (12) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- Single-use tokens mailed to users: password resets and email verification. Only the
+-- hash of the token is kept.
+CREATE TABLE IF NOT EXISTS account_tokens (
+  id          UUID PRIMARY KEY,
+  user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  purpose     TEXT NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+  token_hash  TEXT NOT NULL UNIQUE,
+  email       TEXT NOT NULL,   -- the address the token was sent to
+  expires_at  TIMESTAMPTZ NOT NULL,
+  used_at     TIMESTAMPTZ,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user ON account_tokens(user_id, purpose);
+
+INSERT INTO contexta_meta(version) VALUES (13) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- Archives of a user's data, built in the background and kept in object storage until
+-- they expire.
+CREATE TABLE IF NOT EXISTS data_exports (
+  id              UUID PRIMARY KEY,
+  user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status          TEXT NOT NULL CHECK (status IN ('pending', 'ready', 'failed')),
+  storage_key     TEXT NOT NULL DEFAULT '',
+  size_bytes      BIGINT NOT NULL DEFAULT 0,
+  failure_reason  TEXT,
+  expires_at      TIMESTAMPTZ NOT NULL,
+  completed_at    TIMESTAMPTZ,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_created ON data_exports(user_id, created_at);
+
+INSERT INTO contexta_meta(version) VALUES (14) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Emails are stored lowercased and looked up case-insensitively. Lowercase existing
+-- addresses unless that would collide with another account.
+UPDATE users u SET email = lower(u.email)
+WHERE u.email <> lower(u.email)
+  AND NOT EXISTS (SELECT 1 FROM users o WHERE o.id <> u.id AND lower(o.email) = lower(u.email));
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
+
+INSERT INTO contexta_meta(version) VALUES (15) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+-- Token buckets of the request rate limiter, shared by every replica. A bucket that has
+-- not been used for a while is full and is deleted.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+  key         TEXT PRIMARY KEY,
+  tokens      DOUBLE PRECISION NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated ON rate_limit_buckets(updated_at);
+
+INSERT INTO contexta_meta(version) VALUES (16) ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- One row per question asked through a share link, so that shared queries count
+-- against the daily query quota of the link's creator, who is billed for them.
+CREATE TABLE IF NOT EXISTS share_queries (
+  id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  share_link_id  UUID REFERENCES share_links(id) ON DELETE SET NULL,
+  user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_share_queries_user_created ON share_queries(user_id, created_at);
+
+INSERT INTO contexta_meta(version) VALUES (17) ON CONFLICT DO NOTHING;
+
+COMMIT;
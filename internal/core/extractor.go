@@ -2,19 +2,48 @@ package core
 
 import (
 	"context"
+	"errors"
+	"io"
 
 	"golang.org/x/sync/errgroup"
 )
 
-// ExtractedText represents the result of text extraction, potentially with metadata.
-type ExtractedText struct {
-	Text     string
-	Metadata map[string]string
+// Extraction errors. Extractors wrap one of these so the ingestion pipeline can
+// record why a document failed instead of marking it ready with no chunks.
+var (
+	ErrUnsupportedFormat = errors.New("unsupported document format")
+	ErrEncryptedDocument = errors.New("document is encrypted or password protected")
+	ErrCorruptDocument   = errors.New("document is corrupt or unreadable")
+	ErrEmptyText         = errors.New("no text could be extracted from document")
+	ErrOCRUnavailable    = errors.New("OCR is required but not available on this server")
+	ErrDocumentTooLarge  = errors.New("document is too large to convert")
+)
+
+// Fragment is one piece of extracted text together with where it came from.
+//
+// Page:        1-based page number, 0 when the format has no pages.
+// HeadingPath: enclosing section headings, outermost first (Markdown headings, code declarations).
+// Offset:      byte offset of the fragment in the extracted text stream.
+// Metadata:    extractor-specific extras (sheet name, row range, ...).
+type Fragment struct {
+	Text        string
+	Page        int
+	HeadingPath []string
+	Offset      int64
+	Metadata    map[string]string
 }
 
 // DocumentExtractor defines the interface for extracting text from various document types.
 type DocumentExtractor interface {
-	// ExtractText takes an io.Reader and content type, and returns a channel of extracted text fragments.
+	// ExtractText takes an io.Reader and content type, and returns a channel of extracted fragments.
 	// The `contentType` hint helps the extractor choose the right parsing strategy.
-	ExtractText(ctx context.Context, g *errgroup.Group, r []byte, contentType string) (<-chan string, error)
+	// Implementations must not buffer the whole document; fragments are emitted as they are read.
+	// Extraction runs inside g, so a failure surfaces from g.Wait() wrapped around one of the Err* values above.
+	ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan Fragment, error)
+}
+
+// ContentTypeResolver is implemented by extractors that can refine a document's declared
+// content type from its file name, e.g. a README.md uploaded as text/plain.
+type ContentTypeResolver interface {
+	ResolveContentType(contentType, fileName string) string
 }
@@ -0,0 +1,66 @@
+package core
+
+import "context"
+
+// Task names what a generation request is for, so a routing provider can pick a model.
+type Task string
+
+const (
+	TaskAnswer  Task = "answer"  // answering the user from retrieved context
+	TaskRewrite Task = "rewrite" // rewriting or expanding a query before retrieval
+)
+
+type taskKey struct{}
+
+// WithTask marks ctx as carrying a request for task.
+func WithTask(ctx context.Context, task Task) context.Context {
+	return context.WithValue(ctx, taskKey{}, task)
+}
+
+// TaskFrom returns the task set with WithTask, or TaskAnswer.
+func TaskFrom(ctx context.Context) Task {
+	if t, ok := ctx.Value(taskKey{}).(Task); ok && t != "" {
+		return t
+	}
+	return TaskAnswer
+}
+
+// GenerationInfo describes how a generation request was served.
+//
+// Provider:     backend that produced the answer.
+// Model:        model of that backend.
+// Task:         task the request was routed for.
+// Attempted:    backends tried before it, in order, with why they were skipped or failed.
+// InputTokens:  prompt tokens billed for the request.
+// OutputTokens: generated tokens billed for the request.
+// CostUSD:      estimated cost of the request.
+type GenerationInfo struct {
+	Provider     string         `json:"provider,omitempty"`
+	Model        string         `json:"model,omitempty"`
+	Task         Task           `json:"task,omitempty"`
+	Attempted    []RouteAttempt `json:"attempted,omitempty"`
+	InputTokens  int            `json:"input_tokens,omitempty"`
+	OutputTokens int            `json:"output_tokens,omitempty"`
+	CostUSD      float64        `json:"cost_usd,omitempty"`
+}
+
+// RouteAttempt is one backend passed over by a routing provider.
+type RouteAttempt struct {
+	Provider string `json:"provider"`
+	Reason   string `json:"reason"` // "circuit_open" or the error
+}
+
+type generationInfoKey struct{}
+
+// WithGenerationInfo returns a context that providers fill in as they serve a request,
+// and the record they fill.
+func WithGenerationInfo(ctx context.Context) (context.Context, *GenerationInfo) {
+	info := &GenerationInfo{}
+	return context.WithValue(ctx, generationInfoKey{}, info), info
+}
+
+// GenerationInfoFrom returns the record installed by WithGenerationInfo, or nil.
+func GenerationInfoFrom(ctx context.Context) *GenerationInfo {
+	info, _ := ctx.Value(generationInfoKey{}).(*GenerationInfo)
+	return info
+}
@@ -2,114 +2,139 @@ package ingestion_engine
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
+	"github.com/markdave123-py/Contexta/internal/core"
+
 	"golang.org/x/sync/errgroup"
 )
 
-// streamChunk groups incoming fragments into token-bounded chunks with optional overlap.
+// streamChunk runs chunker over the incoming fragments and streams the chunks it emits,
+// numbered in order and with their token counts filled in.
 //
-// frags:          upstream fragments channel.
-// targetTokens:   approximate tokens per chunk.
-// overlapTokens:  tokens to retain from the end of the previous chunk as seed of the next (e.g., 50).
-// out:            receive-only channel of chunk structs with Pos/Text/TokenCnt.
+// frags:   upstream fragments channel.
+// chunker: grouping strategy for this document.
+// out:     receive-only channel of chunk structs with Pos/Text/TokenCnt.
 func (i *DocumentIngestor) streamChunk(
 	ctx context.Context,
 	g *errgroup.Group,
-	frags <-chan string,
-	targetTokens int,
-	overlapTokens int,
+	frags <-chan core.Fragment,
+	chunker Chunker,
 ) <-chan chunk {
 	out := make(chan chunk, 8)
 
 	g.Go(func() error {
 		defer close(out)
 
-		var (
-			buf    []string
-			tokSum int
-			pos    int
-		)
-
-		// flush emits the current buffer as a chunk and prepares the buffer for the next chunk,
-		// preserving overlapTokens from the tail if configured.
-		flush := func(force bool) error {
-			if tokSum == 0 && !force {
-				return nil
-			}
-			text := strings.Join(buf, "\n")
-			ch := chunk{Pos: pos, Text: text, TokenCnt: tokSum}
+		pos := 0
+		return chunker.Chunk(ctx, frags, func(ch chunk) error {
+			ch.Pos = pos
+			ch.TokenCnt = i.tokenizer.Count(ch.Text)
 			pos++
 
 			// Emit the chunk to downstream; backpressure applies here.
 			select {
 			case out <- ch:
+				return nil
 			case <-ctx.Done():
 				return ctx.Err()
 			}
-			fmt.Printf("[CHUNK #%d] emitted %d tokens (%d lines)\n", pos, tokSum, len(buf))
-			// Compute overlap: keep a tail whose token sum ≈ overlapTokens.
-			if overlapTokens > 0 {
-				keep := []string{}
-				remain := overlapTokens
-				for j := len(buf) - 1; j >= 0 && remain > 0; j-- {
-					t := approxTokens(buf[j])
-					keep = append([]string{buf[j]}, keep...) // prepend to keep original order
-					remain -= t
-				}
-				buf = keep
-
-				// Recompute tokSum for the kept tail.
-				tokSum = 0
-				for _, s := range buf {
-					tokSum += approxTokens(s)
-				}
-			} else {
-				// No overlap: clear buffer.
-				buf = buf[:0]
-				tokSum = 0
-			}
-			return nil
-		}
+		})
+	})
 
-		for frag := range frags {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+	return out
+}
 
-			// Accumulate fragment and its token estimate.
-			t := approxTokens(frag)
-			buf = append(buf, frag)
-			tokSum += t
+// lineChunker joins extractor fragments while the next one still fits the target.
+// Fragments over limit tokens are first split at word boundaries, so no chunk exceeds
+// the target and the overlap carried into the next chunk never exceeds overlap tokens.
+//
+// target:   tokens per chunk.
+// overlap:  tokens to retain from the end of the previous chunk as seed of the next (e.g., 50).
+// limit:    hard upper bound for a single fragment.
+type lineChunker struct {
+	tok     core.Tokenizer
+	target  int
+	overlap int
+	limit   int
+}
 
-			// If we've reached the target, emit a chunk.
-			if tokSum >= targetTokens {
-				if err := flush(false); err != nil {
-					return err
-				}
+func (c *lineChunker) Chunk(ctx context.Context, frags <-chan core.Fragment, emit func(chunk) error) error {
+	p := newPacker(c.tok, c.target, c.overlap, false, emit)
+	for frag := range frags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, u := range boundFragment(c.tok, frag, c.limit) {
+			if err := p.add(u); err != nil {
+				return err
 			}
-
 		}
+	}
+	return p.flush()
+}
 
-		// Emit remaining tail (if any).
-		if err := flush(true); err != nil {
-			return err
+// boundFragment returns frag as a single unit, or, when it exceeds limit tokens, as
+// runs of whole words that each stay within limit.
+func boundFragment(tok core.Tokenizer, frag core.Fragment, limit int) []unit {
+	if n := tok.Count(frag.Text); n <= limit {
+		return []unit{pieceUnit(frag, frag.Text, frag.Offset, n)}
+	}
+
+	var out []unit
+	var run []unit
+	sum := 0
+	closeRun := func() {
+		if len(run) == 0 {
+			return
 		}
-		return nil
-	})
+		var b strings.Builder
+		for _, w := range run {
+			b.WriteString(w.frag.Text)
+		}
+		out = append(out, pieceUnit(frag, b.String(), run[0].frag.Offset, sum))
+		run, sum = run[:0], 0
+	}
+	for _, w := range splitWords(tok, frag, limit) {
+		if sum+w.toks > limit {
+			closeRun()
+		}
+		run = append(run, w)
+		sum += w.toks
+	}
+	closeRun()
 
+	for k := range out {
+		out[k].cont = k > 0
+	}
 	return out
 }
 
-// approxTokens is a cheap token estimator (~4 chars ≈ 1 token).
-// Replace with a real tokenizer later to improve chunk boundaries.
-func approxTokens(s string) int {
-	n := len([]rune(s))
-	if n <= 0 {
-		return 0
+// buildChunk assembles a chunk from the buffered fragments. Pages span the whole buffer;
+// heading path and metadata come from the first fragment that is not overlap, so a chunk
+// is attributed to the section its new content starts in.
+func buildChunk(pos int, buf []core.Fragment, seed int) chunk {
+	texts := make([]string, len(buf))
+	ch := chunk{Pos: pos}
+	for k, f := range buf {
+		texts[k] = f.Text
+		if f.Page > 0 && (ch.PageStart == 0 || f.Page < ch.PageStart) {
+			ch.PageStart = f.Page
+		}
+		if f.Page > ch.PageEnd {
+			ch.PageEnd = f.Page
+		}
+	}
+	ch.Text = strings.Join(texts, "\n")
+
+	if len(buf) > 0 {
+		ch.Offset = buf[0].Offset
+		lead := buf[0]
+		if seed < len(buf) {
+			lead = buf[seed]
+		}
+		ch.HeadingPath = lead.HeadingPath
+		ch.Metadata = lead.Metadata
 	}
-	return (n + 3) / 4
+	return ch
 }
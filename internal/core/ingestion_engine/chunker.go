@@ -0,0 +1,487 @@
+package ingestion_engine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// Chunking strategies selectable per content type through IngestConfig or per document.
+const (
+	ChunkLines     = "lines"     // join extractor fragments until the target is reached
+	ChunkRecursive = "recursive" // split on paragraph, sentence and word boundaries, cut at sentence ends
+	ChunkHeadings  = "headings"  // recursive, but never crossing a heading boundary
+	ChunkWindow    = "window"    // fixed-size sliding window over words
+	ChunkSemantic  = "semantic"  // split where sentence embeddings shift topic
+)
+
+// ChunkStrategies lists the strategies a document can ask for.
+var ChunkStrategies = []string{ChunkLines, ChunkRecursive, ChunkHeadings, ChunkWindow, ChunkSemantic}
+
+// ValidChunkStrategy reports whether s names a known strategy. Empty means "use the config".
+func ValidChunkStrategy(s string) bool {
+	return s == "" || slices.Contains(ChunkStrategies, s)
+}
+
+// Chunker groups a document's fragments into chunks. Implementations call emit once per
+// chunk in document order; positions and final token counts are filled in by the caller.
+type Chunker interface {
+	Chunk(ctx context.Context, frags <-chan core.Fragment, emit func(chunk) error) error
+}
+
+// newChunker builds the chunker for strategy.
+func (i *DocumentIngestor) newChunker(strategy string) (Chunker, error) {
+	target, overlap, limit := i.cfg.bounds()
+	switch strategy {
+	case ChunkLines:
+		return &lineChunker{tok: i.tokenizer, target: target, overlap: overlap, limit: limit}, nil
+	case ChunkRecursive:
+		return &recursiveChunker{tok: i.tokenizer, target: target, overlap: overlap, limit: limit}, nil
+	case ChunkHeadings:
+		return &recursiveChunker{tok: i.tokenizer, target: target, overlap: overlap, limit: limit, bySection: true}, nil
+	case ChunkWindow:
+		return &windowChunker{tok: i.tokenizer, size: target, overlap: overlap, limit: limit}, nil
+	case ChunkSemantic:
+		return &semanticChunker{tok: i.tokenizer, embedder: i.embedder, cfg: i.cfg.Semantic.withDefaults(target)}, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk strategy %q", strategy)
+	}
+}
+
+// bounds returns the chunk target, the overlap and the per-fragment limit, clamped so
+// that 0 < limit <= target and 0 <= overlap < target.
+func (c *IngestConfig) bounds() (target, overlap, limit int) {
+	target = max(c.TargetTokens, 1)
+	overlap = min(max(c.OverlapTokens, 0), target-1)
+	limit = target
+	if c.MaxFragmentLen > 0 {
+		limit = min(c.MaxFragmentLen, target)
+	}
+	return target, overlap, limit
+}
+
+// chunkStrategy picks the strategy for a document: its own choice, then the override for
+// its content type, then the configured default.
+func (c *IngestConfig) chunkStrategy(doc, contentType string) string {
+	if doc != "" {
+		return doc
+	}
+	if s, ok := c.ChunkStrategyByType[normalizeContentType(contentType)]; ok {
+		return s
+	}
+	if c.ChunkStrategy != "" {
+		return c.ChunkStrategy
+	}
+	return ChunkRecursive
+}
+
+// recursiveChunker splits oversized fragments on paragraph, line, sentence and word
+// boundaries (in that order, falling back to characters) until every piece fits the
+// target, then packs pieces into chunks, preferring to cut after a sentence. With
+// bySection set, a change of heading path always starts a new chunk and no overlap is
+// carried across it.
+type recursiveChunker struct {
+	tok       core.Tokenizer
+	target    int
+	overlap   int
+	limit     int
+	bySection bool
+}
+
+func (c *recursiveChunker) Chunk(ctx context.Context, frags <-chan core.Fragment, emit func(chunk) error) error {
+	p := newPacker(c.tok, c.target, c.overlap, true, emit)
+	var section []string
+	for frag := range frags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.bySection && !slices.Equal(frag.HeadingPath, section) {
+			if err := p.flush(); err != nil {
+				return err
+			}
+			section = frag.HeadingPath
+		}
+		for _, u := range splitRecursive(c.tok, frag, c.limit) {
+			if err := p.add(u); err != nil {
+				return err
+			}
+		}
+	}
+	return p.flush()
+}
+
+// windowChunker slides a window of size tokens over the document's words, stepping by
+// size minus overlap, regardless of sentence or section boundaries.
+type windowChunker struct {
+	tok     core.Tokenizer
+	size    int
+	overlap int
+	limit   int
+}
+
+func (c *windowChunker) Chunk(ctx context.Context, frags <-chan core.Fragment, emit func(chunk) error) error {
+	p := newPacker(c.tok, c.size, c.overlap, false, emit)
+	for frag := range frags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, u := range splitWords(c.tok, frag, c.limit) {
+			if err := p.add(u); err != nil {
+				return err
+			}
+		}
+	}
+	return p.flush()
+}
+
+// unit is a piece of a fragment small enough to fit in one chunk.
+//
+// frag:        the piece, with Offset adjusted to where it starts.
+// toks:        token count of frag.Text.
+// cont:        continues the previous unit's fragment, so it is joined without a newline.
+// sentenceEnd: the piece ends a sentence or paragraph, a good place to cut.
+type unit struct {
+	frag        core.Fragment
+	toks        int
+	cont        bool
+	sentenceEnd bool
+}
+
+// packer accumulates units into chunks of at most target tokens. When a unit does not
+// fit, the buffer is cut (after the last sentence end past half the target, if
+// preferSentences) and the cut part emitted; a tail of at most overlap tokens is kept
+// as the seed of the next chunk.
+type packer struct {
+	tok             core.Tokenizer
+	target          int
+	overlap         int
+	preferSentences bool
+	joinCost        int
+	emit            func(chunk) error
+
+	buf  []unit
+	seed int // leading units of buf carried over as overlap
+	toks int // tokens in buf, including joins
+}
+
+func newPacker(tok core.Tokenizer, target, overlap int, preferSentences bool, emit func(chunk) error) *packer {
+	return &packer{
+		tok: tok, target: max(target, 1), overlap: max(overlap, 0),
+		preferSentences: preferSentences, joinCost: tok.Count("\n"), emit: emit,
+	}
+}
+
+// cost is what appending u to the buffer adds.
+func (p *packer) cost(u unit) int {
+	return p.unitCost(u, len(p.buf) == 0)
+}
+
+// unitCost is what u adds to a run of units: its tokens, plus a newline join unless it
+// comes first or continues the previous unit's fragment.
+func (p *packer) unitCost(u unit, first bool) int {
+	if first || u.cont {
+		return u.toks
+	}
+	return u.toks + p.joinCost
+}
+
+// span is the tokens units take up when packed on their own, joins included.
+func (p *packer) span(units []unit) int {
+	n := 0
+	for k, u := range units {
+		n += p.unitCost(u, k == 0)
+	}
+	return n
+}
+
+func (p *packer) add(u unit) error {
+	if strings.TrimSpace(u.frag.Text) == "" && !u.cont {
+		return nil
+	}
+	for len(p.buf) > 0 && p.toks+p.cost(u) > p.target {
+		if len(p.buf) == p.seed {
+			// Only overlap left and the unit still does not fit: drop the overlap.
+			p.reset()
+			break
+		}
+		if err := p.cut(); err != nil {
+			return err
+		}
+	}
+	p.toks += p.cost(u)
+	p.buf = append(p.buf, u)
+	return nil
+}
+
+// cut emits a prefix of the buffer holding new content and keeps the rest plus overlap.
+func (p *packer) cut() error {
+	k := len(p.buf)
+	if p.preferSentences {
+		sum := 0
+		best := -1
+		for j := 0; j < len(p.buf); j++ {
+			sum += p.unitCost(p.buf[j], j == 0)
+			if j >= p.seed && p.buf[j].sentenceEnd && sum >= p.target/2 {
+				best = j + 1
+			}
+		}
+		if best > 0 {
+			k = best
+		}
+	}
+
+	if err := p.emitUnits(p.buf[:k]); err != nil {
+		return err
+	}
+
+	// Overlap: the longest tail of the emitted part within p.overlap tokens.
+	start := k
+	for start > 1 && p.overlap > 0 && p.span(p.buf[start-1:k]) <= p.overlap {
+		start--
+	}
+	rest := append(slices.Clone(p.buf[start:k]), p.buf[k:]...)
+	p.seed = k - start
+	p.buf = rest
+	p.recount()
+	return nil
+}
+
+// flush emits whatever new content is buffered and forgets the overlap.
+func (p *packer) flush() error {
+	defer p.reset()
+	if len(p.buf) == p.seed {
+		return nil
+	}
+	return p.emitUnits(p.buf)
+}
+
+// emitUnits emits units as a chunk unless they hold nothing but whitespace.
+func (p *packer) emitUnits(units []unit) error {
+	ch := chunkFromUnits(units, p.seed)
+	if ch.Text == "" {
+		return nil
+	}
+	return p.emit(ch)
+}
+
+func (p *packer) reset() {
+	p.buf, p.seed, p.toks = p.buf[:0], 0, 0
+}
+
+func (p *packer) recount() {
+	p.toks = p.span(p.buf)
+}
+
+// chunkFromUnits assembles a chunk from units; pieces of one fragment are joined as they
+// were, separate fragments with a newline.
+func chunkFromUnits(units []unit, seed int) chunk {
+	frags := make([]core.Fragment, len(units))
+	var b strings.Builder
+	for k, u := range units {
+		frags[k] = u.frag
+		if k > 0 && !u.cont {
+			b.WriteByte('\n')
+		}
+		b.WriteString(u.frag.Text)
+	}
+	ch := buildChunk(0, frags, seed)
+	ch.Text = strings.TrimSpace(b.String())
+	return ch
+}
+
+// splitRecursive breaks frag into units of at most limit tokens, trying paragraph, line,
+// sentence and word separators in turn. Separators stay attached to the preceding piece,
+// so the pieces concatenate back to the fragment text.
+func splitRecursive(tok core.Tokenizer, frag core.Fragment, limit int) []unit {
+	var out []unit
+	var rec func(text string, off int64, level int)
+	rec = func(text string, off int64, level int) {
+		if text == "" {
+			return
+		}
+		if n := tok.Count(text); n <= limit || level == len(splitLevels) {
+			if n > limit {
+				// Even a single word is too long: fall back to characters.
+				for _, piece := range splitRunes(tok, text, limit) {
+					out = append(out, pieceUnit(frag, piece.text, off+int64(piece.start), tok.Count(piece.text)))
+				}
+				return
+			}
+			out = append(out, pieceUnit(frag, text, off, n))
+			return
+		}
+		start := 0
+		for _, end := range splitLevels[level](text) {
+			rec(text[start:end], off+int64(start), level+1)
+			start = end
+		}
+	}
+	rec(frag.Text, frag.Offset, 0)
+
+	for k := range out {
+		out[k].cont = k > 0
+	}
+	return out
+}
+
+// splitLevels return the end offsets of the pieces of text at each level.
+var splitLevels = []func(string) []int{
+	func(s string) []int { return separatorEnds(s, "\n\n") },
+	func(s string) []int { return separatorEnds(s, "\n") },
+	sentenceEnds,
+	whitespaceEnds,
+}
+
+func pieceUnit(frag core.Fragment, text string, off int64, toks int) unit {
+	f := frag
+	f.Text, f.Offset = text, off
+	return unit{frag: f, toks: toks, sentenceEnd: endsSentence(text)}
+}
+
+// splitWords breaks frag into word units for the sliding window; words longer than
+// limit are split by characters.
+func splitWords(tok core.Tokenizer, frag core.Fragment, limit int) []unit {
+	var out []unit
+	start := 0
+	for _, end := range whitespaceEnds(frag.Text) {
+		w := frag.Text[start:end]
+		if n := tok.Count(w); n <= limit {
+			out = append(out, pieceUnit(frag, w, frag.Offset+int64(start), n))
+		} else {
+			for _, piece := range splitRunes(tok, w, limit) {
+				out = append(out, pieceUnit(frag, piece.text, frag.Offset+int64(start+piece.start), tok.Count(piece.text)))
+			}
+		}
+		start = end
+	}
+	for k := range out {
+		out[k].cont = k > 0
+	}
+	return out
+}
+
+// splitRunes cuts text into the longest runs of runes that stay within limit tokens.
+func splitRunes(tok core.Tokenizer, text string, limit int) []piece {
+	var out []piece
+	for start := 0; start < len(text); {
+		// Grow by doubling, then back off to the last rune boundary that fits.
+		end, step := start, 16
+		for end < len(text) {
+			next := min(len(text), end+step)
+			for next < len(text) && !utf8.RuneStart(text[next]) {
+				next++
+			}
+			if tok.Count(text[start:next]) > limit {
+				if step == 1 || next-end <= 1 {
+					break
+				}
+				step /= 2
+				continue
+			}
+			end = next
+			step *= 2
+		}
+		if end == start {
+			_, n := utf8.DecodeRuneInString(text[start:])
+			end = start + n
+		}
+		out = append(out, piece{text: text[start:end], start: start})
+		start = end
+	}
+	return out
+}
+
+// separatorEnds returns the end offsets of the pieces of s split after every sep.
+func separatorEnds(s, sep string) []int {
+	var ends []int
+	for i := 0; ; {
+		k := strings.Index(s[i:], sep)
+		if k < 0 {
+			break
+		}
+		i += k + len(sep)
+		for strings.HasPrefix(s[i:], sep) {
+			i += len(sep)
+		}
+		if i < len(s) {
+			ends = append(ends, i)
+		}
+	}
+	return append(ends, len(s))
+}
+
+// whitespaceEnds returns the end offsets of the words in s, each including the
+// whitespace that follows it.
+func whitespaceEnds(s string) []int {
+	var ends []int
+	space := false
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+		} else if space {
+			ends = append(ends, i)
+			space = false
+		}
+	}
+	return append(ends, len(s))
+}
+
+// sentenceEnds returns the end offsets of the sentences in s, each including the
+// whitespace that follows it.
+func sentenceEnds(s string) []int {
+	var ends []int
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		if !isSentenceTerminator(r) {
+			continue
+		}
+		j := i
+		for j < len(s) {
+			c, m := utf8.DecodeRuneInString(s[j:])
+			if !strings.ContainsRune(`"')]”’`, c) {
+				break
+			}
+			j += m
+		}
+		c, _ := utf8.DecodeRuneInString(s[j:])
+		if j < len(s) && !unicode.IsSpace(c) && !isCJKTerminator(r) {
+			continue
+		}
+		for j < len(s) {
+			c, m := utf8.DecodeRuneInString(s[j:])
+			if !unicode.IsSpace(c) {
+				break
+			}
+			j += m
+		}
+		if j < len(s) {
+			ends = append(ends, j)
+		}
+		i = j
+	}
+	return append(ends, len(s))
+}
+
+func endsSentence(text string) bool {
+	t := strings.TrimRightFunc(text, unicode.IsSpace)
+	if t != text && strings.Contains(text[len(t):], "\n\n") {
+		return true
+	}
+	t = strings.TrimRight(t, `"')]”’`)
+	r, _ := utf8.DecodeLastRuneInString(t)
+	return isSentenceTerminator(r)
+}
+
+func isSentenceTerminator(r rune) bool {
+	return r == '.' || r == '!' || r == '?' || isCJKTerminator(r)
+}
+
+func isCJKTerminator(r rune) bool {
+	return r == '。' || r == '！' || r == '？'
+}
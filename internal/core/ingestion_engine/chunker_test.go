@@ -0,0 +1,149 @@
+package ingestion_engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+)
+
+// FuzzPacker packs fuzzed text split into fragments by line and checks the packer's
+// bounds: no chunk over the target, and no chunk seeded with more than the overlap.
+func FuzzPacker(f *testing.F) {
+	f.Add("One sentence. Another one here.\nA second line.\n\nA new paragraph with more words in it.", uint8(12), uint8(4), true)
+	f.Add(strings.Repeat("short\n", 40), uint8(5), uint8(3), true)
+	f.Add(strings.Repeat("a b c d e f g h ", 30), uint8(7), uint8(6), false)
+	f.Add("x\ny\nz\n"+strings.Repeat("word ", 50)+"\n1\n2\n3", uint8(9), uint8(8), true)
+	f.Add("文書を取り込み、テキストを抽出します。\n埋め込みはまとめて計算されます。", uint8(6), uint8(2), true)
+
+	tok := tokenizer.NewEstimator()
+	f.Fuzz(func(t *testing.T, text string, target, overlap uint8, preferSentences bool) {
+		tgt := int(target%64) + 1
+		ovl := min(int(overlap%64), tgt-1)
+
+		var p *packer
+		p = newPacker(tok, tgt, ovl, preferSentences, func(ch chunk) error {
+			if n := tok.Count(ch.Text); n > tgt {
+				t.Fatalf("chunk of %d tokens over the %d target: %q", n, tgt, ch.Text)
+			}
+			if n := p.span(p.buf[:p.seed]); n > ovl {
+				t.Fatalf("chunk seeded with %d tokens of overlap, over %d", n, ovl)
+			}
+			return nil
+		})
+		var off int64
+		for _, line := range strings.SplitAfter(text, "\n") {
+			frag := core.Fragment{Text: strings.TrimSuffix(line, "\n"), Offset: off}
+			off += int64(len(line))
+			for _, u := range splitRecursive(tok, frag, tgt) {
+				if err := p.add(u); err != nil {
+					t.Fatal(err)
+				}
+				if p.toks != p.span(p.buf) {
+					t.Fatalf("packer counts %d tokens buffered, the buffer holds %d", p.toks, p.span(p.buf))
+				}
+			}
+		}
+		if err := p.flush(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// FuzzChunkers runs every strategy over fuzzed text, one fragment per line, where a line
+// starting with '#' opens a section. It checks that no chunk exceeds the target, that
+// without overlap the chunks hold exactly the document's text, and that the headings
+// strategy never mixes sections.
+func FuzzChunkers(f *testing.F) {
+	f.Add("# Intro\nOne sentence. Another one here.\n\n# Usage\nA second section with more words in it.\nAnd a line.", uint8(8), uint8(3))
+	f.Add(strings.Repeat("word ", 200), uint8(10), uint8(4))
+	f.Add("#a\n#b\nx\n#a\n"+strings.Repeat("longwordwithoutspaces", 20), uint8(3), uint8(1))
+	f.Add("文書を取り込み、テキストを抽出します。\n# 見出し\n埋め込みはまとめて計算されます。", uint8(5), uint8(2))
+
+	tok := tokenizer.NewEstimator()
+	f.Fuzz(func(t *testing.T, text string, target, overlap uint8) {
+		tgt := int(target%48) + 1
+		var frags []core.Fragment
+		var path []string
+		var off int64
+		for _, line := range strings.SplitAfter(text, "\n") {
+			if strings.HasPrefix(line, "#") {
+				path = []string{strings.TrimSpace(line)}
+			}
+			frags = append(frags, core.Fragment{Text: strings.TrimSuffix(line, "\n"), HeadingPath: path, Offset: off})
+			off += int64(len(line))
+		}
+
+		for _, strategy := range ChunkStrategies {
+			for _, ovl := range []int{0, min(int(overlap%48), tgt-1)} {
+				chunks := runChunker(t, tok, strategy, tgt, ovl, frags)
+				for _, ch := range chunks {
+					if n := tok.Count(ch.Text); n > tgt {
+						t.Fatalf("%s: chunk of %d tokens over the %d target: %q", strategy, n, tgt, ch.Text)
+					}
+				}
+				if ovl > 0 {
+					continue
+				}
+				var got strings.Builder
+				for _, ch := range chunks {
+					got.WriteString(nonSpace(ch.Text))
+				}
+				if want := nonSpace(text); got.String() != want {
+					t.Fatalf("%s: chunks hold %q, the document %q", strategy, got.String(), want)
+				}
+				if strategy == ChunkHeadings {
+					checkSections(t, frags, chunks)
+				}
+			}
+		}
+	})
+}
+
+// runChunker chunks frags with strategy and returns the chunks.
+func runChunker(t *testing.T, tok core.Tokenizer, strategy string, target, overlap int, frags []core.Fragment) []chunk {
+	t.Helper()
+	ing := &DocumentIngestor{tokenizer: tok, embedder: fakeEmbedder{}, cfg: &IngestConfig{TargetTokens: target, OverlapTokens: overlap}}
+	c, err := ing.newChunker(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan core.Fragment, len(frags))
+	for _, f := range frags {
+		in <- f
+	}
+	close(in)
+	var chunks []chunk
+	if err := c.Chunk(context.Background(), in, func(ch chunk) error {
+		chunks = append(chunks, ch)
+		return nil
+	}); err != nil {
+		t.Fatalf("%s: %v", strategy, err)
+	}
+	return chunks
+}
+
+// checkSections checks that each section's text is exactly what the chunks labelled with
+// its heading path hold, which fails if any chunk spans two sections.
+func checkSections(t *testing.T, frags []core.Fragment, chunks []chunk) {
+	t.Helper()
+	want, got := map[string]string{}, map[string]string{}
+	for _, f := range frags {
+		want[strings.Join(f.HeadingPath, "/")] += nonSpace(f.Text)
+	}
+	for _, ch := range chunks {
+		got[strings.Join(ch.HeadingPath, "/")] += nonSpace(ch.Text)
+	}
+	for k, w := range want {
+		if got[k] != w {
+			t.Fatalf("section %q: chunks hold %q, the section %q", k, got[k], w)
+		}
+	}
+}
+
+// nonSpace returns s without its whitespace, which chunking may change.
+func nonSpace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,208 @@
+package ingestion_engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ core.DocumentExtractor = (*CodeExtractor)(nil)
+
+// codeLanguage describes how to find top-level declarations in one language.
+//
+// decl:     matches an unindented line that starts a declaration.
+// preamble: matches unindented lines (comments, decorators) that belong to the next declaration.
+type codeLanguage struct {
+	name         string
+	contentTypes []string
+	exts         []string
+	decl         *regexp.Regexp
+	preamble     *regexp.Regexp
+}
+
+var codeLanguages = []codeLanguage{
+	{
+		name:         "go",
+		contentTypes: []string{"text/x-go"},
+		exts:         []string{".go"},
+		decl:         regexp.MustCompile(`^(func|type|var|const)\b`),
+		preamble:     regexp.MustCompile(`^(//|/\*| \*)`),
+	},
+	{
+		name:         "python",
+		contentTypes: []string{"text/x-python", "application/x-python-code", "text/x-script.python"},
+		exts:         []string{".py", ".pyi"},
+		decl:         regexp.MustCompile(`^(async\s+def|def|class)\s`),
+		preamble:     regexp.MustCompile(`^(#|@)`),
+	},
+	{
+		name:         "javascript",
+		contentTypes: []string{"text/javascript", "application/javascript", "application/x-javascript"},
+		exts:         []string{".js", ".mjs", ".cjs", ".jsx"},
+		decl:         jsDecl,
+		preamble:     regexp.MustCompile(`^(//|/\*| \*|@)`),
+	},
+	{
+		name:         "typescript",
+		contentTypes: []string{"text/x-typescript", "application/typescript", "application/x-typescript"},
+		exts:         []string{".ts", ".tsx", ".mts", ".cts"},
+		decl:         jsDecl,
+		preamble:     regexp.MustCompile(`^(//|/\*| \*|@)`),
+	},
+}
+
+// jsDecl covers JavaScript and TypeScript top-level declarations, exported or not.
+var jsDecl = regexp.MustCompile(`^(export\s+)?(default\s+)?(declare\s+)?(abstract\s+)?(async\s+)?(function\b|class\b|interface\b|type\s|enum\b|namespace\b|const\b|let\b|var\b)`)
+
+// CodeExtractor splits source files at top-level declarations: functions, types and
+// classes. Each declaration, with the comments and decorators directly above it, is
+// emitted under a section path naming the declaration, e.g. "func (s *Server) Start()".
+// It relies on declarations starting in column zero, which holds for formatted code.
+type CodeExtractor struct {
+	byType map[string]*codeLanguage
+}
+
+func NewCodeExtractor() *CodeExtractor {
+	e := &CodeExtractor{byType: make(map[string]*codeLanguage)}
+	for i := range codeLanguages {
+		for _, ct := range codeLanguages[i].contentTypes {
+			e.byType[ct] = &codeLanguages[i]
+		}
+	}
+	return e
+}
+
+// RegisterCodeExtractor registers e for every supported language's content types and extensions.
+func RegisterCodeExtractor(r *ExtractorRegistry, e *CodeExtractor) {
+	for _, lang := range codeLanguages {
+		r.Register(e, lang.contentTypes, lang.exts)
+	}
+}
+
+// ExtractText streams declaration blocks from r.
+func (e *CodeExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan core.Fragment, error) {
+	lang, ok := e.byType[normalizeContentType(contentType)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", core.ErrUnsupportedFormat, contentType)
+	}
+
+	out := make(chan core.Fragment, 32)
+
+	g.Go(func() error {
+		defer close(out)
+
+		em := &sectionEmitter{out: out}
+		if err := e.extract(ctx, r, lang, em); err != nil {
+			return err
+		}
+		if em.n == 0 {
+			return fmt.Errorf("%w (content type %q)", core.ErrEmptyText, contentType)
+		}
+		return nil
+	})
+
+	return out, nil
+}
+
+func (e *CodeExtractor) extract(ctx context.Context, r io.Reader, lang *codeLanguage, em *sectionEmitter) error {
+	sc := newLineScanner(r)
+
+	var (
+		path         []string // section path of the current block; nil for the file preamble
+		block        []string
+		blockBytes   int
+		blockStart   int64    // stream offset of the first line in block
+		pending      []string // comments/decorators waiting to see what follows them
+		pendingStart int64
+	)
+
+	flush := func() error {
+		text := strings.Join(block, "\n")
+		block, blockBytes = block[:0], 0
+		return em.emit(ctx, path, blockStart, text)
+	}
+	appendLines := func(start int64, lines ...string) error {
+		for _, l := range lines {
+			if len(block) == 0 {
+				blockStart = start
+			}
+			start += int64(len(l)) + 1
+			block = append(block, l)
+			blockBytes += len(l) + 1
+			if blockBytes >= maxFragmentBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		topLevel := line != "" && line[0] != ' ' && line[0] != '\t'
+
+		switch {
+		case topLevel && lang.decl.MatchString(line):
+			if err := flush(); err != nil {
+				return err
+			}
+			path = []string{declSignature(line)}
+			if err := appendLines(pendingStart, pending...); err != nil {
+				return err
+			}
+			pending = pending[:0]
+			if err := appendLines(sc.Offset(), line); err != nil {
+				return err
+			}
+
+		case topLevel && lang.preamble.MatchString(line):
+			if len(pending) == 0 {
+				pendingStart = sc.Offset()
+			}
+			pending = append(pending, line)
+
+		default:
+			// Anything else, including a blank line, detaches pending comments from
+			// whatever follows and keeps them with the current block.
+			if err := appendLines(pendingStart, pending...); err != nil {
+				return err
+			}
+			pending = pending[:0]
+			if err := appendLines(sc.Offset(), line); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if err := appendLines(pendingStart, pending...); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// declSignature trims a declaration line down to its signature for use as a section name.
+func declSignature(line string) string {
+	sig := strings.TrimSpace(line)
+	if i := strings.IndexByte(sig, '{'); i > 0 {
+		sig = sig[:i]
+	}
+	// Drop initialisers ("const x = ...") but not parameter defaults inside parentheses.
+	if i := strings.Index(sig, " = "); i > 0 {
+		if p := strings.IndexByte(sig, '('); p < 0 || i < p {
+			sig = sig[:i]
+		}
+	}
+	sig = strings.TrimSuffix(strings.TrimSpace(sig), ":")
+	if r := []rune(sig); len(r) > 120 {
+		sig = string(r[:120]) + "…"
+	}
+	return sig
+}
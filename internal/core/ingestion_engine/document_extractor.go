@@ -1,9 +1,12 @@
 package ingestion_engine
 
 import (
-	"bytes"
+	"archive/zip"
 	"context"
-	"log"
+	"fmt"
+	"io"
+	"mime"
+	"os"
 	"strings"
 
 	"code.sajari.com/docconv" // Using the corrected module path
@@ -13,52 +16,158 @@ import (
 
 var _ core.DocumentExtractor = (*DocconvExtractor)(nil)
 
+// docconvTypes lists the content types docconv converts without the ocr build tag.
+var docconvTypes = map[string]bool{
+	"application/msword":                      true,
+	"application/vnd.ms-word":                 true,
+	"application/rtf":                         true,
+	"application/x-rtf":                       true,
+	"text/rtf":                                true,
+	"text/richtext":                           true,
+	"text/html":                               true,
+	"text/xml":                                true,
+	"application/xml":                         true,
+	"application/vnd.apple.pages":             true,
+	"application/x-iwork-pages-sffpages":      true,
+	"application/vnd.oasis.opendocument.text": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+// cfbMagic starts an OLE compound file. Office wraps password-protected
+// OOXML documents in one, so a .docx/.pptx carrying it is encrypted.
+var cfbMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// maxDocconvBytes is the most docconv is given to convert: the XML parts of a ZIP-based
+// document such as .docx, or else the whole file. docconv reads its input whole, returns
+// the converted body as one string, and takes time superlinear in the XML (about 15s
+// at this limit, see BenchmarkExtractMemory), so larger documents are rejected with
+// core.ErrDocumentTooLarge. Plain text and PDFs stream and have no such limit.
+const maxDocconvBytes = 2 << 20
+
 func NewDocconvExtractor(useReadability bool) *DocconvExtractor {
-	return &DocconvExtractor{useReadability: useReadability}
+	return &DocconvExtractor{useReadability: useReadability, maxBytes: maxDocconvBytes}
 }
 
-// ExtractText uses docconv to extract text from the given reader based on content type.
-// It writes the extracted text as fragments to the output channel.
-func (e *DocconvExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r []byte, contentType string) (<-chan string, error) {
-	out := make(chan string, 32) // Buffered channel for fragments
+// ExtractText streams text out of r based on content type.
+// Plain text is scanned straight from the reader and PDFs are spooled to a temp file
+// and converted page by page; other formats still go through docconv, which needs
+// the file on disk and returns the converted body in one piece, so they are limited to
+// maxDocconvBytes.
+//
+// Extraction runs in g. Unsupported content types are rejected up front; conversion
+// failures and documents without any text fail the group with a wrapped core.Err* value.
+func (e *DocconvExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan core.Fragment, error) {
+	contentType = normalizeContentType(contentType)
+	if contentType != "text/plain" && contentType != "application/pdf" && !docconvTypes[contentType] {
+		return nil, fmt.Errorf("%w: %q", core.ErrUnsupportedFormat, contentType)
+	}
 
-	reader := bytes.NewReader(r)
+	out := make(chan core.Fragment, 32) // Buffered channel for fragments
 
-	go func() {
+	g.Go(func() error {
 		defer close(out)
 
-		res, err := docconv.Convert(reader, contentType, e.useReadability)
+		n, err := e.extract(ctx, r, contentType, out)
 		if err != nil {
-			log.Printf("docconv: extraction failed for content type '%s' (OCR: %t): %v", contentType, e.useReadability, err)
-			return 
+			return err
 		}
-
-		if err := ctx.Err(); err != nil {
-			println("context canlled after extraction")
-			return
+		if n == 0 {
+			return fmt.Errorf("%w (content type %q)", core.ErrEmptyText, contentType)
 		}
+		return nil
+	})
+
+	return out, nil
+}
+
+// extract dispatches on content type and returns the number of fragments emitted.
+func (e *DocconvExtractor) extract(ctx context.Context, r io.Reader, contentType string, out chan<- core.Fragment) (int, error) {
+	if contentType == "text/plain" {
+		return scanFragments(ctx, r, 0, out)
+	}
+
+	f, cleanup, err := spoolToTempFile(r)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	if contentType == "application/pdf" {
+		return streamPDF(ctx, f.Name(), out)
+	}
 
-		text := res.Body
+	size, err := docconvInputSize(f)
+	if err != nil {
+		return 0, err
+	}
+	if size > e.maxBytes {
+		return 0, fmt.Errorf("%w: %d bytes of %s to convert, over the %d byte limit", core.ErrDocumentTooLarge, size, contentType, e.maxBytes)
+	}
 
-		if text == "" {
-			log.Printf("docconv: extracted empty text for content type '%s'", contentType)
-			return
+	if strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument.") {
+		encrypted, err := headContains(f.Name(), len(cfbMagic), cfbMagic)
+		if err != nil {
+			return 0, err
+		}
+		if encrypted {
+			return 0, fmt.Errorf("%w: office document is password protected", core.ErrEncryptedDocument)
 		}
+	}
+
+	res, err := e.convert(f, contentType)
+	if err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return scanFragments(ctx, strings.NewReader(res.Body), 0, out)
+}
 
-		// Split the extracted text into fragments
-		lines := strings.Split(text, "\n")
-		for _, line := range lines {
-			if line = strings.TrimSpace(line); line == "" {
-				continue
-			}
-			select {
-			case out <- line:
-			case <-ctx.Done():
-
-				return // Context cancelled, stop processing
-			}
+// convert runs docconv over f. docconv panics on some malformed documents, such as a
+// .docx without its content types part; that is reported as core.ErrCorruptDocument
+// rather than taking the worker down.
+func (e *DocconvExtractor) convert(f *os.File, contentType string) (res *docconv.Response, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			res, err = nil, fmt.Errorf("%w: docconv (%s): %v", core.ErrCorruptDocument, contentType, p)
 		}
 	}()
+	res, err = docconv.Convert(f, contentType, e.useReadability)
+	if err != nil {
+		return nil, fmt.Errorf("%w: docconv (%s): %v", core.ErrCorruptDocument, contentType, err)
+	}
+	return res, nil
+}
 
-	return out, nil
+// docconvInputSize returns how much of f docconv reads into memory: the uncompressed
+// XML parts of a ZIP-based document, which compress too well for the file size to tell,
+// or else the size of the file. Embedded media is not converted and is not counted.
+func docconvInputSize(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat spooled file: %w", err)
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return info.Size(), nil
+	}
+	var n int64
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, ".xml") {
+			n += int64(zf.UncompressedSize64)
+		}
+	}
+	return n, nil
+}
+
+// normalizeContentType strips parameters such as charset and lowercases the media type.
+func normalizeContentType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
 }
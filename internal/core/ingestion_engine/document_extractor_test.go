@@ -0,0 +1,295 @@
+package ingestion_engine
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+)
+
+// repeatReader yields line over and over, so benchmarks can stream large documents
+// without holding them in memory.
+type repeatReader struct {
+	line []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.line[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.line)
+	}
+	return n, nil
+}
+
+// extractAll runs e over r and drains its fragments, calling each for every one.
+func extractAll(e core.DocumentExtractor, r io.Reader, contentType string, each func(core.Fragment)) error {
+	g, ctx := errgroup.WithContext(context.Background())
+	out, err := e.ExtractText(ctx, g, r, contentType)
+	if err != nil {
+		return err
+	}
+	for f := range out {
+		each(f)
+	}
+	return g.Wait()
+}
+
+const docxType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// Parts of a minimal Word document.
+const (
+	docxContentTypes = `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/></Types>`
+	docxBodyStart = `<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`
+	docxBodyEnd   = `</w:body></w:document>`
+)
+
+// testDocx returns a Word document of paragraphs copies of the paragraph text.
+func testDocx(t testing.TB, text string, paragraphs int) []byte {
+	t.Helper()
+	body := docxBodyStart + strings.Repeat("<w:p><w:r><w:t>"+text+"</w:t></w:r></w:p>\n", paragraphs) + docxBodyEnd
+	return testZip(t, "[Content_Types].xml", docxContentTypes, "word/document.xml", body)
+}
+
+// testZip returns a ZIP archive of the given name and content pairs.
+func testZip(t testing.TB, namesAndBodies ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(namesAndBodies); i += 2 {
+		w, err := zw.Create(namesAndBodies[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, namesAndBodies[i+1])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractionCases are documents the extractor must fail on, with the error it must
+// wrap and the failure reason the pipeline records for it.
+var extractionCases = []struct {
+	name        string
+	contentType string
+	data        string
+	err         error
+	reason      string
+}{
+	{"unsupported format", "image/png", "\x89PNG\r\n\x1a\n", core.ErrUnsupportedFormat, FailureUnsupportedFormat},
+	{"blank text", "text/plain", "  \n\n\t \n", core.ErrEmptyText, FailureEmptyText},
+	{"encrypted docx", docxType, string(cfbMagic) + strings.Repeat("\x00", 512), core.ErrEncryptedDocument, FailureEncrypted},
+	{"docx that is not a zip", docxType, "this is not a word document", core.ErrCorruptDocument, FailureCorrupt},
+	{"docx without content types", docxType, "", core.ErrCorruptDocument, FailureCorrupt},
+	{"docx without text", docxType, "", core.ErrEmptyText, FailureEmptyText},
+	{"pdf without header", "application/pdf", "<html>not a pdf</html>", core.ErrCorruptDocument, FailureCorrupt},
+}
+
+// extractionCaseData fills in the cases whose documents are built at run time.
+func extractionCaseData(t testing.TB, name, data string) string {
+	switch name {
+	case "docx without content types":
+		return string(testZip(t, "word/document.xml", docxBodyStart+"<w:p><w:r><w:t>text</w:t></w:r></w:p>"+docxBodyEnd))
+	case "docx without text":
+		return string(testDocx(t, "", 2))
+	}
+	return data
+}
+
+func TestDocconvExtractorErrors(t *testing.T) {
+	e := NewDocconvExtractor(false)
+	for _, c := range extractionCases {
+		t.Run(c.name, func(t *testing.T) {
+			data := extractionCaseData(t, c.name, c.data)
+			n := 0
+			err := extractAll(e, strings.NewReader(data), c.contentType, func(core.Fragment) { n++ })
+			if !errors.Is(err, c.err) {
+				t.Fatalf("got %v, want %v", err, c.err)
+			}
+			if got := failureReason(err); got != c.reason {
+				t.Fatalf("failure reason %q, want %q", got, c.reason)
+			}
+		})
+	}
+}
+
+func TestDocconvExtractorText(t *testing.T) {
+	e := NewDocconvExtractor(false)
+	cases := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        []string
+	}{
+		{"plain text with charset", "text/plain; charset=utf-8", []byte("First line.\n\n  Second line.  \n"), []string{"First line.", "Second line."}},
+		{"docx", docxType, testDocx(t, "A paragraph.", 2), []string{"A paragraph.", "A paragraph."}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []string
+			if err := extractAll(e, bytes.NewReader(c.data), c.contentType, func(f core.Fragment) { got = append(got, f.Text) }); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(c.want, "|") {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPDFErrorsAreClassified(t *testing.T) {
+	exit3 := exec.Command("sh", "-c", "exit 3").Run()
+	exit1 := exec.Command("sh", "-c", "exit 1").Run()
+	cases := []struct {
+		name   string
+		err    error
+		stderr string
+		want   error
+	}{
+		{"permission exit code", exit3, "", core.ErrEncryptedDocument},
+		{"incorrect password", exit1, "Command Line Error: Incorrect password", core.ErrEncryptedDocument},
+		{"broken file", exit1, "Syntax Error: Couldn't find trailer dictionary", core.ErrCorruptDocument},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := classifyPDFError(c.err, c.stderr); !errors.Is(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestExtractionErrorsFailTheDocument(t *testing.T) {
+	for _, c := range extractionCases {
+		t.Run(c.name, func(t *testing.T) {
+			doc := testDocument("doc")
+			doc.ContentType = c.contentType
+			fdb := newFakeDB(doc)
+			cfg := &IngestConfig{TargetTokens: 40, OverlapTokens: 5, BatchSize: 2}
+			objects := fakeObjects{data: extractionCaseData(t, c.name, c.data)}
+			ing := NewDocumentIngestor(fdb, objects, fakeEmbedder{}, NewDocconvExtractor(false), tokenizer.NewEstimator(), cfg)
+
+			if err := ing.ProcessOne(context.Background(), doc.ID); !errors.Is(err, c.err) {
+				t.Fatalf("got %v, want %v", err, c.err)
+			}
+			got, _ := fdb.GetDocumentByID(context.Background(), doc.ID)
+			if got.Status != "failed" || got.FailureReason != c.reason {
+				t.Fatalf("document %s with reason %q, want failed with %q", got.Status, got.FailureReason, c.reason)
+			}
+			if n := fdb.chunkCount(doc.ID); n != 0 {
+				t.Fatalf("%d chunks stored for a failed document", n)
+			}
+		})
+	}
+}
+
+func TestDocconvRejectsOversizedFiles(t *testing.T) {
+	e := NewDocconvExtractor(false)
+	e.maxBytes = 4 << 10
+	small := testDocx(t, "A paragraph of the document.", 3)
+
+	var got []string
+	if err := extractAll(e, bytes.NewReader(small), docxType, func(f core.Fragment) { got = append(got, f.Text) }); err != nil {
+		t.Fatalf("document under the limit: %v", err)
+	}
+	if len(got) != 3 || got[0] != "A paragraph of the document." {
+		t.Fatalf("document under the limit gave %q", got)
+	}
+
+	// The limit is on the XML docconv converts, not the compressed file.
+	large := testDocx(t, "A paragraph of the document.", 300)
+	if int64(len(large)) > e.maxBytes {
+		t.Fatalf("test document of %d bytes does not compress under the limit", len(large))
+	}
+	err := extractAll(e, bytes.NewReader(large), docxType, func(core.Fragment) {})
+	if !errors.Is(err, core.ErrDocumentTooLarge) || failureReason(err) != FailureTooLarge {
+		t.Fatalf("document over the limit: got %v", err)
+	}
+
+	// Plain text streams, so the limit does not apply to it.
+	text := io.LimitReader(&repeatReader{line: []byte("A line of plain text.\n")}, 64*e.maxBytes)
+	if err := extractAll(e, text, "text/plain", func(core.Fragment) {}); err != nil {
+		t.Fatalf("plain text over the limit: %v", err)
+	}
+}
+
+// peakHeap extracts r, sampling the heap in use every few milliseconds, and returns the
+// largest sample.
+func peakHeap(b *testing.B, e core.DocumentExtractor, r io.Reader, contentType string) uint64 {
+	b.Helper()
+	runtime.GC()
+	done := make(chan struct{})
+	sampled := make(chan uint64)
+	go func() {
+		var ms runtime.MemStats
+		var peak uint64
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			runtime.ReadMemStats(&ms)
+			peak = max(peak, ms.HeapInuse)
+			select {
+			case <-tick.C:
+			case <-done:
+				sampled <- peak
+				return
+			}
+		}
+	}()
+	err := extractAll(e, r, contentType, func(core.Fragment) {})
+	close(done)
+	peak := <-sampled
+	if err != nil {
+		b.Fatal(err)
+	}
+	return peak
+}
+
+// BenchmarkExtractMemory reports the peak heap in use while a document is extracted.
+// Plain text streams, so its peak stays flat as the input grows; Word documents go
+// through docconv, whose peak and time grow with the document up to maxDocconvBytes.
+func BenchmarkExtractMemory(b *testing.B) {
+	e := NewDocconvExtractor(false)
+	const line = "Line of the benchmark document about one topic or another."
+	for _, size := range []int64{1 << 20, 16 << 20} {
+		b.Run(fmt.Sprintf("text/%dMB", size>>20), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			var peak uint64
+			for range b.N {
+				r := io.LimitReader(&repeatReader{line: []byte(line + "\n")}, size)
+				peak = max(peak, peakHeap(b, e, r, "text/plain"))
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+	for _, size := range []int64{256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("docx/%dKB", size>>10), func(b *testing.B) {
+			doc := testDocx(b, line, int(size)/(len(line)+40))
+			b.SetBytes(size)
+			b.ReportAllocs()
+			var peak uint64
+			for range b.N {
+				peak = max(peak, peakHeap(b, e, bytes.NewReader(doc), docxType))
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
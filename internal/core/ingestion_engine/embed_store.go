@@ -2,7 +2,10 @@ package ingestion_engine
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
 
 	"github.com/google/uuid"
 	"github.com/markdave123-py/Contexta/internal/models"
@@ -21,7 +24,6 @@ func (i *DocumentIngestor) embedAndPersist(
 	batchSize int,
 ) error {
 	batch := make([]chunk, 0, batchSize)
-	fmt.Printf("[EMBED] received %d chunks\n", len(batch))
 
 	// flush embeds the current batch and inserts it into the database.
 	flush := func(items []chunk) error {
@@ -34,24 +36,26 @@ func (i *DocumentIngestor) embedAndPersist(
 			texts[idx] = items[idx].Text
 		}
 
-		vecs, err := i.embedder.EmbedTexts(ctx, texts)
+		vecs, err := i.embedCached(ctx, texts)
 		if err != nil {
-			return fmt.Errorf("embed: %w", err)
-		}
-		if len(vecs) != len(items) {
-			return fmt.Errorf("embed size mismatch: got %d want %d", len(vecs), len(items))
+			return err
 		}
 
 		// 3) Map to persistence rows and write once.
 		rows := make([]models.DocumentChunk, len(items))
 		for k := range items {
 			rows[k] = models.DocumentChunk{
-				ID:         uuid.NewString(),
-				DocumentID: docID,
-				Text:       items[k].Text,
-				Embedding:  vecs[k],
-				Position:   items[k].Pos,
-				TokenCount: items[k].TokenCnt,
+				ID:           uuid.NewString(),
+				DocumentID:   docID,
+				Text:         items[k].Text,
+				Embedding:    vecs[k],
+				Position:     items[k].Pos,
+				TokenCount:   items[k].TokenCnt,
+				PageStart:    items[k].PageStart,
+				PageEnd:      items[k].PageEnd,
+				HeadingPath:  items[k].HeadingPath,
+				SourceOffset: items[k].Offset,
+				Metadata:     items[k].Metadata,
 			}
 		}
 		if err := i.db.InsertDocumentChunks(ctx, rows); err != nil {
@@ -76,3 +80,63 @@ func (i *DocumentIngestor) embedAndPersist(
 	}
 	return nil
 }
+
+// embedCached embeds texts, reusing embeddings cached for the configured model and
+// caching the new ones. Identical texts within the batch are embedded once. Cache
+// failures are logged and fall back to embedding everything.
+func (i *DocumentIngestor) embedCached(ctx context.Context, texts []string) ([][]float32, error) {
+	hashes := make([]string, len(texts))
+	for k, t := range texts {
+		hashes[k] = textHash(t)
+	}
+
+	cached, err := i.db.GetCachedEmbeddings(ctx, i.cfg.EmbedModel, hashes)
+	if err != nil {
+		log.Printf("embedding cache lookup failed: %v", err)
+		cached = map[string][]float32{}
+	}
+
+	var (
+		missTexts []string
+		missHash  []string
+		seen      = make(map[string]bool)
+	)
+	for k, h := range hashes {
+		if _, ok := cached[h]; ok || seen[h] {
+			continue
+		}
+		seen[h] = true
+		missTexts = append(missTexts, texts[k])
+		missHash = append(missHash, h)
+	}
+
+	if len(missTexts) > 0 {
+		vecs, err := i.embedder.EmbedTexts(ctx, missTexts)
+		if err != nil {
+			return nil, fmt.Errorf("embed: %w", err)
+		}
+		if len(vecs) != len(missTexts) {
+			return nil, fmt.Errorf("embed size mismatch: got %d want %d", len(vecs), len(missTexts))
+		}
+		fresh := make(map[string][]float32, len(vecs))
+		for k, v := range vecs {
+			fresh[missHash[k]] = v
+			cached[missHash[k]] = v
+		}
+		if err := i.db.PutCachedEmbeddings(ctx, i.cfg.EmbedModel, fresh); err != nil {
+			log.Printf("embedding cache write failed: %v", err)
+		}
+	}
+
+	out := make([][]float32, len(texts))
+	for k, h := range hashes {
+		out[k] = cached[h]
+	}
+	return out, nil
+}
+
+// textHash is the hex SHA-256 of s, the key of the embedding cache.
+func textHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
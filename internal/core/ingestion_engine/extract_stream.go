@@ -0,0 +1,291 @@
+package ingestion_engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// maxFragmentBytes caps a single fragment read from a text stream.
+// Lines longer than this are emitted in several pieces so a worker never
+// holds more than one bounded line (plus the channel buffer) in memory.
+const maxFragmentBytes = 64 * 1024
+
+// spoolToTempFile copies r into a temporary file for parsers that need random access.
+// If r is already an *os.File it is rewound and reused. The returned cleanup func
+// closes the file and removes it when it was created here.
+func spoolToTempFile(r io.Reader) (*os.File, func(), error) {
+	if f, ok := r.(*os.File); ok {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("rewind file: %w", err)
+		}
+		return f, func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "contexta-extract-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create temp file: %w", err)
+	}
+	cleanup := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("spool to temp file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("rewind temp file: %w", err)
+	}
+	return f, cleanup, nil
+}
+
+// lineScanner reads bounded lines (see splitBoundedLines) and tracks the byte
+// offset at which each line starts in the underlying stream.
+type lineScanner struct {
+	sc       *bufio.Scanner
+	consumed int64
+	start    int64
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	s := &lineScanner{sc: bufio.NewScanner(r)}
+	s.sc.Buffer(make([]byte, 0, 64*1024), maxFragmentBytes)
+	s.sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := splitBoundedLines(data, atEOF)
+		if token != nil {
+			s.start = s.consumed
+		}
+		s.consumed += int64(advance)
+		return advance, token, err
+	})
+	return s
+}
+
+func (s *lineScanner) Scan() bool    { return s.sc.Scan() }
+func (s *lineScanner) Text() string  { return s.sc.Text() }
+func (s *lineScanner) Offset() int64 { return s.start }
+func (s *lineScanner) Err() error    { return s.sc.Err() }
+
+// scanFragments reads r line by line and emits every non-empty, trimmed line to out.
+// Memory stays bounded by maxFragmentBytes regardless of the size of r.
+// When firstPage is positive, fragments are numbered from it and every form feed in
+// the stream advances the page (pdftotext and tesseract separate pages with them);
+// 0 leaves fragments unpaged. It returns the number of fragments emitted.
+func scanFragments(ctx context.Context, r io.Reader, firstPage int, out chan<- core.Fragment) (int, error) {
+	sc := newLineScanner(r)
+
+	n, page := 0, firstPage
+	paged := firstPage > 0
+	for sc.Scan() {
+		raw := sc.Text()
+		line := strings.TrimLeftFunc(raw, unicode.IsSpace)
+		lead := raw[:len(raw)-len(line)]
+		if paged {
+			page += strings.Count(lead, "\f")
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			frag := core.Fragment{Text: line, Page: page, Offset: sc.Offset() + int64(len(lead))}
+			select {
+			case out <- frag:
+				n++
+			case <-ctx.Done():
+				return n, ctx.Err()
+			}
+		}
+		if paged {
+			page += strings.Count(raw[len(lead):], "\f")
+		}
+	}
+	return n, sc.Err()
+}
+
+// splitBoundedLines is a bufio.SplitFunc like bufio.ScanLines, except that a line
+// that does not fit in maxFragmentBytes is cut at the last rune boundary instead
+// of failing with bufio.ErrTooLong.
+func splitBoundedLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, bytes.TrimRight(data[:i], "\r"), nil
+	}
+	if len(data) >= maxFragmentBytes {
+		cut := maxFragmentBytes
+		// Back off a multi-byte rune that straddles the cut.
+		start := cut - 1
+		for start > 0 && !utf8.RuneStart(data[start]) {
+			start--
+		}
+		if !utf8.FullRune(data[start:cut]) && start > 0 {
+			cut = start
+		}
+		return cut, data[:cut], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// streamPDF runs pdftotext over the spooled file and emits its output as it is produced.
+// Pages are separated by form feeds in the stream, so fragments arrive page by page
+// instead of after the whole document has been converted.
+func streamPDF(ctx context.Context, path string, out chan<- core.Fragment) (int, error) {
+	if ok, err := headContains(path, 1024, []byte("%PDF-")); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("%w: missing PDF header", core.ErrCorruptDocument)
+	}
+
+	var stderr cappedBuffer
+	cmd := exec.CommandContext(ctx, "pdftotext", "-enc", "UTF-8", "-eol", "unix", path, "-")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("pdftotext pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("pdftotext start: %w", err)
+	}
+
+	n, scanErr := scanFragments(ctx, stdout, 1, out)
+	if scanErr != nil {
+		// Drain so the child process can exit before we wait on it.
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil && scanErr == nil {
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		return n, classifyPDFError(err, stderr.String())
+	}
+	return n, scanErr
+}
+
+// classifyPDFError maps a pdftotext failure onto the core extraction errors.
+// pdftotext exits with 3 on permission errors and reports "Incorrect password"
+// for documents encrypted with a user password.
+func classifyPDFError(err error, stderr string) error {
+	msg := strings.TrimSpace(stderr)
+	if msg == "" {
+		msg = err.Error()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		return fmt.Errorf("%w: %s", core.ErrEncryptedDocument, msg)
+	}
+	lower := strings.ToLower(msg)
+	if strings.Contains(lower, "incorrect password") || strings.Contains(lower, "encrypt") {
+		return fmt.Errorf("%w: %s", core.ErrEncryptedDocument, msg)
+	}
+	return fmt.Errorf("%w: %s", core.ErrCorruptDocument, msg)
+}
+
+// headContains reports whether magic appears within the first limit bytes of the file.
+func headContains(path string, limit int, magic []byte) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("open spooled file: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, limit)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, fmt.Errorf("read spooled file: %w", err)
+	}
+	return bytes.Contains(head[:n], magic), nil
+}
+
+// cappedBuffer keeps the first 4 KiB written to it; used to hold tool stderr
+// without letting a noisy converter grow memory.
+type cappedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	const limit = 4 * 1024
+	if room := limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// sectionEmitter sends blocks of text downstream as fragments tagged with the
+// section path they appeared under, splitting blocks larger than maxFragmentBytes.
+type sectionEmitter struct {
+	out chan<- core.Fragment
+	n   int
+}
+
+func (e *sectionEmitter) emit(ctx context.Context, path []string, offset int64, text string) error {
+	for _, p := range boundedPieces(text) {
+		frag := core.Fragment{
+			Text:        p.text,
+			HeadingPath: path,
+			Offset:      offset + int64(p.start),
+		}
+		select {
+		case e.out <- frag:
+			e.n++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// piece is a trimmed slice of a larger text and where it starts in that text.
+type piece struct {
+	text  string
+	start int
+}
+
+// boundedPieces splits text into trimmed pieces of at most maxFragmentBytes,
+// preferring line breaks. Empty pieces are dropped.
+func boundedPieces(text string) []piece {
+	var pieces []piece
+	add := func(start, end int) {
+		seg := text[start:end]
+		trimmed := strings.TrimLeftFunc(seg, unicode.IsSpace)
+		start += len(seg) - len(trimmed)
+		if trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace); trimmed != "" {
+			pieces = append(pieces, piece{text: trimmed, start: start})
+		}
+	}
+
+	pos := 0
+	for len(text)-pos > maxFragmentBytes {
+		window := text[pos : pos+maxFragmentBytes]
+		cut := strings.LastIndexByte(window, '\n')
+		if cut <= 0 {
+			cut = maxFragmentBytes
+			for cut > 0 && !utf8.RuneStart(text[pos+cut]) {
+				cut--
+			}
+		}
+		add(pos, pos+cut)
+		pos += cut
+	}
+	add(pos, len(text))
+	return pieces
+}
@@ -0,0 +1,76 @@
+package ingestion_engine
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	_ core.DocumentExtractor   = (*ExtractorRegistry)(nil)
+	_ core.ContentTypeResolver = (*ExtractorRegistry)(nil)
+)
+
+// ExtractorRegistry routes each document to the extractor registered for its content type.
+// Extensions map to a canonical content type so files uploaded with a generic or wrong
+// type (browsers send .md as text/plain and .ts as video/mp2t) still reach the right extractor.
+// Anything not registered goes to the fallback extractor.
+type ExtractorRegistry struct {
+	byType   map[string]core.DocumentExtractor
+	byExt    map[string]string
+	fallback core.DocumentExtractor
+}
+
+// NewExtractorRegistry returns a registry that defers to fallback for unregistered types.
+func NewExtractorRegistry(fallback core.DocumentExtractor) *ExtractorRegistry {
+	return &ExtractorRegistry{
+		byType:   make(map[string]core.DocumentExtractor),
+		byExt:    make(map[string]string),
+		fallback: fallback,
+	}
+}
+
+// Register routes contentTypes to e. Extensions (with leading dot) resolve to the first content type.
+func (r *ExtractorRegistry) Register(e core.DocumentExtractor, contentTypes []string, exts []string) {
+	for _, ct := range contentTypes {
+		r.byType[normalizeContentType(ct)] = e
+	}
+	if len(contentTypes) == 0 {
+		return
+	}
+	canonical := normalizeContentType(contentTypes[0])
+	for _, ext := range exts {
+		r.byExt[strings.ToLower(ext)] = canonical
+	}
+}
+
+// ResolveContentType picks the content type a document should be extracted as.
+// A registered extension wins unless the declared type already has its own extractor.
+func (r *ExtractorRegistry) ResolveContentType(contentType, fileName string) string {
+	declared := normalizeContentType(contentType)
+	byExt, ok := r.byExt[strings.ToLower(filepath.Ext(fileName))]
+	if !ok {
+		return declared
+	}
+	if _, registered := r.byType[declared]; registered && !isGenericContentType(declared) {
+		return declared
+	}
+	return byExt
+}
+
+// ExtractText dispatches to the extractor registered for contentType.
+func (r *ExtractorRegistry) ExtractText(ctx context.Context, g *errgroup.Group, rd io.Reader, contentType string) (<-chan core.Fragment, error) {
+	if e, ok := r.byType[normalizeContentType(contentType)]; ok {
+		return e.ExtractText(ctx, g, rd, contentType)
+	}
+	return r.fallback.ExtractText(ctx, g, rd, contentType)
+}
+
+// isGenericContentType reports types that say nothing useful about the file format.
+func isGenericContentType(ct string) bool {
+	return ct == "" || ct == "application/octet-stream" || ct == "text/plain"
+}
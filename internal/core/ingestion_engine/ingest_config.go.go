@@ -1,6 +1,8 @@
 package ingestion_engine
 
 import (
+	"context"
+
 	"github.com/markdave123-py/Contexta/internal/core"
 	db "github.com/markdave123-py/Contexta/internal/core/database"
 	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
@@ -8,27 +10,56 @@ import (
 
 // IngestConfig tunes the streaming pipeline.
 //
-// TargetTokens:   approximate tokens per chunk (e.g., 500).
+// TargetTokens:   tokens per chunk, as counted by the ingestor's tokenizer (e.g., 500).
 // OverlapTokens:  token overlap between consecutive chunks for context bleed (e.g., 50).
 // BatchSize:      how many chunks to embed/write in one batch (e.g., 32).
-// MaxFragmentLen: soft upper bound for individual fragments coming from the extractor.
+// MaxFragmentLen: hard token limit per extractor fragment; longer ones are split at word boundaries (0 = TargetTokens).
 // EmbedDim:       embedding dimension (use 0 to let model default apply; set to 768 if you want IVF on pgvector).
+// EmbedModel:     embedding model name; keys the embedding cache so a model change never reuses stale vectors.
+// ChunkStrategy:  default chunking strategy (recursive when empty); see ChunkStrategies.
+// ChunkStrategyByType: per content type overrides, e.g. text/markdown → headings.
+// Semantic:       settings of the semantic strategy.
+// PageQuota:      monthly page allowance of the document's owner; nil for no limit.
+//
+// A document's own chunk strategy, chosen at upload, wins over both.
 type IngestConfig struct {
-	TargetTokens  int
-	OverlapTokens int
-	BatchSize     int
-	EmbedDim      int
+	TargetTokens        int
+	OverlapTokens       int
+	BatchSize           int
+	MaxFragmentLen      int
+	EmbedDim            int
+	EmbedModel          string
+	ChunkStrategy       string
+	ChunkStrategyByType map[string]string
+	Semantic            SemanticConfig
+	PageQuota           PageQuota
+}
+
+// PageQuota reports how many more pages a user may ingest this month. limited is false
+// when the user has no page limit.
+type PageQuota interface {
+	RemainingPages(ctx context.Context, userID string) (remaining int, limited bool, err error)
 }
 
 // chunk is the internal representation passed through the pipeline.
 //
-// Pos:      stable, zero-based position of the chunk inside the document.
-// Text:     chunk content (built from one or more fragments).
-// TokenCnt: approximate token count (used for batching and overlap math).
+// Pos:         stable, zero-based position of the chunk inside the document.
+// Text:        chunk content (built from one or more fragments).
+// TokenCnt:    token count of Text, as counted by the ingestor's tokenizer.
+// PageStart:   first page the chunk covers (0 when unknown).
+// PageEnd:     last page the chunk covers (0 when unknown).
+// HeadingPath: section the chunk's new content starts in.
+// Offset:      byte offset of the chunk's first fragment in the extracted text.
+// Metadata:    extractor-specific metadata of the chunk's first new fragment.
 type chunk struct {
-	Pos      int
-	Text     string
-	TokenCnt int
+	Pos         int
+	Text        string
+	TokenCnt    int
+	PageStart   int
+	PageEnd     int
+	HeadingPath []string
+	Offset      int64
+	Metadata    map[string]string
 }
 
 // DocumentIngestor orchestrates the background ingestion pipeline:
@@ -36,18 +67,24 @@ type chunk struct {
 // db:        persistence for document and chunks.
 // obj:       object storage for streaming large files.
 // embedder:  embedding provider (Gemini/OpenAI/etc).
+// tokenizer: token counter used for chunk sizing, overlap and stored token counts.
 // cfg:       runtime tuning knobs for the pipeline.
 // jobs:      in-memory queue of document IDs to process (easy to swap with Kafka later).
 type DocumentIngestor struct {
-	db       db.DbClient
-	obj      objectclient.ObjectClient
-	embedder core.EmbeddingProvider
-	extrator core.DocumentExtractor
-	cfg      *IngestConfig
-	jobs     chan string
+	db        db.DbClient
+	obj       objectclient.ObjectClient
+	embedder  core.EmbeddingProvider
+	extrator  core.DocumentExtractor
+	tokenizer core.Tokenizer
+	cfg       *IngestConfig
+	jobs      chan string
 }
 
 // DocumentExtractor implements core.DocumentExtractor using sajari/docconv.
+//
+// useReadability: keep only the main content of HTML pages.
+// maxBytes:       largest file handed to docconv, which holds its output in memory.
 type DocconvExtractor struct {
 	useReadability bool
+	maxBytes       int64
 }
@@ -2,9 +2,9 @@ package ingestion_engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/markdave123-py/Contexta/internal/core"
@@ -14,9 +14,9 @@ import (
 )
 
 // NewDocumentIngestor constructs the ingestor with a bounded job queue (64).
-func NewDocumentIngestor(db db.DbClient, obj objectclient.ObjectClient, emb core.EmbeddingProvider, extrator core.DocumentExtractor, cfg *IngestConfig) Ingestor {
+func NewDocumentIngestor(db db.DbClient, obj objectclient.ObjectClient, emb core.EmbeddingProvider, extrator core.DocumentExtractor, tok core.Tokenizer, cfg *IngestConfig) Ingestor {
 	return &DocumentIngestor{
-		db: db, obj: obj, embedder: emb, cfg: cfg, extrator: extrator,
+		db: db, obj: obj, embedder: emb, cfg: cfg, extrator: extrator, tokenizer: tok,
 		jobs: make(chan string, 64),
 	}
 }
@@ -60,23 +60,63 @@ func (i *DocumentIngestor) ProcessOne(ctx context.Context, docID string) error {
 		return fmt.Errorf("document not found: %w", err)
 	}
 
-	bucket, key := parseS3URL(doc.StorageURL)
+	// A retry starts over: drop whatever chunks an earlier, failed run stored.
+	if err := i.db.DeleteDocumentChunks(ctx, docID); err != nil {
+		_ = i.db.MarkDocumentFailed(ctx, docID, FailureInternal)
+		return fmt.Errorf("delete old chunks: %w", err)
+	}
+
+	bucket, key := objectclient.ParseS3URL(doc.StorageURL)
 
 	// get streaming reader from object storage
-	rc, err := i.obj.GetFile(proctx, bucket, key)
+	rc, err := i.obj.GetObjectReader(proctx, bucket, key)
 	if err != nil {
-		_ = i.db.UpdateDocumentStatus(ctx, docID, "failed")
+		_ = i.db.MarkDocumentFailed(ctx, docID, FailureStorage)
 		return fmt.Errorf("get object reader: %w", err)
 	}
+	defer rc.Close()
 
-	// Build an errgroup to tie the pipeline stages together.
-	g, gctx := errgroup.WithContext(context.Background())
+	// Build an errgroup to tie the pipeline stages together. Embedding calls are billed to the document.
+	scope := core.UsageScope{UserID: doc.UserID, DocumentID: doc.ID}
+	g, gctx := errgroup.WithContext(core.WithUsageScope(context.Background(), scope))
+
+	contentType := doc.ContentType
+	if r, ok := i.extrator.(core.ContentTypeResolver); ok {
+		contentType = r.ResolveContentType(contentType, doc.FileName)
+	}
+
+	chunker, err := i.newChunker(i.cfg.chunkStrategy(doc.ChunkStrategy, contentType))
+	if err != nil {
+		_ = i.db.MarkDocumentFailed(ctx, docID, FailureInternal)
+		return err
+	}
+
+	// Pages this document may still use of its owner's monthly allowance; -1 for no limit.
+	allowance := -1
+	if i.cfg.PageQuota != nil {
+		remaining, limited, err := i.cfg.PageQuota.RemainingPages(ctx, doc.UserID)
+		if err != nil {
+			_ = i.db.MarkDocumentFailed(ctx, docID, FailureInternal)
+			return fmt.Errorf("page quota: %w", err)
+		}
+		if limited {
+			allowance = remaining
+		}
+	}
 
 	// extract documents ->  fragments (receive-only channel).
-	fragCh, err := i.extrator.ExtractText(gctx, g, rc, doc.ContentType)
+	fragCh, err := i.extrator.ExtractText(gctx, g, rc, contentType)
+	if err != nil {
+		_ = i.db.MarkDocumentFailed(ctx, docID, failureReason(err))
+		return fmt.Errorf("extract: %w", err)
+	}
+
+	// fragments -> the same fragments, counting pages against the allowance.
+	pages := &pageCounter{allowance: allowance}
+	fragCh = countPages(gctx, g, fragCh, pages)
 
 	// fragments -> chunks (receive-only channel).
-	chunkCh := i.streamChunk(gctx, g, fragCh, i.cfg.TargetTokens, i.cfg.OverlapTokens)
+	chunkCh := i.streamChunk(gctx, g, fragCh, chunker)
 
 	// chunks → embed + persist.
 	g.Go(func() error {
@@ -85,25 +125,48 @@ func (i *DocumentIngestor) ProcessOne(ctx context.Context, docID string) error {
 
 	// Wait for all stages. Any error cancels the rest.
 	if err := g.Wait(); err != nil {
-		_ = i.db.UpdateDocumentStatus(ctx, docID, "failed")
+		_ = i.db.MarkDocumentFailed(ctx, docID, failureReason(err))
 		return err
 	}
 
 	// Success.
+	if err := i.db.SetDocumentPageCount(ctx, docID, pages.total()); err != nil {
+		log.Printf("DocumentIngestor: recording page count of %s: %v", docID, err)
+	}
 	return i.db.UpdateDocumentStatus(ctx, docID, "ready")
 }
 
-// parseS3URL extracts the bucket and key from a typical virtual-hosted–style S3 URL.
-// Example: https://my-bucket.s3.us-east-2.amazonaws.com/path/to/file.pdf
-func parseS3URL(u string) (bucket, key string) {
-	hostPath := strings.SplitN(strings.TrimPrefix(u, "https://"), "/", 2)
-	host := hostPath[0]
-	if len(hostPath) == 2 {
-		key = hostPath[1]
-	}
-	parts := strings.Split(host, ".")
-	if len(parts) > 0 {
-		bucket = parts[0]
+// Failure reasons recorded on a failed document.
+const (
+	FailureUnsupportedFormat = "unsupported_format"
+	FailureEncrypted         = "encrypted"
+	FailureCorrupt           = "corrupt"
+	FailureEmptyText         = "empty_text"
+	FailureOCRUnavailable    = "ocr_unavailable"
+	FailureTooLarge          = "too_large"
+	FailureStorage           = "storage_error"
+	FailureInternal          = "processing_error"
+	FailureQuota             = "quota_exceeded"
+)
+
+// failureReason maps a pipeline error onto the reason stored with the document.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, core.ErrUnsupportedFormat):
+		return FailureUnsupportedFormat
+	case errors.Is(err, core.ErrEncryptedDocument):
+		return FailureEncrypted
+	case errors.Is(err, core.ErrCorruptDocument):
+		return FailureCorrupt
+	case errors.Is(err, core.ErrEmptyText):
+		return FailureEmptyText
+	case errors.Is(err, core.ErrOCRUnavailable):
+		return FailureOCRUnavailable
+	case errors.Is(err, core.ErrDocumentTooLarge):
+		return FailureTooLarge
+	case errors.Is(err, ErrPageQuotaExceeded):
+		return FailureQuota
+	default:
+		return FailureInternal
 	}
-	return bucket, key
 }
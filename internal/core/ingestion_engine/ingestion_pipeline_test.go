@@ -0,0 +1,208 @@
+package ingestion_engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// fakeDB keeps documents and chunks in memory. Methods the pipeline does not use fall
+// through to the nil embedded client and panic.
+type fakeDB struct {
+	db.DbClient
+
+	mu     sync.Mutex
+	docs   map[string]*models.Document
+	chunks map[string][]models.DocumentChunk
+}
+
+func newFakeDB(docs ...*models.Document) *fakeDB {
+	f := &fakeDB{docs: make(map[string]*models.Document), chunks: make(map[string][]models.DocumentChunk)}
+	for _, d := range docs {
+		f.docs[d.ID] = d
+	}
+	return f
+}
+
+func (f *fakeDB) GetDocumentByID(_ context.Context, id string) (*models.Document, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.docs[id]; ok {
+		cp := *d
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeDB) UpdateDocumentStatus(_ context.Context, id, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.docs[id].Status = status
+	return nil
+}
+
+func (f *fakeDB) MarkDocumentFailed(_ context.Context, id, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.docs[id].Status, f.docs[id].FailureReason = "failed", reason
+	return nil
+}
+
+func (f *fakeDB) SetDocumentPageCount(context.Context, string, int) error { return nil }
+
+func (f *fakeDB) InsertDocumentChunks(_ context.Context, rows []models.DocumentChunk) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range rows {
+		f.chunks[r.DocumentID] = append(f.chunks[r.DocumentID], r)
+	}
+	return nil
+}
+
+func (f *fakeDB) DeleteDocumentChunks(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.chunks, id)
+	return nil
+}
+
+func (f *fakeDB) GetCachedEmbeddings(context.Context, string, []string) (map[string][]float32, error) {
+	return map[string][]float32{}, nil
+}
+
+func (f *fakeDB) PutCachedEmbeddings(context.Context, string, map[string][]float32) error {
+	return nil
+}
+
+func (f *fakeDB) chunkCount(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.chunks[id])
+}
+
+// fakeObjects serves every key from one in-memory file.
+type fakeObjects struct {
+	objectclient.ObjectClient
+	data string
+}
+
+func (o fakeObjects) GetObjectReader(context.Context, string, string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(o.data)), nil
+}
+
+// fakeEmbedder returns a small fixed vector per text.
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) EmbedTexts(_ context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for k, t := range texts {
+		out[k] = []float32{float32(len(t)), 1}
+	}
+	return out, nil
+}
+
+// lineExtractor emits one fragment per line. With failAfter > 0 it fails once it has
+// emitted that many.
+type lineExtractor struct {
+	failAfter int
+}
+
+var errExtractInterrupted = errors.New("extraction interrupted")
+
+func (e *lineExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, _ string) (<-chan core.Fragment, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan core.Fragment)
+	g.Go(func() error {
+		defer close(out)
+		for n, line := range strings.Split(string(data), "\n") {
+			if e.failAfter > 0 && n == e.failAfter {
+				return errExtractInterrupted
+			}
+			select {
+			case out <- core.Fragment{Text: line}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	return out, nil
+}
+
+func testDocument(id string) *models.Document {
+	return &models.Document{ID: id, UserID: "user", StorageURL: "https://bucket.s3.us-east-1.amazonaws.com/user/doc.txt", ContentType: "text/plain", Status: "uploaded"}
+}
+
+func testText(lines int) string {
+	var sb strings.Builder
+	for n := range lines {
+		fmt.Fprintf(&sb, "Line %d of the test document talks about a different topic each time.\n", n)
+	}
+	return sb.String()
+}
+
+func TestProcessOneRetryReplacesChunks(t *testing.T) {
+	ctx := context.Background()
+	doc := testDocument("doc-1")
+	fdb := newFakeDB(doc)
+	ext := &lineExtractor{}
+	cfg := &IngestConfig{TargetTokens: 40, OverlapTokens: 5, BatchSize: 2}
+	ing := NewDocumentIngestor(fdb, fakeObjects{data: testText(60)}, fakeEmbedder{}, ext, tokenizer.NewEstimator(), cfg)
+
+	// A clean run gives the expected count.
+	if err := ing.ProcessOne(ctx, doc.ID); err != nil {
+		t.Fatalf("clean run: %v", err)
+	}
+	want := fdb.chunkCount(doc.ID)
+	if want < 4 {
+		t.Fatalf("clean run stored %d chunks; the test needs several batches", want)
+	}
+	if err := fdb.DeleteDocumentChunks(ctx, doc.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run that fails part way leaves some chunks behind.
+	ext.failAfter = 40
+	if err := ing.ProcessOne(ctx, doc.ID); !errors.Is(err, errExtractInterrupted) {
+		t.Fatalf("failing run: got %v", err)
+	}
+	if got, _ := fdb.GetDocumentByID(ctx, doc.ID); got.Status != "failed" {
+		t.Fatalf("status after failure = %q, want failed", got.Status)
+	}
+	if n := fdb.chunkCount(doc.ID); n == 0 {
+		t.Fatal("failing run stored no chunks; the retry would not be tested")
+	}
+
+	// The retry replaces them rather than adding to them.
+	ext.failAfter = 0
+	if err := ing.ProcessOne(ctx, doc.ID); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if got := fdb.chunkCount(doc.ID); got != want {
+		t.Fatalf("chunks after retry = %d, want %d", got, want)
+	}
+
+	// Positions are each stored once.
+	seen := make(map[int]bool)
+	for _, c := range fdb.chunks[doc.ID] {
+		if seen[c.Position] {
+			t.Fatalf("position %d stored twice", c.Position)
+		}
+		seen[c.Position] = true
+	}
+}
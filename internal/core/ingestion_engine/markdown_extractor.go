@@ -0,0 +1,217 @@
+package ingestion_engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ core.DocumentExtractor = (*MarkdownExtractor)(nil)
+
+// MarkdownContentTypes and MarkdownExtensions are what the MarkdownExtractor is registered under.
+var (
+	MarkdownContentTypes = []string{"text/markdown", "text/x-markdown"}
+	MarkdownExtensions   = []string{".md", ".markdown", ".mdown", ".mkd"}
+)
+
+// MarkdownExtractor reads Markdown line by line, keeping the heading hierarchy as the
+// heading path of every fragment. Paragraphs, lists and fenced code blocks are emitted
+// whole (up to maxFragmentBytes) rather than line by line, so code is never split
+// from its fence.
+type MarkdownExtractor struct{}
+
+func NewMarkdownExtractor() *MarkdownExtractor {
+	return &MarkdownExtractor{}
+}
+
+// ExtractText streams Markdown blocks from r.
+func (e *MarkdownExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan core.Fragment, error) {
+	out := make(chan core.Fragment, 32)
+
+	g.Go(func() error {
+		defer close(out)
+
+		em := &sectionEmitter{out: out}
+		if err := e.extract(ctx, r, em); err != nil {
+			return err
+		}
+		if em.n == 0 {
+			return fmt.Errorf("%w (content type %q)", core.ErrEmptyText, contentType)
+		}
+		return nil
+	})
+
+	return out, nil
+}
+
+func (e *MarkdownExtractor) extract(ctx context.Context, r io.Reader, em *sectionEmitter) error {
+	sc := newLineScanner(r)
+
+	var (
+		headings    [6]string // current heading text per level
+		block       []string  // lines of the paragraph or code block being built
+		blockBytes  int
+		blockStart  int64  // stream offset of the first line in block
+		fence       string // opening fence marker while inside a code block
+		first       = true
+		frontMatter bool
+	)
+
+	path := func() []string {
+		var p []string
+		for _, h := range headings {
+			if h != "" {
+				p = append(p, h)
+			}
+		}
+		return p
+	}
+
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		text := strings.Join(block, "\n")
+		block, blockBytes = block[:0], 0
+		return em.emit(ctx, path(), blockStart, text)
+	}
+
+	addLine := func(line string) {
+		if len(block) == 0 {
+			blockStart = sc.Offset()
+		}
+		block = append(block, line)
+		blockBytes += len(line) + 1
+	}
+
+	setHeading := func(level int, text string) {
+		headings[level-1] = text
+		for l := level; l < len(headings); l++ {
+			headings[l] = ""
+		}
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// YAML front matter is metadata, not content.
+		if first {
+			first = false
+			if trimmed == "---" {
+				frontMatter = true
+				continue
+			}
+		}
+		if frontMatter {
+			if trimmed == "---" || trimmed == "..." {
+				frontMatter = false
+			}
+			continue
+		}
+
+		// Inside a fenced code block everything is literal until the closing fence.
+		if fence != "" {
+			addLine(line)
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if blockBytes >= maxFragmentBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if marker := fenceMarker(trimmed); marker != "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			fence = marker
+			addLine(line)
+			continue
+		}
+
+		if level, text, ok := atxHeading(trimmed); ok {
+			if err := flush(); err != nil {
+				return err
+			}
+			setHeading(level, text)
+			continue
+		}
+
+		// Setext headings underline the paragraph that precedes them.
+		if len(block) > 0 && isSetextUnderline(trimmed) {
+			level := 2
+			if trimmed[0] == '=' {
+				level = 1
+			}
+			text := strings.TrimSpace(strings.Join(block, " "))
+			block, blockBytes = block[:0], 0
+			setHeading(level, text)
+			continue
+		}
+
+		if trimmed == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		addLine(line)
+		if blockBytes >= maxFragmentBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// atxHeading parses "## Title ##" style headings.
+func atxHeading(line string) (level int, text string, ok bool) {
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, "", false
+	}
+	text = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#"))
+	return level, text, true
+}
+
+// fenceMarker returns the fence that opens a code block (``` or ~~~, possibly longer).
+func fenceMarker(line string) string {
+	for _, c := range []byte{'`', '~'} {
+		n := 0
+		for n < len(line) && line[n] == c {
+			n++
+		}
+		if n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+func isSetextUnderline(line string) bool {
+	if line == "" {
+		return false
+	}
+	return strings.Trim(line, "=") == "" || strings.Trim(line, "-") == ""
+}
@@ -0,0 +1,257 @@
+package ingestion_engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ core.DocumentExtractor = (*OCRExtractor)(nil)
+
+// OCRContentTypes and OCRExtensions are what the OCRExtractor is registered under.
+// PDFs are included so scanned documents fall back to OCR when their text layer is empty.
+var (
+	OCRContentTypes = []string{"application/pdf", "image/png", "image/jpeg", "image/jpg", "image/tiff", "image/tif"}
+	OCRExtensions   = []string{".pdf", ".png", ".jpg", ".jpeg", ".tif", ".tiff"}
+)
+
+// OCRConfig tunes the OCR extractor.
+//
+// Languages:   tesseract language spec, e.g. "eng" or "eng+deu".
+// MaxPages:    pages OCRed per document; later pages are skipped (0 = no limit).
+// Parallelism: pages rasterised and recognised concurrently per document.
+// DPI:         rasterisation resolution for PDF pages.
+type OCRConfig struct {
+	Languages   string
+	MaxPages    int
+	Parallelism int
+	DPI         int
+}
+
+// OCRExtractor recognises text in images and scanned PDFs with a local Tesseract binary.
+// PDFs are first read through their text layer; only when that yields nothing are the
+// pages rasterised with pdftoppm and recognised in parallel, still emitted in page order.
+type OCRExtractor struct {
+	cfg OCRConfig
+}
+
+func NewOCRExtractor(cfg OCRConfig) *OCRExtractor {
+	if cfg.Languages == "" {
+		cfg.Languages = "eng"
+	}
+	if cfg.Parallelism <= 0 {
+		cfg.Parallelism = 1
+	}
+	if cfg.DPI <= 0 {
+		cfg.DPI = 300
+	}
+	return &OCRExtractor{cfg: cfg}
+}
+
+// ExtractText streams recognised text from r. Images fail up front with
+// core.ErrOCRUnavailable when tesseract is not installed.
+func (e *OCRExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan core.Fragment, error) {
+	contentType = normalizeContentType(contentType)
+	isPDF := contentType == "application/pdf"
+	if !isPDF && !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%w: %q", core.ErrUnsupportedFormat, contentType)
+	}
+	if !isPDF {
+		if err := requireTools("tesseract"); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan core.Fragment, 32)
+
+	g.Go(func() error {
+		defer close(out)
+
+		f, cleanup, err := spoolToTempFile(r)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		var n int
+		if isPDF {
+			n, err = e.extractPDF(ctx, f.Name(), out)
+		} else {
+			n, err = e.recognise(ctx, f.Name(), out)
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w (content type %q)", core.ErrEmptyText, contentType)
+		}
+		return nil
+	})
+
+	return out, nil
+}
+
+// extractPDF reads the text layer and falls back to OCR when it is empty.
+func (e *OCRExtractor) extractPDF(ctx context.Context, path string, out chan<- core.Fragment) (int, error) {
+	n, err := streamPDF(ctx, path, out)
+	if err != nil || n > 0 {
+		return n, err
+	}
+	if err := requireTools("tesseract", "pdftoppm", "pdfinfo"); err != nil {
+		return 0, fmt.Errorf("scanned PDF has no text layer: %w", err)
+	}
+
+	pages, err := pdfPageCount(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if e.cfg.MaxPages > 0 && pages > e.cfg.MaxPages {
+		log.Printf("ocr: document has %d pages, recognising the first %d", pages, e.cfg.MaxPages)
+		pages = e.cfg.MaxPages
+	}
+
+	dir, err := os.MkdirTemp("", "contexta-ocr-*")
+	if err != nil {
+		return 0, fmt.Errorf("create ocr dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Pages are recognised in windows of cfg.Parallelism and written to the pipe in
+	// order, separated by form feeds, so scanFragments can number them.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(e.recognisePages(ctx, path, dir, pages, pw))
+	}()
+	defer pr.Close()
+
+	return scanFragments(ctx, pr, 1, out)
+}
+
+func (e *OCRExtractor) recognisePages(ctx context.Context, path, dir string, pages int, w io.Writer) error {
+	for start := 1; start <= pages; start += e.cfg.Parallelism {
+		end := min(start+e.cfg.Parallelism-1, pages)
+		texts := make([][]byte, end-start+1)
+
+		pg, pctx := errgroup.WithContext(ctx)
+		for p := start; p <= end; p++ {
+			pg.Go(func() error {
+				text, err := e.ocrPDFPage(pctx, path, dir, p)
+				texts[p-start] = text
+				return err
+			})
+		}
+		if err := pg.Wait(); err != nil {
+			return err
+		}
+
+		for _, text := range texts {
+			if _, err := w.Write(text); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte("\n\f")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ocrPDFPage rasterises one page and returns its recognised text.
+func (e *OCRExtractor) ocrPDFPage(ctx context.Context, path, dir string, page int) ([]byte, error) {
+	prefix := filepath.Join(dir, fmt.Sprintf("page-%d", page))
+	p := strconv.Itoa(page)
+
+	var stderr cappedBuffer
+	raster := exec.CommandContext(ctx, "pdftoppm", "-r", strconv.Itoa(e.cfg.DPI), "-f", p, "-l", p, "-png", "-singlefile", path, prefix)
+	raster.Stderr = &stderr
+	if err := raster.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, classifyPDFError(err, stderr.String())
+	}
+	image := prefix + ".png"
+	defer os.Remove(image)
+
+	stderr.Reset()
+	cmd := exec.CommandContext(ctx, "tesseract", image, "stdout", "-l", e.cfg.Languages)
+	cmd.Stderr = &stderr
+	text, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("tesseract page %d: %v: %s", page, err, strings.TrimSpace(stderr.String()))
+	}
+	return text, nil
+}
+
+// recognise runs tesseract over an image. Multi-page TIFFs come back separated by form feeds.
+func (e *OCRExtractor) recognise(ctx context.Context, path string, out chan<- core.Fragment) (int, error) {
+	var stderr cappedBuffer
+	cmd := exec.CommandContext(ctx, "tesseract", path, "stdout", "-l", e.cfg.Languages)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("tesseract pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("tesseract start: %w", err)
+	}
+
+	n, scanErr := scanFragments(ctx, stdout, 1, out)
+	if scanErr != nil {
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil && scanErr == nil {
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		return n, fmt.Errorf("%w: tesseract: %s", core.ErrCorruptDocument, strings.TrimSpace(stderr.String()))
+	}
+	return n, scanErr
+}
+
+// pdfPageCount reads the page count reported by pdfinfo.
+func pdfPageCount(ctx context.Context, path string) (int, error) {
+	var stderr cappedBuffer
+	cmd := exec.CommandContext(ctx, "pdfinfo", path)
+	cmd.Stderr = &stderr
+	info, err := cmd.Output()
+	if err != nil {
+		return 0, classifyPDFError(err, stderr.String())
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(info))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && strings.TrimSpace(k) == "Pages" {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return 0, fmt.Errorf("%w: bad page count %q", core.ErrCorruptDocument, v)
+			}
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: pdfinfo reported no page count", core.ErrCorruptDocument)
+}
+
+// requireTools checks that every external binary OCR depends on is on PATH.
+func requireTools(tools ...string) error {
+	for _, t := range tools {
+		if _, err := exec.LookPath(t); err != nil {
+			return fmt.Errorf("%w: %s not found on PATH", core.ErrOCRUnavailable, t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package ingestion_engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrPageQuotaExceeded stops ingestion of a document that goes over its owner's
+// monthly page allowance.
+var ErrPageQuotaExceeded = errors.New("monthly page quota exceeded")
+
+// pageTextBytes is how much text counts as one page for formats without pages.
+const pageTextBytes = 3000
+
+// pageCounter counts the pages a document uses: the highest page number seen for paged
+// formats, plus one page per pageTextBytes of text without a page number.
+//
+// allowance: pages the document may use; -1 for no limit.
+type pageCounter struct {
+	allowance int
+	maxPage   int
+	unpaged   int64
+}
+
+func (p *pageCounter) add(f core.Fragment) {
+	if f.Page > 0 {
+		p.maxPage = max(p.maxPage, f.Page)
+		return
+	}
+	p.unpaged += int64(len(f.Text))
+}
+
+func (p *pageCounter) total() int {
+	return p.maxPage + int((p.unpaged+pageTextBytes-1)/pageTextBytes)
+}
+
+// countPages passes fragments through unchanged while counting their pages, and fails
+// the pipeline as soon as the count goes over the allowance.
+func countPages(ctx context.Context, g *errgroup.Group, frags <-chan core.Fragment, pages *pageCounter) <-chan core.Fragment {
+	out := make(chan core.Fragment, 8)
+
+	g.Go(func() error {
+		defer close(out)
+		for f := range frags {
+			pages.add(f)
+			if pages.allowance >= 0 && pages.total() > pages.allowance {
+				return fmt.Errorf("%w: document needs more than the %d pages left this month", ErrPageQuotaExceeded, pages.allowance)
+			}
+			select {
+			case out <- f:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	return out
+}
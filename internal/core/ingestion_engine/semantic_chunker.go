@@ -0,0 +1,225 @@
+package ingestion_engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// SemanticConfig tunes the semantic chunker. Zero values take the defaults in brackets.
+//
+// Percentile:   neighbour distances above this percentile of their window become breakpoints [95].
+// MinTokens:    a chunk is not closed at a breakpoint before it has this many tokens [TargetTokens/4].
+// MaxTokens:    hard cap on chunk size; longer runs are cut at sentence ends [TargetTokens].
+// Window:       sentences per breakpoint window; percentiles are computed per window so memory stays bounded [256].
+// Context:      neighbouring sentences on each side embedded together with a sentence, to smooth noise [1; negative for none].
+// BatchSize:    sentences per embedding request [64].
+// MaxSentences: sentences embedded per document; the rest is chunked by size only, to protect the embedding quota [4000].
+type SemanticConfig struct {
+	Percentile   float64
+	MinTokens    int
+	MaxTokens    int
+	Window       int
+	Context      int
+	BatchSize    int
+	MaxSentences int
+}
+
+func (c SemanticConfig) withDefaults(target int) SemanticConfig {
+	if c.Percentile <= 0 || c.Percentile >= 100 {
+		c.Percentile = 95
+	}
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = max(target, 1)
+	}
+	if c.MinTokens <= 0 {
+		c.MinTokens = c.MaxTokens / 4
+	}
+	c.MinTokens = min(c.MinTokens, c.MaxTokens)
+	if c.Window <= 1 {
+		c.Window = 256
+	}
+	if c.Context < 0 {
+		c.Context = 0
+	} else if c.Context == 0 {
+		c.Context = 1
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 64
+	}
+	if c.MaxSentences <= 0 {
+		c.MaxSentences = 4000
+	}
+	return c
+}
+
+// semanticChunker embeds every sentence (with its neighbours as context) and closes a
+// chunk after a sentence whose cosine distance to the next one is unusually high for
+// its window, as long as the chunk has reached MinTokens. MaxTokens is enforced by the
+// packer regardless of breakpoints. Chunks carry no overlap: they end where the topic does.
+type semanticChunker struct {
+	tok      core.Tokenizer
+	embedder core.EmbeddingProvider
+	cfg      SemanticConfig
+
+	embedded  int       // sentences embedded so far in this document
+	exhausted bool      // MaxSentences reached; the rest is chunked by size
+	prev      []float32 // embedding of the last sentence of the previous window
+}
+
+func (c *semanticChunker) Chunk(ctx context.Context, frags <-chan core.Fragment, emit func(chunk) error) error {
+	p := newPacker(c.tok, c.cfg.MaxTokens, 0, true, emit)
+	window := make([]unit, 0, c.cfg.Window)
+
+	for frag := range frags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, u := range splitSentences(c.tok, frag, c.cfg.MaxTokens) {
+			if strings.TrimSpace(u.frag.Text) == "" {
+				continue
+			}
+			window = append(window, u)
+			if len(window) == c.cfg.Window {
+				if err := c.packWindow(ctx, p, window); err != nil {
+					return err
+				}
+				window = window[:0]
+			}
+		}
+	}
+	if err := c.packWindow(ctx, p, window); err != nil {
+		return err
+	}
+	return p.flush()
+}
+
+// packWindow finds the breakpoints of one window and feeds its sentences to the packer.
+func (c *semanticChunker) packWindow(ctx context.Context, p *packer, window []unit) error {
+	if len(window) == 0 {
+		return nil
+	}
+
+	// breakBefore[j]: the topic shifts between sentence j-1 and j.
+	breakBefore := make([]bool, len(window))
+	if !c.exhausted && c.embedded+len(window) > c.cfg.MaxSentences {
+		log.Printf("semantic chunker: embedding budget of %d sentences reached, chunking the rest by size", c.cfg.MaxSentences)
+		c.exhausted, c.prev = true, nil
+	}
+	if !c.exhausted {
+		vecs, err := c.embedWindow(ctx, window)
+		if err != nil {
+			return err
+		}
+		c.embedded += len(window)
+
+		// dist[j] is the distance between sentence j-1 (the previous window's last for j = 0) and j.
+		dist := make([]float64, len(window))
+		var observed []float64
+		for j := range window {
+			before := c.prev
+			if j > 0 {
+				before = vecs[j-1]
+			}
+			if before == nil {
+				dist[j] = math.NaN()
+				continue
+			}
+			dist[j] = cosineDistance(before, vecs[j])
+			observed = append(observed, dist[j])
+		}
+		c.prev = vecs[len(vecs)-1]
+
+		threshold := percentile(observed, c.cfg.Percentile)
+		for j, d := range dist {
+			breakBefore[j] = !math.IsNaN(d) && d > threshold
+		}
+	}
+
+	for j, u := range window {
+		if breakBefore[j] && p.toks >= c.cfg.MinTokens {
+			if err := p.flush(); err != nil {
+				return err
+			}
+		}
+		if err := p.add(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedWindow embeds each sentence together with Context neighbours on either side,
+// BatchSize sentences per request.
+func (c *semanticChunker) embedWindow(ctx context.Context, window []unit) ([][]float32, error) {
+	texts := make([]string, len(window))
+	for j := range window {
+		lo, hi := max(0, j-c.cfg.Context), min(len(window), j+c.cfg.Context+1)
+		parts := make([]string, 0, hi-lo)
+		for _, u := range window[lo:hi] {
+			parts = append(parts, strings.TrimSpace(u.frag.Text))
+		}
+		texts[j] = strings.Join(parts, " ")
+	}
+
+	vecs := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += c.cfg.BatchSize {
+		batch := texts[start:min(len(texts), start+c.cfg.BatchSize)]
+		out, err := c.embedder.EmbedTexts(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("embed sentences: %w", err)
+		}
+		if len(out) != len(batch) {
+			return nil, fmt.Errorf("embed sentences: got %d vectors for %d sentences", len(out), len(batch))
+		}
+		vecs = append(vecs, out...)
+	}
+	return vecs, nil
+}
+
+// splitSentences breaks frag into sentence units, splitting any sentence over limit
+// tokens further on line and word boundaries.
+func splitSentences(tok core.Tokenizer, frag core.Fragment, limit int) []unit {
+	var out []unit
+	start := 0
+	for _, end := range sentenceEnds(frag.Text) {
+		sub := frag
+		sub.Text, sub.Offset = frag.Text[start:end], frag.Offset+int64(start)
+		out = append(out, splitRecursive(tok, sub, limit)...)
+		start = end
+	}
+	for k := range out {
+		out[k].cont = k > 0
+	}
+	return out
+}
+
+func cosineDistance(a, b []float32) float64 {
+	var dot, na, nb float64
+	for k := range min(len(a), len(b)) {
+		dot += float64(a[k]) * float64(b[k])
+		na += float64(a[k]) * float64(a[k])
+		nb += float64(b[k]) * float64(b[k])
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(na)*math.Sqrt(nb))
+}
+
+// percentile returns the nearest-rank p-th percentile of xs, or +Inf when xs is empty
+// so that nothing counts as a breakpoint.
+func percentile(xs []float64, p float64) float64 {
+	if len(xs) == 0 {
+		return math.Inf(1)
+	}
+	s := slices.Clone(xs)
+	slices.Sort(s)
+	rank := int(math.Ceil(p / 100 * float64(len(s))))
+	return s[min(max(rank, 1), len(s))-1]
+}
@@ -0,0 +1,354 @@
+package ingestion_engine
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ core.DocumentExtractor = (*TableExtractor)(nil)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// TableContentTypes and TableExtensions are what the TableExtractor is registered under.
+var (
+	TableContentTypes = []string{"text/csv", "application/csv", "text/tab-separated-values", xlsxContentType}
+	TableExtensions   = []string{".csv", ".tsv", ".xlsx"}
+)
+
+// TableConfig tunes how spreadsheets are grouped into fragments.
+//
+// MaxGroupRows:   data rows per fragment.
+// MaxGroupBytes:  soft cap on a rendered fragment; a group closes once it is reached.
+// MaxGroupTokens: hard cap on a rendered fragment, header included; set to the chunk target
+// so the chunker never splits a group away from its header. A single row may exceed it.
+// Tokenizer:      counts tokens for MaxGroupTokens; without one the token cap is off.
+// WideColumns:    tables with more columns than this are rendered as Markdown tables.
+type TableConfig struct {
+	MaxGroupRows   int
+	MaxGroupBytes  int
+	MaxGroupTokens int
+	Tokenizer      core.Tokenizer
+	WideColumns    int
+}
+
+// TableExtractor reads CSV, TSV and XLSX files row by row and emits groups of rows,
+// each carrying the header so every chunk can be read on its own. Rows are rendered
+// as "Header: value" records; tables wider than WideColumns become Markdown tables.
+// Fragments carry the sheet name as heading path and the row range as metadata.
+type TableExtractor struct {
+	cfg TableConfig
+}
+
+func NewTableExtractor(cfg TableConfig) *TableExtractor {
+	if cfg.MaxGroupRows <= 0 {
+		cfg.MaxGroupRows = 25
+	}
+	if cfg.MaxGroupBytes <= 0 {
+		cfg.MaxGroupBytes = 2000
+	}
+	if cfg.WideColumns <= 0 {
+		cfg.WideColumns = 8
+	}
+	if cfg.Tokenizer == nil {
+		cfg.MaxGroupTokens = 0
+	}
+	return &TableExtractor{cfg: cfg}
+}
+
+// ExtractText streams row groups from r.
+func (e *TableExtractor) ExtractText(ctx context.Context, g *errgroup.Group, r io.Reader, contentType string) (<-chan core.Fragment, error) {
+	contentType = normalizeContentType(contentType)
+	switch contentType {
+	case "text/csv", "application/csv", "text/tab-separated-values", xlsxContentType:
+	default:
+		return nil, fmt.Errorf("%w: %q", core.ErrUnsupportedFormat, contentType)
+	}
+
+	out := make(chan core.Fragment, 32)
+
+	g.Go(func() error {
+		defer close(out)
+
+		var (
+			n   int
+			err error
+		)
+		switch contentType {
+		case xlsxContentType:
+			n, err = e.extractXLSX(ctx, r, out)
+		case "text/tab-separated-values":
+			n, err = e.extractCSV(ctx, r, '\t', out)
+		default:
+			n, err = e.extractCSV(ctx, r, ',', out)
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w (content type %q)", core.ErrEmptyText, contentType)
+		}
+		return nil
+	})
+
+	return out, nil
+}
+
+func (e *TableExtractor) extractCSV(ctx context.Context, r io.Reader, comma rune, out chan<- core.Fragment) (int, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+
+	tg := e.newGrouper(ctx, "", out)
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				return tg.n, fmt.Errorf("%w: %v", core.ErrCorruptDocument, err)
+			}
+			return tg.n, err
+		}
+		if err := tg.add(row, rec); err != nil {
+			return tg.n, err
+		}
+	}
+	return tg.n, tg.flush()
+}
+
+// tableGrouper accumulates rows under a header and emits them as fragments.
+type tableGrouper struct {
+	ctx    context.Context
+	cfg    TableConfig
+	sheet  string
+	out    chan<- core.Fragment
+	header []string
+	rows   [][]string
+	nums   []int // sheet row number of each of rows
+	bytes  int
+	toks   int // estimated tokens of the rendered group
+	n      int
+}
+
+func (e *TableExtractor) newGrouper(ctx context.Context, sheet string, out chan<- core.Fragment) *tableGrouper {
+	return &tableGrouper{ctx: ctx, cfg: e.cfg, sheet: sheet, out: out}
+}
+
+// add takes one sheet row. The first non-empty row becomes the header.
+func (t *tableGrouper) add(rowNum int, cells []string) error {
+	if isBlankRow(cells) {
+		return nil
+	}
+	if t.header == nil {
+		t.header = make([]string, len(cells))
+		for i, c := range cells {
+			t.header[i] = strings.TrimSpace(c)
+		}
+		return nil
+	}
+
+	row := make([]string, len(cells))
+	copy(row, cells)
+	if t.cfg.MaxGroupTokens > 0 {
+		cost := t.rowTokens(row)
+		if len(t.rows) > 0 && t.toks+cost > t.cfg.MaxGroupTokens {
+			if err := t.flush(); err != nil {
+				return err
+			}
+			cost = t.rowTokens(row)
+		}
+		t.toks += cost
+	}
+	t.rows = append(t.rows, row)
+	t.nums = append(t.nums, rowNum)
+	for _, c := range row {
+		t.bytes += len(c) + 3
+	}
+
+	if len(t.rows) >= t.cfg.MaxGroupRows || t.bytes >= t.cfg.MaxGroupBytes {
+		return t.flush()
+	}
+	return nil
+}
+
+// rowTokens estimates what row adds to the rendered group: its line, and the header
+// lines of a Markdown table if it starts the group.
+func (t *tableGrouper) rowTokens(row []string) int {
+	tok := t.cfg.Tokenizer
+	header := t.columns(max(len(t.header), len(row)))
+	if len(header) <= t.cfg.WideColumns {
+		return tok.Count(renderRecords(header, [][]string{row})) + tok.Count("\n")
+	}
+	n := tok.Count(markdownRow(header, row))
+	if len(t.rows) == 0 {
+		n += tok.Count(markdownHeader(header))
+	}
+	return n
+}
+
+// columns returns the header names of the first width columns, naming unnamed ones
+// by their letter.
+func (t *tableGrouper) columns(width int) []string {
+	header := make([]string, width)
+	for i := range header {
+		if i < len(t.header) && t.header[i] != "" {
+			header[i] = t.header[i]
+		} else {
+			header[i] = "Column " + columnName(i)
+		}
+	}
+	return header
+}
+
+func (t *tableGrouper) flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	err := t.emitRows(t.rows, t.nums)
+	t.rows, t.nums, t.bytes, t.toks = t.rows[:0], t.nums[:0], 0, 0
+	return err
+}
+
+// emitRows renders rows as one fragment. A group over MaxGroupTokens, which the
+// estimate in add can let through, is halved until its parts fit.
+func (t *tableGrouper) emitRows(rows [][]string, nums []int) error {
+	width := len(t.header)
+	for _, r := range rows {
+		width = max(width, len(r))
+	}
+	header := t.columns(width)
+
+	var text string
+	if width > t.cfg.WideColumns {
+		text = renderMarkdownTable(header, rows)
+	} else {
+		text = renderRecords(header, rows)
+	}
+	if t.cfg.MaxGroupTokens > 0 && t.cfg.Tokenizer.Count(text) > t.cfg.MaxGroupTokens {
+		if len(rows) > 1 {
+			half := len(rows) / 2
+			if err := t.emitRows(rows[:half], nums[:half]); err != nil {
+				return err
+			}
+			return t.emitRows(rows[half:], nums[half:])
+		}
+		// One row too big for a chunk even alone will be split by the chunker; label each
+		// cell so the pieces can still be read.
+		text = renderRecords(header, rows)
+	}
+
+	frag := core.Fragment{
+		Text: text,
+		Metadata: map[string]string{
+			"row_start": strconv.Itoa(nums[0]),
+			"row_end":   strconv.Itoa(nums[len(nums)-1]),
+		},
+	}
+	if t.sheet != "" {
+		frag.HeadingPath = []string{t.sheet}
+		frag.Metadata["sheet"] = t.sheet
+	}
+
+	select {
+	case t.out <- frag:
+		t.n++
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+// renderRecords writes one "Header: value | Header: value" line per row, skipping empty cells.
+func renderRecords(header []string, rows [][]string) string {
+	var b strings.Builder
+	for _, row := range rows {
+		first := true
+		for i, c := range row {
+			if c = cleanCell(c); c == "" {
+				continue
+			}
+			if !first {
+				b.WriteString(" | ")
+			}
+			first = false
+			b.WriteString(header[i])
+			b.WriteString(": ")
+			b.WriteString(c)
+		}
+		b.WriteByte('\n')
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// renderMarkdownTable writes the rows as a Markdown table under the header.
+func renderMarkdownTable(header []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(markdownHeader(header))
+	for _, r := range rows {
+		b.WriteString(markdownRow(header, r))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// markdownHeader returns the header line and separator of a Markdown table.
+func markdownHeader(header []string) string {
+	var b strings.Builder
+	b.WriteString(markdownRow(header, header))
+	b.WriteByte('|')
+	for range header {
+		b.WriteString(" --- |")
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// markdownRow returns one Markdown table line with a cell per header column.
+func markdownRow(header, cells []string) string {
+	var b strings.Builder
+	b.WriteByte('|')
+	for i := range header {
+		c := ""
+		if i < len(cells) {
+			c = strings.ReplaceAll(cleanCell(cells[i]), "|", `\|`)
+		}
+		b.WriteByte(' ')
+		b.WriteString(c)
+		b.WriteString(" |")
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+func cleanCell(c string) string {
+	return strings.Join(strings.Fields(c), " ")
+}
+
+func isBlankRow(cells []string) bool {
+	for _, c := range cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// columnName converts a zero-based column index to spreadsheet letters (0 → A, 27 → AB).
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
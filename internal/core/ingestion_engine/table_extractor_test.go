@@ -0,0 +1,76 @@
+package ingestion_engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+)
+
+// testCSV returns a header and rows data rows with the given number of columns.
+func testCSV(columns, rows int) string {
+	var b strings.Builder
+	for c := range columns {
+		if c > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "Heading%c", 'A'+c)
+	}
+	b.WriteByte('\n')
+	for r := range rows {
+		for c := range columns {
+			if c > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "value %d of row %d", c, r)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestTableChunksKeepTheirHeader(t *testing.T) {
+	tok := tokenizer.NewEstimator()
+	cases := []struct {
+		name    string
+		columns int
+		target  int
+		header  string // what every chunk must contain
+	}{
+		{"records", 4, 100, "HeadingD: "},
+		{"markdown", 10, 300, "| HeadingA | HeadingB |"},
+		{"row wider than a chunk", 10, 60, "Heading"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			doc := testDocument("table")
+			doc.ContentType = "text/csv"
+			fdb := newFakeDB(doc)
+			cfg := &IngestConfig{TargetTokens: c.target, OverlapTokens: 5, BatchSize: 4}
+			ext := NewTableExtractor(TableConfig{MaxGroupTokens: cfg.TargetTokens, Tokenizer: tok})
+			ing := NewDocumentIngestor(fdb, fakeObjects{data: testCSV(c.columns, 80)}, fakeEmbedder{}, ext, tok, cfg)
+
+			if err := ing.ProcessOne(ctx, doc.ID); err != nil {
+				t.Fatal(err)
+			}
+			chunks := fdb.chunks[doc.ID]
+			if len(chunks) < 5 {
+				t.Fatalf("%d chunks; the table should span many", len(chunks))
+			}
+			for _, ch := range chunks {
+				if !strings.Contains(ch.Text, c.header) {
+					t.Errorf("chunk %d lost the header:\n%s", ch.Position, ch.Text)
+				}
+				if ch.TokenCount > cfg.TargetTokens {
+					t.Errorf("chunk %d has %d tokens, over the %d target", ch.Position, ch.TokenCount, cfg.TargetTokens)
+				}
+				if ch.Metadata["row_start"] == "" {
+					t.Errorf("chunk %d has no row range", ch.Position)
+				}
+			}
+		})
+	}
+}
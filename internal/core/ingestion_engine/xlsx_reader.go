@@ -0,0 +1,305 @@
+package ingestion_engine
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// extractXLSX walks every worksheet of an XLSX workbook in order. Sheets are decoded
+// token by token so only the shared strings table and the current row group are held
+// in memory.
+func (e *TableExtractor) extractXLSX(ctx context.Context, r io.Reader, out chan<- core.Fragment) (int, error) {
+	f, cleanup, err := spoolToTempFile(r)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	encrypted, err := headContains(f.Name(), len(cfbMagic), cfbMagic)
+	if err != nil {
+		return 0, err
+	}
+	if encrypted {
+		return 0, fmt.Errorf("%w: workbook is password protected", core.ErrEncryptedDocument)
+	}
+
+	zr, err := zip.OpenReader(f.Name())
+	if err != nil {
+		return 0, fmt.Errorf("%w: xlsx: %v", core.ErrCorruptDocument, err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, zf := range zr.File {
+		files[zf.Name] = zf
+	}
+
+	sheets, err := xlsxSheets(files)
+	if err != nil {
+		return 0, err
+	}
+	shared, err := xlsxSharedStrings(files)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, sh := range sheets {
+		zf, ok := files[sh.target]
+		if !ok {
+			return n, fmt.Errorf("%w: xlsx: missing sheet part %s", core.ErrCorruptDocument, sh.target)
+		}
+		tg := e.newGrouper(ctx, sh.name, out)
+		err := readXLSXSheet(zf, shared, tg.add)
+		if err == nil {
+			err = tg.flush()
+		}
+		n += tg.n
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+type xlsxSheet struct {
+	name   string
+	target string // zip path of the worksheet part
+}
+
+// xlsxSheets lists worksheets in workbook order, resolving their parts through the workbook rels.
+func xlsxSheets(files map[string]*zip.File) ([]xlsxSheet, error) {
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(files, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Rels))
+	for _, rel := range rels.Rels {
+		t := rel.Target
+		if strings.HasPrefix(t, "/") {
+			t = strings.TrimPrefix(t, "/")
+		} else {
+			t = path.Join("xl", t)
+		}
+		targets[rel.ID] = t
+	}
+
+	sheets := make([]xlsxSheet, 0, len(wb.Sheets))
+	for _, s := range wb.Sheets {
+		t, ok := targets[s.RID]
+		if !ok {
+			return nil, fmt.Errorf("%w: xlsx: sheet %q has no relationship", core.ErrCorruptDocument, s.Name)
+		}
+		sheets = append(sheets, xlsxSheet{name: s.Name, target: t})
+	}
+	return sheets, nil
+}
+
+// xlsxSharedStrings loads the shared strings table; rich text runs are concatenated
+// and phonetic hints dropped.
+func xlsxSharedStrings(files map[string]*zip.File) ([]string, error) {
+	zf, ok := files["xl/sharedStrings.xml"]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: xlsx: %v", core.ErrCorruptDocument, err)
+	}
+	defer rc.Close()
+
+	var (
+		out     []string
+		cur     strings.Builder
+		inText  bool
+		inPhon  bool
+		inEntry bool
+	)
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: xlsx shared strings: %v", core.ErrCorruptDocument, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				inEntry = true
+				cur.Reset()
+			case "rPh":
+				inPhon = true
+			case "t":
+				inText = inEntry && !inPhon
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				inEntry = false
+				out = append(out, cur.String())
+			case "rPh":
+				inPhon = false
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				cur.Write(t)
+			}
+		}
+	}
+}
+
+// readXLSXSheet streams the rows of one worksheet to emit, with cells placed at
+// their column index and row numbers taken from the sheet.
+func readXLSXSheet(zf *zip.File, shared []string, emit func(row int, cells []string) error) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("%w: xlsx: %v", core.ErrCorruptDocument, err)
+	}
+	defer rc.Close()
+
+	var (
+		rowNum   int
+		cells    []string
+		cellCol  int
+		cellType string
+		value    strings.Builder
+		inValue  bool
+	)
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: xlsx sheet %s: %v", core.ErrCorruptDocument, zf.Name, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				rowNum++
+				if v := xmlAttr(t, "r"); v != "" {
+					if n, err := strconv.Atoi(v); err == nil {
+						rowNum = n
+					}
+				}
+				cells = cells[:0]
+				cellCol = -1
+			case "c":
+				cellCol++
+				if ref := xmlAttr(t, "r"); ref != "" {
+					if col, ok := cellColumn(ref); ok {
+						cellCol = col
+					}
+				}
+				cellType = xmlAttr(t, "t")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				for len(cells) <= cellCol {
+					cells = append(cells, "")
+				}
+				cells[cellCol] = xlsxCellValue(cellType, value.String(), shared)
+			case "row":
+				if err := emit(rowNum, cells); err != nil {
+					return err
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
+
+func xlsxCellValue(typ, raw string, shared []string) string {
+	switch typ {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || i < 0 || i >= len(shared) {
+			return ""
+		}
+		return shared[i]
+	case "b":
+		if strings.TrimSpace(raw) == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return raw
+	}
+}
+
+// cellColumn returns the zero-based column of a reference such as "AB12".
+func cellColumn(ref string) (int, bool) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return col - 1, true
+}
+
+func xmlAttr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func decodeZipXML(files map[string]*zip.File, name string, v any) error {
+	zf, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%w: xlsx: missing %s", core.ErrCorruptDocument, name)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("%w: xlsx: %v", core.ErrCorruptDocument, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%w: xlsx %s: %v", core.ErrCorruptDocument, name, err)
+	}
+	return nil
+}
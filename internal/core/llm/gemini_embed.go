@@ -3,7 +3,9 @@ package llm
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
+	"sync/atomic"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
@@ -11,9 +13,13 @@ import (
 	"github.com/markdave123-py/Contexta/internal/core"
 )
 
+// GeminiEmbedder embeds with a Gemini embedding model. Embedding responses carry no usage
+// metadata, so each batch's tokens are counted by the model's countTokens method and
+// reported; models that cannot count are left to the caller's fallback.
 type GeminiEmbedder struct {
-	client    *genai.Client
-	modelName string
+	client      *genai.Client
+	modelName   string
+	cannotCount atomic.Bool
 }
 
 func NewGeminiEmbedder(ctx context.Context, apiKey, modelName string) (*GeminiEmbedder, error) {
@@ -60,7 +66,29 @@ func (g *GeminiEmbedder) EmbedTexts(ctx context.Context, texts []string) ([][]fl
 	for _, e := range resp.Embeddings {
 		out = append(out, e.Values)
 	}
+	g.reportUsage(ctx, texts)
 	return out, nil
 }
 
+// reportUsage reports the tokens the model counts in texts. A model that rejects the
+// count is not asked again; a transient failure only skips this batch.
+func (g *GeminiEmbedder) reportUsage(ctx context.Context, texts []string) {
+	if g.cannotCount.Load() {
+		return
+	}
+	parts := make([]genai.Part, len(texts))
+	for k, t := range texts {
+		parts[k] = genai.Text(t)
+	}
+	res, err := g.client.GenerativeModel(g.modelName).CountTokens(ctx, parts...)
+	if err != nil {
+		if !IsRetryable(err) && ctx.Err() == nil {
+			g.cannotCount.Store(true)
+			log.Printf("gemini embed: %s cannot count tokens, usage will be estimated: %v", g.modelName, err)
+		}
+		return
+	}
+	core.ReportUsage(ctx, core.Usage{Operation: core.UsageEmbed, Model: g.modelName, InputTokens: int(res.TotalTokens)})
+}
+
 var _ core.EmbeddingProvider = (*GeminiEmbedder)(nil)
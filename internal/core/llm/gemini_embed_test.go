@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// fakeGemini serves batchEmbedContents and countTokens. With canCount unset, countTokens
+// is rejected the way Gemini rejects it for models that do not support it.
+func fakeGemini(t *testing.T, canCount bool, counts *atomic.Int32) *GeminiEmbedder {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":batchEmbedContents"):
+			w.Write([]byte(`{"embeddings":[{"values":[1,0]},{"values":[0,1]}]}`))
+		case strings.HasSuffix(r.URL.Path, ":countTokens"):
+			counts.Add(1)
+			if !canCount {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"code":400,"message":"countTokens is not supported","status":"INVALID_ARGUMENT"}}`))
+				return
+			}
+			w.Write([]byte(`{"totalTokens":17}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cl, err := genai.NewClient(context.Background(), option.WithAPIKey("key"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return &GeminiEmbedder{client: cl, modelName: "embed-model"}
+}
+
+func TestGeminiEmbedderReportsCountedTokens(t *testing.T) {
+	var counts atomic.Int32
+	g := fakeGemini(t, true, &counts)
+
+	ctx, collected := core.WithUsageCollector(context.Background())
+	vecs, err := g.EmbedTexts(ctx, []string{"one", "two"})
+	if err != nil || len(vecs) != 2 {
+		t.Fatalf("got %d vectors, %v", len(vecs), err)
+	}
+	u := collected.Usages()
+	if len(u) != 1 || u[0].InputTokens != 17 || u[0].Model != "embed-model" || u[0].Operation != core.UsageEmbed {
+		t.Fatalf("usage %+v, want the 17 tokens the provider counted", u)
+	}
+}
+
+func TestGeminiEmbedderWithoutCountingLeavesFallback(t *testing.T) {
+	var counts atomic.Int32
+	g := fakeGemini(t, false, &counts)
+
+	for range 3 {
+		ctx, collected := core.WithUsageCollector(context.Background())
+		if _, err := g.EmbedTexts(ctx, []string{"one", "two"}); err != nil {
+			t.Fatalf("a failed count failed the embedding: %v", err)
+		}
+		if u := collected.Usages(); len(u) != 0 {
+			t.Fatalf("usage %+v reported without a count", u)
+		}
+	}
+	if n := counts.Load(); n != 1 {
+		t.Fatalf("countTokens called %d times, want once: a model that cannot count is not asked again", n)
+	}
+}
@@ -31,6 +31,16 @@ func NewGeminiLLM(ctx context.Context, apiKey, modelName string) (*GeminiLLM, er
 	return &GeminiLLM{client: cl, modelName: modelName}, nil
 }
 
+// WithModel returns a GeminiLLM for another model that shares g's client.
+func (g *GeminiLLM) WithModel(modelName string) *GeminiLLM {
+	return &GeminiLLM{client: g.client, modelName: modelName}
+}
+
+// Model returns the model name requests are sent to.
+func (g *GeminiLLM) Model() string {
+	return g.modelName
+}
+
 func (g *GeminiLLM) Close() error {
 	if g.client != nil {
 		return g.client.Close()
@@ -50,6 +60,15 @@ func (g *GeminiLLM) Generate(ctx context.Context, systemPrompt, userPrompt strin
 	if err != nil {
 		return "", fmt.Errorf("gemini generate: %w", err)
 	}
+	if u := resp.UsageMetadata; u != nil {
+		// Thinking tokens are billed as output but only show up in the total.
+		core.ReportUsage(ctx, core.Usage{
+			Operation:    core.UsageGenerate,
+			Model:        g.modelName,
+			InputTokens:  int(u.PromptTokenCount),
+			OutputTokens: int(max(u.CandidatesTokenCount, u.TotalTokenCount-u.PromptTokenCount)),
+		})
+	}
 	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
 		return "", nil
 	}
@@ -0,0 +1,307 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.EmbeddingProvider = (*LimitedEmbedder)(nil)
+
+// EmbedLimits bounds how an embedding provider is called. Zero values disable a limit,
+// except MaxBatch and MaxRetries which take the defaults in brackets.
+//
+// RequestsPerMinute: provider requests per minute across all callers.
+// TokensPerMinute:   input tokens per minute across all callers.
+// MaxBatch:          texts per provider request [100].
+// MaxBatchTokens:    input tokens per provider request.
+// MaxConcurrent:     provider requests in flight across all callers.
+// MaxRetries:        retries of a request failing with a retryable error [5].
+// BaseBackoff:       first retry delay, doubled per attempt with jitter [500ms].
+// MaxBackoff:        cap on a single retry delay [30s].
+type EmbedLimits struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	MaxBatch          int
+	MaxBatchTokens    int
+	MaxConcurrent     int
+	MaxRetries        int
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
+}
+
+// LimitedEmbedder wraps any EmbeddingProvider with rate limiting, batch splitting and
+// retries. One instance is meant to be shared by every ingestion worker and the chat
+// handler, so the limits hold for the whole process.
+type LimitedEmbedder struct {
+	inner  core.EmbeddingProvider
+	tok    core.Tokenizer
+	limits EmbedLimits
+
+	requests *rate.Limiter // nil when unlimited
+	tokens   *rate.Limiter // nil when unlimited
+	slots    chan struct{} // nil when unlimited
+}
+
+// NewLimitedEmbedder wraps inner. tok measures request sizes for the token limits.
+func NewLimitedEmbedder(inner core.EmbeddingProvider, tok core.Tokenizer, limits EmbedLimits) *LimitedEmbedder {
+	if limits.MaxBatch <= 0 {
+		limits.MaxBatch = 100
+	}
+	if limits.MaxRetries <= 0 {
+		limits.MaxRetries = 5
+	}
+	if limits.BaseBackoff <= 0 {
+		limits.BaseBackoff = 500 * time.Millisecond
+	}
+	if limits.MaxBackoff <= 0 {
+		limits.MaxBackoff = 30 * time.Second
+	}
+
+	e := &LimitedEmbedder{inner: inner, tok: tok, limits: limits}
+	if limits.RequestsPerMinute > 0 {
+		e.requests = rate.NewLimiter(rate.Limit(float64(limits.RequestsPerMinute)/60), max(1, limits.RequestsPerMinute/60))
+	}
+	if limits.TokensPerMinute > 0 {
+		e.tokens = rate.NewLimiter(rate.Limit(float64(limits.TokensPerMinute)/60), limits.TokensPerMinute)
+	}
+	if limits.MaxConcurrent > 0 {
+		e.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return e
+}
+
+// EmbedTexts splits texts into batches within MaxBatch and MaxBatchTokens, embeds them
+// (concurrently, within MaxConcurrent) and returns the vectors in input order.
+func (e *LimitedEmbedder) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	out := make([][]float32, len(texts))
+	g, gctx := errgroup.WithContext(ctx)
+	for _, b := range e.batches(texts) {
+		g.Go(func() error {
+			vecs, err := e.embedBatch(gctx, texts[b.start:b.end], b.tokens)
+			if err != nil {
+				return err
+			}
+			copy(out[b.start:b.end], vecs)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type batch struct {
+	start, end int
+	tokens     int
+}
+
+// batches cuts texts into consecutive runs within the batch limits. A single text over
+// MaxBatchTokens still goes alone; the provider decides whether it is too long.
+func (e *LimitedEmbedder) batches(texts []string) []batch {
+	var out []batch
+	cur := batch{}
+	for k, t := range texts {
+		n := e.tok.Count(t)
+		full := k-cur.start >= e.limits.MaxBatch ||
+			(e.limits.MaxBatchTokens > 0 && cur.tokens+n > e.limits.MaxBatchTokens)
+		if k > cur.start && full {
+			cur.end = k
+			out = append(out, cur)
+			cur = batch{start: k}
+		}
+		cur.tokens += n
+	}
+	cur.end = len(texts)
+	return append(out, cur)
+}
+
+// embedBatch sends one batch, retrying retryable failures with exponential backoff, or
+// after the delay the provider asks for if that is longer. A batch the provider rejects
+// as too large is halved and both halves sent separately.
+func (e *LimitedEmbedder) embedBatch(ctx context.Context, texts []string, tokens int) ([][]float32, error) {
+	for attempt := 0; ; attempt++ {
+		vecs, err := e.call(ctx, texts, tokens)
+		if err == nil {
+			if len(vecs) != len(texts) {
+				return nil, fmt.Errorf("embed: got %d vectors for %d texts", len(vecs), len(texts))
+			}
+			return vecs, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if isTooLarge(err) && len(texts) > 1 {
+			mid := len(texts) / 2
+			first, err := e.embedBatch(ctx, texts[:mid], tokens/2)
+			if err != nil {
+				return nil, err
+			}
+			second, err := e.embedBatch(ctx, texts[mid:], tokens-tokens/2)
+			if err != nil {
+				return nil, err
+			}
+			return append(first, second...), nil
+		}
+
+		if !IsRetryable(err) || attempt >= e.limits.MaxRetries {
+			return nil, err
+		}
+		delay := e.backoff(attempt)
+		if wait, ok := retryAfter(err); ok && wait > delay {
+			delay = wait
+		}
+		log.Printf("embed: attempt %d failed (%v), retrying in %s", attempt+1, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// call waits for a concurrency slot and the rate limiters, then calls the provider.
+func (e *LimitedEmbedder) call(ctx context.Context, texts []string, tokens int) ([][]float32, error) {
+	if e.slots != nil {
+		select {
+		case e.slots <- struct{}{}:
+			defer func() { <-e.slots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if e.requests != nil {
+		if err := e.requests.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if e.tokens != nil && tokens > 0 {
+		// A batch larger than the whole minute's budget waits for a full bucket.
+		if err := e.tokens.WaitN(ctx, min(tokens, e.tokens.Burst())); err != nil {
+			return nil, err
+		}
+	}
+	return e.inner.EmbedTexts(ctx, texts)
+}
+
+// backoff is the full-jitter delay before retry attempt+1.
+func (e *LimitedEmbedder) backoff(attempt int) time.Duration {
+	d := e.limits.BaseBackoff << min(attempt, 16)
+	if d <= 0 || d > e.limits.MaxBackoff {
+		d = e.limits.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// IsRetryable reports whether err is a transient provider failure: rate limiting (429,
+// RESOURCE_EXHAUSTED) or unavailability (500, 502, 503, 504, UNAVAILABLE).
+func IsRetryable(err error) bool {
+	if code, ok := httpCode(err); ok {
+		switch code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.ResourceExhausted, codes.Unavailable, codes.Internal:
+			return true
+		}
+	}
+	return false
+}
+
+// isTooLarge reports a request rejected for its size: 413, or the 400 INVALID_ARGUMENT
+// Gemini answers with for too many texts in a batch ("at most 100 requests can be in one
+// batch") or too large a payload ("Request payload size exceeds the limit").
+func isTooLarge(err error) bool {
+	code, ok := httpCode(err)
+	if ok && code == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	invalid := ok && code == http.StatusBadRequest
+	if s, isStatus := status.FromError(err); !ok && isStatus && s.Code() == codes.InvalidArgument {
+		invalid = true
+	}
+	if !invalid {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range tooLargeMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLargeMessages are lowercased fragments of providers' messages for oversized requests.
+var tooLargeMessages = []string{
+	"can be in one batch",
+	"payload size exceeds",
+	"request too large",
+	"too many requests in batch",
+}
+
+// retryAfter returns the delay a provider asked for before retrying: the RetryInfo
+// detail Gemini's 429s carry, or a Retry-After header.
+func retryAfter(err error) (time.Duration, bool) {
+	var aerr *apierror.APIError
+	if errors.As(err, &aerr) {
+		if d := aerr.Details().RetryInfo.GetRetryDelay(); d != nil && d.AsDuration() > 0 {
+			return d.AsDuration(), true
+		}
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Header != nil {
+		v := strings.TrimSpace(gerr.Header.Get("Retry-After"))
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if at, err := http.ParseTime(v); err == nil && time.Until(at) > 0 {
+			return time.Until(at), true
+		}
+	}
+	return 0, false
+}
+
+// httpCode extracts an HTTP status from provider errors that carry one (googleapi.Error,
+// gax APIError and similar).
+func httpCode(err error) (int, bool) {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code > 0 {
+		return gerr.Code, true
+	}
+	var withCode interface{ HTTPCode() int }
+	if errors.As(err, &withCode) && withCode.HTTPCode() > 0 {
+		return withCode.HTTPCode(), true
+	}
+	var withStatus interface{ StatusCode() int }
+	if errors.As(err, &withStatus) && withStatus.StatusCode() > 0 {
+		return withStatus.StatusCode(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,284 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+)
+
+// scriptedEmbedder answers each call with the next error of script, or once the script
+// runs out, with vectors that carry the index of each text's first byte. reject, when
+// set, refuses a call before the script is consulted.
+type scriptedEmbedder struct {
+	mu       sync.Mutex
+	script   []error
+	reject   func(texts []string) error
+	calls    [][]string
+	at       []time.Time
+	inFlight int
+	peak     int
+	hold     time.Duration
+}
+
+func (e *scriptedEmbedder) EmbedTexts(_ context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, texts)
+	e.at = append(e.at, time.Now())
+	e.inFlight++
+	e.peak = max(e.peak, e.inFlight)
+	var err error
+	if e.reject != nil {
+		err = e.reject(texts)
+	}
+	if err == nil && len(e.script) > 0 {
+		err, e.script = e.script[0], e.script[1:]
+	}
+	e.mu.Unlock()
+
+	time.Sleep(e.hold)
+	e.mu.Lock()
+	e.inFlight--
+	e.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]float32, len(texts))
+	for k, t := range texts {
+		out[k] = []float32{float32(t[0])}
+	}
+	return out, nil
+}
+
+// geminiError builds the error Gemini's REST client returns for an HTTP error response.
+func geminiError(t *testing.T, code int, status, message, details string) error {
+	t.Helper()
+	body := fmt.Sprintf(`{"error":{"code":%d,"message":%q,"status":%q,"details":[%s]}}`, code, message, status, details)
+	aerr, ok := apierror.FromError(&googleapi.Error{Code: code, Message: message, Body: body})
+	if !ok {
+		t.Fatalf("could not parse %s", body)
+	}
+	return aerr
+}
+
+// texts returns n one-letter texts, "a", "b", ...
+func texts(n int) []string {
+	out := make([]string, n)
+	for k := range out {
+		out[k] = string(rune('a' + k))
+	}
+	return out
+}
+
+// checkVectors checks vecs are in the order of in.
+func checkVectors(t *testing.T, in []string, vecs [][]float32) {
+	t.Helper()
+	if len(vecs) != len(in) {
+		t.Fatalf("got %d vectors for %d texts", len(vecs), len(in))
+	}
+	for k, v := range vecs {
+		if len(v) != 1 || v[0] != float32(in[k][0]) {
+			t.Fatalf("vector %d is %v, want the one for %q", k, v, in[k])
+		}
+	}
+}
+
+func fastLimits(l EmbedLimits) EmbedLimits {
+	l.BaseBackoff, l.MaxBackoff = time.Millisecond, 5*time.Millisecond
+	return l
+}
+
+func TestLimitedEmbedderRetriesTransientErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		err   error
+		calls int
+		fails bool
+	}{
+		{"429", &googleapi.Error{Code: http.StatusTooManyRequests}, 3, false},
+		{"503", &googleapi.Error{Code: http.StatusServiceUnavailable}, 3, false},
+		{"gemini 429", geminiError(t, 429, "RESOURCE_EXHAUSTED", "Resource has been exhausted", ""), 3, false},
+		{"400 is final", &googleapi.Error{Code: http.StatusBadRequest, Message: "API key not valid"}, 1, true},
+		{"403 is final", &googleapi.Error{Code: http.StatusForbidden}, 1, true},
+		{"plain errors are final", errors.New("connection reset"), 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inner := &scriptedEmbedder{script: []error{c.err, c.err}}
+			e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{}))
+			in := texts(3)
+			vecs, err := e.EmbedTexts(context.Background(), in)
+			if len(inner.calls) != c.calls {
+				t.Fatalf("%d calls, want %d", len(inner.calls), c.calls)
+			}
+			if c.fails {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("got %v, want %v", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVectors(t, in, vecs)
+		})
+	}
+}
+
+func TestLimitedEmbedderGivesUpAfterMaxRetries(t *testing.T) {
+	busy := &googleapi.Error{Code: http.StatusTooManyRequests}
+	inner := &scriptedEmbedder{script: []error{busy, busy, busy, busy}}
+	e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{MaxRetries: 2}))
+	if _, err := e.EmbedTexts(context.Background(), texts(2)); !errors.Is(err, busy) {
+		t.Fatalf("got %v, want the 429", err)
+	}
+	if len(inner.calls) != 3 {
+		t.Fatalf("%d calls, want the first and 2 retries", len(inner.calls))
+	}
+}
+
+func TestLimitedEmbedderHonorsRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "1")
+	cases := []struct {
+		name string
+		err  error
+		wait time.Duration
+	}{
+		{"gemini RetryInfo", geminiError(t, 429, "RESOURCE_EXHAUSTED", "Quota exceeded",
+			`{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.3s"}`), 300 * time.Millisecond},
+		{"Retry-After header", &googleapi.Error{Code: http.StatusTooManyRequests, Header: header}, time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inner := &scriptedEmbedder{script: []error{c.err}}
+			// The backoff alone would retry within 5ms.
+			e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{}))
+			if _, err := e.EmbedTexts(context.Background(), texts(1)); err != nil {
+				t.Fatal(err)
+			}
+			if len(inner.at) != 2 {
+				t.Fatalf("%d calls, want 2", len(inner.at))
+			}
+			if waited := inner.at[1].Sub(inner.at[0]); waited < c.wait {
+				t.Fatalf("retried after %s, the provider asked for %s", waited, c.wait)
+			}
+		})
+	}
+}
+
+func TestLimitedEmbedderSplitsBatches(t *testing.T) {
+	tok := tokenizer.NewEstimator()
+	cases := []struct {
+		name   string
+		limits EmbedLimits
+		in     []string
+		sizes  []int
+	}{
+		{"by count", EmbedLimits{MaxBatch: 3}, texts(7), []int{3, 3, 1}},
+		{"by tokens", EmbedLimits{MaxBatchTokens: 2}, texts(5), []int{2, 2, 1}},
+		{"oversized text goes alone", EmbedLimits{MaxBatchTokens: 3},
+			[]string{"a", "bbbb bbbb bbbb bbbb bbbb", "c", "d"}, []int{1, 1, 2}},
+		{"default of 100", EmbedLimits{}, make([]string, 250), []int{100, 100, 50}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for k := range c.in {
+				if c.in[k] == "" {
+					c.in[k] = "x"
+				}
+			}
+			inner := &scriptedEmbedder{}
+			e := NewLimitedEmbedder(inner, tok, fastLimits(c.limits))
+			vecs, err := e.EmbedTexts(context.Background(), c.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVectors(t, c.in, vecs)
+
+			var sizes []int
+			for _, b := range e.batches(c.in) {
+				sizes = append(sizes, b.end-b.start)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(c.sizes) || len(inner.calls) != len(c.sizes) {
+				t.Fatalf("batches %v in %d calls, want %v", sizes, len(inner.calls), c.sizes)
+			}
+		})
+	}
+}
+
+func TestLimitedEmbedderHalvesBatchesTheProviderRefuses(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"gemini batch limit", geminiError(t, 400, "INVALID_ARGUMENT",
+			"* BatchEmbedContentsRequest.requests: at most 2 requests can be in one batch\n", "")},
+		{"gemini payload limit", geminiError(t, 400, "INVALID_ARGUMENT",
+			"Request payload size exceeds the limit: 10485760 bytes.", "")},
+		{"413", &googleapi.Error{Code: http.StatusRequestEntityTooLarge}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inner := &scriptedEmbedder{reject: func(texts []string) error {
+				if len(texts) > 2 {
+					return c.err
+				}
+				return nil
+			}}
+			e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{MaxBatch: 8}))
+			in := texts(8)
+			vecs, err := e.EmbedTexts(context.Background(), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVectors(t, in, vecs)
+			// 8 refused, both 4s refused, then four 2s.
+			if len(inner.calls) != 7 {
+				t.Fatalf("%d calls, want 7", len(inner.calls))
+			}
+		})
+	}
+
+	// Other invalid arguments are the request's fault whatever its size: no halving.
+	bad := geminiError(t, 400, "INVALID_ARGUMENT", "* BatchEmbedContentsRequest.model: unexpected model name format", "")
+	inner := &scriptedEmbedder{script: []error{bad}}
+	e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{}))
+	if _, err := e.EmbedTexts(context.Background(), texts(4)); !errors.Is(err, bad) || len(inner.calls) != 1 {
+		t.Fatalf("got %v after %d calls, want the error after 1", err, len(inner.calls))
+	}
+}
+
+func TestLimitedEmbedderCapsConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 3} {
+		t.Run(strconv.Itoa(limit), func(t *testing.T) {
+			inner := &scriptedEmbedder{hold: 20 * time.Millisecond}
+			e := NewLimitedEmbedder(inner, tokenizer.NewEstimator(), fastLimits(EmbedLimits{MaxBatch: 1, MaxConcurrent: limit}))
+
+			// Two callers share the limit, as the ingestion workers and chat do.
+			var wg sync.WaitGroup
+			for range 2 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := e.EmbedTexts(context.Background(), texts(6)); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if inner.peak != limit {
+				t.Fatalf("%d calls in flight at once, want %d", inner.peak, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+var (
+	_ core.EmbeddingProvider = (*MeteredEmbedder)(nil)
+	_ core.LLMProvider       = (*MeteredLLM)(nil)
+)
+
+// UsageStore persists usage records.
+type UsageStore interface {
+	InsertUsageRecords(ctx context.Context, records []models.UsageRecord) error
+}
+
+// meter prices provider usage and stores it under the caller's core.UsageScope.
+type meter struct {
+	tok    core.Tokenizer
+	prices Prices
+	store  UsageStore
+}
+
+// record stores usages. A failure to record is logged, never returned: the call it
+// describes has already succeeded.
+func (m *meter) record(ctx context.Context, usages []core.Usage) {
+	if len(usages) == 0 {
+		return
+	}
+	scope := core.UsageScopeFrom(ctx)
+	now := time.Now().UTC()
+	records := make([]models.UsageRecord, 0, len(usages))
+	for _, u := range usages {
+		records = append(records, models.UsageRecord{
+			ID:           uuid.NewString(),
+			UserID:       scope.UserID,
+			DocumentID:   scope.DocumentID,
+			MessageID:    scope.MessageID,
+			Operation:    u.Operation,
+			Model:        u.Model,
+			InputTokens:  u.InputTokens,
+			OutputTokens: u.OutputTokens,
+			CostUSD:      m.prices.Cost(u),
+			CreatedAt:    now,
+		})
+	}
+
+	// Record even if the caller stops waiting right after the call returns.
+	sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := m.store.InsertUsageRecords(sctx, records); err != nil {
+		log.Printf("usage: record %d entries for user %q: %v", len(records), scope.UserID, err)
+	}
+}
+
+// MeteredEmbedder records the usage of every successful embedding request, as reported
+// by the provider through core.ReportUsage. Providers that report none are metered with
+// the tokenizer.
+type MeteredEmbedder struct {
+	inner core.EmbeddingProvider
+	model string
+	meter
+}
+
+// NewMeteredEmbedder wraps inner, which embeds with model. Wrap the raw provider, inside
+// any retrying layer, so that each provider request is recorded once.
+func NewMeteredEmbedder(inner core.EmbeddingProvider, model string, tok core.Tokenizer, prices Prices, store UsageStore) *MeteredEmbedder {
+	return &MeteredEmbedder{inner: inner, model: model, meter: meter{tok: tok, prices: prices, store: store}}
+}
+
+func (e *MeteredEmbedder) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	cctx, collected := core.WithUsageCollector(ctx)
+	vecs, err := e.inner.EmbedTexts(cctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := collected.Usages()
+	if len(usages) == 0 {
+		u := core.Usage{Operation: core.UsageEmbed, Model: e.model}
+		for _, t := range texts {
+			u.InputTokens += e.tok.Count(t)
+		}
+		usages = append(usages, u)
+	}
+	e.record(ctx, usages)
+	return vecs, nil
+}
+
+// MeteredLLM records the usage of every successful generation request and adds its
+// token counts and cost to the request's core.GenerationInfo. Providers that return no
+// usage metadata are metered with the tokenizer.
+type MeteredLLM struct {
+	inner core.LLMProvider
+	meter
+}
+
+func NewMeteredLLM(inner core.LLMProvider, tok core.Tokenizer, prices Prices, store UsageStore) *MeteredLLM {
+	return &MeteredLLM{inner: inner, meter: meter{tok: tok, prices: prices, store: store}}
+}
+
+func (l *MeteredLLM) Generate(ctx context.Context, systemPrompt, userPrompt string) (string, error) {
+	cctx, collected := core.WithUsageCollector(ctx)
+	out, err := l.inner.Generate(cctx, systemPrompt, userPrompt)
+	if err != nil {
+		return "", err
+	}
+
+	info := core.GenerationInfoFrom(ctx)
+	usages := collected.Usages()
+	if len(usages) == 0 {
+		u := core.Usage{
+			Operation:    core.UsageGenerate,
+			Model:        "unknown",
+			InputTokens:  l.tok.Count(systemPrompt) + l.tok.Count(userPrompt),
+			OutputTokens: l.tok.Count(out),
+		}
+		if info != nil && info.Model != "" {
+			u.Model = info.Model
+		}
+		usages = append(usages, u)
+	}
+	if info != nil {
+		for _, u := range usages {
+			info.InputTokens += u.InputTokens
+			info.OutputTokens += u.OutputTokens
+			info.CostUSD += l.prices.Cost(u)
+		}
+	}
+	l.record(ctx, usages)
+	return out, nil
+}
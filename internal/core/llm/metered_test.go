@@ -0,0 +1,72 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+	"github.com/markdave123-py/Contexta/internal/core/tokenizer"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// memoryUsageStore keeps recorded usage in memory.
+type memoryUsageStore struct {
+	mu      sync.Mutex
+	records []models.UsageRecord
+}
+
+func (s *memoryUsageStore) InsertUsageRecords(_ context.Context, records []models.UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// reportingEmbedder reports tokens of usage per request when tokens is set.
+type reportingEmbedder struct {
+	tokens int
+}
+
+func (e reportingEmbedder) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.tokens > 0 {
+		core.ReportUsage(ctx, core.Usage{Operation: core.UsageEmbed, Model: "provider-model", InputTokens: e.tokens})
+	}
+	return make([][]float32, len(texts)), nil
+}
+
+func TestMeteredEmbedderPrefersProviderUsage(t *testing.T) {
+	tok := tokenizer.NewEstimator()
+	texts := []string{"the first text to embed", "and a second one"}
+	prices := Prices{"provider-model": {InputPerMillion: 1e6}, "configured-model": {InputPerMillion: 1e6}}
+
+	cases := []struct {
+		name     string
+		reported int
+		model    string
+		tokens   int
+	}{
+		{"provider reports usage", 1234, "provider-model", 1234},
+		{"provider reports none", 0, "configured-model", tok.Count(texts[0]) + tok.Count(texts[1])},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := &memoryUsageStore{}
+			e := NewMeteredEmbedder(reportingEmbedder{tokens: c.reported}, "configured-model", tok, prices, store)
+			ctx := core.WithUsageScope(context.Background(), core.UsageScope{UserID: "user", DocumentID: "doc"})
+			if _, err := e.EmbedTexts(ctx, texts); err != nil {
+				t.Fatal(err)
+			}
+			if len(store.records) != 1 {
+				t.Fatalf("%d records, want 1", len(store.records))
+			}
+			r := store.records[0]
+			if r.Model != c.model || r.InputTokens != c.tokens || r.CostUSD != float64(c.tokens) {
+				t.Fatalf("record %+v, want model %s with %d tokens", r, c.model, c.tokens)
+			}
+			if r.UserID != "user" || r.DocumentID != "doc" || r.Operation != core.UsageEmbed {
+				t.Fatalf("record %+v not billed to the scope", r)
+			}
+		})
+	}
+}
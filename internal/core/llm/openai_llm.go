@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.LLMProvider = (*OpenAILLM)(nil)
+
+// DefaultOpenAIBaseURL is the OpenAI API; any server speaking its chat completions API
+// (vLLM, Ollama, Azure OpenAI, ...) can be used instead.
+const DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAILLM generates with an OpenAI-compatible chat completions endpoint.
+type OpenAILLM struct {
+	baseURL   string
+	apiKey    string
+	modelName string
+	client    *http.Client
+}
+
+// NewOpenAILLM returns a provider for modelName at baseURL [DefaultOpenAIBaseURL]. The API
+// key may be empty for local servers that do not check one.
+func NewOpenAILLM(baseURL, apiKey, modelName string) (*OpenAILLM, error) {
+	if modelName == "" {
+		return nil, fmt.Errorf("openai: model is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	return &OpenAILLM{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		apiKey:    apiKey,
+		modelName: modelName,
+		client:    &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// WithModel returns an OpenAILLM for another model at the same endpoint.
+func (o *OpenAILLM) WithModel(modelName string) *OpenAILLM {
+	cp := *o
+	cp.modelName = modelName
+	return &cp
+}
+
+// Model returns the model name requests are sent to.
+func (o *OpenAILLM) Model() string {
+	return o.modelName
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *OpenAILLM) Generate(ctx context.Context, systemPrompt, userPrompt string) (string, error) {
+	req := openAIChatRequest{Model: o.modelName}
+	if systemPrompt != "" {
+		req.Messages = append(req.Messages, openAIMessage{Role: "system", Content: systemPrompt})
+	}
+	req.Messages = append(req.Messages, openAIMessage{Role: "user", Content: userPrompt})
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("openai generate: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("openai generate: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("openai generate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out openAIChatResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("openai generate: decode response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := resp.Status
+		if out.Error != nil && out.Error.Message != "" {
+			msg += ": " + out.Error.Message
+		}
+		return "", fmt.Errorf("openai generate: %s", msg)
+	}
+	if out.Usage != nil {
+		core.ReportUsage(ctx, core.Usage{
+			Operation:    core.UsageGenerate,
+			Model:        o.modelName,
+			InputTokens:  out.Usage.PromptTokens,
+			OutputTokens: out.Usage.CompletionTokens,
+		})
+	}
+	if len(out.Choices) == 0 {
+		return "", nil
+	}
+	return out.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+func TestOpenAILLMGenerate(t *testing.T) {
+	var got openAIChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"an answer"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`))
+	}))
+	defer srv.Close()
+
+	o, err := NewOpenAILLM(srv.URL+"/v1/", "key", "gpt-4o-mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, usage := core.WithUsageCollector(context.Background())
+	out, err := o.Generate(ctx, "be brief", "question")
+	if err != nil || out != "an answer" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if got.Model != "gpt-4o-mini" || len(got.Messages) != 2 || got.Messages[0].Role != "system" || got.Messages[1].Content != "question" {
+		t.Fatalf("request %+v", got)
+	}
+	if u := usage.Usages(); len(u) != 1 || u[0].InputTokens != 12 || u[0].OutputTokens != 3 || u[0].Model != "gpt-4o-mini" {
+		t.Fatalf("usage %+v", u)
+	}
+}
+
+func TestOpenAILLMReportsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+	}))
+	defer srv.Close()
+
+	o, _ := NewOpenAILLM(srv.URL, "", "m")
+	if _, err := o.Generate(context.Background(), "", "q"); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Fatalf("got %v, want the provider's error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// DefaultPrices is the price table used when MODEL_PRICES is not set, in USD per
+// million tokens as input/output.
+const DefaultPrices = "gemini-2.5-pro=1.25/10,gemini-2.5-flash=0.30/2.50,gemini-2.5-flash-lite=0.10/0.40,gemini-1.5-flash=0.075/0.30," +
+	"gpt-4o=2.50/10,gpt-4o-mini=0.15/0.60,gemini-embedding-001=0.15,text-embedding-004=0"
+
+// ModelPrice is what a model costs in USD per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// Prices maps model names to their prices.
+type Prices map[string]ModelPrice
+
+// ParsePrices reads a comma-separated price table of model=input/output entries, prices
+// in USD per million tokens. The output price may be omitted for embedding models.
+func ParsePrices(s string) (Prices, error) {
+	out := Prices{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, price, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("price entry %q: want model=input/output", entry)
+		}
+		in, outp, _ := strings.Cut(price, "/")
+		var p ModelPrice
+		var err error
+		if p.InputPerMillion, err = strconv.ParseFloat(strings.TrimSpace(in), 64); err != nil {
+			return nil, fmt.Errorf("price entry %q: input price: %w", entry, err)
+		}
+		if strings.TrimSpace(outp) != "" {
+			if p.OutputPerMillion, err = strconv.ParseFloat(strings.TrimSpace(outp), 64); err != nil {
+				return nil, fmt.Errorf("price entry %q: output price: %w", entry, err)
+			}
+		}
+		if p.InputPerMillion < 0 || p.OutputPerMillion < 0 {
+			return nil, fmt.Errorf("price entry %q: negative price", entry)
+		}
+		out[strings.TrimSpace(model)] = p
+	}
+	return out, nil
+}
+
+// Cost estimates what u cost in USD. Models missing from the table cost nothing.
+func (p Prices) Cost(u core.Usage) float64 {
+	price, ok := p[u.Model]
+	if !ok {
+		price = p[strings.TrimPrefix(u.Model, "models/")]
+	}
+	return (float64(u.InputTokens)*price.InputPerMillion + float64(u.OutputTokens)*price.OutputPerMillion) / 1e6
+}
@@ -0,0 +1,184 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.LLMProvider = (*Router)(nil)
+
+// ErrNoBackend is returned when every backend of a route failed or has its circuit open.
+var ErrNoBackend = errors.New("no llm backend available")
+
+// Backend is one LLM the router can send requests to.
+//
+// Name:     unique name, reported in GenerationInfo and used for circuit state.
+// Model:    model served by the backend, for reporting.
+// Provider: the backend itself.
+type Backend struct {
+	Name     string
+	Model    string
+	Provider core.LLMProvider
+}
+
+// RouterConfig tunes the routing provider.
+//
+// Routes:           backends per task in priority order, e.g. a cheap model for core.TaskRewrite.
+// Default:          backends for tasks without a route of their own.
+// FailureThreshold: consecutive failures that open a backend's circuit [3].
+// Cooldown:         how long an open circuit rejects requests before one trial is let through [30s].
+type RouterConfig struct {
+	Routes           map[core.Task][]Backend
+	Default          []Backend
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// Router is an LLMProvider that routes each request by its core.Task and fails over
+// through the route's backends in priority order. A backend that keeps failing has its
+// circuit opened and is skipped until the cooldown passes; then a single request probes
+// it and closes the circuit again on success. The backend that answered, and the ones
+// passed over, are recorded in the request's core.GenerationInfo.
+type Router struct {
+	cfg      RouterConfig
+	breakers map[string]*breaker
+	now      func() time.Time
+}
+
+func NewRouter(cfg RouterConfig) (*Router, error) {
+	if len(cfg.Default) == 0 {
+		return nil, fmt.Errorf("llm router: no default backends")
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+
+	r := &Router{cfg: cfg, breakers: make(map[string]*breaker), now: time.Now}
+	register := func(bs []Backend) error {
+		for _, b := range bs {
+			if b.Name == "" || b.Provider == nil {
+				return fmt.Errorf("llm router: backend needs a name and a provider")
+			}
+			if _, ok := r.breakers[b.Name]; !ok {
+				r.breakers[b.Name] = &breaker{}
+			}
+		}
+		return nil
+	}
+	if err := register(cfg.Default); err != nil {
+		return nil, err
+	}
+	for _, bs := range cfg.Routes {
+		if err := register(bs); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Generate sends the request to the first healthy backend of its route that succeeds.
+func (r *Router) Generate(ctx context.Context, systemPrompt, userPrompt string) (string, error) {
+	task := core.TaskFrom(ctx)
+	backends, ok := r.cfg.Routes[task]
+	if !ok || len(backends) == 0 {
+		backends = r.cfg.Default
+	}
+
+	info := core.GenerationInfoFrom(ctx)
+	if info != nil {
+		info.Task = task
+	}
+	attempt := func(name, reason string) {
+		if info != nil {
+			info.Attempted = append(info.Attempted, core.RouteAttempt{Provider: name, Reason: reason})
+		}
+	}
+
+	var errs []string
+	for _, b := range backends {
+		br := r.breakers[b.Name]
+		if !br.allow(r.now(), r.cfg.FailureThreshold) {
+			attempt(b.Name, "circuit_open")
+			errs = append(errs, b.Name+": circuit open")
+			continue
+		}
+
+		out, err := b.Provider.Generate(ctx, systemPrompt, userPrompt)
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up; that says nothing about the backend.
+			br.release()
+			return "", ctx.Err()
+		}
+		if br.record(err, r.now(), r.cfg.FailureThreshold, r.cfg.Cooldown) {
+			log.Printf("llm router: circuit for %s opened after %d consecutive failures", b.Name, r.cfg.FailureThreshold)
+		}
+		if err != nil {
+			attempt(b.Name, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %v", b.Name, err))
+			continue
+		}
+
+		if info != nil {
+			info.Provider, info.Model = b.Name, b.Model
+		}
+		return out, nil
+	}
+	return "", fmt.Errorf("%w for task %q: %s", ErrNoBackend, task, strings.Join(errs, "; "))
+}
+
+// breaker is a consecutive-failure circuit breaker. The circuit opens once failures
+// reach the threshold; after the cooldown a single probe request is let through.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(now time.Time, threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record notes the outcome of a request and reports whether it opened the circuit.
+func (b *breaker) record(err error, now time.Time, threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbe := b.probing
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+		return b.failures == threshold || wasProbe
+	}
+	return false
+}
+
+// release gives up a probe slot without recording an outcome.
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
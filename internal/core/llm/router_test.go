@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var errProviderDown = errors.New("provider down")
+
+// fakeProvider answers with its name, or fails while down is set, and counts its calls.
+type fakeProvider struct {
+	name string
+
+	mu    sync.Mutex
+	down  bool
+	calls int
+}
+
+func (p *fakeProvider) Generate(context.Context, string, string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.down {
+		return "", errProviderDown
+	}
+	return p.name, nil
+}
+
+func (p *fakeProvider) setDown(down bool) {
+	p.mu.Lock()
+	p.down = down
+	p.mu.Unlock()
+}
+
+func (p *fakeProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func fakeBackend(p *fakeProvider) Backend {
+	return Backend{Name: p.name, Model: p.name + "-model", Provider: p}
+}
+
+// testClock is a settable time source for the router.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestRouter(t *testing.T, cfg RouterConfig) (*Router, *testClock) {
+	t.Helper()
+	r, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r.now = clock.now
+	return r, clock
+}
+
+func generate(t *testing.T, r *Router, task core.Task) (string, *core.GenerationInfo, error) {
+	t.Helper()
+	ctx, info := core.WithGenerationInfo(core.WithTask(context.Background(), task))
+	out, err := r.Generate(ctx, "system", "user")
+	return out, info, err
+}
+
+func TestRouterFailsOverInPriorityOrder(t *testing.T) {
+	primary, secondary := &fakeProvider{name: "primary"}, &fakeProvider{name: "secondary"}
+	r, _ := newTestRouter(t, RouterConfig{Default: []Backend{fakeBackend(primary), fakeBackend(secondary)}})
+
+	out, info, err := generate(t, r, core.TaskAnswer)
+	if err != nil || out != "primary" {
+		t.Fatalf("healthy primary: got %q, %v", out, err)
+	}
+	if info.Provider != "primary" || info.Model != "primary-model" || len(info.Attempted) != 0 {
+		t.Fatalf("healthy primary: info %+v", info)
+	}
+
+	primary.setDown(true)
+	out, info, err = generate(t, r, core.TaskAnswer)
+	if err != nil || out != "secondary" {
+		t.Fatalf("primary down: got %q, %v", out, err)
+	}
+	if info.Provider != "secondary" || len(info.Attempted) != 1 || info.Attempted[0].Provider != "primary" {
+		t.Fatalf("primary down: info %+v", info)
+	}
+}
+
+func TestRouterAllBackendsDown(t *testing.T) {
+	a, b := &fakeProvider{name: "a", down: true}, &fakeProvider{name: "b", down: true}
+	r, _ := newTestRouter(t, RouterConfig{Default: []Backend{fakeBackend(a), fakeBackend(b)}})
+
+	_, info, err := generate(t, r, core.TaskAnswer)
+	if !errors.Is(err, ErrNoBackend) {
+		t.Fatalf("got %v, want ErrNoBackend", err)
+	}
+	if len(info.Attempted) != 2 || info.Provider != "" {
+		t.Fatalf("info %+v", info)
+	}
+}
+
+func TestRouterCircuitOpensAndProbes(t *testing.T) {
+	primary, secondary := &fakeProvider{name: "primary", down: true}, &fakeProvider{name: "secondary"}
+	r, clock := newTestRouter(t, RouterConfig{
+		Default:          []Backend{fakeBackend(primary), fakeBackend(secondary)},
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+	})
+
+	// Two failures open the primary's circuit...
+	for range 2 {
+		if out, _, err := generate(t, r, core.TaskAnswer); err != nil || out != "secondary" {
+			t.Fatalf("got %q, %v", out, err)
+		}
+	}
+	// ...so it is skipped without being called.
+	_, info, _ := generate(t, r, core.TaskAnswer)
+	if primary.callCount() != 2 {
+		t.Fatalf("primary called %d times with its circuit open, want 2", primary.callCount())
+	}
+	if len(info.Attempted) != 1 || info.Attempted[0].Reason != "circuit_open" {
+		t.Fatalf("open circuit: info %+v", info)
+	}
+
+	// After the cooldown one probe goes through; it fails and the circuit stays open.
+	clock.advance(time.Minute)
+	generate(t, r, core.TaskAnswer)
+	generate(t, r, core.TaskAnswer)
+	if primary.callCount() != 3 {
+		t.Fatalf("primary called %d times after a failed probe, want 3", primary.callCount())
+	}
+
+	// A successful probe closes it again.
+	clock.advance(time.Minute)
+	primary.setDown(false)
+	for range 2 {
+		if out, _, err := generate(t, r, core.TaskAnswer); err != nil || out != "primary" {
+			t.Fatalf("recovered primary: got %q, %v", out, err)
+		}
+	}
+}
+
+func TestRouterRoutesByTask(t *testing.T) {
+	cheap, strong := &fakeProvider{name: "cheap"}, &fakeProvider{name: "strong"}
+	r, _ := newTestRouter(t, RouterConfig{
+		Routes: map[core.Task][]Backend{
+			core.TaskRewrite: {fakeBackend(cheap), fakeBackend(strong)},
+		},
+		Default: []Backend{fakeBackend(strong)},
+	})
+
+	if out, info, _ := generate(t, r, core.TaskRewrite); out != "cheap" || info.Task != core.TaskRewrite {
+		t.Fatalf("rewrite: got %q, info %+v", out, info)
+	}
+	if out, info, _ := generate(t, r, core.TaskAnswer); out != "strong" || info.Task != core.TaskAnswer {
+		t.Fatalf("answer: got %q, info %+v", out, info)
+	}
+
+	// A rewrite falls back to the stronger model when the cheap one is down.
+	cheap.setDown(true)
+	if out, _, err := generate(t, r, core.TaskRewrite); err != nil || out != "strong" {
+		t.Fatalf("rewrite with cheap model down: got %q, %v", out, err)
+	}
+}
+
+// blockingProvider fails once its context is cancelled.
+type blockingProvider struct{}
+
+func (blockingProvider) Generate(ctx context.Context, _, _ string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestRouterCancellationIsNotAFailure(t *testing.T) {
+	fallback := &fakeProvider{name: "fallback"}
+	r, _ := newTestRouter(t, RouterConfig{
+		Default:          []Backend{{Name: "slow", Provider: blockingProvider{}}, fakeBackend(fallback)},
+		FailureThreshold: 1,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Generate(ctx, "", ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if fallback.callCount() != 0 {
+		t.Fatal("a cancelled request failed over")
+	}
+	if !r.breakers["slow"].allow(r.now(), 1) {
+		t.Fatal("a cancelled request opened the circuit")
+	}
+}
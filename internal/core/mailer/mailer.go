@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them, for development. Links
+// in the messages, such as password resets, can be followed from the log.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("mail: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// MemoryMailer keeps sent messages in memory, for tests.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
+
+var (
+	_ Mailer = LogMailer{}
+	_ Mailer = (*MemoryMailer)(nil)
+)
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig configures an SMTPMailer.
+//
+// Host, Port: the submission server, usually port 587 with STARTTLS or 465 with TLS.
+// Username:   empty to send without authentication.
+// From:       the sender address.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends mail through an SMTP server. It upgrades to TLS with STARTTLS when the
+// server offers it, and only authenticates over TLS.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("smtp host not set")
+	}
+	if cfg.From == "" {
+		return nil, fmt.Errorf("mail sender (MAIL_FROM) not set")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("mail: header contains a line break")
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if m.cfg.Port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.cfg.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("mail: dial %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mail: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return fmt.Errorf("mail: starttls: %w", err)
+		}
+	}
+	if m.cfg.Username != "" {
+		// PlainAuth refuses to send credentials without TLS, except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return fmt.Errorf("mail: auth: %w", err)
+		}
+	}
+	if err := c.Mail(m.cfg.From); err != nil {
+		return fmt.Errorf("mail: from: %w", err)
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("mail: rcpt: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mail: data: %w", err)
+	}
+	if _, err := w.Write(m.format(msg)); err != nil {
+		w.Close()
+		return fmt.Errorf("mail: write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail: send: %w", err)
+	}
+	return c.Quit()
+}
+
+func (m *SMTPMailer) format(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+var _ Mailer = (*SMTPMailer)(nil)
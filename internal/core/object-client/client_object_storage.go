@@ -12,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	cfg "github.com/markdave123-py/Contexta/internal/config"
 )
 
@@ -90,6 +91,48 @@ func (c *S3Client) DeleteFile(ctx context.Context, bucket, key string) error {
 	return nil
 }
 
+// DeletePrefix lists the objects under prefix and deletes them a page at a time.
+func (c *S3Client) DeletePrefix(ctx context.Context, bucket, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("s3 delete: refusing to delete the whole bucket")
+	}
+	ctxDel, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	deleted := 0
+	pages := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctxDel)
+		if err != nil {
+			return deleted, fmt.Errorf("s3 list failed: %w", err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		// A page holds at most 1000 keys, the most one DeleteObjects call takes.
+		ids := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			ids = append(ids, types.ObjectIdentifier{Key: obj.Key})
+		}
+		out, err := c.client.DeleteObjects(ctxDel, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("s3 delete failed: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return deleted, fmt.Errorf("s3 delete failed for %d objects, first %s: %s", len(out.Errors), aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		deleted += len(ids)
+	}
+	return deleted, nil
+}
+
 func (c *S3Client) GetFile(ctx context.Context, bucket, key string) ([]byte, error) {
 	ctxGet, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -111,17 +154,30 @@ func (c *S3Client) GetFile(ctx context.Context, bucket, key string) ([]byte, err
 	return body, nil
 }
 
+// GetObjectReader opens a streaming reader over the object body. The request
+// deadline stays attached to the body, so callers must Close it to release it.
 func (c *S3Client) GetObjectReader(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
 	ctxGet, cancel := context.WithTimeout(ctx, 2*time.Minute)
-	defer cancel()
 
 	resp, err := c.client.GetObject(ctxGet, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("s3 get failed: %w", err)
 	}
 
-	return resp.Body, nil
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+// cancelOnClose releases the request context once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
 }
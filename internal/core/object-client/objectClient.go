@@ -3,6 +3,7 @@ package objectclient
 import (
 	"context"
 	"io"
+	"strings"
 )
 
 // ObjectClient defines interactions with S3 or any object storage.
@@ -10,7 +11,25 @@ import (
 type ObjectClient interface {
 	UploadFile(ctx context.Context, bucket, key string, data io.Reader, contentType string) (url string, err error)
 	DeleteFile(ctx context.Context, bucket, key string) error
+	// DeletePrefix deletes every object whose key starts with prefix and returns how many.
+	DeletePrefix(ctx context.Context, bucket, prefix string) (int, error)
 	GetFile(ctx context.Context, bucket, key string) ([]byte, error)
 
 	GetObjectReader(ctx context.Context, bucket, key string) (io.ReadCloser, error)
 }
+
+// ParseS3URL extracts the bucket and key from a typical virtual-hosted–style S3 URL, as
+// returned by UploadFile.
+// Example: https://my-bucket.s3.us-east-2.amazonaws.com/path/to/file.pdf
+func ParseS3URL(u string) (bucket, key string) {
+	hostPath := strings.SplitN(strings.TrimPrefix(u, "https://"), "/", 2)
+	host := hostPath[0]
+	if len(hostPath) == 2 {
+		key = hostPath[1]
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 0 {
+		bucket = parts[0]
+	}
+	return bucket, key
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Limiter = (*MemoryLimiter)(nil)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket will have refilled
+}
+
+// MemoryLimiter keeps buckets in memory. Limits hold per process, so with several
+// replicas each one allows the full rate.
+type MemoryLimiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+func (m *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		m.buckets[key] = b
+	}
+	tokens, res := take(refill(b.tokens, now.Sub(b.updated), limit), limit)
+	b.tokens, b.updated, b.full = tokens, now, now.Add(res.Reset)
+	return res, nil
+}
+
+// prune drops buckets that have refilled, which are the same as no bucket, at most once
+// a minute.
+func (m *MemoryLimiter) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < time.Minute {
+		return
+	}
+	m.lastPrune = now
+	for key, b := range m.buckets {
+		if !b.full.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var _ Limiter = (*PostgresLimiter)(nil)
+
+// BucketStore persists token buckets. The database client implements it.
+type BucketStore interface {
+	// TakeRateLimitToken refills the bucket at key by the time since it was last used,
+	// at perSecond up to capacity, takes a token if one is there, and returns the
+	// tokens left and whether one was taken. A new bucket starts full.
+	TakeRateLimitToken(ctx context.Context, key string, capacity, perSecond float64) (tokens float64, taken bool, err error)
+	// PruneRateLimitBuckets deletes buckets unused for longer than idle.
+	PruneRateLimitBuckets(ctx context.Context, idle time.Duration) error
+}
+
+// PostgresLimiter keeps buckets in Postgres, so the limits hold across every replica
+// sharing the database. Each request costs a short transaction.
+type PostgresLimiter struct {
+	store BucketStore
+	idle  time.Duration // buckets unused this long are deleted
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewPostgresLimiter stores buckets through store. Buckets unused for longer than idle
+// are deleted; idle should be at least the longest limit period, when they are full.
+func NewPostgresLimiter(store BucketStore, idle time.Duration) *PostgresLimiter {
+	if idle <= 0 {
+		idle = 24 * time.Hour
+	}
+	return &PostgresLimiter{store: store, idle: idle}
+}
+
+func (p *PostgresLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	p.prune()
+
+	tokens, taken, err := p.store.TakeRateLimitToken(ctx, key, float64(limit.Requests), limit.rate())
+	if err != nil {
+		return Result{}, err
+	}
+	return result(tokens, taken, limit), nil
+}
+
+// prune deletes idle buckets in the background, at most every few minutes per process.
+func (p *PostgresLimiter) prune() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.lastPrune) < 5*time.Minute {
+		return
+	}
+	p.lastPrune = time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := p.store.PruneRateLimitBuckets(ctx, p.idle); err != nil {
+			log.Printf("rate limit: prune buckets: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+// Package ratelimit provides token bucket request limits shared by the HTTP middleware,
+// with an in-memory backend for a single process and a Postgres backend for several
+// replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Requests tokens and refills at Requests per
+// Per, so a client may burst Requests at once and then keep to the average rate.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// rate is the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d per %s", l.Requests, l.Per)
+}
+
+// ParseLimit reads a limit written as requests/unit, such as "30/m" or "100/h". The unit
+// is s, m, h or d, or any Go duration such as "10m". An empty string or "0" is no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: want requests/unit, like 30/m", s)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || requests < 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: requests must be a non-negative integer", s)
+	}
+	var per time.Duration
+	switch unit = strings.TrimSpace(unit); unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	case "d":
+		per = 24 * time.Hour
+	default:
+		if per, err = time.ParseDuration(unit); err != nil || per <= 0 {
+			return Limit{}, fmt.Errorf("rate limit %q: unknown unit %q", s, unit)
+		}
+	}
+	return Limit{Requests: requests, Per: per}, nil
+}
+
+// Result is the outcome of taking a token.
+//
+// Allowed:    whether the request may proceed.
+// Limit:      the bucket size.
+// Remaining:  whole tokens left after this request.
+// Reset:      until the bucket is full again.
+// RetryAfter: until a token is available, when the request was refused.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token for a request from the bucket named by key.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	return math.Min(float64(limit.Requests), tokens+elapsed.Seconds()*limit.rate())
+}
+
+// take spends a token from a bucket holding tokens, if it has one, and returns what is
+// left with the result.
+func take(tokens float64, limit Limit) (float64, Result) {
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, result(tokens, allowed, limit)
+}
+
+// result describes a bucket left holding tokens.
+func result(tokens float64, allowed bool, limit Limit) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / limit.rate()),
+	}
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(0, s) * float64(time.Second))
+}
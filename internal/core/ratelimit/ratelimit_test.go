@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestMemoryLimiter() (*MemoryLimiter, *clock) {
+	c := &clock{t: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewMemoryLimiter()
+	m.now = c.now
+	return m, c
+}
+
+// step is a request after a pause, and the result it should get.
+type step struct {
+	after      time.Duration
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+func TestMemoryLimiterBucket(t *testing.T) {
+	perSecond := Limit{Requests: 3, Per: 3 * time.Second} // one token a second, burst 3
+	cases := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{"burst then refused", perSecond, []step{
+			{0, true, 2, 0, time.Second},
+			{0, true, 1, 0, 2 * time.Second},
+			{0, true, 0, 0, 3 * time.Second},
+			{0, false, 0, time.Second, 3 * time.Second},
+		}},
+		{"partial refill", perSecond, []step{
+			{0, true, 2, 0, time.Second},
+			{0, true, 1, 0, 2 * time.Second},
+			{0, true, 0, 0, 3 * time.Second},
+			{400 * time.Millisecond, false, 0, 600 * time.Millisecond, 2600 * time.Millisecond},
+			{600 * time.Millisecond, true, 0, 0, 3 * time.Second},
+		}},
+		{"refused requests cost nothing", perSecond, []step{
+			{0, true, 2, 0, time.Second},
+			{0, true, 1, 0, 2 * time.Second},
+			{0, true, 0, 0, 3 * time.Second},
+			{0, false, 0, time.Second, 3 * time.Second},
+			{0, false, 0, time.Second, 3 * time.Second},
+			{time.Second, true, 0, 0, 3 * time.Second},
+		}},
+		{"idle never fills past the burst", perSecond, []step{
+			{0, true, 2, 0, time.Second},
+			{50 * time.Second, true, 2, 0, time.Second}, // under a minute: not pruned
+			{0, true, 1, 0, 2 * time.Second},
+			{0, true, 0, 0, 3 * time.Second},
+			{0, false, 0, time.Second, 3 * time.Second},
+		}},
+		{"slow window", Limit{Requests: 2, Per: time.Hour}, []step{
+			{0, true, 1, 0, 30 * time.Minute},
+			{0, true, 0, 0, time.Hour},
+			{10 * time.Minute, false, 0, 20 * time.Minute, 50 * time.Minute},
+			{20 * time.Minute, true, 0, 0, time.Hour},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, clk := newTestMemoryLimiter()
+			for k, s := range c.steps {
+				clk.advance(s.after)
+				res, err := m.Allow(context.Background(), "client", c.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := Result{Allowed: s.allowed, Limit: c.limit.Requests, Remaining: s.remaining, RetryAfter: s.retryAfter, Reset: s.reset}
+				if !sameResult(res, want) {
+					t.Fatalf("request %d: got %+v, want %+v", k+1, res, want)
+				}
+			}
+		})
+	}
+}
+
+// sameResult compares results, allowing float rounding in the durations.
+func sameResult(a, b Result) bool {
+	near := func(x, y time.Duration) bool { return math.Abs(float64(x-y)) < float64(time.Millisecond) }
+	return a.Allowed == b.Allowed && a.Limit == b.Limit && a.Remaining == b.Remaining &&
+		near(a.RetryAfter, b.RetryAfter) && near(a.Reset, b.Reset)
+}
+
+func TestMemoryLimiterKeysAreSeparate(t *testing.T) {
+	m, clk := newTestMemoryLimiter()
+	limit := Limit{Requests: 1, Per: time.Minute}
+	for _, key := range []string{"chat:user:a", "chat:user:b", "upload:user:a"} {
+		if res, _ := m.Allow(context.Background(), key, limit); !res.Allowed {
+			t.Fatalf("first request for %s refused", key)
+		}
+	}
+	if res, _ := m.Allow(context.Background(), "chat:user:a", limit); res.Allowed {
+		t.Fatal("second request for chat:user:a allowed")
+	}
+
+	// Full buckets are pruned; a pruned bucket starts full, as it would have been.
+	clk.advance(2 * time.Minute)
+	m.Allow(context.Background(), "chat:user:c", limit)
+	if n := len(m.buckets); n != 1 {
+		t.Fatalf("%d buckets after pruning, want only the one just used", n)
+	}
+	if res, _ := m.Allow(context.Background(), "chat:user:a", limit); !res.Allowed {
+		t.Fatal("request after the window refused")
+	}
+}
+
+func TestDisabledLimitAllowsEverything(t *testing.T) {
+	m, _ := newTestMemoryLimiter()
+	for range 100 {
+		if res, _ := m.Allow(context.Background(), "k", Limit{}); !res.Allowed {
+			t.Fatal("disabled limit refused a request")
+		}
+	}
+	if len(m.buckets) != 0 {
+		t.Fatal("disabled limit kept a bucket")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Limit
+		bad  bool
+	}{
+		{"30/m", Limit{30, time.Minute}, false},
+		{" 10 / h ", Limit{10, time.Hour}, false},
+		{"5/s", Limit{5, time.Second}, false},
+		{"100/d", Limit{100, 24 * time.Hour}, false},
+		{"7/90s", Limit{7, 90 * time.Second}, false},
+		{"", Limit{}, false},
+		{"0", Limit{}, false},
+		{"30", Limit{}, true},
+		{"-1/m", Limit{}, true},
+		{"x/m", Limit{}, true},
+		{"3/fortnight", Limit{}, true},
+		{"3/-1s", Limit{}, true},
+	}
+	for _, c := range cases {
+		got, err := ParseLimit(c.in)
+		if (err != nil) != c.bad || got != c.want {
+			t.Errorf("ParseLimit(%q) = %v, %v", c.in, got, err)
+		}
+	}
+}
+
+// clockStore is a BucketStore doing what DatabaseClient.TakeRateLimitToken does in SQL,
+// against a settable clock in place of the database's.
+type clockStore struct {
+	clock *clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func (s *clockStore) TakeRateLimitToken(_ context.Context, key string, capacity, perSecond float64) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.now()
+	b := s.buckets[key]
+	if b == nil { // INSERT ... ON CONFLICT DO NOTHING
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	elapsed := max(now.Sub(b.updated).Seconds(), 0)
+	tokens := min(capacity, b.tokens+elapsed*perSecond)
+	taken := tokens >= 1
+	if taken {
+		tokens--
+	}
+	b.tokens, b.updated = tokens, now
+	return tokens, taken, nil
+}
+
+func (s *clockStore) PruneRateLimitBuckets(context.Context, time.Duration) error { return nil }
+
+// Replicas may use either backend; a client must see the same limits from both.
+func TestBackendsAgree(t *testing.T) {
+	memory, clk := newTestMemoryLimiter()
+	postgres := NewPostgresLimiter(&clockStore{clock: clk, buckets: map[string]*bucket{}}, time.Hour)
+
+	limits := []Limit{{Requests: 5, Per: time.Second}, {Requests: 3, Per: time.Minute}, {Requests: 1, Per: time.Hour}}
+	pauses := []time.Duration{0, 0, 0, 50 * time.Millisecond, 0, 700 * time.Millisecond, 0, 0, 0, 0, 0, 0,
+		13 * time.Second, 0, 2 * time.Second, 0, time.Hour, 0, 0, 0, 0, 0, 0}
+	for _, limit := range limits {
+		key := fmt.Sprintf("group:%s", limit)
+		for k, pause := range pauses {
+			clk.advance(pause)
+			want, err := memory.Allow(context.Background(), key, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := postgres.Allow(context.Background(), key, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameResult(got, want) {
+				t.Fatalf("%s, request %d: postgres %+v, memory %+v", limit, k+1, got, want)
+			}
+		}
+	}
+}
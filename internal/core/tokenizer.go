@@ -0,0 +1,7 @@
+package core
+
+// Tokenizer counts tokens the way an embedding model would see them. Chunk sizes,
+// overlap and stored token counts are all measured with it.
+type Tokenizer interface {
+	Count(text string) int
+}
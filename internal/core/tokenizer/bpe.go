@@ -0,0 +1,210 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.Tokenizer = (*BPE)(nil)
+
+// BPE is a byte-level byte-pair-encoding tokenizer over a tiktoken rank table. Text is
+// split with the cl100k pre-tokenisation rules and every piece is merged greedily by rank,
+// which yields the same token count as tiktoken for cl100k_base.
+type BPE struct {
+	ranks map[string]int
+	cache wordCache
+}
+
+// LoadBPEFile reads a tiktoken vocabulary from path.
+func LoadBPEFile(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open bpe vocabulary: %w", err)
+	}
+	defer f.Close()
+	return LoadBPE(f)
+}
+
+// LoadBPE parses a tiktoken vocabulary: one "base64(token) rank" pair per line.
+func LoadBPE(r io.Reader) (*BPE, error) {
+	ranks := make(map[string]int, 100_000)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		tok, rank, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("bpe vocabulary line %d: want \"token rank\"", line)
+		}
+		b, err := base64.StdEncoding.DecodeString(tok)
+		if err != nil {
+			return nil, fmt.Errorf("bpe vocabulary line %d: %w", line, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("bpe vocabulary line %d: %w", line, err)
+		}
+		ranks[string(b)] = n
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read bpe vocabulary: %w", err)
+	}
+	if len(ranks) < 256 {
+		return nil, fmt.Errorf("bpe vocabulary has %d tokens, want at least the 256 byte tokens", len(ranks))
+	}
+	return &BPE{ranks: ranks}, nil
+}
+
+// Count returns the number of BPE tokens in text.
+func (t *BPE) Count(text string) int {
+	n := 0
+	splitCL100K(text, func(piece string) {
+		if c, ok := t.cache.get(piece); ok {
+			n += c
+			return
+		}
+		c := t.mergeCount(piece)
+		t.cache.put(piece, c)
+		n += c
+	})
+	return n
+}
+
+// mergeCount applies the lowest-ranked merge until none is left and returns the number
+// of parts. Pieces are short (a word or a run of symbols) so the quadratic scan is cheap.
+func (t *BPE) mergeCount(piece string) int {
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+	// bounds[k] is the start of part k; the last entry is len(piece).
+	bounds := make([]int, len(piece)+1)
+	for k := range bounds {
+		bounds[k] = k
+	}
+	for len(bounds) > 2 {
+		best, at := -1, -1
+		for k := 0; k+2 < len(bounds); k++ {
+			r, ok := t.ranks[piece[bounds[k]:bounds[k+2]]]
+			if ok && (best < 0 || r < best) {
+				best, at = r, k
+			}
+		}
+		if at < 0 {
+			break
+		}
+		bounds = append(bounds[:at+1], bounds[at+2:]...)
+	}
+	return len(bounds) - 1
+}
+
+// splitCL100K pre-tokenises s with the cl100k_base pattern
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// written out by hand because RE2 has no lookahead. Alternatives are tried in order at
+// each position, as the regex engine would.
+func splitCL100K(s string, emit func(string)) {
+	for i := 0; i < len(s); {
+		end := matchCL100K(s, i)
+		emit(s[i:end])
+		i = end
+	}
+}
+
+func matchCL100K(s string, i int) int {
+	r, size := utf8.DecodeRuneInString(s[i:])
+
+	// Contractions.
+	if r == '\'' {
+		rest := strings.ToLower(s[i+1 : min(len(s), i+3)])
+		for _, c := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+			if strings.HasPrefix(rest, c) {
+				return i + 1 + len(c)
+			}
+		}
+	}
+
+	// Letters, optionally led by one non-letter, non-digit, non-newline rune.
+	if unicode.IsLetter(r) {
+		return scanWhile(s, i, unicode.IsLetter)
+	}
+	if r != '\r' && r != '\n' && !unicode.IsNumber(r) {
+		if next, _ := utf8.DecodeRuneInString(s[i+size:]); i+size < len(s) && unicode.IsLetter(next) {
+			return scanWhile(s, i+size, unicode.IsLetter)
+		}
+	}
+
+	// Up to three digits.
+	if unicode.IsNumber(r) {
+		end := i
+		for k := 0; k < 3 && end < len(s); k++ {
+			d, n := utf8.DecodeRuneInString(s[end:])
+			if !unicode.IsNumber(d) {
+				break
+			}
+			end += n
+		}
+		return end
+	}
+
+	// Punctuation and symbols, optionally led by a space, with trailing newlines.
+	start := i
+	if r == ' ' && i+1 < len(s) {
+		if next, _ := utf8.DecodeRuneInString(s[i+1:]); isSymbol(next) {
+			start = i + 1
+		}
+	}
+	if p, _ := utf8.DecodeRuneInString(s[start:]); isSymbol(p) {
+		end := scanWhile(s, start, isSymbol)
+		return scanWhile(s, end, func(c rune) bool { return c == '\r' || c == '\n' })
+	}
+
+	// Whitespace: up to and including the last newline of the run; otherwise the run
+	// minus its final rune when more text follows, so that rune can lead the next word.
+	end := scanWhile(s, i, unicode.IsSpace)
+	lastNL := -1
+	for k := i; k < end; k++ {
+		if s[k] == '\r' || s[k] == '\n' {
+			lastNL = k
+		}
+	}
+	if lastNL >= 0 {
+		return lastNL + 1
+	}
+	if end < len(s) {
+		_, last := utf8.DecodeLastRuneInString(s[i:end])
+		if end-last > i {
+			return end - last
+		}
+	}
+	if end > i {
+		return end
+	}
+	return i + size
+}
+
+func isSymbol(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func scanWhile(s string, i int, ok func(rune) bool) int {
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if !ok(r) {
+			break
+		}
+		i += n
+	}
+	return i
+}
@@ -0,0 +1,43 @@
+package tokenizer
+
+import "testing"
+
+// cl100kGolden holds token counts produced by tiktoken's cl100k_base encoding.
+var cl100kGolden = []struct {
+	name, text string
+	want       int
+}{
+	{"empty", "", 0},
+	{"ascii", "hello world", 2},
+	{"punctuation", "Hello, world!", 4},
+	{"sentence", "The quick brown fox jumps over the lazy dog.", 10},
+	{"contractions", "It's a don't-won't situation; they'll say I'VE done it.", 19},
+	{"accents", "naïve café résumé façade", 9},
+	{"code", "func main() {\n\tfmt.Println(\"hi\")\n}\n", 10},
+	{"japanese", "文書を取り込み、テキストを抽出して、数百トークンのチャンクに分割します。", 37},
+	{"chinese", "你好，世界！今天天气很好。", 16},
+	{"korean", "안녕하세요 세계", 9},
+	{"emoji", "🙂👍🏽 family: 👨‍👩‍👧‍👦 flags 🇳🇬🇯🇵", 40},
+	{"numbers", "1234567890 3.14159 -42 1,000,000 2024-10-19", 24},
+	{"whitespace runs", "a  b   c    d\n\n\n  e\t\tf \n", 13},
+	{"padded", "      leading and trailing      ", 5},
+}
+
+func TestCL100KMatchesTiktoken(t *testing.T) {
+	tok := testCL100K(t)
+	for _, c := range cl100kGolden {
+		if got := tok.Count(c.text); got != c.want {
+			t.Errorf("%s: Count(%q) = %d, tiktoken gives %d", c.name, c.text, got, c.want)
+		}
+	}
+}
+
+func TestNewDefaultsToEmbeddedCL100K(t *testing.T) {
+	tok, err := New("", "")
+	if err != nil {
+		t.Fatalf("New with no configuration: %v", err)
+	}
+	if got := tok.Count("hello world"); got != 2 {
+		t.Errorf("Count(hello world) = %d, want 2", got)
+	}
+}
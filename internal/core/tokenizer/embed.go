@@ -0,0 +1,28 @@
+package tokenizer
+
+import (
+	"bytes"
+	_ "embed"
+	"sync"
+)
+
+// cl100kVocab is the tiktoken cl100k_base vocabulary compiled into the binary; see
+// vocab/README.md.
+//
+//go:embed vocab/cl100k_base.tiktoken
+var cl100kVocab []byte
+
+var (
+	cl100kOnce sync.Once
+	cl100k     *BPE
+	cl100kErr  error
+)
+
+// NewCL100K returns the BPE tokenizer for the embedded cl100k_base vocabulary. The
+// vocabulary is parsed once and shared.
+func NewCL100K() (*BPE, error) {
+	cl100kOnce.Do(func() {
+		cl100k, cl100kErr = LoadBPE(bytes.NewReader(cl100kVocab))
+	})
+	return cl100k, cl100kErr
+}
@@ -0,0 +1,115 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.Tokenizer = (*Estimator)(nil)
+
+// Estimator approximates BPE token counts without a vocabulary. It scores runs of
+// characters by class rather than assuming four characters per token:
+//
+// Latin letters:   ~7 characters per token, plus one per accented letter.
+// Other alphabets: ~2 characters per token (Cyrillic, Greek, Arabic, ...).
+// CJK and kana:    one token per character.
+// Digits:          one token per group of three, as cl100k splits numbers.
+// Symbols:         one token per pair, since punctuation often merges ("()", ":=").
+// Emoji:           two tokens each, being four UTF-8 bytes.
+// Whitespace:      free, except line breaks.
+type Estimator struct{}
+
+func NewEstimator() *Estimator {
+	return &Estimator{}
+}
+
+type charClass int
+
+const (
+	classSpace charClass = iota
+	classNewline
+	classLatin
+	classLetter
+	classIdeograph
+	classDigit
+	classSymbol
+	classEmoji
+)
+
+// Count returns the estimated number of tokens in text.
+func (Estimator) Count(text string) int {
+	n := 0
+	run, runLen := classSpace, 0
+
+	closeRun := func() {
+		switch run {
+		case classLatin:
+			n += (runLen + 6) / 7
+		case classLetter:
+			n += (runLen + 1) / 2
+		case classDigit:
+			n += (runLen + 2) / 3
+		case classSymbol:
+			n += (runLen + 1) / 2
+		}
+		runLen = 0
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+
+		c := classify(r)
+		switch c {
+		case classIdeograph, classNewline:
+			closeRun()
+			n++
+			run = c
+			continue
+		case classEmoji:
+			closeRun()
+			n += 2
+			run = c
+			continue
+		case classSpace:
+			closeRun()
+			run = c
+			continue
+		}
+		if c != run {
+			closeRun()
+			run = c
+		}
+		if c == classLatin && r >= utf8.RuneSelf {
+			n++
+		}
+		runLen++
+	}
+	closeRun()
+	return n
+}
+
+func classify(r rune) charClass {
+	switch {
+	case r == '\n':
+		return classNewline
+	case unicode.IsSpace(r):
+		return classSpace
+	case r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'):
+		return classLatin
+	case unicode.IsNumber(r):
+		return classDigit
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return classIdeograph
+	case unicode.Is(unicode.Latin, r):
+		return classLatin
+	case unicode.IsLetter(r):
+		return classLetter
+	case r > 0xFFFF:
+		return classEmoji
+	default:
+		return classSymbol
+	}
+}
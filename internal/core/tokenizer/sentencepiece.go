@@ -0,0 +1,142 @@
+package tokenizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+var _ core.Tokenizer = (*SentencePiece)(nil)
+
+// spaceMark is the SentencePiece meta symbol that stands in for a space.
+const spaceMark = "▁"
+
+// SentencePiece is a unigram-model tokenizer in the style of SentencePiece, the family
+// Gemini and most open embedding models use. Text is split on whitespace, each word
+// gets the ▁ prefix and is segmented with Viterbi over the piece log-probabilities.
+// Characters the vocabulary cannot cover fall back to one token per UTF-8 byte when
+// the vocabulary has byte pieces (<0x41>), otherwise to a single unknown token.
+type SentencePiece struct {
+	scores       map[string]float64
+	maxPieceLen  int // longest piece in runes
+	byteFallback bool
+	unkScore     float64
+	cache        wordCache
+}
+
+// LoadSentencePieceFile reads a SentencePiece vocabulary from path.
+func LoadSentencePieceFile(path string) (*SentencePiece, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open sentencepiece vocabulary: %w", err)
+	}
+	defer f.Close()
+	return LoadSentencePiece(f)
+}
+
+// LoadSentencePiece parses a vocabulary as written by spm_export_vocab: one
+// "piece<TAB>score" pair per line. Control pieces (<s>, </s>, <unk>, <pad>) are skipped.
+func LoadSentencePiece(r io.Reader) (*SentencePiece, error) {
+	t := &SentencePiece{scores: make(map[string]float64, 32_000)}
+	minScore := 0.0
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if text == "" {
+			continue
+		}
+		piece, score, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("sentencepiece vocabulary line %d: want \"piece<TAB>score\"", line)
+		}
+		s, err := strconv.ParseFloat(strings.TrimSpace(score), 64)
+		if err != nil {
+			return nil, fmt.Errorf("sentencepiece vocabulary line %d: %w", line, err)
+		}
+		switch {
+		case piece == "<s>" || piece == "</s>" || piece == "<unk>" || piece == "<pad>":
+			continue
+		case len(piece) == 6 && strings.HasPrefix(piece, "<0x") && piece[5] == '>':
+			t.byteFallback = true
+			continue
+		}
+		t.scores[piece] = s
+		t.maxPieceLen = max(t.maxPieceLen, utf8.RuneCountInString(piece))
+		minScore = min(minScore, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read sentencepiece vocabulary: %w", err)
+	}
+	if len(t.scores) == 0 {
+		return nil, fmt.Errorf("sentencepiece vocabulary is empty")
+	}
+	// Unknown characters score below every real piece, as in SentencePiece.
+	t.unkScore = minScore - 10
+	return t, nil
+}
+
+// Count returns the number of pieces in text.
+func (t *SentencePiece) Count(text string) int {
+	n := 0
+	for _, w := range strings.FieldsFunc(text, unicode.IsSpace) {
+		w = spaceMark + w
+		if c, ok := t.cache.get(w); ok {
+			n += c
+			continue
+		}
+		c := t.segment(w)
+		t.cache.put(w, c)
+		n += c
+	}
+	return n
+}
+
+// segment runs Viterbi over word and returns the token count of the best path.
+func (t *SentencePiece) segment(word string) int {
+	runes := []rune(word)
+	// offs[k] is the byte offset of rune k so pieces can be sliced from word directly.
+	offs := make([]int, len(runes)+1)
+	for k, r := range runes {
+		offs[k+1] = offs[k] + utf8.RuneLen(r)
+	}
+
+	best := make([]float64, len(runes)+1)
+	count := make([]int, len(runes)+1)
+	for k := 1; k <= len(runes); k++ {
+		best[k] = math.Inf(-1)
+	}
+
+	for end := 1; end <= len(runes); end++ {
+		for start := max(0, end-t.maxPieceLen); start < end; start++ {
+			if math.IsInf(best[start], -1) {
+				continue
+			}
+			s, ok := t.scores[word[offs[start]:offs[end]]]
+			if !ok {
+				continue
+			}
+			if v := best[start] + s; v > best[end] {
+				best[end], count[end] = v, count[start]+1
+			}
+		}
+		// A single character the vocabulary lacks is always reachable as unknown.
+		if math.IsInf(best[end], -1) {
+			unk := 1
+			if t.byteFallback {
+				unk = offs[end] - offs[end-1]
+			}
+			best[end] = best[end-1] + t.unkScore*float64(unk)
+			count[end] = count[end-1] + unk
+		}
+	}
+	return count[len(runes)]
+}
@@ -0,0 +1,79 @@
+// Package tokenizer provides the core.Tokenizer implementations used to size chunks:
+// a byte-level BPE tokenizer for tiktoken vocabularies (cl100k_base), a SentencePiece
+// unigram tokenizer for exported SentencePiece vocabularies, and a vocabulary-free
+// estimator for when neither is available.
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// Tokenizer kinds accepted by New.
+const (
+	KindCL100K        = "cl100k"
+	KindBPE           = "bpe"
+	KindSentencePiece = "sentencepiece"
+	KindEstimate      = "estimate"
+)
+
+// New builds the tokenizer named by kind.
+//
+// cl100k:        BPE with the vocabulary embedded in the binary, or vocabPath when set.
+// bpe:           BPE with the tiktoken vocabulary at vocabPath.
+// sentencepiece: unigram model with the SentencePiece vocabulary at vocabPath.
+// estimate:      character-class estimator, no vocabulary needed.
+//
+// An empty kind is cl100k.
+func New(kind, vocabPath string) (core.Tokenizer, error) {
+	switch strings.ToLower(kind) {
+	case "", KindCL100K:
+		if vocabPath != "" {
+			return LoadBPEFile(vocabPath)
+		}
+		return NewCL100K()
+	case KindBPE:
+		if vocabPath == "" {
+			return nil, fmt.Errorf("tokenizer %q needs a vocabulary path", kind)
+		}
+		return LoadBPEFile(vocabPath)
+	case KindSentencePiece:
+		if vocabPath == "" {
+			return nil, fmt.Errorf("tokenizer %q needs a vocabulary path", kind)
+		}
+		return LoadSentencePieceFile(vocabPath)
+	case KindEstimate:
+		return NewEstimator(), nil
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q", kind)
+	}
+}
+
+// wordCacheSize bounds the per-tokenizer cache of word counts. Natural text repeats a
+// small set of words, so most lookups hit; the cache is dropped when it fills up.
+const wordCacheSize = 1 << 16
+
+// wordCache memoises token counts of pre-tokenised pieces.
+type wordCache struct {
+	mu sync.RWMutex
+	m  map[string]int
+}
+
+func (c *wordCache) get(w string) (int, bool) {
+	c.mu.RLock()
+	n, ok := c.m[w]
+	c.mu.RUnlock()
+	return n, ok
+}
+
+func (c *wordCache) put(w string, n int) {
+	c.mu.Lock()
+	if c.m == nil || len(c.m) >= wordCacheSize {
+		c.m = make(map[string]int, 1024)
+	}
+	c.m[strings.Clone(w)] = n
+	c.mu.Unlock()
+}
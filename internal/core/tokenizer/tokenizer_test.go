@@ -0,0 +1,141 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/markdave123-py/Contexta/internal/core"
+)
+
+// testWords become whole pieces in the test SentencePiece vocabulary.
+var testWords = []string{"the", "of", "and", "to", "in", "is", "document", "token", "chunk", "func", "return", "err"}
+
+func testCL100K(tb testing.TB) *BPE {
+	tb.Helper()
+	t, err := NewCL100K()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return t
+}
+
+// testSentencePiece builds a unigram vocabulary with single letters, byte fallback and
+// the test words as whole pieces.
+func testSentencePiece(tb testing.TB) *SentencePiece {
+	tb.Helper()
+	var sb strings.Builder
+	sb.WriteString("<unk>\t0\n<0x00>\t0\n")
+	for c := 'a'; c <= 'z'; c++ {
+		fmt.Fprintf(&sb, "%c\t-8\n%s%c\t-7\n", c, spaceMark, c)
+	}
+	for _, w := range testWords {
+		fmt.Fprintf(&sb, "%s%s\t-3\n%s\t-4\n", spaceMark, w, w)
+	}
+	t, err := LoadSentencePiece(strings.NewReader(sb.String()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return t
+}
+
+func TestSplitCL100KCoversInput(t *testing.T) {
+	for _, s := range []string{benchProse, benchCode, benchCJK, "it's   done\n\n  ok", "héllo wörld 12345!!"} {
+		var sb strings.Builder
+		splitCL100K(s, func(p string) {
+			if p == "" {
+				t.Fatalf("empty piece splitting %q", s)
+			}
+			sb.WriteString(p)
+		})
+		if sb.String() != s {
+			t.Fatalf("pieces of %q do not join back to it", s)
+		}
+	}
+}
+
+func TestSentencePiecePrefersWholeWords(t *testing.T) {
+	tok := testSentencePiece(t)
+	if got := tok.Count("the document"); got != 2 {
+		t.Errorf("Count(the document) = %d, want 2", got)
+	}
+	if got := tok.Count("zq"); got != 2 {
+		t.Errorf("Count(zq) = %d, want 2", got)
+	}
+}
+
+func TestNewNeedsVocabularyPaths(t *testing.T) {
+	for _, kind := range []string{KindBPE, KindSentencePiece} {
+		if _, err := New(kind, ""); err == nil {
+			t.Errorf("New(%q) without a vocabulary path succeeded", kind)
+		}
+	}
+	if _, err := New(KindEstimate, ""); err != nil {
+		t.Errorf("New(estimate): %v", err)
+	}
+}
+
+func TestEstimatorTracksBPE(t *testing.T) {
+	bpe, err := NewCL100K()
+	if err != nil {
+		t.Fatal(err)
+	}
+	est := NewEstimator()
+	for name, s := range map[string]string{"prose": benchProse, "code": benchCode} {
+		exact, guess := bpe.Count(s), est.Count(s)
+		if diff := float64(guess-exact) / float64(exact); diff < -0.25 || diff > 0.25 {
+			t.Errorf("%s: estimator %d vs cl100k %d tokens", name, guess, exact)
+		}
+	}
+}
+
+const (
+	benchProse = "The ingestion pipeline streams each document from object storage, extracts its text, " +
+		"splits it into chunks of a few hundred tokens and embeds them in batches. Chunk sizes are " +
+		"measured in tokens, the unit the embedding model is limited by, rather than in characters.\n"
+	benchCode = "func (i *DocumentIngestor) ProcessOne(ctx context.Context, docID string) error {\n" +
+		"\tdoc, err := i.db.GetDocumentByID(ctx, docID)\n\tif err != nil || doc == nil {\n" +
+		"\t\treturn fmt.Errorf(\"document not found: %w\", err)\n\t}\n\treturn nil\n}\n"
+	benchCJK = "文書を取り込み、テキストを抽出して、数百トークンのチャンクに分割します。埋め込みはまとめて計算されます。\n"
+)
+
+func benchmarkCount(b *testing.B, tok core.Tokenizer) {
+	samples := []struct{ name, text string }{{"prose", benchProse}, {"code", benchCode}, {"cjk", benchCJK}}
+	for _, sample := range samples {
+		text := strings.Repeat(sample.text, 200)
+		b.Run(sample.name, func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			b.ReportAllocs()
+			for b.Loop() {
+				tok.Count(text)
+			}
+		})
+	}
+}
+
+func BenchmarkEstimator(b *testing.B) {
+	benchmarkCount(b, NewEstimator())
+}
+
+func BenchmarkCL100K(b *testing.B) {
+	benchmarkCount(b, testCL100K(b))
+}
+
+func BenchmarkSentencePiece(b *testing.B) {
+	benchmarkCount(b, testSentencePiece(b))
+}
+
+// BenchmarkCL100KColdCache measures text whose words all miss the word cache.
+func BenchmarkCL100KColdCache(b *testing.B) {
+	tok := testCL100K(b)
+	words := make([]string, 5000)
+	for k := range words {
+		words[k] = fmt.Sprintf("w%dx%d", k, k*7)
+	}
+	text := strings.Join(words, " ")
+	b.SetBytes(int64(len(text)))
+	for b.Loop() {
+		tok.cache = wordCache{}
+		tok.Count(text)
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage operations.
+const (
+	UsageEmbed    = "embed"
+	UsageGenerate = "generate"
+)
+
+// Usage is what one provider call consumed.
+//
+// Operation:    UsageEmbed or UsageGenerate.
+// Model:        model the call was billed under.
+// InputTokens:  prompt or embedded text tokens.
+// OutputTokens: generated tokens, including any the model spent thinking; 0 for embeddings.
+type Usage struct {
+	Operation    string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+type usageCollectorKey struct{}
+
+// UsageCollector gathers the Usage reported by providers while serving one request.
+type UsageCollector struct {
+	mu     sync.Mutex
+	usages []Usage
+}
+
+// WithUsageCollector returns a context that providers report their usage to, and the
+// collector it goes to.
+func WithUsageCollector(ctx context.Context) (context.Context, *UsageCollector) {
+	c := &UsageCollector{}
+	return context.WithValue(ctx, usageCollectorKey{}, c), c
+}
+
+// Usages returns everything reported so far.
+func (c *UsageCollector) Usages() []Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Usage(nil), c.usages...)
+}
+
+// ReportUsage is called by providers with the usage metadata of a successful call.
+// It is a no-op when nobody collects usage for ctx.
+func ReportUsage(ctx context.Context, u Usage) {
+	c, _ := ctx.Value(usageCollectorKey{}).(*UsageCollector)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.usages = append(c.usages, u)
+	c.mu.Unlock()
+}
+
+// UsageScope says who provider calls made under a context are billed to. Empty fields
+// are stored as unknown.
+type UsageScope struct {
+	UserID     string
+	DocumentID string
+	MessageID  string
+}
+
+type usageScopeKey struct{}
+
+// WithUsageScope attributes provider calls made under ctx to scope.
+func WithUsageScope(ctx context.Context, scope UsageScope) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, scope)
+}
+
+// UsageScopeFrom returns the scope set with WithUsageScope, or an empty one.
+func UsageScopeFrom(ctx context.Context) UsageScope {
+	s, _ := ctx.Value(usageScopeKey{}).(UsageScope)
+	return s
+}
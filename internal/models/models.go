@@ -10,47 +10,223 @@ type User struct {
 	FirstName    string    `db:"first_name" json:"first_name"`
 	Email        string    `db:"email" json:"email"`
 	PasswordHash string    `db:"password" json:"-"`
+	Plan         string    `db:"plan" json:"plan"` // quota plan
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+
+	EmailVerifiedAt *time.Time `db:"email_verified_at" json:"email_verified_at,omitempty"`
 }
 
 // Document represents a user-uploaded or crawled document.
 type Document struct {
-	ID          string    `db:"id" json:"id"`
-	UserID      string    `db:"user_id" json:"user_id"`
-	FileName    string    `db:"file_name" json:"file_name"`
-	StorageURL  string    `db:"storage_url" json:"storage_url"` // S3 URL or original link
-	SourceType  string    `db:"source_type" json:"source_type"` // "upload" or "url"
-	ContentType string 	  `db:"content_type" json:"content_type"`
-	Status      string    `db:"status" json:"status"`           // uploaded | processing | ready | failed
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+	ID            string    `db:"id" json:"id"`
+	UserID        string    `db:"user_id" json:"user_id"` // uploader
+	OrgID         string    `db:"org_id" json:"org_id"`   // owning workspace
+	FileName      string    `db:"file_name" json:"file_name"`
+	StorageURL    string    `db:"storage_url" json:"storage_url"` // S3 URL or original link
+	SourceType    string    `db:"source_type" json:"source_type"` // "upload" or "url"
+	ContentType   string    `db:"content_type" json:"content_type"`
+	Status        string    `db:"status" json:"status"`                           // uploaded | processing | ready | failed
+	FailureReason string    `db:"failure_reason" json:"failure_reason,omitempty"` // set when Status is failed
+	ChunkStrategy string    `db:"chunk_strategy" json:"chunk_strategy,omitempty"` // empty uses the server default
+	ContentHash   string    `db:"content_hash" json:"content_hash,omitempty"`     // hex SHA-256 of the uploaded bytes
+	SizeBytes     int64     `db:"size_bytes" json:"size_bytes"`                   // stored size, counted against the storage quota
+	PageCount     int       `db:"page_count" json:"page_count,omitempty"`         // pages ingested, counted against the monthly page quota
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
 }
 
 // DocumentChunk represents one text chunk from a document.
 type DocumentChunk struct {
-	ID          string    `db:"id" json:"id"`
-	DocumentID  string    `db:"document_id" json:"document_id"`
-	Text        string    `db:"text" json:"text"`
-	Embedding   []float32 `db:"embedding" json:"embedding"` // pgvector column
-	Position    int       `db:"position" json:"position"`
-	TokenCount  int       `db:"token_count" json:"token_count"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ID           string            `db:"id" json:"id"`
+	DocumentID   string            `db:"document_id" json:"document_id"`
+	Text         string            `db:"text" json:"text"`
+	Embedding    []float32         `db:"embedding" json:"embedding"` // pgvector column
+	Position     int               `db:"position" json:"position"`
+	TokenCount   int               `db:"token_count" json:"token_count"`
+	PageStart    int               `db:"page_start" json:"page_start,omitempty"` // 0 when the source has no pages
+	PageEnd      int               `db:"page_end" json:"page_end,omitempty"`
+	HeadingPath  []string          `db:"heading_path" json:"heading_path,omitempty"` // enclosing section headings, outermost first
+	SourceOffset int64             `db:"source_offset" json:"source_offset"`         // byte offset in the extracted text
+	Metadata     map[string]string `db:"metadata" json:"metadata,omitempty"`
+	CreatedAt    time.Time         `db:"created_at" json:"created_at"`
 }
 
 // ChatSession represents one conversation session for a document.
 type ChatSession struct {
-	ID          string    `db:"id" json:"id"`
-	UserID      string    `db:"user_id" json:"user_id"`
-	DocumentID  string    `db:"document_id" json:"document_id"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ID         string    `db:"id" json:"id"`
+	UserID     string    `db:"user_id" json:"user_id"`
+	DocumentID string    `db:"document_id" json:"document_id"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
 }
 
 // ChatMessage represents an individual chat message (user or assistant).
 type ChatMessage struct {
-	ID         string    `db:"id" json:"id"`
-	SessionID  string    `db:"session_id" json:"session_id"`
-	Role       string    `db:"role" json:"role"`       // "user" or "assistant"
-	Content    string    `db:"content" json:"content"` // message text
-	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ID        string    `db:"id" json:"id"`
+	SessionID string    `db:"session_id" json:"session_id"`
+	Role      string    `db:"role" json:"role"`       // "user" or "assistant"
+	Content   string    `db:"content" json:"content"` // message text
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UsageRecord is one billed provider call, priced when it was made.
+type UsageRecord struct {
+	ID           string    `db:"id" json:"id"`
+	UserID       string    `db:"user_id" json:"user_id,omitempty"`         // empty when the call had no user
+	DocumentID   string    `db:"document_id" json:"document_id,omitempty"` // document being ingested or chatted with
+	MessageID    string    `db:"message_id" json:"message_id,omitempty"`   // chat message the call answered
+	Operation    string    `db:"operation" json:"operation"`               // "embed" or "generate"
+	Model        string    `db:"model" json:"model"`
+	InputTokens  int       `db:"input_tokens" json:"input_tokens"`
+	OutputTokens int       `db:"output_tokens" json:"output_tokens"`
+	CostUSD      float64   `db:"cost_usd" json:"cost_usd"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// UsageTotal sums a user's usage of one model for one operation on one UTC day.
+type UsageTotal struct {
+	Day          time.Time `json:"day"`
+	Operation    string    `json:"operation"`
+	Model        string    `json:"model"`
+	Calls        int       `json:"calls"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CostUSD      float64   `json:"cost_usd"`
+}
+
+// UserQuota is a user's quota plan and any per-user overrides of its limits.
+// A nil override keeps the plan's limit.
+type UserQuota struct {
+	Plan             string
+	MaxBytes         *int64
+	MaxDocuments     *int
+	MaxPagesPerMonth *int
+	MaxQueriesPerDay *int
+}
+
+// QuotaUsage is what a user has used of each quota.
+type QuotaUsage struct {
+	Bytes          int64 `json:"bytes"`
+	Documents      int   `json:"documents"`
+	PagesThisMonth int   `json:"pages_this_month"`
+	QueriesToday   int   `json:"queries_today"`
+}
+
+// RefreshToken is a stored refresh token. Only the hash of the token is kept.
+type RefreshToken struct {
+	ID         string     `db:"id" json:"id"`
+	UserID     string     `db:"user_id" json:"user_id"`
+	FamilyID   string     `db:"family_id" json:"family_id"` // shared by every rotation of one login
+	TokenHash  string     `db:"token_hash" json:"-"`        // hex SHA-256 of the token
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	ReplacedBy string     `db:"replaced_by" json:"replaced_by,omitempty"` // successor after rotation
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// APIKey is a user's personal API key. Only the hash of the key is kept.
+type APIKey struct {
+	ID         string     `db:"id" json:"id"`
+	UserID     string     `db:"user_id" json:"-"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"` // public part of the key, for lookup and display
+	KeyHash    string     `db:"key_hash" json:"-"`    // hex SHA-256 of the full key
+	Scopes     []string   `db:"scopes" json:"scopes"` // read | upload | chat
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	Provider  string    `db:"provider" json:"provider"` // configured provider name, e.g. "google"
+	Subject   string    `db:"subject" json:"subject"`   // the provider's stable user ID
+	UserID    string    `db:"user_id" json:"user_id"`
+	Email     string    `db:"email" json:"email"` // email the provider reported when linked
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Organization is a workspace that owns documents and has members.
+type Organization struct {
+	ID        string    `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Personal  bool      `db:"personal" json:"personal"` // the creator's own workspace, not shareable
+	CreatedBy string    `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	Role      string    `db:"role" json:"role,omitempty"` // the requesting user's role, when listed for them
+}
+
+// Membership is a user's role in an organization.
+type Membership struct {
+	OrgID     string    `db:"org_id" json:"org_id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	Email     string    `db:"email" json:"email,omitempty"` // the member's email, when listed
+	Role      string    `db:"role" json:"role"`             // owner | admin | editor | viewer
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Invitation invites an email address to join an organization with a role. Only the hash
+// of the invitation token is kept.
+type Invitation struct {
+	ID         string     `db:"id" json:"id"`
+	OrgID      string     `db:"org_id" json:"org_id"`
+	Email      string     `db:"email" json:"email"`
+	Role       string     `db:"role" json:"role"`
+	TokenHash  string     `db:"token_hash" json:"-"`
+	InvitedBy  string     `db:"invited_by" json:"invited_by,omitempty"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	AcceptedAt *time.Time `db:"accepted_at" json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ShareLink gives anyone with its token chat-only access to one document. Only hashes of
+// the token and password are kept.
+type ShareLink struct {
+	ID           string     `db:"id" json:"id"`
+	DocumentID   string     `db:"document_id" json:"document_id"`
+	CreatedBy    string     `db:"created_by" json:"created_by,omitempty"` // billed for the link's usage
+	TokenHash    string     `db:"token_hash" json:"-"`
+	PasswordHash string     `db:"password_hash" json:"-"` // bcrypt, empty when there is no password
+	HasPassword  bool       `db:"-" json:"has_password"`
+	ExpiresAt    time.Time  `db:"expires_at" json:"expires_at"`
+	MaxQueries   *int       `db:"max_queries" json:"max_queries,omitempty"` // nil is unlimited
+	QueryCount   int        `db:"query_count" json:"query_count"`
+	LastUsedAt   *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt    *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// AccountToken is a single-use token mailed to a user. Only the hash of the token is kept.
+type AccountToken struct {
+	ID        string     `db:"id" json:"id"`
+	UserID    string     `db:"user_id" json:"user_id"`
+	Purpose   string     `db:"purpose" json:"purpose"` // password_reset | email_verification
+	TokenHash string     `db:"token_hash" json:"-"`
+	Email     string     `db:"email" json:"email"` // the address it was sent to
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ChatTranscript is a user's conversation about one document, for data exports.
+type ChatTranscript struct {
+	SessionID  string        `json:"session_id"`
+	DocumentID string        `json:"document_id"`
+	FileName   string        `json:"file_name"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Messages   []ChatMessage `json:"messages"`
+}
+
+// DataExport is an archive of a user's data, built in the background.
+type DataExport struct {
+	ID            string     `db:"id" json:"id"`
+	UserID        string     `db:"user_id" json:"user_id"`
+	Status        string     `db:"status" json:"status"` // pending | ready | failed
+	StorageKey    string     `db:"storage_key" json:"-"` // object key of the archive once ready
+	SizeBytes     int64      `db:"size_bytes" json:"size_bytes"`
+	FailureReason string     `db:"failure_reason" json:"failure_reason,omitempty"`
+	ExpiresAt     time.Time  `db:"expires_at" json:"expires_at"`
+	CompletedAt   *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
 }
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/core/mailer"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Account errors.
+var (
+	ErrAccountInput     = errors.New("invalid account request")
+	ErrWrongPassword    = errors.New("current password is wrong")
+	ErrAccountTokenUsed = errors.New("link is invalid, expired or already used")
+	ErrAlreadyVerified  = errors.New("email is already verified")
+	ErrAccountNotFound  = errors.New("account not found")
+)
+
+// Account token purposes, matching the account_tokens.purpose check.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+// Account token lifetimes.
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// AccountService manages the user's own account: profile, password and the single-use
+// links mailed for password resets and email verification.
+type AccountService struct {
+	db      db.DbClient
+	mail    mailer.Mailer
+	baseURL string // web app the mailed links open
+	now     func() time.Time
+}
+
+func NewAccountService(db db.DbClient, mail mailer.Mailer, baseURL string) *AccountService {
+	return &AccountService{db: db, mail: mail, baseURL: strings.TrimRight(baseURL, "/"), now: time.Now}
+}
+
+// Profile returns the user.
+func (s *AccountService) Profile(ctx context.Context, userID string) (*models.User, error) {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrAccountNotFound
+	}
+	return user, nil
+}
+
+// UpdateProfile sets the user's first name.
+func (s *AccountService) UpdateProfile(ctx context.Context, userID, firstName string) (*models.User, error) {
+	firstName = strings.TrimSpace(firstName)
+	if len(firstName) > 100 {
+		return nil, fmt.Errorf("%w: first_name must be at most 100 characters", ErrAccountInput)
+	}
+	if err := s.db.UpdateUserProfile(ctx, userID, firstName); err != nil {
+		return nil, fmt.Errorf("update profile: %w", err)
+	}
+	return s.Profile(ctx, userID)
+}
+
+// ChangePassword sets a new password after checking the current one. Accounts created
+// through an identity provider have no password and may set one without it. Every
+// refresh token of the user is revoked, signing out their other sessions. API keys stay:
+// the caller knew the password, unlike after a reset.
+func (s *AccountService) ChangePassword(ctx context.Context, userID, current, next string) error {
+	user, err := s.Profile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" && !CheckPassword(user, current) {
+		return ErrWrongPassword
+	}
+	return s.setPassword(ctx, user.ID, user.Email, next)
+}
+
+// RequestPasswordReset mails a reset link to the address if it belongs to a user. It
+// succeeds either way so the caller cannot learn which addresses have accounts.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	user, err := s.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	link, err := s.issue(ctx, user, PurposePasswordReset, passwordResetTTL, "reset_token")
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Contexta password",
+		Body: "Someone asked to reset the password of your Contexta account.\n\n" +
+			"Open this link within an hour to choose a new password:\n\n" + link + "\n\n" +
+			"If it was not you, ignore this email; your password has not changed.\n",
+	})
+}
+
+// SendAccountExists tells the owner of an address that someone tried to sign up with it,
+// with a link to reset their password in case they forgot it. Signup sends it instead of
+// failing, so the signup answer does not reveal which addresses have accounts.
+func (s *AccountService) SendAccountExists(ctx context.Context, user *models.User) error {
+	link, err := s.issue(ctx, user, PurposePasswordReset, passwordResetTTL, "reset_token")
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "You already have a Contexta account",
+		Body: "Someone tried to sign up for Contexta with this email address, but it already has an account.\n\n" +
+			"If it was you, log in instead. If you forgot your password, open this link within an hour to choose a new one:\n\n" +
+			link + "\n\n" +
+			"If it was not you, ignore this email; nothing has changed.\n",
+	})
+}
+
+// ResetPassword spends a reset token and sets the new password. A reset is how an account
+// is recovered from whoever else knew the password, so every session and API key of the
+// user is revoked: nothing issued before the reset keeps working.
+func (s *AccountService) ResetPassword(ctx context.Context, token, next string) error {
+	// Checked before the token is spent, so a rejected password can be retried.
+	if err := ValidatePassword(next, ""); err != nil {
+		return err
+	}
+	t, err := s.db.ConsumeAccountToken(ctx, PurposePasswordReset, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("use reset token: %w", err)
+	}
+	if t == nil {
+		return ErrAccountTokenUsed
+	}
+	if err := s.setPassword(ctx, t.UserID, t.Email, next); err != nil {
+		return err
+	}
+	if err := s.db.RevokeUserAPIKeys(ctx, t.UserID); err != nil {
+		return fmt.Errorf("revoke api keys: %w", err)
+	}
+	return nil
+}
+
+// SendVerification mails the user a link to verify their email address.
+func (s *AccountService) SendVerification(ctx context.Context, userID string) error {
+	user, err := s.Profile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+
+	link, err := s.issue(ctx, user, PurposeEmailVerification, emailVerificationTTL, "verify_token")
+	if err != nil {
+		return err
+	}
+	return s.mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Contexta email address",
+		Body:    "Open this link within 48 hours to verify your email address:\n\n" + link + "\n",
+	})
+}
+
+// VerifyEmail spends a verification token and marks the address it was sent to as
+// verified, provided it is still the user's address.
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	t, err := s.db.ConsumeAccountToken(ctx, PurposeEmailVerification, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("use verification token: %w", err)
+	}
+	if t == nil {
+		return ErrAccountTokenUsed
+	}
+	ok, err := s.db.MarkEmailVerified(ctx, t.UserID, t.Email)
+	if err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+	if !ok {
+		return ErrAccountTokenUsed
+	}
+	return nil
+}
+
+func (s *AccountService) setPassword(ctx context.Context, userID, email, password string) error {
+	if err := ValidatePassword(password, email); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.db.UpdateUserPassword(ctx, userID, hash); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if err := s.db.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// issue stores a new account token for the user and returns the web app link that
+// carries it. The token travels in the fragment so it stays out of server logs.
+func (s *AccountService) issue(ctx context.Context, user *models.User, purpose string, ttl time.Duration, param string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := s.now()
+	if err := s.db.CreateAccountToken(ctx, &models.AccountToken{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}); err != nil {
+		return "", fmt.Errorf("store account token: %w", err)
+	}
+	return s.baseURL + "/#" + url.Values{param: {token}}.Encode(), nil
+}
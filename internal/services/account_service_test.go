@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// After a reset, nothing issued before it may still get in: not the refresh token, not
+// an access token that has yet to expire, and not an API key.
+func TestPasswordResetRevokesEveryCredential(t *testing.T) {
+	ctx := context.Background()
+	store := newSessionDB()
+	tokens := newTestTokenService(store, time.Now)
+	accounts := NewAccountService(store, nil, "https://contexta.test")
+
+	pair, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokens.Login(ctx, "user-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.activeKeys["user-1"], store.activeKeys["user-2"] = 2, 1
+	store.accountTokens[hashToken("reset-token")] = &models.AccountToken{
+		UserID: "user-1", Purpose: PurposePasswordReset, Email: "ada@example.com", ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if err := accounts.ResetPassword(ctx, "reset-token", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if store.passwords["user-1"] == "" {
+		t.Fatal("password was not set")
+	}
+	if _, err := tokens.Verify(ctx, pair.AccessToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("access token from before the reset: got %v, want ErrRevokedToken", err)
+	}
+	if _, err := tokens.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("refresh token from before the reset: got %v, want ErrRevokedToken", err)
+	}
+	if n := store.activeKeys["user-1"]; n != 0 {
+		t.Fatalf("%d API keys survived the reset", n)
+	}
+
+	// Other users keep everything.
+	if _, err := tokens.Verify(ctx, other.AccessToken); err != nil {
+		t.Fatalf("another user's access token: %v", err)
+	}
+	if store.activeKeys["user-2"] != 1 {
+		t.Fatal("another user's API key was revoked")
+	}
+
+	// The token is spent.
+	if err := accounts.ResetPassword(ctx, "reset-token", "another fine passphrase"); !errors.Is(err, ErrAccountTokenUsed) {
+		t.Fatalf("second use of the reset token: got %v, want ErrAccountTokenUsed", err)
+	}
+}
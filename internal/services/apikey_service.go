@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// APIKeyPrefix starts every key, so leaked keys are easy to scan for.
+const APIKeyPrefix = "ctx_"
+
+// API key errors.
+var (
+	ErrInvalidAPIKey  = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyInput    = errors.New("invalid API key request")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+const (
+	maxAPIKeyName  = 100
+	maxKeysPerUser = 50
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// APIKeyService creates, lists, renames and revokes personal API keys and authenticates
+// requests made with them. A key reads ctx_<prefix>_<secret>: the prefix finds the stored
+// row, and the SHA-256 of the whole key must match its hash.
+type APIKeyService struct {
+	db  db.DbClient
+	now func() time.Time
+}
+
+func NewAPIKeyService(db db.DbClient) *APIKeyService {
+	return &APIKeyService{db: db, now: time.Now}
+}
+
+// Create makes a key for the user and returns it with its plaintext, which is not
+// stored and cannot be shown again. ttl of zero never expires.
+func (s *APIKeyService) Create(ctx context.Context, userID, name string, scopes []string, ttl time.Duration) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyName {
+		return nil, "", fmt.Errorf("%w: name must be 1-%d characters", ErrAPIKeyInput, maxAPIKeyName)
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if ttl < 0 {
+		return nil, "", fmt.Errorf("%w: expiry must be in the future", ErrAPIKeyInput)
+	}
+
+	existing, err := s.db.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("list api keys: %w", err)
+	}
+	active := 0
+	for _, k := range existing {
+		if k.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= maxKeysPerUser {
+		return nil, "", fmt.Errorf("%w: at most %d active keys per user", ErrAPIKeyInput, maxKeysPerUser)
+	}
+
+	prefix, err := randomKeyPart(5)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomKeyPart(20)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := APIKeyPrefix + prefix + "_" + secret
+
+	now := s.now()
+	key := &models.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plaintext),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		exp := now.Add(ttl)
+		key.ExpiresAt = &exp
+	}
+	if err := s.db.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", fmt.Errorf("store api key: %w", err)
+	}
+	return key, plaintext, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]models.APIKey, error) {
+	return s.db.ListAPIKeys(ctx, userID)
+}
+
+func (s *APIKeyService) Rename(ctx context.Context, userID, id, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyName {
+		return fmt.Errorf("%w: name must be 1-%d characters", ErrAPIKeyInput, maxAPIKeyName)
+	}
+	if uuid.Validate(id) != nil {
+		return ErrAPIKeyNotFound
+	}
+	ok, err := s.db.RenameAPIKey(ctx, userID, id, name)
+	if err != nil {
+		return fmt.Errorf("rename api key: %w", err)
+	}
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrAPIKeyNotFound
+	}
+	ok, err := s.db.RevokeAPIKey(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext key to the principal it acts as.
+func (s *APIKeyService) Authenticate(ctx context.Context, plaintext string) (*Principal, error) {
+	rest, ok := strings.CutPrefix(plaintext, APIKeyPrefix)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.db.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("look up api key: %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(plaintext))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !s.now().Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := s.db.TouchAPIKey(ctx, key.ID); err != nil {
+		log.Printf("api key %s: recording use: %v", key.ID, err)
+	}
+	return &Principal{UserID: key.UserID, Method: AuthAPIKey, Scopes: key.Scopes, APIKeyID: key.ID}, nil
+}
+
+// normalizeScopes validates scopes and returns them deduplicated in display order.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope of %s is required", ErrAPIKeyInput, strings.Join(Scopes, ", "))
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(Scopes, sc) {
+			return nil, fmt.Errorf("%w: unknown scope %q, want %s", ErrAPIKeyInput, sc, strings.Join(Scopes, ", "))
+		}
+	}
+	var out []string
+	for _, sc := range Scopes {
+		if slices.Contains(scopes, sc) {
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+// randomKeyPart returns n random bytes as lowercase base32.
+func randomKeyPart(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate api key: %w", err)
+	}
+	return strings.ToLower(keyEncoding.EncodeToString(b)), nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Credential errors.
+var (
+	ErrInvalidEmail = errors.New("a valid email address is required")
+	ErrWeakPassword = errors.New("password does not meet the password policy")
+)
+
+// Password policy: at least minPasswordLen characters from three of the four character
+// classes, or a passphrase of at least passphraseLen characters. bcrypt ignores
+// everything past 72 bytes, so longer passwords are refused rather than truncated.
+const (
+	minPasswordLen = 10
+	passphraseLen  = 16
+	maxPasswordLen = 72
+)
+
+// commonPasswords are well-known passwords that would otherwise pass the policy.
+var commonPasswords = map[string]bool{
+	"password123!":     true,
+	"qwerty123456!":    true,
+	"1234567890qwerty": true,
+	"iloveyou12345678": true,
+	"letmein123456789": true,
+	"passwordpassword": true,
+	"welcome12345678!": true,
+	"aaaaaaaaaaaaaaaa": true,
+	"1234567890123456": true,
+}
+
+// revokeCredentials revokes every refresh token and API key of the user, so that only
+// whoever holds the account's password or identity can get back in.
+func revokeCredentials(ctx context.Context, store db.DbClient, userID string) error {
+	if err := store.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	if err := store.RevokeUserAPIKeys(ctx, userID); err != nil {
+		return fmt.Errorf("revoke api keys: %w", err)
+	}
+	return nil
+}
+
+// NormalizeEmail trims and lowercases an email address and checks that it is a plain
+// address, without a display name.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || len(email) > 254 {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 1 || !strings.Contains(email[at+1:], ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// ValidatePassword checks a new password for the account with the given email against
+// the password policy.
+func ValidatePassword(password, email string) error {
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("%w: it must be at most %d bytes", ErrWeakPassword, maxPasswordLen)
+	}
+	n := len([]rune(password))
+	if n < minPasswordLen {
+		return fmt.Errorf("%w: it must be at least %d characters", ErrWeakPassword, minPasswordLen)
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 3 && n < passphraseLen {
+		return fmt.Errorf("%w: use three of lowercase, uppercase, digits and symbols, or at least %d characters", ErrWeakPassword, passphraseLen)
+	}
+
+	folded := strings.ToLower(password)
+	if commonPasswords[folded] {
+		return fmt.Errorf("%w: it is too common", ErrWeakPassword)
+	}
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(local) >= 4 && strings.Contains(folded, local) {
+		return fmt.Errorf("%w: it must not contain your email address", ErrWeakPassword)
+	}
+	return nil
+}
+
+// HashPassword hashes a password for storage.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CheckPassword reports whether password is the user's password. A nil user, or one
+// without a password, is compared against a dummy hash so the time taken does not tell
+// whether the account exists.
+func CheckPassword(user *models.User, password string) bool {
+	if user == nil || user.PasswordHash == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("contexta-dummy-password"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// sessionDB keeps refresh tokens, the access token denylist, API keys and account tokens
+// in memory, with the semantics of the SQL behind them. Methods the tests do not use fall
+// through to the nil embedded client and panic.
+type sessionDB struct {
+	db.DbClient
+
+	mu            sync.Mutex
+	refresh       map[string]*models.RefreshToken // by token hash
+	denied        map[string]bool                 // jti
+	activeKeys    map[string]int                  // user ID -> unrevoked API keys
+	accountTokens map[string]*models.AccountToken // by token hash
+	passwords     map[string]string               // user ID -> hash
+}
+
+func newSessionDB() *sessionDB {
+	return &sessionDB{
+		refresh:       map[string]*models.RefreshToken{},
+		denied:        map[string]bool{},
+		activeKeys:    map[string]int{},
+		accountTokens: map[string]*models.AccountToken{},
+		passwords:     map[string]string{},
+	}
+}
+
+func (d *sessionDB) CreateRefreshToken(_ context.Context, t *models.RefreshToken) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cp := *t
+	d.refresh[t.TokenHash] = &cp
+	return nil
+}
+
+func (d *sessionDB) GetRefreshToken(_ context.Context, hash string) (*models.RefreshToken, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.refresh[hash]; ok {
+		cp := *t
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (d *sessionDB) RevokeRefreshToken(_ context.Context, id, replacedBy string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.refresh {
+		if t.ID == id && t.RevokedAt == nil {
+			now := time.Now()
+			t.RevokedAt, t.ReplacedBy = &now, replacedBy
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *sessionDB) RevokeRefreshFamily(_ context.Context, familyID string) error {
+	d.revokeWhere(func(t *models.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (d *sessionDB) RevokeUserRefreshTokens(_ context.Context, userID string) error {
+	d.revokeWhere(func(t *models.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (d *sessionDB) revokeWhere(match func(*models.RefreshToken) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for _, t := range d.refresh {
+		if match(t) && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+}
+
+func (d *sessionDB) RevokeAccessToken(_ context.Context, jti, _ string, _ time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.denied[jti] = true
+	return nil
+}
+
+func (d *sessionDB) IsAccessTokenRevoked(_ context.Context, jti, sessionID string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.denied[jti] {
+		return true, nil
+	}
+	for _, t := range d.refresh {
+		if t.FamilyID == sessionID && t.RevokedAt != nil && t.ReplacedBy == "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *sessionDB) RevokeUserAPIKeys(_ context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.activeKeys, userID)
+	return nil
+}
+
+func (d *sessionDB) ConsumeAccountToken(_ context.Context, purpose, hash string) (*models.AccountToken, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.accountTokens[hash]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil {
+		return nil, nil
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	cp := *t
+	return &cp, nil
+}
+
+func (d *sessionDB) UpdateUserPassword(_ context.Context, id, hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.passwords[id] = hash
+	return nil
+}
+
+// newTestTokenService returns a TokenService over store with the given clock.
+func newTestTokenService(store db.DbClient, now func() time.Time) *TokenService {
+	tokens, err := NewTokenService(store, "k8#Qz!v2Lp9@wR4m^Xt7&Yb1-nF6%hJ3*cD0", 15*time.Minute, 24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	tokens.now = now
+	return tokens
+}
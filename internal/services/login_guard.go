@@ -0,0 +1,137 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginGuardConfig configures a LoginGuard. Zero values take the defaults.
+//
+// MaxAccountFailures: failed logins to one email within Window before it is locked.
+// MaxIPFailures:      failed logins from one client IP within Window before it is locked.
+// Window:             how long a failure counts.
+// Lockout:            how long a locked email or IP is refused.
+type LoginGuardConfig struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	Window             time.Duration
+	Lockout            time.Duration
+}
+
+// loginCounter is the recent failures of one email or IP.
+type loginCounter struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// LoginGuard throttles password guessing. Failed logins are counted per email and per
+// client IP; either reaching its limit locks it out for a while. Emails are counted
+// whether or not they have an account, so a lockout does not reveal that one exists.
+// State is kept in memory, per process.
+type LoginGuard struct {
+	cfg LoginGuardConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	accounts  map[string]*loginCounter
+	ips       map[string]*loginCounter
+	lastPrune time.Time
+}
+
+func NewLoginGuard(cfg LoginGuardConfig) *LoginGuard {
+	if cfg.MaxAccountFailures <= 0 {
+		cfg.MaxAccountFailures = 5
+	}
+	if cfg.MaxIPFailures <= 0 {
+		cfg.MaxIPFailures = 50
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = 15 * time.Minute
+	}
+	return &LoginGuard{
+		cfg:      cfg,
+		now:      time.Now,
+		accounts: make(map[string]*loginCounter),
+		ips:      make(map[string]*loginCounter),
+	}
+}
+
+// Check returns how long logins for the email from the IP are locked out, or 0 if they
+// may be tried.
+func (g *LoginGuard) Check(email, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	for _, c := range []*loginCounter{g.accounts[email], g.ips[ip]} {
+		if c != nil && c.lockedUntil.After(now) {
+			wait = max(wait, c.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail records a failed login for the email from the IP.
+func (g *LoginGuard) Fail(email, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.prune(now)
+	g.record(g.accounts, email, g.cfg.MaxAccountFailures, now)
+	g.record(g.ips, ip, g.cfg.MaxIPFailures, now)
+}
+
+// Succeed clears the email's failures after a successful login. The IP's failures stand,
+// so one valid account cannot be used to reset guessing at others.
+func (g *LoginGuard) Succeed(email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.accounts, email)
+}
+
+func (g *LoginGuard) record(counters map[string]*loginCounter, key string, limit int, now time.Time) {
+	if key == "" {
+		return
+	}
+	c := counters[key]
+	if c == nil {
+		c = &loginCounter{}
+		counters[key] = c
+	}
+	c.failures = append(recent(c.failures, now.Add(-g.cfg.Window)), now)
+	if len(c.failures) >= limit {
+		c.lockedUntil = now.Add(g.cfg.Lockout)
+		c.failures = nil
+	}
+}
+
+// prune drops counters with no recent failures and no lockout, at most once a minute.
+func (g *LoginGuard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < time.Minute {
+		return
+	}
+	g.lastPrune = now
+	since := now.Add(-g.cfg.Window)
+	for _, counters := range []map[string]*loginCounter{g.accounts, g.ips} {
+		for key, c := range counters {
+			c.failures = recent(c.failures, since)
+			if len(c.failures) == 0 && !c.lockedUntil.After(now) {
+				delete(counters, key)
+			}
+		}
+	}
+}
+
+// recent returns the failures after since; they are in time order.
+func recent(failures []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(failures) && !failures[i].After(since) {
+		i++
+	}
+	return failures[i:]
+}
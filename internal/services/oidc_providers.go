@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+
+	"github.com/markdave123-py/Contexta/internal/config"
+)
+
+// oidcProvider is an OpenID Connect issuer. Its discovery document is fetched on first
+// use and kept; ID tokens are verified against the issuer's JWKS.
+type oidcProvider struct {
+	name        string
+	cfg         config.OIDCProvider
+	redirectURL string
+	client      *http.Client
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCProvider(name string, cfg config.OIDCProvider, redirectURL string, client *http.Client) *oidcProvider {
+	return &oidcProvider{name: name, cfg: cfg, redirectURL: redirectURL, client: client}
+}
+
+// discover loads the issuer's configuration, retrying on later calls if it failed.
+func (p *oidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// The key set keeps using the context it was created with, so it must outlive ctx.
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.WithoutCancel(ctx), p.client), p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", p.name, err)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.cfg.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	conf, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return conf.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	conf, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, p.client)
+
+	tok, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok || raw == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := idVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("verify id_token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string    `json:"email"`
+		EmailVerified claimBool `json:"email_verified"`
+		Name          string    `json:"name"`
+		GivenName     string    `json:"given_name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("read id_token claims: %w", err)
+	}
+	name := claims.GivenName
+	if name == "" {
+		name = claims.Name
+	}
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          name,
+	}, nil
+}
+
+// claimBool reads a boolean claim that some issuers send as the string "true".
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	if s, err := strconv.Unquote(string(data)); err == nil {
+		data = []byte(s)
+	}
+	v, err := strconv.ParseBool(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid boolean claim %s", data)
+	}
+	*b = claimBool(v)
+	return nil
+}
+
+// githubProvider logs in with GitHub, which speaks OAuth2 but not OIDC: the identity
+// comes from the REST API instead of an ID token, and there is no nonce.
+type githubProvider struct {
+	oauth  *oauth2.Config
+	apiURL string
+	client *http.Client
+}
+
+func newGitHubProvider(cfg config.OIDCProvider, redirectURL string, client *http.Client) *githubProvider {
+	endpoint, apiURL := github.Endpoint, "https://api.github.com"
+	if host := strings.TrimRight(cfg.Issuer, "/"); host != "" {
+		// GitHub Enterprise Server.
+		endpoint = oauth2.Endpoint{
+			AuthURL:  host + "/login/oauth/authorize",
+			TokenURL: host + "/login/oauth/access_token",
+		}
+		apiURL = host + "/api/v3"
+	}
+	return &githubProvider{
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     endpoint,
+			RedirectURL:  redirectURL,
+			Scopes:       cfg.Scopes,
+		},
+		apiURL: apiURL,
+		client: client,
+	}
+}
+
+func (p *githubProvider) AuthCodeURL(_ context.Context, state, _, verifier string) (string, error) {
+	return p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code, verifier, _ string) (*Identity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	api := p.oauth.Client(ctx, tok)
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(ctx, api, "/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github user has no id")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, api, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if id.Name == "" {
+		id.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email, id.EmailVerified = e.Email, e.Verified
+			break
+		}
+	}
+	return id, nil
+}
+
+func (p *githubProvider) get(ctx context.Context, client *http.Client, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("github %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("github %s: %s: %s", path, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("github %s: %w", path, err)
+	}
+	return nil
+}
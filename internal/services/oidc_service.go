@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/markdave123-py/Contexta/internal/config"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// OIDC login errors.
+var (
+	ErrUnknownProvider  = errors.New("unknown login provider")
+	ErrLoginState       = errors.New("login state is missing, expired or does not match; start the login again")
+	ErrIdentityRejected = errors.New("identity provider login failed")
+	ErrEmailUnverified  = errors.New("an account with this email exists, but the provider has not verified the email")
+)
+
+// loginStateTTL bounds how long a user may take at the identity provider.
+const loginStateTTL = 10 * time.Minute
+
+// Identity is a user as an identity provider vouches for them.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityProvider runs the authorization-code flow with PKCE against one provider.
+type IdentityProvider interface {
+	// AuthCodeURL is where to send the user, carrying state, nonce and the PKCE challenge of verifier.
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange redeems code and returns the verified identity; nonce must match the one sent.
+	Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
+
+// OIDCService logs users in through external identity providers. Login state (state,
+// nonce, PKCE verifier) travels in a signed value the handler keeps in a cookie, so no
+// server-side session is needed between the redirect and the callback.
+type OIDCService struct {
+	db        db.DbClient
+	tokens    *TokenService
+	providers map[string]IdentityProvider
+	stateKey  []byte
+	now       func() time.Time
+}
+
+// NewOIDCService configures the providers. Callbacks go to
+// <redirectBase>/api/auth/oidc/<name>/callback. client is used for every call to the
+// providers; nil means http.DefaultClient.
+func NewOIDCService(db db.DbClient, tokens *TokenService, providers map[string]config.OIDCProvider, redirectBase string, client *http.Client) (*OIDCService, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	s := &OIDCService{
+		db:        db,
+		tokens:    tokens,
+		providers: map[string]IdentityProvider{},
+		stateKey:  tokens.deriveKey("oidc-login-state"),
+		now:       time.Now,
+	}
+	for name, cfg := range providers {
+		if cfg.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q: client id is not set", name)
+		}
+		redirectURL := strings.TrimRight(redirectBase, "/") + "/api/auth/oidc/" + name + "/callback"
+		if name == "github" {
+			s.providers[name] = newGitHubProvider(cfg, redirectURL, client)
+			continue
+		}
+		if cfg.Issuer == "" {
+			return nil, fmt.Errorf("oidc provider %q: issuer is not set", name)
+		}
+		s.providers[name] = newOIDCProvider(name, cfg, redirectURL, client)
+	}
+	return s, nil
+}
+
+// Providers lists the configured provider names.
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// loginState is what the service needs back at the callback.
+type loginState struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Redirect string `json:"r,omitempty"`
+	Expires  int64  `json:"e"`
+}
+
+// Begin starts a login with provider. It returns the provider URL to send the user to
+// and the signed login state to hand back to Complete. redirect is where the client
+// wants to land afterwards and must be a path on this site, or empty.
+func (s *OIDCService) Begin(ctx context.Context, provider, redirect string) (authURL, signedState string, err error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	if redirect != "" && !isLocalPath(redirect) {
+		return "", "", fmt.Errorf("%w: redirect must be a path on this site", ErrIdentityRejected)
+	}
+
+	st := loginState{Provider: provider, Verifier: oauth2.GenerateVerifier(), Redirect: redirect,
+		Expires: s.now().Add(loginStateTTL).Unix()}
+	if st.State, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	if st.Nonce, err = randomToken(); err != nil {
+		return "", "", err
+	}
+
+	authURL, err = p.AuthCodeURL(ctx, st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrIdentityRejected, err)
+	}
+	signedState, err = s.sign(st)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, signedState, nil
+}
+
+// Complete finishes a login: it checks the callback's state against the signed login
+// state, redeems the code, finds or creates the linked user and logs them in. It also
+// returns the redirect passed to Begin.
+func (s *OIDCService) Complete(ctx context.Context, provider, code, state, signedState string) (*TokenPair, *models.User, string, error) {
+	st, err := s.verify(signedState)
+	if err != nil || st.Provider != provider || !hmac.Equal([]byte(st.State), []byte(state)) {
+		return nil, nil, "", ErrLoginState
+	}
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, nil, "", ErrUnknownProvider
+	}
+	if code == "" {
+		return nil, nil, "", fmt.Errorf("%w: no authorization code", ErrIdentityRejected)
+	}
+
+	identity, err := p.Exchange(ctx, code, st.Verifier, st.Nonce)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%w: %v", ErrIdentityRejected, err)
+	}
+	identity.Provider = provider
+
+	user, err := s.resolveUser(ctx, identity)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	pair, err := s.tokens.Login(ctx, user.ID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return pair, user, st.Redirect, nil
+}
+
+// resolveUser returns the user linked to the identity. An unlinked identity is linked to
+// the user with the same email if the provider verified it, claiming that account if its
+// own email was never verified, or gets a new user.
+func (s *OIDCService) resolveUser(ctx context.Context, id *Identity) (*models.User, error) {
+	user, err := s.db.GetUserByIdentity(ctx, id.Provider, id.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("look up identity: %w", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	email := strings.ToLower(strings.TrimSpace(id.Email))
+	if email == "" {
+		return nil, fmt.Errorf("%w: the provider did not share an email address", ErrIdentityRejected)
+	}
+	user, err = s.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("look up user: %w", err)
+	}
+	if user != nil && !id.EmailVerified {
+		return nil, ErrEmailUnverified
+	}
+	if user != nil && user.EmailVerifiedAt == nil {
+		if err := s.claim(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		user = &models.User{
+			ID:        uuid.NewString(),
+			FirstName: id.Name,
+			Email:     email,
+			CreatedAt: s.now(),
+			UpdatedAt: s.now(),
+		}
+		// No password: the account signs in through its identity provider.
+		if err := s.db.CreateUser(ctx, user); err != nil {
+			// Lost a race with another first login of the same identity.
+			if existing, _ := s.db.GetUserByIdentity(ctx, id.Provider, id.Subject); existing != nil {
+				return existing, nil
+			}
+			return nil, fmt.Errorf("create user: %w", err)
+		}
+		if id.EmailVerified {
+			if _, err := s.db.MarkEmailVerified(ctx, user.ID, email); err != nil {
+				return nil, fmt.Errorf("verify email: %w", err)
+			}
+		}
+	}
+
+	if err := s.db.LinkIdentity(ctx, &models.UserIdentity{
+		Provider: id.Provider, Subject: id.Subject, UserID: user.ID, Email: email, CreatedAt: s.now(),
+	}); err != nil {
+		return nil, fmt.Errorf("link identity: %w", err)
+	}
+	return user, nil
+}
+
+// claim hands an account whose email was never verified to the provider's verified
+// owner of that email. Anyone could have signed up with the address, so the password
+// and every session and API key it issued stop working; the owner can set a new one.
+func (s *OIDCService) claim(ctx context.Context, user *models.User) error {
+	if err := s.db.UpdateUserPassword(ctx, user.ID, ""); err != nil {
+		return fmt.Errorf("clear password: %w", err)
+	}
+	if err := revokeCredentials(ctx, s.db, user.ID); err != nil {
+		return err
+	}
+	if _, err := s.db.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+	user.PasswordHash = ""
+	return nil
+}
+
+func (s *OIDCService) sign(st loginState) (string, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, s.stateKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *OIDCService) verify(signed string) (*loginState, error) {
+	enc, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, ErrLoginState
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, ErrLoginState
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrLoginState
+	}
+	mac := hmac.New(sha256.New, s.stateKey)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, ErrLoginState
+	}
+
+	var st loginState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, ErrLoginState
+	}
+	if s.now().Unix() > st.Expires {
+		return nil, ErrLoginState
+	}
+	return &st, nil
+}
+
+// isLocalPath accepts absolute paths on this site and rejects anything that a browser
+// could read as another origin ("//host", "/\host", "https://...").
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.ContainsAny(p, "\\\r\n")
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/markdave123-py/Contexta/internal/config"
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+const testClientID = "contexta-test"
+
+// fakeIssuer is an OpenID Connect issuer. authorize plays the user consenting: it
+// records the request's nonce and PKCE challenge and returns a code, which the token
+// endpoint redeems for an ID token for the issuer's current subject and email.
+type fakeIssuer struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu            sync.Mutex
+	codes         map[string]url.Values // authorization request, by code
+	subject       string
+	email         string
+	emailVerified any    // bool, or a string as some issuers send it
+	nonce         string // overrides the requested nonce when set
+	signer        *rsa.PrivateKey
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &fakeIssuer{key: key, signer: key, codes: map[string]url.Values{}, subject: "subject-1", email: "Ada@Example.com", emailVerified: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                iss.srv.URL,
+			"authorization_endpoint":                iss.srv.URL + "/authorize",
+			"token_endpoint":                        iss.srv.URL + "/token",
+			"jwks_uri":                              iss.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &iss.key.PublicKey, KeyID: "key-1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", iss.token)
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// authorize accepts the authorization request at authURL and returns the code.
+func (iss *fakeIssuer) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != testClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" || q.Get("state") == "" {
+		t.Fatalf("authorization request %s", authURL)
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	code = "code-" + q.Get("state")
+	iss.codes[code] = q
+	return code, q.Get("state")
+}
+
+func (iss *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	fail := func(reason string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": reason})
+	}
+	if err := r.ParseForm(); err != nil {
+		fail(err.Error())
+		return
+	}
+	req, ok := iss.codes[r.PostForm.Get("code")]
+	if !ok {
+		fail("unknown code")
+		return
+	}
+	delete(iss.codes, r.PostForm.Get("code"))
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.Get("code_challenge") {
+		fail("code verifier does not match the challenge")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != req.Get("redirect_uri") {
+		fail("redirect uri does not match")
+		return
+	}
+
+	nonce := req.Get("nonce")
+	if iss.nonce != "" {
+		nonce = iss.nonce
+	}
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            iss.srv.URL,
+		"aud":            testClientID,
+		"sub":            iss.subject,
+		"email":          iss.email,
+		"email_verified": iss.emailVerified,
+		"given_name":     "Ada",
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	})
+	idToken.Header["kid"] = "key-1"
+	raw, err := idToken.SignedString(iss.signer)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": raw})
+}
+
+// identityDB keeps users and linked identities in memory. Methods the login does not
+// use fall through to the nil embedded client and panic.
+type identityDB struct {
+	db.DbClient
+
+	mu         sync.Mutex
+	users      map[string]*models.User // by ID
+	identities map[string]string       // provider/subject -> user ID
+	revoked    map[string]string       // user ID -> what was revoked, "sessions" and "keys"
+}
+
+func newIdentityDB() *identityDB {
+	return &identityDB{users: map[string]*models.User{}, identities: map[string]string{}, revoked: map[string]string{}}
+}
+
+func (d *identityDB) GetUserByIdentity(_ context.Context, provider, subject string) (*models.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, ok := d.identities[provider+"/"+subject]; ok {
+		return d.users[id], nil
+	}
+	return nil, nil
+}
+
+func (d *identityDB) GetUserByEmail(_ context.Context, email string) (*models.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, u := range d.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *identityDB) CreateUser(_ context.Context, u *models.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[u.ID] = u
+	return nil
+}
+
+func (d *identityDB) MarkEmailVerified(_ context.Context, id, _ string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.users[id].EmailVerifiedAt = &now
+	return true, nil
+}
+
+func (d *identityDB) LinkIdentity(_ context.Context, identity *models.UserIdentity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.identities[identity.Provider+"/"+identity.Subject] = identity.UserID
+	return nil
+}
+
+func (d *identityDB) UpdateUserPassword(_ context.Context, id, hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[id].PasswordHash = hash
+	return nil
+}
+
+func (d *identityDB) RevokeUserRefreshTokens(_ context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revoked[userID] += "sessions "
+	return nil
+}
+
+func (d *identityDB) RevokeUserAPIKeys(_ context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revoked[userID] += "keys "
+	return nil
+}
+
+func (d *identityDB) CreateRefreshToken(context.Context, *models.RefreshToken) error { return nil }
+
+func newTestOIDCService(t *testing.T, store db.DbClient, iss *fakeIssuer) *OIDCService {
+	t.Helper()
+	tokens, err := NewTokenService(store, "k8#Qz!v2Lp9@wR4m^Xt7&Yb1-nF6%hJ3*cD0", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewOIDCService(store, tokens, map[string]config.OIDCProvider{
+		"test": {Issuer: iss.srv.URL, ClientID: testClientID, ClientSecret: "secret", Scopes: []string{"email", "profile"}},
+	}, "https://contexta.test", iss.srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// login runs a login through the fake issuer and returns what Complete returns.
+func login(t *testing.T, s *OIDCService, iss *fakeIssuer) (*TokenPair, *models.User, string, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, signed, err := s.Begin(ctx, "test", "/documents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := iss.authorize(t, authURL)
+	return s.Complete(ctx, "test", code, state, signed)
+}
+
+func TestOIDCLoginCreatesAndLinksUser(t *testing.T) {
+	iss := newFakeIssuer(t)
+	store := newIdentityDB()
+	s := newTestOIDCService(t, store, iss)
+
+	pair, user, redirect, err := login(t, s, iss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.AccessToken == "" || redirect != "/documents" {
+		t.Fatalf("tokens %+v, redirect %q", pair, redirect)
+	}
+	if user.Email != "ada@example.com" || user.FirstName != "Ada" || user.PasswordHash != "" || user.EmailVerifiedAt == nil {
+		t.Fatalf("created user %+v", user)
+	}
+	if store.identities["test/subject-1"] != user.ID {
+		t.Fatalf("identity linked to %q, want %q", store.identities["test/subject-1"], user.ID)
+	}
+
+	// The next login finds the linked user, even once the email changes at the issuer.
+	iss.email = "ada@elsewhere.example"
+	_, again, _, err := login(t, s, iss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID || len(store.users) != 1 {
+		t.Fatalf("second login gave user %s of %d, want %s", again.ID, len(store.users), user.ID)
+	}
+}
+
+func TestOIDCLoginLinksExistingAccountByVerifiedEmail(t *testing.T) {
+	for _, verified := range []any{true, "true", false} {
+		iss := newFakeIssuer(t)
+		iss.emailVerified = verified
+		store := newIdentityDB()
+		verifiedAt := time.Now().Add(-time.Hour)
+		store.users["existing"] = &models.User{ID: "existing", Email: "ada@example.com", PasswordHash: "hash", EmailVerifiedAt: &verifiedAt}
+		s := newTestOIDCService(t, store, iss)
+
+		_, user, _, err := login(t, s, iss)
+		if verified == false {
+			if !errors.Is(err, ErrEmailUnverified) || len(store.identities) != 0 {
+				t.Fatalf("unverified email: got %v with %d identities linked", err, len(store.identities))
+			}
+			continue
+		}
+		if err != nil || user.ID != "existing" || store.identities["test/subject-1"] != "existing" {
+			t.Fatalf("email_verified %v: got user %+v, %v", verified, user, err)
+		}
+		// The account's owner proved the address before; their password and sessions stand.
+		if user.PasswordHash != "hash" || store.revoked["existing"] != "" {
+			t.Fatalf("email_verified %v: password %q, revoked %q", verified, user.PasswordHash, store.revoked["existing"])
+		}
+	}
+}
+
+// Someone who signed up with another person's address, and never verified it, must lose
+// the account when the address's owner signs in through a provider that verified it.
+func TestOIDCLoginClaimsAccountWithUnverifiedEmail(t *testing.T) {
+	iss := newFakeIssuer(t)
+	store := newIdentityDB()
+	store.users["squatter"] = &models.User{ID: "squatter", Email: "ada@example.com", PasswordHash: "attacker's hash"}
+	s := newTestOIDCService(t, store, iss)
+
+	_, user, _, err := login(t, s, iss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "squatter" || store.identities["test/subject-1"] != "squatter" {
+		t.Fatalf("logged in as %+v, identity linked to %q", user, store.identities["test/subject-1"])
+	}
+	stored := store.users["squatter"]
+	if stored.PasswordHash != "" || user.PasswordHash != "" {
+		t.Fatalf("the password set at signup still works: stored %q, returned %q", stored.PasswordHash, user.PasswordHash)
+	}
+	if got := store.revoked["squatter"]; got != "sessions keys " {
+		t.Fatalf("revoked %q, want the sessions and API keys", got)
+	}
+	if stored.EmailVerifiedAt == nil {
+		t.Fatal("email is still unverified")
+	}
+}
+
+func TestOIDCLoginRejections(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s *OIDCService, iss *fakeIssuer) error
+		want error
+	}{
+		{"state does not match", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			authURL, signed, _ := s.Begin(ctx, "test", "")
+			code, _ := iss.authorize(t, authURL)
+			_, _, _, err := s.Complete(ctx, "test", code, "forged-state", signed)
+			return err
+		}, ErrLoginState},
+		{"tampered login state", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			authURL, signed, _ := s.Begin(ctx, "test", "")
+			code, state := iss.authorize(t, authURL)
+			_, _, _, err := s.Complete(ctx, "test", code, state, signed+"x")
+			return err
+		}, ErrLoginState},
+		{"expired login state", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			authURL, signed, _ := s.Begin(ctx, "test", "")
+			code, state := iss.authorize(t, authURL)
+			s.now = func() time.Time { return time.Now().Add(loginStateTTL + time.Second) }
+			_, _, _, err := s.Complete(ctx, "test", code, state, signed)
+			return err
+		}, ErrLoginState},
+		{"code of another login", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			// The code was issued against the other login's PKCE challenge.
+			first, signed, _ := s.Begin(ctx, "test", "")
+			_, state := iss.authorize(t, first)
+			second, _, _ := s.Begin(ctx, "test", "")
+			code, _ := iss.authorize(t, second)
+			_, _, _, err := s.Complete(ctx, "test", code, state, signed)
+			return err
+		}, ErrIdentityRejected},
+		{"nonce does not match", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			iss.nonce = "replayed-nonce"
+			_, _, _, err := login(t, s, iss)
+			return err
+		}, ErrIdentityRejected},
+		{"id token signed by another key", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			other, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			iss.signer = other
+			_, _, _, err = login(t, s, iss)
+			return err
+		}, ErrIdentityRejected},
+		{"redirect to another site", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			_, _, err := s.Begin(ctx, "test", "//evil.example/")
+			return err
+		}, ErrIdentityRejected},
+		{"unknown provider", func(t *testing.T, s *OIDCService, iss *fakeIssuer) error {
+			_, _, err := s.Begin(ctx, "nope", "")
+			return err
+		}, ErrUnknownProvider},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			iss := newFakeIssuer(t)
+			store := newIdentityDB()
+			err := c.run(t, newTestOIDCService(t, store, iss), iss)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+			if len(store.users) != 0 || len(store.identities) != 0 {
+				t.Fatalf("a rejected login created %d users and linked %d identities", len(store.users), len(store.identities))
+			}
+		})
+	}
+}
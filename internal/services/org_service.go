@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Organization errors.
+var (
+	ErrOrgInput          = errors.New("invalid organization request")
+	ErrLastOwner         = errors.New("an organization must keep at least one owner")
+	ErrInvitationInvalid = errors.New("invitation is invalid, expired or already used")
+)
+
+// invitationTTL is how long an invitation can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// OrgService manages organizations, their members and invitations. Every check of what
+// the caller may do goes through the Policy.
+type OrgService struct {
+	db     db.DbClient
+	policy *Policy
+	now    func() time.Time
+}
+
+func NewOrgService(db db.DbClient, policy *Policy) *OrgService {
+	return &OrgService{db: db, policy: policy, now: time.Now}
+}
+
+// Create makes a new organization owned by the user.
+func (s *OrgService) Create(ctx context.Context, userID, name string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("%w: name must be 1-100 characters", ErrOrgInput)
+	}
+	org := &models.Organization{ID: uuid.NewString(), Name: name, CreatedBy: userID, CreatedAt: s.now(), Role: RoleOwner}
+	if err := s.db.CreateOrganization(ctx, org, userID); err != nil {
+		return nil, fmt.Errorf("create organization: %w", err)
+	}
+	return org, nil
+}
+
+// List returns the user's organizations with their role in each, personal workspace first.
+func (s *OrgService) List(ctx context.Context, userID string) ([]models.Organization, error) {
+	return s.db.ListOrganizations(ctx, userID)
+}
+
+// Personal returns the user's personal workspace.
+func (s *OrgService) Personal(ctx context.Context, userID string) (*models.Organization, error) {
+	orgs, err := s.db.ListOrganizations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orgs {
+		if orgs[i].Personal && orgs[i].CreatedBy == userID {
+			return &orgs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("user %s has no personal workspace", userID)
+}
+
+// Members lists the organization's members.
+func (s *OrgService) Members(ctx context.Context, principal *Principal, orgID string) ([]models.Membership, error) {
+	if _, err := s.policy.Authorize(ctx, principal, orgID, ActionReadMembers); err != nil {
+		return nil, err
+	}
+	return s.db.ListMemberships(ctx, orgID)
+}
+
+// Invite invites an email address to the organization with a role. The returned token is
+// what the invitee accepts with; it is not stored and cannot be retrieved again.
+func (s *OrgService) Invite(ctx context.Context, principal *Principal, orgID, email, role string) (*models.Invitation, string, error) {
+	g, err := s.policy.Authorize(ctx, principal, orgID, ActionManageMembers)
+	if err != nil {
+		return nil, "", err
+	}
+	email, err = NormalizeEmail(email)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrOrgInput, err)
+	}
+	if !ValidRole(role) {
+		return nil, "", fmt.Errorf("%w: role must be one of %s", ErrOrgInput, strings.Join(Roles, ", "))
+	}
+	if role == RoleOwner && !g.Allows(ActionManageOwners) {
+		return nil, "", ErrForbidden
+	}
+	org, err := s.db.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, "", fmt.Errorf("look up organization: %w", err)
+	}
+	if org == nil {
+		return nil, "", ErrNotFound
+	}
+	if org.Personal {
+		return nil, "", fmt.Errorf("%w: a personal workspace cannot be shared; create an organization", ErrOrgInput)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := s.now()
+	inv := &models.Invitation{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: principal.UserID,
+		ExpiresAt: now.Add(invitationTTL),
+		CreatedAt: now,
+	}
+	if err := s.db.CreateInvitation(ctx, inv); err != nil {
+		return nil, "", fmt.Errorf("store invitation: %w", err)
+	}
+	return inv, token, nil
+}
+
+// Invitations lists the organization's pending invitations.
+func (s *OrgService) Invitations(ctx context.Context, principal *Principal, orgID string) ([]models.Invitation, error) {
+	if _, err := s.policy.Authorize(ctx, principal, orgID, ActionManageMembers); err != nil {
+		return nil, err
+	}
+	return s.db.ListInvitations(ctx, orgID)
+}
+
+// RevokeInvitation withdraws a pending invitation.
+func (s *OrgService) RevokeInvitation(ctx context.Context, principal *Principal, orgID, id string) error {
+	if _, err := s.policy.Authorize(ctx, principal, orgID, ActionManageMembers); err != nil {
+		return err
+	}
+	if uuid.Validate(id) != nil {
+		return ErrNotFound
+	}
+	ok, err := s.db.DeleteInvitation(ctx, orgID, id)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Accept joins the user to the organization of the invitation token. The invitation
+// must have been sent to the user's email.
+func (s *OrgService) Accept(ctx context.Context, userID, token string) (*models.Membership, error) {
+	inv, err := s.db.GetInvitationByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("look up invitation: %w", err)
+	}
+	if inv == nil || inv.AcceptedAt != nil || !s.now().Before(inv.ExpiresAt) {
+		return nil, ErrInvitationInvalid
+	}
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil || !strings.EqualFold(strings.TrimSpace(user.Email), inv.Email) {
+		return nil, fmt.Errorf("%w: the invitation was sent to another email address", ErrForbidden)
+	}
+
+	ok, err := s.db.AcceptInvitation(ctx, inv.ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	if !ok {
+		return nil, ErrInvitationInvalid
+	}
+	return s.db.GetMembership(ctx, inv.OrgID, userID)
+}
+
+// SetRole changes a member's role. Only owners may grant the owner role or change an
+// owner's role.
+func (s *OrgService) SetRole(ctx context.Context, principal *Principal, orgID, userID, role string) error {
+	g, err := s.policy.Authorize(ctx, principal, orgID, ActionManageMembers)
+	if err != nil {
+		return err
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("%w: role must be one of %s", ErrOrgInput, strings.Join(Roles, ", "))
+	}
+	target, err := s.member(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if (role == RoleOwner || target.Role == RoleOwner) && !g.Allows(ActionManageOwners) {
+		return ErrForbidden
+	}
+
+	ok, err := s.db.SetMembershipRole(ctx, orgID, userID, role)
+	if err != nil {
+		return fmt.Errorf("set role: %w", err)
+	}
+	if !ok {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// RemoveMember removes a member from the organization. Any member may remove themselves;
+// removing others needs member management, and removing an owner needs an owner.
+func (s *OrgService) RemoveMember(ctx context.Context, principal *Principal, orgID, userID string) error {
+	if principal == nil || userID != principal.UserID {
+		g, err := s.policy.Authorize(ctx, principal, orgID, ActionManageMembers)
+		if err != nil {
+			return err
+		}
+		target, err := s.member(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if target.Role == RoleOwner && !g.Allows(ActionManageOwners) {
+			return ErrForbidden
+		}
+	} else if _, err := s.policy.Resolve(ctx, principal, orgID); err != nil {
+		return err
+	}
+
+	ok, err := s.db.RemoveMembership(ctx, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	if !ok {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (s *OrgService) member(ctx context.Context, orgID, userID string) (*models.Membership, error) {
+	if uuid.Validate(userID) != nil {
+		return nil, ErrNotFound
+	}
+	m, err := s.db.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("look up member: %w", err)
+	}
+	if m == nil {
+		return nil, ErrNotFound
+	}
+	return m, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Authorization errors. ErrNotFound is also returned for resources the principal cannot
+// see at all, so their existence is not revealed.
+var (
+	ErrForbidden = errors.New("you do not have permission to do this")
+	ErrNotFound  = errors.New("not found")
+)
+
+// Organization roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Roles lists every role, most privileged first.
+var Roles = []string{RoleOwner, RoleAdmin, RoleEditor, RoleViewer}
+
+// Action is something a principal may be allowed to do in an organization.
+type Action string
+
+const (
+	ActionReadDocuments  Action = "documents:read"  // list documents
+	ActionWriteDocuments Action = "documents:write" // upload documents
+	ActionShareDocuments Action = "documents:share" // create and revoke share links
+	ActionChat           Action = "documents:chat"  // query documents
+	ActionReadMembers    Action = "members:read"    // list members
+	ActionManageMembers  Action = "members:manage"  // invite, change roles, remove members
+	ActionManageOwners   Action = "members:owners"  // grant or take away the owner role
+)
+
+// rolePermissions is what each role may do.
+var rolePermissions = map[string][]Action{
+	RoleViewer: {ActionReadDocuments, ActionChat, ActionReadMembers},
+	RoleEditor: {ActionReadDocuments, ActionChat, ActionReadMembers, ActionWriteDocuments, ActionShareDocuments},
+	RoleAdmin:  {ActionReadDocuments, ActionChat, ActionReadMembers, ActionWriteDocuments, ActionShareDocuments, ActionManageMembers},
+	RoleOwner:  {ActionReadDocuments, ActionChat, ActionReadMembers, ActionWriteDocuments, ActionShareDocuments, ActionManageMembers, ActionManageOwners},
+}
+
+// actionScopes is the API key scope an action needs. Actions missing here are for
+// logged-in sessions only.
+var actionScopes = map[Action]string{
+	ActionReadDocuments:  ScopeRead,
+	ActionWriteDocuments: ScopeUpload,
+	ActionChat:           ScopeChat,
+	ActionReadMembers:    ScopeRead,
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// Grant is what a principal may do in one organization.
+//
+// OrgID:   the organization.
+// Role:    the principal's role in it.
+// Actions: the role's actions that the principal's credentials allow.
+type Grant struct {
+	OrgID   string
+	Role    string
+	Actions []Action
+}
+
+// Allows reports whether the grant includes action.
+func (g *Grant) Allows(action Action) bool {
+	return g != nil && slices.Contains(g.Actions, action)
+}
+
+// Policy decides what principals may do with organizations and their documents. It is
+// the one place handlers ask; they do not compare user IDs themselves.
+type Policy struct {
+	db db.DbClient
+}
+
+func NewPolicy(db db.DbClient) *Policy {
+	return &Policy{db: db}
+}
+
+// Resolve returns the principal's grant in the organization, or ErrNotFound if they are
+// not a member.
+func (p *Policy) Resolve(ctx context.Context, principal *Principal, orgID string) (*Grant, error) {
+	if principal == nil {
+		return nil, ErrForbidden
+	}
+	if uuid.Validate(orgID) != nil {
+		return nil, ErrNotFound
+	}
+	m, err := p.db.GetMembership(ctx, orgID, principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("look up membership: %w", err)
+	}
+	if m == nil {
+		return nil, ErrNotFound
+	}
+
+	g := &Grant{OrgID: orgID, Role: m.Role}
+	for _, a := range rolePermissions[m.Role] {
+		if principal.Method == AuthSession {
+			g.Actions = append(g.Actions, a)
+		} else if scope, ok := actionScopes[a]; ok && principal.Can(scope) {
+			g.Actions = append(g.Actions, a)
+		}
+	}
+	return g, nil
+}
+
+// Authorize returns the principal's grant in the organization if it allows action,
+// ErrNotFound if they are not a member, and ErrForbidden otherwise.
+func (p *Policy) Authorize(ctx context.Context, principal *Principal, orgID string, action Action) (*Grant, error) {
+	g, err := p.Resolve(ctx, principal, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !g.Allows(action) {
+		return nil, ErrForbidden
+	}
+	return g, nil
+}
+
+// AuthorizeDocument loads a document and authorizes action on it in its organization.
+// Documents outside the principal's organizations are ErrNotFound.
+func (p *Policy) AuthorizeDocument(ctx context.Context, principal *Principal, documentID string, action Action) (*models.Document, error) {
+	if uuid.Validate(documentID) != nil {
+		return nil, ErrNotFound
+	}
+	doc, err := p.db.GetDocumentByID(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("look up document: %w", err)
+	}
+	if doc == nil {
+		return nil, ErrNotFound
+	}
+	if _, err := p.Authorize(ctx, principal, doc.OrgID, action); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import "slices"
+
+// API key scopes. A principal authenticated with a session token has all of them.
+const (
+	ScopeRead   = "read"   // list documents, read usage
+	ScopeUpload = "upload" // upload documents
+	ScopeChat   = "chat"   // query documents
+)
+
+// Scopes lists every scope, in display order.
+var Scopes = []string{ScopeRead, ScopeUpload, ScopeChat}
+
+// Authentication methods of a Principal.
+const (
+	AuthSession = "session" // Bearer access token from a login
+	AuthAPIKey  = "api_key" // X-API-Key
+)
+
+// Principal is the authenticated caller of a request, however it authenticated.
+//
+// UserID:   the user acting.
+// Method:   AuthSession or AuthAPIKey.
+// Scopes:   what the caller may do.
+// APIKeyID: the key used, for AuthAPIKey.
+// Claims:   the access token's claims, for AuthSession.
+type Principal struct {
+	UserID   string
+	Method   string
+	Scopes   []string
+	APIKeyID string
+	Claims   *AccessClaims
+}
+
+// Can reports whether the principal holds scope.
+func (p *Principal) Can(scope string) bool {
+	return p != nil && slices.Contains(p.Scopes, scope)
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	objectclient "github.com/markdave123-py/Contexta/internal/core/object-client"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Data export errors.
+var (
+	ErrExportNotReady = errors.New("export is still being built")
+	ErrExportExpired  = errors.New("export has expired or failed; request a new one")
+)
+
+// Data export statuses, matching the data_exports.status check.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+const (
+	exportTTL       = 7 * 24 * time.Hour // how long a ready export can be downloaded
+	exportBuildTime = 30 * time.Minute   // a pending export older than this has died
+	exportFailTime  = 10 * time.Second   // to mark an interrupted export failed
+)
+
+// exportInterrupted is the failure reason of exports cut short by a shutdown.
+const exportInterrupted = "the server restarted while the export was built; request a new one"
+
+// PrivacyService deletes accounts and exports a user's data. Everything a user uploads
+// is stored under their ID as the key prefix, and so are their exports.
+//
+// Exports are built in the background under the service's own context, which Shutdown
+// cancels before waiting for the builds to stop.
+type PrivacyService struct {
+	db     db.DbClient
+	obj    objectclient.ObjectClient
+	bucket string
+	now    func() time.Time
+
+	mu     sync.Mutex // orders starting builds against Shutdown
+	stop   context.Context
+	cancel context.CancelFunc
+	builds sync.WaitGroup
+}
+
+func NewPrivacyService(db db.DbClient, obj objectclient.ObjectClient, bucket string) *PrivacyService {
+	stop, cancel := context.WithCancel(context.Background())
+	return &PrivacyService{db: db, obj: obj, bucket: bucket, now: time.Now, stop: stop, cancel: cancel}
+}
+
+// FailInterruptedExports marks the exports a previous run left pending as failed, so
+// their users can ask for new ones at once. Call it at startup, before serving requests.
+func (s *PrivacyService) FailInterruptedExports(ctx context.Context) error {
+	n, err := s.db.FailPendingDataExports(ctx, exportInterrupted)
+	if err != nil {
+		return fmt.Errorf("fail interrupted exports: %w", err)
+	}
+	if n > 0 {
+		log.Printf("marked %d interrupted exports failed", n)
+	}
+	return nil
+}
+
+// Shutdown cancels the exports being built, which are marked failed, and waits for
+// them to stop or for ctx to end.
+func (s *PrivacyService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.builds.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeleteAccount deletes the user, their documents and chat history, the organizations
+// they are the only member of, and every stored object under their prefix. Users with a
+// password must give it. The last owner of an organization with other members must hand
+// it over first.
+func (s *PrivacyService) DeleteAccount(ctx context.Context, userID, password string) error {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil {
+		return ErrAccountNotFound
+	}
+	if user.PasswordHash != "" && !CheckPassword(user, password) {
+		return ErrWrongPassword
+	}
+
+	urls, ok, err := s.db.DeleteUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: transfer ownership of your organizations or remove their other members first", ErrLastOwner)
+	}
+
+	// The rows are gone, so storage failures are logged rather than returned: the
+	// request cannot be retried.
+	prefix := userID + "/"
+	if n, err := s.obj.DeletePrefix(ctx, s.bucket, prefix); err != nil {
+		log.Printf("delete account %s: delete objects under %s: %v", userID, prefix, err)
+	} else {
+		log.Printf("delete account %s: deleted %d objects", userID, n)
+	}
+	// Uploads by former members of the user's deleted organizations live elsewhere.
+	for _, u := range urls {
+		bucket, key := objectclient.ParseS3URL(u)
+		if strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := s.obj.DeleteFile(ctx, bucket, key); err != nil {
+			log.Printf("delete account %s: delete %s: %v", userID, key, err)
+		}
+	}
+	return nil
+}
+
+// Export returns the user's current export, starting a new one in the background if
+// there is none that is pending or still downloadable.
+func (s *PrivacyService) Export(ctx context.Context, userID string) (*models.DataExport, error) {
+	exports, err := s.db.ListDataExports(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list exports: %w", err)
+	}
+	now := s.now()
+	if len(exports) > 0 {
+		latest := exports[0]
+		switch {
+		case latest.Status == ExportPending && now.Sub(latest.CreatedAt) < exportBuildTime:
+			return &latest, nil
+		case latest.Status == ExportReady && now.Before(latest.ExpiresAt):
+			return &latest, nil
+		}
+	}
+
+	export := &models.DataExport{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Status:    ExportPending,
+		ExpiresAt: now.Add(exportTTL),
+		CreatedAt: now,
+	}
+	if err := s.db.CreateDataExport(ctx, export); err != nil {
+		return nil, fmt.Errorf("create export: %w", err)
+	}
+	// Only the newest export is kept.
+	for _, old := range exports {
+		s.discard(ctx, &old)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop.Err() != nil {
+		s.fail(ctx, export.ID, errors.New(exportInterrupted))
+		export.Status, export.FailureReason = ExportFailed, exportInterrupted
+		return export, nil
+	}
+	s.builds.Add(1)
+	go func() {
+		defer s.builds.Done()
+		s.build(*export)
+	}()
+	return export, nil
+}
+
+// LatestExport returns the user's newest export, or ErrNotFound.
+func (s *PrivacyService) LatestExport(ctx context.Context, userID string) (*models.DataExport, error) {
+	exports, err := s.db.ListDataExports(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list exports: %w", err)
+	}
+	if len(exports) == 0 {
+		return nil, ErrNotFound
+	}
+	return &exports[0], nil
+}
+
+// GetExport returns one of the user's exports, or ErrNotFound.
+func (s *PrivacyService) GetExport(ctx context.Context, userID, id string) (*models.DataExport, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrNotFound
+	}
+	export, err := s.db.GetDataExport(ctx, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("look up export: %w", err)
+	}
+	if export == nil {
+		return nil, ErrNotFound
+	}
+	return export, nil
+}
+
+// OpenExport opens the archive of one of the user's ready exports.
+func (s *PrivacyService) OpenExport(ctx context.Context, userID, id string) (*models.DataExport, io.ReadCloser, error) {
+	export, err := s.GetExport(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case export.Status == ExportPending:
+		return nil, nil, ErrExportNotReady
+	case export.Status != ExportReady || !s.now().Before(export.ExpiresAt):
+		return nil, nil, ErrExportExpired
+	}
+
+	rc, err := s.obj.GetObjectReader(ctx, s.bucket, export.StorageKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open export: %w", err)
+	}
+	return export, rc, nil
+}
+
+// build writes the export archive to a temporary file, stores it and marks the export
+// ready, or failed.
+func (s *PrivacyService) build(export models.DataExport) {
+	ctx, cancel := context.WithTimeout(s.stop, exportBuildTime)
+	defer cancel()
+
+	fail := func(err error) {
+		if s.stop.Err() != nil {
+			log.Printf("export %s: %v", export.ID, err)
+			err = errors.New(exportInterrupted)
+		}
+		s.fail(ctx, export.ID, err)
+	}
+
+	f, err := os.CreateTemp("", "contexta-export-*.zip")
+	if err != nil {
+		fail(fmt.Errorf("create temp file: %w", err))
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := s.writeArchive(ctx, export.UserID, f); err != nil {
+		fail(err)
+		return
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		fail(err)
+		return
+	}
+
+	key := fmt.Sprintf("%s/exports/%s.zip", export.UserID, export.ID)
+	if _, err := s.obj.UploadFile(ctx, s.bucket, key, f, "application/zip"); err != nil {
+		fail(fmt.Errorf("store archive: %w", err))
+		return
+	}
+	ok, err := s.db.CompleteDataExport(ctx, export.ID, key, size)
+	if err != nil {
+		fail(fmt.Errorf("complete export: %w", err))
+		return
+	}
+	if !ok {
+		// The user was deleted, or asked for a newer export, while this one was built.
+		_ = s.obj.DeleteFile(ctx, s.bucket, key)
+	}
+}
+
+// fail marks a pending export failed. It outlives ctx, so that a build cancelled by a
+// shutdown is still recorded as failed.
+func (s *PrivacyService) fail(ctx context.Context, id string, err error) {
+	log.Printf("export %s: %v", id, err)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), exportFailTime)
+	defer cancel()
+	if ferr := s.db.FailDataExport(ctx, id, err.Error()); ferr != nil {
+		log.Printf("export %s: mark failed: %v", id, ferr)
+	}
+}
+
+// writeArchive writes the user's profile, document metadata, chat transcripts and
+// original uploads as a ZIP archive.
+func (s *PrivacyService) writeArchive(ctx context.Context, userID string, w io.Writer) error {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil {
+		return ErrAccountNotFound
+	}
+	docs, err := s.db.ListDocumentsByUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("list documents: %w", err)
+	}
+	chats, err := s.db.ListChatTranscripts(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("list chats: %w", err)
+	}
+	if docs == nil {
+		docs = []models.Document{}
+	}
+	if chats == nil {
+		chats = []models.ChatTranscript{}
+	}
+
+	zw := zip.NewWriter(w)
+	entries := []struct {
+		name string
+		v    any
+	}{{"profile.json", user}, {"documents.json", docs}, {"chats.json", chats}}
+	for _, e := range entries {
+		name, v := e.name, e.v
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+
+	for _, d := range docs {
+		if d.SourceType != "upload" {
+			continue
+		}
+		if err := s.copyFile(ctx, zw, d); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (s *PrivacyService) copyFile(ctx context.Context, zw *zip.Writer, d models.Document) error {
+	bucket, key := objectclient.ParseS3URL(d.StorageURL)
+	rc, err := s.obj.GetObjectReader(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", d.FileName, err)
+	}
+	defer rc.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     path.Join("files", d.ID, path.Base(d.FileName)),
+		Method:   zip.Deflate,
+		Modified: d.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		return fmt.Errorf("copy %s: %w", d.FileName, err)
+	}
+	return nil
+}
+
+// discard deletes an export and its archive. Failures only leave garbage behind.
+func (s *PrivacyService) discard(ctx context.Context, export *models.DataExport) {
+	if export.StorageKey != "" {
+		if err := s.obj.DeleteFile(ctx, s.bucket, export.StorageKey); err != nil {
+			log.Printf("export %s: delete archive: %v", export.ID, err)
+			return
+		}
+	}
+	if err := s.db.DeleteDataExport(ctx, export.ID); err != nil {
+		log.Printf("export %s: delete: %v", export.ID, err)
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// DefaultQuotaPlans is the plan table used when QUOTA_PLANS is not set.
+const DefaultQuotaPlans = "free:bytes=100MB,documents=50,pages=1000,queries=100;" +
+	"pro:bytes=10GB,documents=2000,pages=50000,queries=2000"
+
+// Quota names, as reported in quota errors and the quota endpoint.
+const (
+	QuotaBytes     = "storage"
+	QuotaDocuments = "documents"
+	QuotaPages     = "pages_per_month"
+	QuotaQueries   = "queries_per_day"
+)
+
+// QuotaLimits are the limits of a plan. Zero means unlimited.
+//
+// MaxBytes:         total bytes of stored documents.
+// MaxDocuments:     number of stored documents.
+// MaxPagesPerMonth: pages ingested from documents uploaded in the current UTC month.
+// MaxQueriesPerDay: chat queries in the current UTC day.
+type QuotaLimits struct {
+	MaxBytes         int64 `json:"max_bytes"`
+	MaxDocuments     int   `json:"max_documents"`
+	MaxPagesPerMonth int   `json:"max_pages_per_month"`
+	MaxQueriesPerDay int   `json:"max_queries_per_day"`
+}
+
+// ParseQuotaPlans reads a plan table such as
+// "free:bytes=100MB,documents=50,pages=1000,queries=100;pro:bytes=10GB". Limits left out
+// of a plan are unlimited. Sizes accept KB, MB and GB suffixes (powers of 1024).
+func ParseQuotaPlans(s string) (map[string]QuotaLimits, error) {
+	plans := map[string]QuotaLimits{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("quota plan %q: want name:limit=value,...", entry)
+		}
+		var l QuotaLimits
+		for _, kv := range strings.Split(spec, ",") {
+			kv = strings.TrimSpace(kv)
+			if kv == "" {
+				continue
+			}
+			key, val, _ := strings.Cut(kv, "=")
+			n, err := parseQuotaValue(strings.TrimSpace(val))
+			if err != nil {
+				return nil, fmt.Errorf("quota plan %q: %s: %w", name, key, err)
+			}
+			switch strings.TrimSpace(key) {
+			case "bytes":
+				l.MaxBytes = n
+			case "documents":
+				l.MaxDocuments = int(n)
+			case "pages":
+				l.MaxPagesPerMonth = int(n)
+			case "queries":
+				l.MaxQueriesPerDay = int(n)
+			default:
+				return nil, fmt.Errorf("quota plan %q: unknown limit %q", name, key)
+			}
+		}
+		plans[name] = l
+	}
+	return plans, nil
+}
+
+func parseQuotaValue(s string) (int64, error) {
+	mult := int64(1)
+	upper := strings.ToUpper(s)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			mult, s = unit.mult, strings.TrimSpace(s[:len(s)-len(unit.suffix)])
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative limit %d", n)
+	}
+	return n * mult, nil
+}
+
+// QuotaError is returned when an action would exceed one of the user's quotas.
+//
+// Quota:      which quota, one of the Quota* names.
+// Limit:      the user's limit.
+// Used:       what the user has used so far.
+// Requested:  what the action needed, e.g. the upload's size.
+// Status:     HTTP status to answer with: 413 for storage, 429 otherwise.
+// RetryAfter: when the quota resets, zero for quotas that never reset.
+type QuotaError struct {
+	Quota      string    `json:"quota"`
+	Limit      int64     `json:"limit"`
+	Used       int64     `json:"used"`
+	Requested  int64     `json:"requested,omitempty"`
+	Status     int       `json:"-"`
+	RetryAfter time.Time `json:"resets_at,omitzero"`
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Quota {
+	case QuotaBytes:
+		return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, upload needs %d more", e.Used, e.Limit, e.Requested)
+	case QuotaDocuments:
+		return fmt.Sprintf("document quota exceeded: %d of %d documents stored", e.Used, e.Limit)
+	case QuotaPages:
+		return fmt.Sprintf("monthly page quota exhausted: %d of %d pages ingested this month", e.Used, e.Limit)
+	case QuotaQueries:
+		return fmt.Sprintf("daily query quota exhausted: %d of %d queries sent today", e.Used, e.Limit)
+	}
+	return fmt.Sprintf("%s quota exceeded", e.Quota)
+}
+
+// QuotaStatus is a user's plan, limits and what is left of them. Remaining values are
+// nil for unlimited quotas.
+type QuotaStatus struct {
+	Plan      string            `json:"plan"`
+	Limits    QuotaLimits       `json:"limits"`
+	Used      models.QuotaUsage `json:"used"`
+	Remaining QuotaRemaining    `json:"remaining"`
+	ResetsAt  QuotaResets       `json:"resets_at"`
+}
+
+type QuotaRemaining struct {
+	Bytes          *int64 `json:"bytes"`
+	Documents      *int64 `json:"documents"`
+	PagesThisMonth *int64 `json:"pages_this_month"`
+	QueriesToday   *int64 `json:"queries_today"`
+}
+
+type QuotaResets struct {
+	Pages   time.Time `json:"pages"`
+	Queries time.Time `json:"queries"`
+}
+
+// QuotaService enforces per-user quotas. Limits come from the user's plan, overridden
+// per user where the database says so.
+type QuotaService struct {
+	db          db.DbClient
+	plans       map[string]QuotaLimits
+	defaultPlan string
+	now         func() time.Time
+}
+
+func NewQuotaService(db db.DbClient, plans map[string]QuotaLimits, defaultPlan string) *QuotaService {
+	return &QuotaService{db: db, plans: plans, defaultPlan: defaultPlan, now: time.Now}
+}
+
+// Status returns the user's limits, usage and remaining allowance.
+func (s *QuotaService) Status(ctx context.Context, userID string) (*QuotaStatus, error) {
+	plan, limits, err := s.limits(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	used, err := s.db.GetQuotaUsage(ctx, userID, monthStart, dayStart)
+	if err != nil {
+		return nil, fmt.Errorf("quota usage: %w", err)
+	}
+
+	return &QuotaStatus{
+		Plan:   plan,
+		Limits: limits,
+		Used:   *used,
+		Remaining: QuotaRemaining{
+			Bytes:          remaining(limits.MaxBytes, used.Bytes),
+			Documents:      remaining(int64(limits.MaxDocuments), int64(used.Documents)),
+			PagesThisMonth: remaining(int64(limits.MaxPagesPerMonth), int64(used.PagesThisMonth)),
+			QueriesToday:   remaining(int64(limits.MaxQueriesPerDay), int64(used.QueriesToday)),
+		},
+		ResetsAt: QuotaResets{
+			Pages:   monthStart.AddDate(0, 1, 0),
+			Queries: dayStart.AddDate(0, 0, 1),
+		},
+	}, nil
+}
+
+// CheckUpload returns a *QuotaError if storing size more bytes as a new document would
+// exceed the user's storage or document quota, or the month's pages are used up.
+func (s *QuotaService) CheckUpload(ctx context.Context, userID string, size int64) error {
+	st, err := s.Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	l, u := st.Limits, st.Used
+	switch {
+	case l.MaxDocuments > 0 && u.Documents >= l.MaxDocuments:
+		return &QuotaError{Quota: QuotaDocuments, Limit: int64(l.MaxDocuments), Used: int64(u.Documents), Status: http.StatusTooManyRequests}
+	case l.MaxBytes > 0 && u.Bytes+size > l.MaxBytes:
+		return &QuotaError{Quota: QuotaBytes, Limit: l.MaxBytes, Used: u.Bytes, Requested: size, Status: http.StatusRequestEntityTooLarge}
+	}
+	return pagesLeft(st)
+}
+
+// CheckRetry returns a *QuotaError if the month's pages are used up, so a failed document
+// may not be ingested again. The document already counts toward the user's storage and
+// documents.
+func (s *QuotaService) CheckRetry(ctx context.Context, userID string) error {
+	st, err := s.Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return pagesLeft(st)
+}
+
+func pagesLeft(st *QuotaStatus) error {
+	if l, u := st.Limits.MaxPagesPerMonth, st.Used.PagesThisMonth; l > 0 && u >= l {
+		return &QuotaError{Quota: QuotaPages, Limit: int64(l), Used: int64(u),
+			Status: http.StatusTooManyRequests, RetryAfter: st.ResetsAt.Pages}
+	}
+	return nil
+}
+
+// CheckQuery returns a *QuotaError if the user has no chat queries left today.
+func (s *QuotaService) CheckQuery(ctx context.Context, userID string) error {
+	st, err := s.Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if l, u := st.Limits.MaxQueriesPerDay, st.Used.QueriesToday; l > 0 && u >= l {
+		return &QuotaError{Quota: QuotaQueries, Limit: int64(l), Used: int64(u),
+			Status: http.StatusTooManyRequests, RetryAfter: st.ResetsAt.Queries}
+	}
+	return nil
+}
+
+// RemainingPages reports how many more pages the user may ingest this month; limited is
+// false when the plan has no page limit. The ingestion pipeline stops a document that
+// goes over.
+func (s *QuotaService) RemainingPages(ctx context.Context, userID string) (remaining int, limited bool, err error) {
+	st, err := s.Status(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	if st.Remaining.PagesThisMonth == nil {
+		return 0, false, nil
+	}
+	return int(*st.Remaining.PagesThisMonth), true, nil
+}
+
+// limits resolves the user's plan and applies their overrides.
+func (s *QuotaService) limits(ctx context.Context, userID string) (string, QuotaLimits, error) {
+	uq, err := s.db.GetUserQuota(ctx, userID)
+	if err != nil {
+		return "", QuotaLimits{}, fmt.Errorf("user quota: %w", err)
+	}
+	if uq == nil {
+		return "", QuotaLimits{}, fmt.Errorf("user quota: user %s not found", userID)
+	}
+
+	plan := uq.Plan
+	limits, ok := s.plans[plan]
+	if !ok {
+		log.Printf("quota: user %s has unknown plan %q, applying %q", userID, plan, s.defaultPlan)
+		plan, limits = s.defaultPlan, s.plans[s.defaultPlan]
+	}
+	if uq.MaxBytes != nil {
+		limits.MaxBytes = *uq.MaxBytes
+	}
+	if uq.MaxDocuments != nil {
+		limits.MaxDocuments = *uq.MaxDocuments
+	}
+	if uq.MaxPagesPerMonth != nil {
+		limits.MaxPagesPerMonth = *uq.MaxPagesPerMonth
+	}
+	if uq.MaxQueriesPerDay != nil {
+		limits.MaxQueriesPerDay = *uq.MaxQueriesPerDay
+	}
+	return plan, limits, nil
+}
+
+// remaining is what is left of limit, or nil when limit is 0 (unlimited).
+func remaining(limit, used int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	r := max(limit-used, 0)
+	return &r
+}
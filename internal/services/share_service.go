@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Share link errors.
+var (
+	ErrShareInput     = errors.New("invalid share link request")
+	ErrShareInvalid   = errors.New("share link is invalid, expired or revoked")
+	ErrSharePassword  = errors.New("share link password is missing or wrong")
+	ErrShareExhausted = errors.New("share link has no queries left")
+	ErrShareLocked    = errors.New("too many wrong share link passwords; try again later")
+)
+
+// ShareLockedError is ErrShareLocked with how long the link or client stays locked.
+type ShareLockedError struct {
+	Wait time.Duration
+}
+
+func (e *ShareLockedError) Error() string { return ErrShareLocked.Error() }
+func (e *ShareLockedError) Unwrap() error { return ErrShareLocked }
+
+// Share link lifetimes: the default when none is given, and the longest allowed.
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 365 * 24 * time.Hour
+)
+
+// ShareOptions configure a new share link.
+//
+// ExpiresIn:  lifetime, defaulting to 7 days; at most a year.
+// Password:   required to use the link if not empty.
+// MaxQueries: query budget, 0 for unlimited.
+type ShareOptions struct {
+	ExpiresIn  time.Duration
+	Password   string
+	MaxQueries int
+}
+
+// ShareService manages share links, which let anyone holding the token chat with one
+// document without an account. Queries through a link are billed to its creator.
+// Wrong passwords are counted per link and per client IP, as logins are, so a link's
+// password cannot be guessed at bcrypt's pace for as long as the attacker likes.
+type ShareService struct {
+	db     db.DbClient
+	policy *Policy
+	guard  *LoginGuard
+	now    func() time.Time
+}
+
+func NewShareService(db db.DbClient, policy *Policy) *ShareService {
+	return &ShareService{
+		db:     db,
+		policy: policy,
+		guard:  NewLoginGuard(LoginGuardConfig{MaxAccountFailures: 10, MaxIPFailures: 50}),
+		now:    time.Now,
+	}
+}
+
+// Create makes a share link for the document. The token is returned only here.
+func (s *ShareService) Create(ctx context.Context, principal *Principal, documentID string, opts ShareOptions) (*models.ShareLink, string, error) {
+	doc, err := s.policy.AuthorizeDocument(ctx, principal, documentID, ActionShareDocuments)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.ExpiresIn < 0 || opts.ExpiresIn > maxShareTTL {
+		return nil, "", fmt.Errorf("%w: expiry must be at most %d days", ErrShareInput, int(maxShareTTL.Hours()/24))
+	}
+	if opts.ExpiresIn == 0 {
+		opts.ExpiresIn = defaultShareTTL
+	}
+	if opts.MaxQueries < 0 {
+		return nil, "", fmt.Errorf("%w: max_queries cannot be negative", ErrShareInput)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := s.now()
+	link := &models.ShareLink{
+		ID:         uuid.NewString(),
+		DocumentID: doc.ID,
+		CreatedBy:  principal.UserID,
+		TokenHash:  hashToken(token),
+		ExpiresAt:  now.Add(opts.ExpiresIn),
+		CreatedAt:  now,
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrShareInput, err)
+		}
+		link.PasswordHash, link.HasPassword = string(hash), true
+	}
+	if opts.MaxQueries > 0 {
+		link.MaxQueries = &opts.MaxQueries
+	}
+
+	if err := s.db.CreateShareLink(ctx, link); err != nil {
+		return nil, "", fmt.Errorf("store share link: %w", err)
+	}
+	return link, token, nil
+}
+
+// List returns the document's share links.
+func (s *ShareService) List(ctx context.Context, principal *Principal, documentID string) ([]models.ShareLink, error) {
+	doc, err := s.policy.AuthorizeDocument(ctx, principal, documentID, ActionShareDocuments)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.ListShareLinks(ctx, doc.ID)
+}
+
+// Revoke disables one of the document's share links.
+func (s *ShareService) Revoke(ctx context.Context, principal *Principal, documentID, id string) error {
+	doc, err := s.policy.AuthorizeDocument(ctx, principal, documentID, ActionShareDocuments)
+	if err != nil {
+		return err
+	}
+	if uuid.Validate(id) != nil {
+		return ErrNotFound
+	}
+	ok, err := s.db.RevokeShareLink(ctx, doc.ID, id)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Open checks a share token and the password sent from ip, and returns the link and its
+// document. Once a link or an IP has had too many wrong passwords it is refused with a
+// *ShareLockedError until the lockout ends, before the password is compared.
+func (s *ShareService) Open(ctx context.Context, token, password, ip string) (*models.ShareLink, *models.Document, error) {
+	link, err := s.db.GetShareLinkByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, nil, fmt.Errorf("look up share link: %w", err)
+	}
+	// A link whose creator is gone has nobody to bill.
+	if link == nil || link.RevokedAt != nil || !s.now().Before(link.ExpiresAt) || link.CreatedBy == "" {
+		return nil, nil, ErrShareInvalid
+	}
+	if link.HasPassword {
+		if wait := s.guard.Check(link.ID, ip); wait > 0 {
+			return nil, nil, &ShareLockedError{Wait: wait}
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			s.guard.Fail(link.ID, ip)
+			return nil, nil, ErrSharePassword
+		}
+		s.guard.Succeed(link.ID)
+	}
+
+	doc, err := s.db.GetDocumentByID(ctx, link.DocumentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("look up document: %w", err)
+	}
+	if doc == nil {
+		return nil, nil, ErrShareInvalid
+	}
+	return link, doc, nil
+}
+
+// Use spends one query of the link's budget.
+func (s *ShareService) Use(ctx context.Context, link *models.ShareLink) error {
+	ok, err := s.db.UseShareLink(ctx, link.ID)
+	if err != nil {
+		return fmt.Errorf("use share link: %w", err)
+	}
+	if !ok {
+		// Out of queries, or revoked or expired since it was opened.
+		if link.MaxQueries != nil {
+			return ErrShareExhausted
+		}
+		return ErrShareInvalid
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	db "github.com/markdave123-py/Contexta/internal/core/database"
+	"github.com/markdave123-py/Contexta/internal/models"
+)
+
+// Token errors. Handlers answer all of them with 401.
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrRevokedToken = errors.New("token has been revoked")
+	ErrTokenReused  = errors.New("refresh token reuse detected; all sessions of this login were revoked")
+)
+
+// minSecretLen is the shortest JWT secret accepted: 256 bits for HS256.
+const minSecretLen = 32
+
+// ValidateSecret rejects JWT secrets that are short, repetitive or placeholders.
+func ValidateSecret(secret string) error {
+	if len(secret) < minSecretLen {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes, got %d", minSecretLen, len(secret))
+	}
+	distinct := map[rune]bool{}
+	for _, r := range secret {
+		distinct[r] = true
+	}
+	if len(distinct) < 10 {
+		return fmt.Errorf("JWT_SECRET is too repetitive (%d distinct characters)", len(distinct))
+	}
+	lower := strings.ToLower(secret)
+	for _, weak := range []string{"secret", "changeme", "password", "example", "default"} {
+		if strings.Contains(lower, weak) {
+			return fmt.Errorf("JWT_SECRET looks like a placeholder (contains %q)", weak)
+		}
+	}
+	return nil
+}
+
+// AccessClaims are the claims of an access token.
+//
+// UserID:    the authenticated user.
+// SessionID: refresh token family the token was issued under, empty for none.
+type AccessClaims struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is what a login or refresh returns to the client.
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// TokenService issues short-lived HS256 access tokens and rotating refresh tokens, and
+// revokes both.
+type TokenService struct {
+	db         db.DbClient
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewTokenService validates secret with ValidateSecret.
+func NewTokenService(db db.DbClient, secret string, accessTTL, refreshTTL time.Duration) (*TokenService, error) {
+	if err := ValidateSecret(secret); err != nil {
+		return nil, err
+	}
+	if accessTTL <= 0 {
+		accessTTL = 15 * time.Minute
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+	return &TokenService{db: db, secret: []byte(secret), accessTTL: accessTTL, refreshTTL: refreshTTL, now: time.Now}, nil
+}
+
+// Login starts a new refresh token family for the user and returns its first pair.
+func (s *TokenService) Login(ctx context.Context, userID string) (*TokenPair, error) {
+	return s.issue(ctx, userID, uuid.NewString(), uuid.NewString())
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and a new pair in the
+// same family is returned. Presenting a token that was already rotated revokes its whole
+// family and returns ErrTokenReused.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	stored, err := s.db.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("look up refresh token: %w", err)
+	}
+	if stored == nil {
+		return nil, ErrInvalidToken
+	}
+	if stored.RevokedAt != nil {
+		if stored.ReplacedBy == "" {
+			// Revoked by logout or with its family, not spent by a rotation.
+			return nil, ErrRevokedToken
+		}
+		return nil, s.reused(ctx, stored)
+	}
+	if !s.now().Before(stored.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	next := uuid.NewString()
+	claimed, err := s.db.RevokeRefreshToken(ctx, stored.ID, next)
+	if err != nil {
+		return nil, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if !claimed {
+		// Another request rotated the same token first.
+		return nil, s.reused(ctx, stored)
+	}
+	return s.issue(ctx, stored.UserID, stored.FamilyID, next)
+}
+
+func (s *TokenService) reused(ctx context.Context, stored *models.RefreshToken) error {
+	log.Printf("auth: refresh token reuse for user %s, revoking family %s", stored.UserID, stored.FamilyID)
+	if err := s.db.RevokeRefreshFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("revoke refresh family: %w", err)
+	}
+	return ErrTokenReused
+}
+
+// Logout revokes the access token and, if given, the refresh token family of the session.
+func (s *TokenService) Logout(ctx context.Context, claims *AccessClaims, refreshToken string) error {
+	if err := s.RevokeAccess(ctx, claims); err != nil {
+		return err
+	}
+	if refreshToken != "" {
+		stored, err := s.db.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err != nil {
+			return fmt.Errorf("look up refresh token: %w", err)
+		}
+		if stored != nil && stored.UserID == claims.UserID {
+			return s.db.RevokeRefreshFamily(ctx, stored.FamilyID)
+		}
+	}
+	if claims.SessionID != "" {
+		return s.db.RevokeRefreshFamily(ctx, claims.SessionID)
+	}
+	return nil
+}
+
+// RevokeAccess denylists an access token until it expires.
+func (s *TokenService) RevokeAccess(ctx context.Context, claims *AccessClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	if err := s.db.RevokeAccessToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	return nil
+}
+
+// Verify parses an access token, checks its signature, expiry, the revocation denylist
+// and that its session has not ended, and returns its claims. Ending a session, by logout,
+// reuse detection or a password change, so takes its unexpired access tokens with it.
+func (s *TokenService) Verify(ctx context.Context, token string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithTimeFunc(s.now))
+	if err != nil || !parsed.Valid || claims.UserID == "" || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.db.IsAccessTokenRevoked(ctx, claims.ID, claims.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrRevokedToken
+	}
+	return claims, nil
+}
+
+// issue stores a new refresh token with the given ID and signs an access token for it.
+func (s *TokenService) issue(ctx context.Context, userID, familyID, refreshID string) (*TokenPair, error) {
+	now := s.now()
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	stored := &models.RefreshToken{
+		ID:        refreshID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: now.Add(s.refreshTTL),
+		CreatedAt: now,
+	}
+	if err := s.db.CreateRefreshToken(ctx, stored); err != nil {
+		return nil, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	access, accessExp, err := s.sign(userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExp,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+// sign creates an access token with a fresh jti.
+func (s *TokenService) sign(userID, sessionID string) (string, time.Time, error) {
+	now := s.now()
+	exp := now.Add(s.accessTTL)
+	claims := AccessClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign access token: %w", err)
+	}
+	return token, exp, nil
+}
+
+// deriveKey returns a key for another use of the secret, so that e.g. a signed cookie
+// can never pass for an access token.
+func (s *TokenService) deriveKey(label string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// randomToken returns 256 random bits, base64url encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefreshRotatesAndDetectsReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newSessionDB()
+	tokens := newTestTokenService(store, time.Now)
+
+	first, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokens.Login(ctx, "user-1") // another device
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := tokens.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh returned the same tokens")
+	}
+	// Rotation ends nothing: the access token issued before it still works.
+	if _, err := tokens.Verify(ctx, first.AccessToken); err != nil {
+		t.Fatalf("access token from before the rotation: %v", err)
+	}
+
+	// The spent token comes back: it leaked, so the whole family goes.
+	if _, err := tokens.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("replayed refresh token: got %v, want ErrTokenReused", err)
+	}
+	if _, err := tokens.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("successor of the replayed token: got %v, want ErrRevokedToken", err)
+	}
+	for name, access := range map[string]string{"first": first.AccessToken, "second": second.AccessToken} {
+		if _, err := tokens.Verify(ctx, access); !errors.Is(err, ErrRevokedToken) {
+			t.Fatalf("%s access token of the revoked family: got %v, want ErrRevokedToken", name, err)
+		}
+	}
+
+	// Other sessions of the user are not part of the family.
+	if _, err := tokens.Verify(ctx, other.AccessToken); err != nil {
+		t.Fatalf("other session's access token: %v", err)
+	}
+	if _, err := tokens.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Fatalf("other session's refresh token: %v", err)
+	}
+}
+
+// Two requests racing to rotate one token cannot both get a successor.
+func TestConcurrentRefreshCountsAsReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newSessionDB()
+	tokens := newTestTokenService(store, time.Now)
+	pair, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 8
+	var wg sync.WaitGroup
+	results := make([]error, racers)
+	for k := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, results[k] = tokens.Refresh(ctx, pair.RefreshToken)
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range results {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrTokenReused):
+			t.Fatalf("losing refresh: got %v, want ErrTokenReused", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d refreshes succeeded, want 1", won)
+	}
+	for _, rt := range store.refresh {
+		if rt.RevokedAt == nil {
+			t.Fatal("the family survived a replay")
+		}
+	}
+}
+
+func TestRefreshRejectsUnknownExpiredAndRevokedTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newSessionDB()
+	tokens := newTestTokenService(store, func() time.Time { return now })
+
+	if _, err := tokens.Refresh(ctx, "never-issued"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("unknown token: got %v, want ErrInvalidToken", err)
+	}
+
+	old, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(25 * time.Hour)
+	if _, err := tokens.Refresh(ctx, old.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expired token: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Verify(ctx, old.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expired access token: got %v, want ErrInvalidToken", err)
+	}
+
+	pair, err := tokens.Login(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.Verify(ctx, pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Logout(ctx, claims, pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	// Logged out is revoked, not replayed: no reuse alarm.
+	if _, err := tokens.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("logged out refresh token: got %v, want ErrRevokedToken", err)
+	}
+	if _, err := tokens.Verify(ctx, pair.AccessToken); !errors.Is(err, ErrRevokedToken) {
+		t.Fatalf("logged out access token: got %v, want ErrRevokedToken", err)
+	}
+	if !store.denied[claims.ID] {
+		t.Fatal("logout did not denylist the access token")
+	}
+}
@@ -5,11 +5,107 @@ class ContextaApp {
         this.documents = [];
         this.chatHistory = [];
         this.token = localStorage.getItem('authToken');
+        this.refreshToken = localStorage.getItem('refreshToken');
         this.userEmail = localStorage.getItem('userEmail');
+        this.consumeLoginRedirect();
 
         this.initializeElements();
         this.setupEventListeners();
+        this.loadLoginProviders();
         this.checkAuthStatus();
+        this.consumeAccountLink();
+    }
+
+    // Pick up the tokens an identity provider login left in the URL fragment
+    consumeLoginRedirect() {
+        const params = new URLSearchParams(window.location.hash.slice(1));
+        if (!params.get('access_token')) return;
+        this.storeTokens({
+            access_token: params.get('access_token'),
+            refresh_token: params.get('refresh_token')
+        });
+        if (params.get('email')) {
+            this.userEmail = params.get('email');
+            localStorage.setItem('userEmail', this.userEmail);
+        }
+        history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+
+    // Finish a password reset or email verification opened from an emailed link
+    async consumeAccountLink() {
+        const params = new URLSearchParams(window.location.hash.slice(1));
+        const resetToken = params.get('reset_token');
+        const verifyToken = params.get('verify_token');
+        if (!resetToken && !verifyToken) return;
+        history.replaceState(null, '', window.location.pathname + window.location.search);
+
+        let request;
+        if (resetToken) {
+            const newPassword = prompt('Choose a new password (at least 10 characters, with three of lowercase, uppercase, digits and symbols):');
+            if (!newPassword) return;
+            request = { path: 'auth/password/reset', body: { token: resetToken, new_password: newPassword }, done: 'Password changed. Please login.' };
+        } else {
+            request = { path: 'auth/verify-email', body: { token: verifyToken }, done: 'Email address verified.' };
+        }
+
+        try {
+            const response = await fetch(`${this.baseUrl}/${request.path}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(request.body)
+            });
+            if (!response.ok) throw new Error(await this.errorMessage(response, 'Request failed'));
+            this.authStatus.innerHTML = `<div class="success">${request.done}</div>`;
+        } catch (error) {
+            this.authStatus.innerHTML = `<div class="error">${error.message}</div>`;
+        }
+    }
+
+    // The message of an API error response: {"error": {"code": ..., "message": ...}}
+    async errorMessage(response, fallback) {
+        try {
+            const data = await response.json();
+            return (data.error && data.error.message) || fallback;
+        } catch (error) {
+            return fallback;
+        }
+    }
+
+    async handleForgotPassword() {
+        const email = document.getElementById('email').value || prompt('Email address:');
+        if (!email) return;
+        try {
+            await fetch(`${this.baseUrl}/auth/password/forgot`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ email })
+            });
+            this.authStatus.innerHTML = '<div class="success">If the address has an account, a reset link is on its way.</div>';
+        } catch (error) {
+            this.authStatus.innerHTML = `<div class="error">${error.message}</div>`;
+        }
+    }
+
+    async loadLoginProviders() {
+        try {
+            const response = await fetch(`${this.baseUrl}/auth/oidc`);
+            if (!response.ok) return;
+            const { providers } = await response.json();
+            this.providerLogins.innerHTML = '';
+            providers.forEach(name => {
+                const button = document.createElement('button');
+                button.type = 'button';
+                button.className = 'login-button';
+                button.textContent = `Continue with ${name.charAt(0).toUpperCase() + name.slice(1)}`;
+                button.addEventListener('click', () => {
+                    const redirect = encodeURIComponent(window.location.pathname);
+                    window.location.href = `${this.baseUrl}/auth/oidc/${encodeURIComponent(name)}/login?redirect=${redirect}`;
+                });
+                this.providerLogins.appendChild(button);
+            });
+        } catch (error) {
+            // Password login still works without the provider list
+        }
     }
 
     initializeElements() {
@@ -22,9 +118,11 @@ class ContextaApp {
         this.signupButton = document.getElementById('signupButton');
         this.switchToSignup = document.getElementById('switchToSignup');
         this.switchToLogin = document.getElementById('switchToLogin');
+        this.forgotPassword = document.getElementById('forgotPassword');
         this.authStatus = document.getElementById('authStatus');
         this.logoutButton = document.getElementById('logoutButton');
         this.userEmailSpan = document.getElementById('userEmail');
+        this.providerLogins = document.getElementById('providerLogins');
 
         // App elements
         this.uploadForm = document.getElementById('uploadForm');
@@ -46,6 +144,7 @@ class ContextaApp {
         this.signupForm.addEventListener('submit', (e) => this.handleSignup(e));
         this.switchToSignup.addEventListener('click', () => this.switchAuthForm('signup'));
         this.switchToLogin.addEventListener('click', () => this.switchAuthForm('login'));
+        this.forgotPassword.addEventListener('click', () => this.handleForgotPassword());
         this.logoutButton.addEventListener('click', () => this.handleLogout());
 
         // App event listeners
@@ -98,16 +197,14 @@ class ContextaApp {
             });
 
             if (!response.ok) {
-                const errorData = await response.json();
-                throw new Error(errorData.error || 'Login failed');
+                throw new Error(await this.errorMessage(response, 'Login failed'));
             }
 
             const data = await response.json();
             
-            // Store token and user info
-            this.token = data.token;
+            // Store tokens and user info
+            this.storeTokens(data);
             this.userEmail = email;
-            localStorage.setItem('authToken', this.token);
             localStorage.setItem('userEmail', this.userEmail);
 
             this.showApp();
@@ -141,13 +238,12 @@ class ContextaApp {
             });
 
             if (!response.ok) {
-                const errorData = await response.json();
-                throw new Error(errorData.error || 'Signup failed');
+                throw new Error(await this.errorMessage(response, 'Signup failed'));
             }
 
-            // Switch to login form after successful signup
+            // The answer is the same for new and existing addresses; the email says which
             this.switchAuthForm('login');
-            this.authStatus.innerHTML = '<div class="success">Account created! Please login.</div>';
+            this.authStatus.innerHTML = '<div class="success">Check your email to continue, then log in.</div>';
 
         } catch (error) {
             this.authStatus.innerHTML = `<div class="error">Signup failed: ${error.message}</div>`;
@@ -157,10 +253,55 @@ class ContextaApp {
         }
     }
 
-    handleLogout() {
+    storeTokens(data) {
+        this.token = data.access_token || data.token;
+        this.refreshToken = data.refresh_token || null;
+        localStorage.setItem('authToken', this.token);
+        if (this.refreshToken) {
+            localStorage.setItem('refreshToken', this.refreshToken);
+        } else {
+            localStorage.removeItem('refreshToken');
+        }
+    }
+
+    // Exchange the refresh token for a new pair; false if the session is over
+    async refreshTokens() {
+        if (!this.refreshToken) return false;
+        try {
+            const response = await fetch(`${this.baseUrl}/auth/refresh`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: this.refreshToken })
+            });
+            if (!response.ok) return false;
+            this.storeTokens(await response.json());
+            return true;
+        } catch (error) {
+            return false;
+        }
+    }
+
+    async handleLogout() {
+        if (this.token) {
+            // Best effort: revoke the session server-side
+            fetch(`${this.baseUrl}/auth/logout`, {
+                method: 'POST',
+                headers: {
+                    'Authorization': `Bearer ${this.token}`,
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ refresh_token: this.refreshToken || '' })
+            }).catch(() => {});
+        }
+        this.clearSession();
+    }
+
+    clearSession() {
         this.token = null;
+        this.refreshToken = null;
         this.userEmail = null;
         localStorage.removeItem('authToken');
+        localStorage.removeItem('refreshToken');
         localStorage.removeItem('userEmail');
         this.showLogin();
     }
@@ -181,7 +322,7 @@ class ContextaApp {
     }
 
     // Helper method for authenticated requests
-    async authenticatedFetch(url, options = {}) {
+    async authenticatedFetch(url, options = {}, retried = false) {
         const headers = {
             'Authorization': `Bearer ${this.token}`,
             ...options.headers
@@ -193,7 +334,11 @@ class ContextaApp {
         });
 
         if (response.status === 401) {
-            this.handleLogout();
+            // Access tokens are short-lived; renew once and replay the request
+            if (!retried && await this.refreshTokens()) {
+                return this.authenticatedFetch(url, options, true);
+            }
+            this.clearSession();
             throw new Error('Session expired. Please login again.');
         }
 
@@ -223,12 +368,15 @@ class ContextaApp {
             });
 
             if (!response.ok) {
-                const errorData = await response.json();
-                throw new Error(errorData.error || `Upload failed: ${response.status}`);
+                throw new Error(await this.errorMessage(response, `Upload failed: ${response.status}`));
             }
 
             const result = await response.json();
-            this.showUploadStatus('Document uploaded successfully! Processing...', 'success');
+            if (result.duplicate) {
+                this.showUploadStatus(`You already uploaded this file as "${result.file_name}".`, 'success');
+            } else {
+                this.showUploadStatus('Document uploaded successfully! Processing...', 'success');
+            }
             this.fileInput.value = '';
             
             // Reload documents list
@@ -277,6 +425,7 @@ class ContextaApp {
                  onclick="app.selectDocument('${doc.id}')">
                 <strong>${doc.file_name}</strong>
                 <span class="document-status status-${doc.status}">${doc.status}</span>
+                ${doc.failure_reason ? `<small class="failure-reason">(${doc.failure_reason.replace(/_/g, ' ')})</small>` : ''}
                 <br>
                 <small>Uploaded: ${new Date(doc.created_at).toLocaleDateString()}</small>
             </div>
@@ -346,14 +495,15 @@ class ContextaApp {
             });
 
             if (!response.ok) {
-                const errorData = await response.json();
-                throw new Error(errorData.error || `HTTP ${response.status}`);
+                throw new Error(await this.errorMessage(response, `HTTP ${response.status}`));
             }
 
             const data = await response.json();
             
-            // Add assistant response
-            this.addMessage('assistant', data.answer);
+            // Add assistant response, followed by where the answer came from
+            const labels = (data.sources || []).filter(s => s.label).map(s => `[${s.ref}] ${s.label}`);
+            const answer = labels.length ? `${data.answer}\n\nSources: ${labels.join('; ')}` : data.answer;
+            this.addMessage('assistant', answer);
 
         } catch (error) {
             this.showError(`Error sending message: ${error.message}`);